@@ -1,8 +1,8 @@
 module ClockAsService
 
-go 1.20
+go 1.21
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.32 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/mattn/go-sqlite3 v1.14.32
 )
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/services"
+)
+
+// ACLRequest replaces who an alarm or event is shared with. Entries are *
+// for everyone in the tenant, user:<principal> or group:<name>; the owner
+// and admins always have full access.
+type ACLRequest struct {
+	Viewers []string `json:"viewers" doc:"May read the resource"`
+	Editors []string `json:"editors" doc:"May read, update, relabel and delete the resource"`
+}
+
+// ACLResponse returns who an alarm or event is shared with
+type ACLResponse struct {
+	ID      string   `json:"id"`
+	Owner   string   `json:"owner" doc:"Principal that created the resource; only it and admins may change the ACL"`
+	Viewers []string `json:"viewers"`
+	Editors []string `json:"editors"`
+}
+
+// lists returns the viewers and editors to store. A missing ACL keeps the
+// storage default; a present one is taken as is, so {} makes the resource
+// private to its owner.
+func (req *ACLRequest) lists() (viewers, editors []string) {
+	if req == nil {
+		return nil, nil
+	}
+	viewers, editors = req.Viewers, req.Editors
+	if viewers == nil {
+		viewers = []string{}
+	}
+	if editors == nil {
+		editors = []string{}
+	}
+	return viewers, editors
+}
+
+// aclStore is the subset of storage used by the ACL handlers
+type aclStore interface {
+	FindByID(id string) (interface{}, error)
+	SetACL(id string, viewers, editors []string, expectedVersion int64) (int64, error)
+}
+
+func alarmACLHandler(w http.ResponseWriter, r *http.Request) {
+	aclHandler(w, r, alarmStore.WithContext(r.Context()), services.AuditAlarm, codeAlarmNotFound)
+}
+
+func eventACLHandler(w http.ResponseWriter, r *http.Request) {
+	aclHandler(w, r, eventStore.WithContext(r.Context()), services.AuditEvent, codeEventNotFound)
+}
+
+// aclHandler returns the ACL on GET, as of a past time if asked, and
+// replaces it on PUT, which requires an If-Match header carrying the
+// current ETag
+func aclHandler(w http.ResponseWriter, r *http.Request, store aclStore, resourceType, notFoundCode string) {
+	id := r.URL.Query().Get("id")
+	var at time.Time
+	switch r.Method {
+	case http.MethodGet:
+		var ok bool
+		if at, ok = asOf(w, r); !ok {
+			return
+		}
+	case http.MethodPut:
+		version, ok := requireIfMatch(w, r, store.FindByID, id, notFoundCode)
+		if !ok {
+			return
+		}
+		var req ACLRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		var verr services.ValidationError
+		viewers, editors := req.lists()
+		if err := services.ValidateACL(viewers); err != nil {
+			verr.Add("viewers", codeInvalidACL, err.Error())
+		}
+		if err := services.ValidateACL(editors); err != nil {
+			verr.Add("editors", codeInvalidACL, err.Error())
+		}
+		if err := verr.Err(); err != nil {
+			writeValidationProblem(w, r, err)
+			return
+		}
+		if _, err := store.SetACL(id, viewers, editors, version); err != nil {
+			writeVersionedWriteError(w, r, err, notFoundCode, "Failed to update ACL")
+			return
+		}
+	default:
+		writeProblem(w, r, codeMethodNotAllowed, "")
+		return
+	}
+	raw, err := findAt(r, store.FindByID, resourceType, id, at)
+	if err != nil {
+		writeLookupProblem(w, r, err, notFoundCode)
+		return
+	}
+	acl := aclOf(id, raw)
+	if !at.IsZero() {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(acl)
+		return
+	}
+	if r.Method == http.MethodGet && writeETag(w, r, resourceVersion(raw)) {
+		return
+	}
+	w.Header().Set("ETag", etag(resourceVersion(raw)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(acl)
+}
+
+func aclOf(id string, raw interface{}) ACLResponse {
+	acl := ACLResponse{ID: id}
+	switch v := raw.(type) {
+	case datapkg.Alarm:
+		acl.Owner, acl.Viewers, acl.Editors = v.Owner, v.Viewers, v.Editors
+	case datapkg.Event:
+		acl.Owner, acl.Viewers, acl.Editors = v.Owner, v.Viewers, v.Editors
+	}
+	return acl
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/services"
+)
+
+// withIfMatch sends every request through handler with an If-Match header
+func withIfMatch(handler http.Handler, tag string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("If-Match", tag)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func TestACL_SharesAlarmsBetweenKeys(t *testing.T) {
+	handler := enableAuth(t)
+	scopes := []string{services.ScopeAlarmsRead, services.ScopeAlarmsWrite}
+	_, alice, _ := apiKeyStore.Create("alice", scopes, "")
+	bobKey, bob, _ := apiKeyStore.Create("bob", scopes, "")
+
+	w := authRequest(handler, "POST", "/v1/alarms/create", alice,
+		`{"id":"a1","name":"standup","target":"2030-01-01T09:00:00Z","acl":{}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	if w := authRequest(handler, "GET", "/v1/alarms/countdown?id=a1", bob, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected a private alarm to look missing, got %d", w.Code)
+	}
+	if w := authRequest(handler, "GET", "/v1/alarms/list", bob, ""); strings.Contains(w.Body.String(), "a1") {
+		t.Errorf("expected a private alarm to be left out of lists, got %s", w.Body.String())
+	}
+
+	share := `{"viewers":["user:key:` + bobKey.ID + `"]}`
+	w = authRequest(withIfMatch(handler, `"1"`), "PUT", "/v1/alarms/acl?id=a1", alice, share)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("sharing failed: %d %s", w.Code, w.Body.String())
+	}
+	var acl ACLResponse
+	json.NewDecoder(w.Body).Decode(&acl)
+	if len(acl.Viewers) != 1 || len(acl.Editors) != 0 || !strings.HasPrefix(acl.Owner, "key:") {
+		t.Errorf("unexpected ACL %+v", acl)
+	}
+
+	if w := authRequest(handler, "GET", "/v1/alarms/countdown?id=a1", bob, ""); w.Code != http.StatusOK {
+		t.Errorf("expected a viewer to read the alarm, got %d", w.Code)
+	}
+	w = authRequest(withIfMatch(handler, `"2"`), "PATCH", "/v1/alarms/update?id=a1", bob, `{"name":"hijack"}`)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), codePermissionDenied) {
+		t.Errorf("expected a viewer not to update, got %d %s", w.Code, w.Body.String())
+	}
+	w = authRequest(withIfMatch(handler, `"2"`), "PUT", "/v1/alarms/acl?id=a1", bob, `{"editors":["*"]}`)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected a viewer not to reshare, got %d", w.Code)
+	}
+	if w := authRequest(withIfMatch(handler, `"2"`), "DELETE", "/v1/alarms/delete?id=a1", newKey(t, services.ScopeAdmin), ""); w.Code != http.StatusOK {
+		t.Errorf("expected an admin to bypass the ACL, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestACL_RejectsInvalidEntries(t *testing.T) {
+	handler := enableAuth(t)
+	key := newKey(t, services.ScopeEventsWrite)
+	w := authRequest(handler, "POST", "/v1/events/create", key, `{"name":"deploy","acl":{"viewers":["ops"]}}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), codeInvalidACL) {
+		t.Errorf("expected invalid_acl on create, got %d %s", w.Code, w.Body.String())
+	}
+	authRequest(handler, "POST", "/v1/events/create", key, `{"id":"e1","name":"deploy"}`)
+	w = authRequest(withIfMatch(handler, `"1"`), "PUT", "/v1/events/acl?id=e1", key, `{"editors":["team:ops"]}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), codeInvalidACL) {
+		t.Errorf("expected invalid_acl on PUT, got %d %s", w.Code, w.Body.String())
+	}
+	if w := authRequest(handler, "PUT", "/v1/events/acl?id=e1", key, `{}`); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected If-Match to be required, got %d", w.Code)
+	}
+}
+
+func TestJWT_GroupsClaimMatchesGroupEntries(t *testing.T) {
+	handler, idp := enableJWT(t, config.JWT{})
+	owner := idp.token(t, map[string]interface{}{"sub": "alice", "scope": "events:write events:read"})
+	member := idp.token(t, map[string]interface{}{"sub": "bob", "groups": []string{"ops"}, "scope": "events:read"})
+	other := idp.token(t, map[string]interface{}{"sub": "carol", "groups": []string{"dev"}, "scope": "events:read"})
+
+	body := `{"id":"e1","name":"deploy","acl":{"viewers":["group:ops"]}}`
+	if w := authRequest(handler, "POST", "/v1/events/create", owner, body); w.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	if w := authRequest(handler, "GET", "/v1/events/acl?id=e1", member, ""); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"owner":"jwt:|alice"`) {
+		t.Errorf("expected a group member to see the event, got %d %s", w.Code, w.Body.String())
+	}
+	if w := authRequest(handler, "GET", "/v1/events/elapsed?id=e1", other, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected another group not to see the event, got %d", w.Code)
+	}
+}
@@ -1,84 +1,130 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	datapkg "ClockAsService/src/data"
 	"ClockAsService/src/services"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // request shapes
 type AlarmRequest struct {
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Target      time.Time `json:"target"`
+	ID          string            `json:"id" openapi:"rule=id" doc:"Optional client-supplied ID; generated when omitted"`
+	Name        string            `json:"name" openapi:"required,rule=name"`
+	Description string            `json:"description" openapi:"rule=description"`
+	Target      time.Time         `json:"target" openapi:"required,rule=target"`
+	Labels      map[string]string `json:"labels"`
+	ACL         *ACLRequest       `json:"acl" doc:"Who the alarm is shared with; everyone in the tenant when omitted"`
 }
 
 type EventRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	ID          string            `json:"id" openapi:"rule=id" doc:"Optional client-supplied ID; generated when omitted"`
+	Name        string            `json:"name" openapi:"required,rule=name"`
+	Description string            `json:"description" openapi:"rule=description"`
+	Labels      map[string]string `json:"labels"`
+	ACL         *ACLRequest       `json:"acl" doc:"Who the event is shared with; everyone in the tenant when omitted"`
+}
+
+// AlarmCountdownResponse is returned by /alarms/countdown
+type AlarmCountdownResponse struct {
+	ID                string        `json:"id"`
+	Countdown         float64       `json:"countdown" doc:"Seconds until the target, never negative"`
+	CountdownDetailed string        `json:"countdown_detailed"`
+	Alarm             datapkg.Alarm `json:"alarm"`
+}
+
+// EventElapsedResponse is returned by /events/elapsed
+type EventElapsedResponse struct {
+	Elapsed         float64       `json:"elapsed" doc:"Seconds since the event started"`
+	ElapsedDetailed string        `json:"elapsed_detailed"`
+	Event           datapkg.Event `json:"event"`
 }
 
 var alarmStore *services.AlarmStorage
 var eventStore *services.EventStorage
 
-// helper to write JSON error responses
-func jsonError(w http.ResponseWriter, msg string, code int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+// alarmFromRequest validates a create request and builds the alarm to store
+// for owner. Failures are reported together as a *services.ValidationError.
+func alarmFromRequest(req AlarmRequest, owner string) (datapkg.Alarm, error) {
+	// normalize target to UTC; it must be in the future (server UTC)
+	alarm := datapkg.Alarm{
+		ID:          req.ID,
+		Name:        req.Name,
+		Description: req.Description,
+		Target:      req.Target.UTC(),
+		Labels:      req.Labels,
+		Owner:       owner,
+	}
+	alarm.Viewers, alarm.Editors = req.ACL.lists()
+	if err := validationRules.ValidateAlarm(alarm, time.Now().UTC()); err != nil {
+		return datapkg.Alarm{}, err
+	}
+	return alarm, nil
 }
 
 func createAlarmHandler(w http.ResponseWriter, r *http.Request) {
 	var req AlarmRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonError(w, "Invalid request", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	// normalize target to UTC and validate it must be in the future (server UTC)
-	req.Target = req.Target.UTC()
-	if req.Target.Before(time.Now().UTC()) {
-		jsonError(w, "Target must be in the future (in UTC)", http.StatusBadRequest)
+	alarm, err := alarmFromRequest(req, ownerOf(r))
+	if err != nil {
+		writeValidationProblem(w, r, err)
 		return
 	}
-	alarm := datapkg.Alarm{
-		Name:        req.Name,
-		Description: req.Description,
-		Target:      req.Target,
+	createdRaw, err := alarmStore.WithContext(r.Context()).Create(alarm)
+	if errors.Is(err, services.ErrAlreadyExists) {
+		writeProblem(w, r, codeAlreadyExists, "An alarm with id "+alarm.ID+" already exists")
+		return
+	}
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		writeProblem(w, r, codeQuotaExceeded, err.Error())
+		return
 	}
-	createdRaw, err := alarmStore.Create(alarm)
 	if err != nil {
-		jsonError(w, "Failed to create alarm", http.StatusInternalServerError)
+		writeStorageProblem(w, r, err, "Failed to create alarm")
 		return
 	}
 	created, ok := createdRaw.(datapkg.Alarm)
 	if !ok {
-		jsonError(w, "Internal error", http.StatusInternalServerError)
+		writeProblem(w, r, codeInternalError, "")
 		return
 	}
+	w.Header().Set("ETag", etag(created.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(created)
+	json.NewEncoder(w).Encode(presentResource(r, created))
 }
 
 func getAlarmCountdownHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
-	raw, err := alarmStore.FindByID(id)
+	at, ok := asOf(w, r)
+	if !ok {
+		return
+	}
+	raw, err := findAt(r, alarmStore.WithContext(r.Context()).FindByID, services.AuditAlarm, id, at)
 	if err != nil {
-		jsonError(w, "Alarm not found", http.StatusNotFound)
+		writeLookupProblem(w, r, err, codeAlarmNotFound)
 		return
 	}
 	alarm, ok := raw.(datapkg.Alarm)
 	if !ok {
-		jsonError(w, "Internal error", http.StatusInternalServerError)
+		writeProblem(w, r, codeInternalError, "")
 		return
 	}
-	countdown := time.Until(alarm.Target)
+	if at.IsZero() {
+		writeClockETag(w, alarm.Version)
+	}
+	now := presentedAt(at)
+	if apiVersionOf(r) == "v2" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(presentResourceAt(r, alarm, now))
+		return
+	}
+	countdown := alarm.Target.Sub(now)
 	// don't return negative countdowns; clamp to zero when target is reached or passed
 	seconds := countdown.Seconds()
 	if seconds < 0 {
@@ -86,67 +132,116 @@ func getAlarmCountdownHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	humanized := services.HumanizeDuration(seconds)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":              id,
-		"countdown":       seconds,
-		"countdown_detailed": humanized,
-		"alarm":           alarm,
+	json.NewEncoder(w).Encode(AlarmCountdownResponse{
+		ID:                id,
+		Countdown:         seconds,
+		CountdownDetailed: humanized,
+		Alarm:             alarm,
 	})
 }
 
-func createEventHandler(w http.ResponseWriter, r *http.Request) {
-	var req EventRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonError(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
+// eventFromRequest validates a create request and builds the event to store
+// for owner. Failures are reported together as a *services.ValidationError.
+func eventFromRequest(req EventRequest, owner string) (datapkg.Event, error) {
 	event := datapkg.Event{
+		ID:          req.ID,
 		Name:        req.Name,
 		Description: req.Description,
 		StartedAt:   time.Now(),
+		Labels:      req.Labels,
+		Owner:       owner,
+	}
+	event.Viewers, event.Editors = req.ACL.lists()
+	if err := validationRules.ValidateEvent(event); err != nil {
+		return datapkg.Event{}, err
 	}
-	createdRaw, err := eventStore.Create(event)
+	return event, nil
+}
+
+func createEventHandler(w http.ResponseWriter, r *http.Request) {
+	var req EventRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	event, err := eventFromRequest(req, ownerOf(r))
 	if err != nil {
-		jsonError(w, "Failed to create event", http.StatusInternalServerError)
+		writeValidationProblem(w, r, err)
+		return
+	}
+	createdRaw, err := eventStore.WithContext(r.Context()).Create(event)
+	if errors.Is(err, services.ErrAlreadyExists) {
+		writeProblem(w, r, codeAlreadyExists, "An event with id "+event.ID+" already exists")
+		return
+	}
+	if err != nil {
+		writeStorageProblem(w, r, err, "Failed to create event")
 		return
 	}
 	created, ok := createdRaw.(datapkg.Event)
 	if !ok {
-		jsonError(w, "Internal error", http.StatusInternalServerError)
+		writeProblem(w, r, codeInternalError, "")
 		return
 	}
+	w.Header().Set("ETag", etag(created.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(created)
+	json.NewEncoder(w).Encode(presentResource(r, created))
 }
 
 func getEventElapsedHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
-	raw, err := eventStore.FindByID(id)
+	at, ok := asOf(w, r)
+	if !ok {
+		return
+	}
+	raw, err := findAt(r, eventStore.WithContext(r.Context()).FindByID, services.AuditEvent, id, at)
 	if err != nil {
-		jsonError(w, "Event not found", http.StatusNotFound)
+		writeLookupProblem(w, r, err, codeEventNotFound)
 		return
 	}
 	event, ok := raw.(datapkg.Event)
 	if !ok {
-		jsonError(w, "Internal error", http.StatusInternalServerError)
+		writeProblem(w, r, codeInternalError, "")
+		return
+	}
+	if at.IsZero() {
+		writeClockETag(w, event.Version)
+	}
+	now := presentedAt(at)
+	if apiVersionOf(r) == "v2" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(presentResourceAt(r, event, now))
 		return
 	}
-	elapsed := time.Since(event.StartedAt)
+	elapsed := now.Sub(event.StartedAt)
 	seconds := elapsed.Seconds()
 	humanized := services.HumanizeDuration(seconds)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"elapsed":       seconds,
-		"elapsed_detailed": humanized,
-		"event":         event,
+	json.NewEncoder(w).Encode(EventElapsedResponse{
+		Elapsed:         seconds,
+		ElapsedDetailed: humanized,
+		Event:           event,
 	})
 }
 
 func listAlarmsHandler(w http.ResponseWriter, r *http.Request) {
-	raws, err := alarmStore.List()
+	sel, err := services.ParseSelector(r.URL.Query().Get("selector"))
 	if err != nil {
-		jsonError(w, "Failed to list alarms", http.StatusInternalServerError)
+		writeProblem(w, r, codeInvalidSelector, err.Error())
+		return
+	}
+	at, ok := asOf(w, r)
+	if !ok {
+		return
+	}
+	var raws []interface{}
+	if at.IsZero() {
+		raws, err = alarmStore.WithContext(r.Context()).ListSelected(sel)
+	} else {
+		raws, err = historyStore.WithContext(r.Context()).ListAsOf(services.AuditAlarm, sel, at)
+	}
+	if err != nil {
+		writeStorageProblem(w, r, err, "Failed to list alarms")
 		return
 	}
 	var alarms []datapkg.Alarm
@@ -156,13 +251,27 @@ func listAlarmsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(alarms)
+	json.NewEncoder(w).Encode(presentAlarms(r, alarms, presentedAt(at)))
 }
 
 func listEventsHandler(w http.ResponseWriter, r *http.Request) {
-	raws, err := eventStore.List()
+	sel, err := services.ParseSelector(r.URL.Query().Get("selector"))
 	if err != nil {
-		jsonError(w, "Failed to list events", http.StatusInternalServerError)
+		writeProblem(w, r, codeInvalidSelector, err.Error())
+		return
+	}
+	at, ok := asOf(w, r)
+	if !ok {
+		return
+	}
+	var raws []interface{}
+	if at.IsZero() {
+		raws, err = eventStore.WithContext(r.Context()).ListSelected(sel)
+	} else {
+		raws, err = historyStore.WithContext(r.Context()).ListAsOf(services.AuditEvent, sel, at)
+	}
+	if err != nil {
+		writeStorageProblem(w, r, err, "Failed to list events")
 		return
 	}
 	var events []datapkg.Event
@@ -172,34 +281,5 @@ func listEventsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(events)
-}
-
-func main() {
-	db, err := sql.Open("sqlite3", "clock.db")
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-
-	alarmStore = &services.AlarmStorage{DB: db}
-	eventStore = &services.EventStorage{DB: db}
-
-	if err := alarmStore.CreateTable(); err != nil {
-		panic(err)
-	}
-	if err := eventStore.CreateTable(); err != nil {
-		panic(err)
-	}
-
-	http.HandleFunc("/alarms/create", createAlarmHandler)
-	http.HandleFunc("/alarms/countdown", getAlarmCountdownHandler)
-	http.HandleFunc("/alarms/list", listAlarmsHandler)
-	http.HandleFunc("/events/create", createEventHandler)
-	http.HandleFunc("/events/elapsed", getEventElapsedHandler)
-	http.HandleFunc("/events/list", listEventsHandler)
-
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		panic(err)
-	}
+	json.NewEncoder(w).Encode(presentEvents(r, events, presentedAt(at)))
 }
@@ -29,12 +29,20 @@ func setupHandlersForTest(t *testing.T) {
 
 	alarmStore = &services.AlarmStorage{DB: db}
 	eventStore = &services.EventStorage{DB: db}
-	if err := alarmStore.CreateTable(); err != nil {
-		t.Fatalf("CreateTable alarm failed: %v", err)
-	}
-	if err := eventStore.CreateTable(); err != nil {
-		t.Fatalf("CreateTable event failed: %v", err)
+	searchStore = &services.SearchStorage{DB: db}
+	batchStore = &services.BatchStorage{DB: db}
+	idempotencyStore = &services.IdempotencyStorage{DB: db}
+	apiKeyStore = &services.APIKeyStorage{DB: db}
+	tenantStore = &services.TenantStorage{DB: db}
+	auditStore = &services.AuditStorage{DB: db}
+	historyStore = &services.HistoryStorage{DB: db}
+	if err := services.MigrateSchema(db, alarmStore, eventStore, searchStore, idempotencyStore, apiKeyStore, tenantStore, auditStore, historyStore); err != nil {
+		t.Fatalf("MigrateSchema failed: %v", err)
 	}
+	storageDB = db
+	limiter = newRateLimiter()
+	callerLimits.Store(RateLimits{})
+	ipPerMinute = 0
 }
 
 func TestCreateAlarm_RejectsPastTarget(t *testing.T) {
@@ -56,12 +64,18 @@ func TestCreateAlarm_RejectsPastTarget(t *testing.T) {
 	if resp.StatusCode != http.StatusBadRequest {
 		t.Fatalf("expected 400, got %d", resp.StatusCode)
 	}
-	var body map[string]string
+	if ct := resp.Header.Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("expected application/problem+json, got %q", ct)
+	}
+	var body Problem
 	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
 		t.Fatalf("failed to decode body: %v", err)
 	}
-	if _, ok := body["error"]; !ok {
-		t.Fatalf("expected error field in response")
+	if body.Code != codeTargetInPast || body.Status != http.StatusBadRequest {
+		t.Fatalf("expected %s problem, got %+v", codeTargetInPast, body)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Field != "target" {
+		t.Fatalf("expected a field error for target, got %+v", body.Errors)
 	}
 }
 
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ClockAsService/src/services"
+)
+
+// auditStore reads the audit log that the alarm, event and batch stores
+// append to
+var auditStore *services.AuditStorage
+
+// AuditPage is one page of audit entries
+type AuditPage struct {
+	Entries []services.AuditEntry `json:"entries"`
+	// NextAfter is set when the page is full, so more entries may follow
+	NextAfter int64 `json:"next_after,omitempty" doc:"Pass as after to read the next page; missing on the last page"`
+}
+
+// auditFilterParams select entries for both /audit and /audit/export
+var auditFilterParams = []param{
+	{Name: "resource_type", In: "query", Enum: []string{services.AuditAlarm, services.AuditEvent}, Description: "Only changes of this resource type"},
+	{Name: "resource_id", In: "query", Description: "Only changes of the alarm or event with this ID"},
+	{Name: "actor", In: "query", Description: "Only changes made by this principal"},
+	{Name: "action", In: "query", Enum: services.AuditActions, Description: "Only changes of this kind"},
+	{Name: "request_id", In: "query", Description: "Only changes made by the request with this X-Request-ID"},
+	{Name: "since", In: "query", Description: "Only changes at or after this RFC 3339 time"},
+	{Name: "until", In: "query", Description: "Only changes before this RFC 3339 time"},
+	{Name: "after", In: "query", Integer: true, Description: "Only entries after this seq"},
+}
+
+func auditRoutes() []route {
+	auditErrors := []string{codeInvalidParameter, codeValidationFailed, codeStorageUnavailable}
+	return []route{
+		{Path: "/audit", Handler: auditHandler, Ops: []operation{{
+			Method:  http.MethodGet,
+			Summary: "Changes made to the tenant's alarms and events, oldest first",
+			Description: "Every create, update, relabel, share, delete and firing is recorded with who made it, " +
+				"from which request, and the fields it changed. Only entries of alarms and events the caller may view " +
+				"are returned; admins also read those of deleted ones.",
+			Scopes: []string{services.ScopeAuditRead},
+			Params: append(append([]param(nil), auditFilterParams...),
+				param{Name: "limit", In: "query", Integer: true, Description: "Maximum number of entries, at most 1000"}),
+			Status:   http.StatusOK,
+			Response: AuditPage{},
+			Errors:   auditErrors,
+		}}},
+		{Path: "/audit/export", Handler: auditExportHandler, Ops: []operation{{
+			Method:      http.MethodGet,
+			Summary:     "Export the tenant's audit entries as JSON Lines",
+			Description: "Writes every matching entry the caller may read, as for /audit, oldest first, one JSON object per line.",
+			Scopes:      []string{services.ScopeAuditRead},
+			Params:      auditFilterParams,
+			Status:      http.StatusOK,
+			Response:    services.AuditEntry{},
+			ContentType: "application/x-ndjson",
+			Errors:      auditErrors,
+		}}},
+		{Path: "/admin/audit/verify", Handler: auditVerifyHandler, Global: true, Ops: []operation{{
+			Method:  http.MethodGet,
+			Summary: "Check the hash chain of the whole audit log",
+			Description: "Recomputes the hash of every entry of every tenant. valid is false, and broken_at names the first " +
+				"bad entry, if an entry was changed or removed outside the service.",
+			Scopes:   []string{services.ScopeAdmin},
+			Status:   http.StatusOK,
+			Response: services.AuditVerification{},
+			Errors:   []string{codeStorageUnavailable},
+		}}},
+	}
+}
+
+// auditFilter reads the filters of an audit request, writing a problem and
+// returning false if one is invalid
+func auditFilter(w http.ResponseWriter, r *http.Request) (services.AuditFilter, bool) {
+	query := r.URL.Query()
+	f := services.AuditFilter{
+		ResourceType: query.Get("resource_type"),
+		ResourceID:   query.Get("resource_id"),
+		Actor:        query.Get("actor"),
+		Action:       query.Get("action"),
+		RequestID:    query.Get("request_id"),
+	}
+	var verr services.ValidationError
+	for _, t := range []struct {
+		name string
+		into *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if raw := query.Get(t.name); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				verr.Add(t.name, codeInvalidParameter, "must be an RFC 3339 time such as 2030-01-01T09:00:00Z")
+			}
+			*t.into = parsed
+		}
+	}
+	if raw := query.Get("after"); raw != "" {
+		after, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || after < 0 {
+			verr.Add("after", codeInvalidParameter, "must be a non-negative integer")
+		}
+		f.After = after
+	}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > services.MaxAuditPage {
+			verr.Add("limit", codeInvalidParameter, "must be an integer from 1 to "+strconv.Itoa(services.MaxAuditPage))
+		}
+		f.Limit = limit
+	}
+	if err := verr.Err(); err != nil {
+		writeValidationProblem(w, r, err)
+		return f, false
+	}
+	return f, true
+}
+
+// auditHandler returns a page of the tenant's audit entries
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := auditFilter(w, r)
+	if !ok {
+		return
+	}
+	if f.Limit == 0 {
+		f.Limit = services.MaxAuditPage
+	}
+	entries, err := auditStore.WithContext(r.Context()).List(f)
+	if err != nil {
+		writeStorageProblem(w, r, err, "Failed to read the audit log")
+		return
+	}
+	page := AuditPage{Entries: entries}
+	if len(entries) == f.Limit {
+		page.NextAfter = entries[len(entries)-1].Seq
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// auditExportHandler streams the tenant's audit entries as JSON Lines
+func auditExportHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := auditFilter(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	enc := json.NewEncoder(w)
+	written := 0
+	err := auditStore.WithContext(r.Context()).Export(f, func(e services.AuditEntry) error {
+		written++
+		return enc.Encode(e)
+	})
+	if err == nil {
+		return
+	}
+	// once an entry is written the status is sent; the client sees a
+	// truncated export
+	if written == 0 {
+		w.Header().Del("Content-Disposition")
+		writeStorageProblem(w, r, err, "Failed to export the audit log")
+		return
+	}
+	requestLogger(r).Error("audit export interrupted", "error", err, "entries", written)
+}
+
+// auditVerifyHandler checks the hash chain of the whole log
+func auditVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	v, err := auditStore.WithContext(r.Context()).Verify()
+	if err != nil {
+		writeStorageProblem(w, r, err, "Failed to verify the audit log")
+		return
+	}
+	if !v.Valid {
+		requestLogger(r).Error("audit log hash chain is broken", "broken_at", v.BrokenAt)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
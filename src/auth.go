@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"ClockAsService/src/services"
+)
+
+// apiKeyStore holds the keys accepted when authentication is enabled
+var apiKeyStore *services.APIKeyStorage
+
+// keyUsageFlushInterval is how often the last use and request count of API
+// keys are written to the database
+const keyUsageFlushInterval = time.Minute
+
+// startKeyUsageFlush writes API key use every interval in the background.
+// The returned step stops it and writes what is left.
+func startKeyUsageFlush(keys *services.APIKeyStorage, interval time.Duration) shutdownStep {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := keys.FlushUsage(); err != nil {
+					slog.Error("record API key use", "error", err)
+				}
+			}
+		}
+	}()
+	return shutdownStep{"api key usage", func(ctx context.Context) error {
+		close(done)
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return keys.FlushUsage()
+	}}
+}
+
+// authEnabled is set from auth.mode. Without it every route is served
+// without a key and scope checks pass.
+var authEnabled bool
+
+// principal is the caller a request was authenticated as
+type principal struct {
+	// ID is recorded as the owner of what the caller creates: key:<id> for
+	// an API key, cert:<name> for a client certificate and
+	// jwt:<issuer>|<principal claim> for a JWT
+	ID     string
+	Scopes []string
+	// Tenant is the only tenant the caller may act in, empty when it is
+	// not bound to one; see withTenant
+	Tenant string
+	// Groups are matched against group: entries of ACLs
+	Groups []string
+}
+
+// has reports whether the principal was granted scope
+func (p principal) has(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == services.ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// principalOf returns the caller that r was authenticated as
+func principalOf(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// ownerOf is the owner recorded for resources r creates, empty when
+// authentication is off
+func ownerOf(r *http.Request) string {
+	p, _ := principalOf(r)
+	return p.ID
+}
+
+// credential returns the API key or JWT sent as a bearer token, or the API
+// key sent in X-API-Key
+func credential(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// requireScopes reports whether the request's caller holds every scope,
+// writing an insufficient_scope problem when it does not
+func requireScopes(w http.ResponseWriter, r *http.Request, scopes ...string) bool {
+	if !authEnabled {
+		return true
+	}
+	caller, _ := principalOf(r)
+	var missing []string
+	for _, scope := range scopes {
+		if !caller.has(scope) && !containsString(missing, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		writeProblem(w, r, codeInsufficientScope, "requires scope "+strings.Join(missing, ", "))
+		return false
+	}
+	return true
+}
+
+// writeUnauthorized rejects a request without a valid key or token
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="clock"`)
+	writeProblem(w, r, codeUnauthorized, detail)
+}
+
+// withAuth authenticates every request to a non-public route, with an API
+// key, when auth.jwt.jwks is set a JWT or, when tls.client_ca_file is set
+// and no key or token is sent, a client certificate, and checks the scopes
+// of its operation. Paths outside rts need a key too, so probing without one
+// reveals nothing.
+func withAuth(mux *http.ServeMux, rts []route, next http.Handler) http.Handler {
+	byPath := map[string]route{}
+	for _, rt := range rts {
+		byPath[rt.Path] = rt
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authEnabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rt, known := byPath[routeOf(mux, r)]
+		if known && rt.Public {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret := credential(r)
+		var caller principal
+		switch cert := clientCertificate(r); {
+		case secret == "" && cert != nil:
+			var err error
+			if caller, err = certPrincipal(cert); err != nil {
+				writeUnauthorized(w, r, err.Error())
+				return
+			}
+		case secret == "":
+			writeUnauthorized(w, r, "send an API key as Authorization: Bearer <key> or in X-API-Key")
+			return
+		case tokenAuth != nil && isJWT(secret):
+			var err error
+			if caller, err = tokenAuth.authenticate(r.Context(), secret); err != nil {
+				requestLogger(r).Info("JWT rejected", "error", err)
+				writeUnauthorized(w, r, "invalid token: "+strings.TrimPrefix(err.Error(), "jwt: "))
+				return
+			}
+		default:
+			key, err := apiKeyStore.Authenticate(secret, time.Now())
+			if errors.Is(err, sql.ErrNoRows) {
+				writeUnauthorized(w, r, "unknown or revoked API key")
+				return
+			}
+			if err != nil {
+				writeStorageProblem(w, r, err, "")
+				return
+			}
+			caller = principal{ID: "key:" + key.ID, Scopes: key.Scopes, Tenant: key.Tenant}
+		}
+		ctx := context.WithValue(r.Context(), principalKey{}, caller)
+		ctx = services.WithActor(ctx, services.Actor{ID: caller.ID, Groups: caller.Groups, Admin: caller.has(services.ScopeAdmin)})
+		r = r.WithContext(ctx)
+		if known && !requireScopes(w, r, operationScopes(rt, r.Method)...) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// operationScopes are the scopes required to call rt with method. A method
+// the route does not document needs every scope of the route, so a handler
+// that does not check its method cannot be reached with fewer.
+func operationScopes(rt route, method string) []string {
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	var all []string
+	for _, op := range rt.Ops {
+		if op.Method == method {
+			return op.Scopes
+		}
+		all = append(all, op.Scopes...)
+	}
+	return all
+}
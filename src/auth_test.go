@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ClockAsService/src/services"
+)
+
+// enableAuth turns authentication on until the test ends and returns a
+// handler that enforces it in front of the routes
+func enableAuth(t *testing.T) http.Handler {
+	t.Helper()
+	setupHandlersForTest(t)
+	authEnabled = true
+	t.Cleanup(func() { authEnabled = false })
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	return withAuth(mux, routes(), withTenant(mux, routes(), withRateLimit(mux, routes(), mux)))
+}
+
+func newKey(t *testing.T, scopes ...string) string {
+	t.Helper()
+	_, secret, err := apiKeyStore.Create("test", scopes, "")
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	return secret
+}
+
+func authRequest(handler http.Handler, method, target, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuth_EveryRouteNeedsAKeyUnlessPublic(t *testing.T) {
+	handler := enableAuth(t)
+	for _, rt := range routes() {
+		for _, op := range rt.Ops {
+			w := authRequest(handler, op.Method, rt.Path, "", "")
+			if rt.Public {
+				if w.Code == http.StatusUnauthorized {
+					t.Errorf("%s %s is public but asked for a key", op.Method, rt.Path)
+				}
+				continue
+			}
+			if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" ||
+				!strings.Contains(w.Body.String(), `"code":"unauthorized"`) {
+				t.Errorf("%s %s without a key: %d %s", op.Method, rt.Path, w.Code, w.Body.String())
+			}
+		}
+	}
+	if w := authRequest(handler, "GET", "/no/such/path", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unknown paths to need a key, got %d", w.Code)
+	}
+}
+
+func TestAuth_EnforcesScopes(t *testing.T) {
+	handler := enableAuth(t)
+	reader := newKey(t, services.ScopeAlarmsRead)
+	writer := newKey(t, services.ScopeAlarmsWrite, services.ScopeEventsRead)
+	admin := newKey(t, services.ScopeAdmin)
+	create := `{"id":"a1","name":"wake","target":"2030-01-01T00:00:00Z"}`
+
+	tests := []struct {
+		name, method, target, key, body string
+		want                            int
+	}{
+		{"unknown key", "GET", "/v1/alarms/list", "ck_unknown", "", http.StatusUnauthorized},
+		{"read", "GET", "/v1/alarms/list", reader, "", http.StatusOK},
+		{"HEAD is a read", "HEAD", "/v1/alarms/list", reader, "", http.StatusOK},
+		{"write without scope", "POST", "/v1/alarms/create", reader, create, http.StatusForbidden},
+		{"other kind", "GET", "/v1/events/list", reader, "", http.StatusForbidden},
+		{"write", "POST", "/alarms/create", writer, create, http.StatusCreated},
+		{"undocumented method needs every scope", "PUT", "/v1/alarms/list", writer, "", http.StatusForbidden},
+		{"admin route", "GET", "/metrics", writer, "", http.StatusForbidden},
+		{"admin grants everything", "GET", "/metrics", admin, "", http.StatusOK},
+		{"search both kinds", "GET", "/v1/search?q=wake", reader, "", http.StatusForbidden},
+		{"search one kind", "GET", "/v1/search?q=wake&type=alarm", reader, "", http.StatusOK},
+		{"batch needs every kind's write scope", "POST", "/v1/batch", writer,
+			`{"operations":[{"op":"delete","type":"alarm","id":"a1"},{"op":"delete","type":"event","id":"e1"}]}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := authRequest(handler, tt.method, tt.target, tt.key, tt.body)
+		if w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d %s", tt.name, tt.want, w.Code, w.Body.String())
+		}
+		if tt.want == http.StatusForbidden && !strings.Contains(w.Body.String(), "requires scope") {
+			t.Errorf("%s: expected the missing scope in %s", tt.name, w.Body.String())
+		}
+	}
+}
+
+func TestAuth_RecordsUsageAndHonoursRevocation(t *testing.T) {
+	handler := enableAuth(t)
+	key, secret, err := apiKeyStore.Create("ci", []string{services.ScopeAlarmsRead}, "")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/v1/alarms/list", nil)
+	req.Header.Set("X-API-Key", secret)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	authRequest(handler, "GET", "/v1/alarms/list", secret, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected X-API-Key to be accepted, got %d", w.Code)
+	}
+	keys, _ := apiKeyStore.List()
+	if len(keys) != 1 || keys[0].RequestCount != 2 || keys[0].LastUsedAt.IsZero() {
+		t.Fatalf("expected two recorded requests, got %+v", keys)
+	}
+
+	apiKeyStore.Revoke(key.ID)
+	if w := authRequest(handler, "GET", "/v1/alarms/list", secret, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a revoked key to be rejected, got %d", w.Code)
+	}
+}
+
+func TestAuth_Disabled(t *testing.T) {
+	setupHandlersForTest(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	if w := authRequest(withAuth(mux, routes(), mux), "GET", "/v1/alarms/list", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected requests to pass with auth disabled, got %d", w.Code)
+	}
+}
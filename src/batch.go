@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/services"
+)
+
+var batchStore *services.BatchStorage
+
+// maxBatchSize caps the number of operations accepted by /batch
+const maxBatchSize = 100
+
+// BatchRequest is the body of POST /batch
+type BatchRequest struct {
+	Atomic     bool                    `json:"atomic" doc:"Apply every operation or none"`
+	Operations []BatchOperationRequest `json:"operations" openapi:"required"`
+}
+
+// BatchOperationRequest is one operation of a batch. data holds an
+// AlarmRequest/EventRequest for create and an AlarmUpdateRequest/
+// EventUpdateRequest for update; version is required for update and delete.
+type BatchOperationRequest struct {
+	Op      string          `json:"op" openapi:"required" doc:"create, update or delete"`
+	Type    string          `json:"type" openapi:"required" doc:"alarm or event"`
+	ID      string          `json:"id"`
+	Version int64           `json:"version" doc:"Expected version, required for update and delete"`
+	Data    json.RawMessage `json:"data" doc:"Create or update fields for the resource type"`
+}
+
+// BatchOperationResult reports the outcome of the operation at Index
+type BatchOperationResult struct {
+	Index    int                   `json:"index"`
+	Status   int                   `json:"status"`
+	ID       string                `json:"id,omitempty"`
+	Resource interface{}           `json:"resource,omitempty"`
+	Code     string                `json:"code,omitempty"`
+	Error    string                `json:"error,omitempty"`
+	Errors   []services.FieldError `json:"errors,omitempty"`
+}
+
+// BatchResponse is returned by /batch
+type BatchResponse struct {
+	Atomic  bool                   `json:"atomic"`
+	Results []BatchOperationResult `json:"results"`
+}
+
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, codeMethodNotAllowed, "")
+		return
+	}
+	var req BatchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Operations) == 0 {
+		writeProblem(w, r, codeValidationFailed, "operations must not be empty", services.FieldError{
+			Field: "operations", Code: services.CodeRequired, Message: "operations must not be empty",
+		})
+		return
+	}
+	if len(req.Operations) > maxBatchSize {
+		writeProblem(w, r, codeBatchTooLarge, fmt.Sprintf("batch exceeds the maximum of %d operations", maxBatchSize))
+		return
+	}
+	var scopes []string
+	for _, op := range req.Operations {
+		switch op.Type {
+		case services.BatchAlarm:
+			scopes = append(scopes, services.ScopeAlarmsWrite)
+		case services.BatchEvent:
+			scopes = append(scopes, services.ScopeEventsWrite)
+		}
+	}
+	if !requireScopes(w, r, scopes...) {
+		return
+	}
+
+	results := make([]BatchOperationResult, len(req.Operations))
+	var ops []services.BatchOperation
+	var opIndex []int
+	invalid := false
+	for i, opReq := range req.Operations {
+		results[i] = BatchOperationResult{Index: i, ID: opReq.ID}
+		op, err := buildBatchOperation(opReq, ownerOf(r))
+		if err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Code = codeValidationFailed
+			results[i].Error = err.Error()
+			var verr *services.ValidationError
+			if errors.As(err, &verr) {
+				results[i].Errors = verr.Errors
+				if _, ok := problemTypes[verr.Errors[0].Code]; ok && len(verr.Errors) == 1 {
+					results[i].Code = verr.Errors[0].Code
+				}
+			}
+			invalid = true
+			continue
+		}
+		ops = append(ops, op)
+		opIndex = append(opIndex, i)
+	}
+	// an atomic batch with invalid operations is rejected before touching storage
+	if invalid && req.Atomic {
+		for i := range results {
+			if results[i].Status == 0 {
+				results[i].Status, results[i].Code, results[i].Error = batchError(services.ErrBatchAborted)
+			}
+		}
+		writeBatchResponse(w, http.StatusBadRequest, req.Atomic, results)
+		return
+	}
+
+	outcomes, err := batchStore.WithContext(r.Context()).Execute(ops, req.Atomic)
+	if err != nil {
+		writeStorageProblem(w, r, err, "Failed to execute batch")
+		return
+	}
+	status := http.StatusOK
+	for n, outcome := range outcomes {
+		i := opIndex[n]
+		if outcome.Err != nil {
+			results[i].Status, results[i].Code, results[i].Error = batchError(outcome.Err)
+			if results[i].Code == codeStorageUnavailable {
+				logStorageError(r, outcome.Err)
+			}
+			if req.Atomic && outcome.Err != services.ErrBatchAborted {
+				status = results[i].Status
+			}
+			continue
+		}
+		results[i].Status = http.StatusOK
+		if ops[n].Op == services.BatchCreate {
+			results[i].Status = http.StatusCreated
+		}
+		if outcome.Resource != nil {
+			results[i].Resource = presentResource(r, outcome.Resource)
+			switch v := outcome.Resource.(type) {
+			case datapkg.Alarm:
+				results[i].ID = v.ID
+			case datapkg.Event:
+				results[i].ID = v.ID
+			}
+		}
+	}
+	writeBatchResponse(w, status, req.Atomic, results)
+}
+
+// buildBatchOperation validates one operation and converts it for storage.
+// Created resources belong to owner.
+func buildBatchOperation(req BatchOperationRequest, owner string) (services.BatchOperation, error) {
+	op := services.BatchOperation{Op: req.Op, Type: req.Type, ID: req.ID, Version: req.Version}
+	if req.Type != services.BatchAlarm && req.Type != services.BatchEvent {
+		return op, errors.New("type must be alarm or event")
+	}
+	switch req.Op {
+	case services.BatchCreate:
+		if len(req.Data) == 0 {
+			return op, errors.New("data is required for create")
+		}
+		if req.Type == services.BatchAlarm {
+			var data AlarmRequest
+			if err := decodeStrict(req.Data, &data); err != nil {
+				return op, dataError(err)
+			}
+			alarm, err := alarmFromRequest(data, owner)
+			if err != nil {
+				return op, err
+			}
+			op.Resource = alarm
+		} else {
+			var data EventRequest
+			if err := decodeStrict(req.Data, &data); err != nil {
+				return op, dataError(err)
+			}
+			event, err := eventFromRequest(data, owner)
+			if err != nil {
+				return op, err
+			}
+			op.Resource = event
+		}
+	case services.BatchUpdate:
+		if req.ID == "" || req.Version <= 0 {
+			return op, errors.New("id and version are required for update")
+		}
+		if len(req.Data) == 0 {
+			return op, errors.New("data is required for update")
+		}
+		if req.Type == services.BatchAlarm {
+			var data AlarmUpdateRequest
+			if err := decodeStrict(req.Data, &data); err != nil {
+				return op, dataError(err)
+			}
+			// validate up front so the error is reported against this index
+			if _, err := applyAlarmUpdate(datapkg.Alarm{}, data); err != nil {
+				return op, err
+			}
+			op.Apply = func(current interface{}) (interface{}, error) {
+				return applyAlarmUpdate(current.(datapkg.Alarm), data)
+			}
+		} else {
+			var data EventUpdateRequest
+			if err := decodeStrict(req.Data, &data); err != nil {
+				return op, dataError(err)
+			}
+			if _, err := applyEventUpdate(datapkg.Event{}, data); err != nil {
+				return op, err
+			}
+			op.Apply = func(current interface{}) (interface{}, error) {
+				return applyEventUpdate(current.(datapkg.Event), data)
+			}
+		}
+	case services.BatchDelete:
+		if req.ID == "" || req.Version <= 0 {
+			return op, errors.New("id and version are required for delete")
+		}
+	default:
+		return op, errors.New("op must be create, update or delete")
+	}
+	return op, nil
+}
+
+// dataError reports a batch operation whose data could not be decoded,
+// naming the offending field where encoding/json allows it
+func dataError(err error) error {
+	var verr services.ValidationError
+	var typeErr *json.UnmarshalTypeError
+	if field, ok := unknownField(err); ok {
+		verr.Add("data."+field, codeUnknownField, "unknown field")
+	} else if errors.As(err, &typeErr) {
+		verr.Add("data."+typeErr.Field, codeInvalidFieldType, "expected "+typeErr.Type.String())
+	} else {
+		verr.Add("data", codeInvalidJSON, err.Error())
+	}
+	return &verr
+}
+
+// batchError maps a storage error from a batch operation to a status, problem
+// code and message
+func batchError(err error) (int, string, string) {
+	var code string
+	switch {
+	case errors.Is(err, services.ErrBatchAborted):
+		return http.StatusFailedDependency, codeBatchAborted, err.Error()
+	case errors.Is(err, services.ErrAlreadyExists):
+		code = codeAlreadyExists
+	case errors.Is(err, sql.ErrNoRows):
+		code = codeResourceNotFound
+	case errors.Is(err, services.ErrVersionMismatch):
+		code = codeVersionMismatch
+	case errors.Is(err, services.ErrQuotaExceeded):
+		code = codeQuotaExceeded
+	case errors.Is(err, services.ErrPermissionDenied):
+		code = codePermissionDenied
+	default:
+		code = codeStorageUnavailable
+	}
+	pt := problemTypes[code]
+	return pt.Status, code, pt.Title
+}
+
+func writeBatchResponse(w http.ResponseWriter, status int, atomic bool, results []BatchOperationResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(BatchResponse{Atomic: atomic, Results: results})
+}
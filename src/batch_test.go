@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func postBatch(t *testing.T, body interface{}) (*httptest.ResponseRecorder, BatchResponse) {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/batch", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	batchHandler(w, req)
+	var resp BatchResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestBatch_AtomicValidationErrors(t *testing.T) {
+	setupHandlersForTest(t)
+
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	w, resp := postBatch(t, map[string]interface{}{
+		"atomic": true,
+		"operations": []map[string]interface{}{
+			{"op": "create", "type": "alarm", "data": map[string]interface{}{"name": "ok", "target": future}},
+			{"op": "create", "type": "alarm", "data": map[string]interface{}{"name": "late", "target": past}},
+			{"op": "delete", "type": "event", "id": "x"},
+		},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	expected := []int{http.StatusFailedDependency, http.StatusBadRequest, http.StatusBadRequest}
+	for i, status := range expected {
+		if resp.Results[i].Status != status {
+			t.Errorf("operation %d: expected status %d, got %d (%s)", i, status, resp.Results[i].Status, resp.Results[i].Error)
+		}
+	}
+	list, _ := alarmStore.List()
+	if len(list) != 0 {
+		t.Fatalf("expected no alarms created, got %d", len(list))
+	}
+}
+
+func TestBatch_BestEffortCreatesValidOperations(t *testing.T) {
+	setupHandlersForTest(t)
+
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	w, resp := postBatch(t, map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"op": "create", "type": "alarm", "data": map[string]interface{}{"name": "a", "target": future}},
+			{"op": "create", "type": "event", "data": map[string]interface{}{"id": "e1", "name": "e"}},
+			{"op": "update", "type": "event", "id": "e1", "version": 1, "data": map[string]interface{}{"name": "renamed"}},
+			{"op": "explode", "type": "event"},
+		},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	expected := []int{http.StatusCreated, http.StatusCreated, http.StatusOK, http.StatusBadRequest}
+	for i, status := range expected {
+		if resp.Results[i].Status != status {
+			t.Errorf("operation %d: expected status %d, got %d (%s)", i, status, resp.Results[i].Status, resp.Results[i].Error)
+		}
+	}
+	if resp.Results[0].ID == "" {
+		t.Errorf("expected generated id in result")
+	}
+}
+
+func TestBatch_RejectsOversizedBatch(t *testing.T) {
+	setupHandlersForTest(t)
+
+	ops := make([]map[string]interface{}, maxBatchSize+1)
+	for i := range ops {
+		ops[i] = map[string]interface{}{"op": "delete", "type": "alarm", "id": "x", "version": 1}
+	}
+	w, _ := postBatch(t, map[string]interface{}{"operations": ops})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", w.Code)
+	}
+}
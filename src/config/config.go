@@ -0,0 +1,615 @@
+// Package config loads the server configuration. Every setting can come from
+// a TOML file, a CLOCK_* environment variable or a command-line flag; flags
+// override the environment, which overrides the file, which overrides the
+// defaults.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ClockAsService/src/services"
+)
+
+// Config is the effective server configuration
+type Config struct {
+	Listen         string
+	TLS            TLS
+	Database       string
+	MaxHeaderBytes int
+	Timeouts       Timeouts
+	Log            Log
+	CORS           CORS
+	Auth           Auth
+	RateLimit      RateLimit
+	Scheduler      Scheduler
+	Tracing        Tracing
+	Retention      Retention
+	Validation     services.ValidationRules
+
+	// File is the config file that was read, if any
+	File string
+	// sources records where each setting's value came from
+	sources map[string]string
+}
+
+// Timeouts bound the HTTP server's connections and its shutdown
+type Timeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	Shutdown   time.Duration
+}
+
+// TLS serves HTTPS with the certificate and key in CertFile and KeyFile,
+// which are read again when they change. ClientCA turns on mutual TLS.
+type TLS struct {
+	CertFile string
+	KeyFile  string
+	// ClientCA is a PEM bundle of the CAs client certificates must chain to
+	ClientCA string
+	// ClientAuth is optional, where callers without a certificate
+	// authenticate with a key or token, or require
+	ClientAuth string
+	// RedirectListen is an address whose plain HTTP requests are redirected
+	// to HTTPS; empty serves no redirects
+	RedirectListen string
+	// Reload is how often the files are checked for changes
+	Reload time.Duration
+}
+
+// Log configures logging
+type Log struct {
+	Level  string
+	Format string
+}
+
+// CORS lets browsers on the listed origins call the API. Tenants may allow
+// further origins of their own.
+type CORS struct {
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders may be sent by browsers, and ExposedHeaders read by
+	// their scripts
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// Auth controls how requests are authenticated
+type Auth struct {
+	Mode    string
+	APIKeys []string
+	JWT     JWT
+}
+
+// JWT configures bearer JWTs issued by an identity provider. They are
+// accepted alongside API keys when JWKS is set.
+type JWT struct {
+	// JWKS is the file path or http(s) URL of the provider's public keys
+	JWKS     string
+	Refresh  time.Duration
+	Issuer   string
+	Audience string
+	// PrincipalClaim names the caller, who becomes the owner of what they
+	// create
+	PrincipalClaim string
+	// ScopesClaim holds the caller's scopes, space-separated or as an array
+	ScopesClaim string
+	// ScopePrefix is stripped from the provider's scope names; scopes
+	// without it are ignored
+	ScopePrefix string
+	// TenantClaim binds the caller to the tenant it names; tokens without
+	// it are not bound. Empty ignores tenants in tokens.
+	TenantClaim string
+	// GroupsClaim lists the groups the caller belongs to, which ACLs can
+	// share resources with. Empty ignores groups in tokens.
+	GroupsClaim string
+}
+
+// RateLimit bounds the requests each caller, an API key, a JWT principal
+// or without authentication a client IP, may make per minute. Reads are GET
+// requests and writes every other method. PerIPPerMinute bounds every
+// request from one client IP before it is authenticated. Zero disables a
+// limit.
+type RateLimit struct {
+	ReadsPerMinute  int
+	WritesPerMinute int
+	PerIPPerMinute  int
+}
+
+// Scheduler configures the background worker that fires alarms
+type Scheduler struct {
+	Tick time.Duration
+}
+
+// Tracing selects where spans are exported
+type Tracing struct {
+	Exporter     string
+	File         string
+	OTLPEndpoint string
+}
+
+// Retention bounds how long stored data is kept. Zero keeps it forever.
+type Retention struct {
+	IdempotencyKeys time.Duration
+	FiredAlarms     time.Duration
+}
+
+// Default returns the configuration used when nothing is set
+func Default() Config {
+	return Config{
+		Listen:         ":8080",
+		TLS:            TLS{ClientAuth: "optional", Reload: 10 * time.Second},
+		Database:       "clock.db",
+		MaxHeaderBytes: 64 << 10,
+		Timeouts: Timeouts{
+			Read:       15 * time.Second,
+			ReadHeader: 5 * time.Second,
+			Write:      30 * time.Second,
+			Idle:       2 * time.Minute,
+			Shutdown:   20 * time.Second,
+		},
+		Log:       Log{Level: "info", Format: "json"},
+		Scheduler: Scheduler{Tick: time.Second},
+		RateLimit: RateLimit{ReadsPerMinute: 600, WritesPerMinute: 120, PerIPPerMinute: 1200},
+		CORS: CORS{
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{
+				"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match",
+				"Idempotency-Key", "X-API-Key", "X-Request-ID", "X-Tenant",
+			},
+			ExposedHeaders: []string{
+				"Deprecation", "ETag", "Idempotent-Replayed", "Link", "RateLimit-Limit", "RateLimit-Policy",
+				"RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "Sunset", "X-Request-ID",
+			},
+			MaxAge: 10 * time.Minute,
+		},
+		Auth: Auth{
+			Mode: "api_key",
+			JWT:  JWT{Refresh: time.Hour, PrincipalClaim: "sub", ScopesClaim: "scope", TenantClaim: "tenant", GroupsClaim: "groups"},
+		},
+		Tracing:    Tracing{Exporter: "none", OTLPEndpoint: "http://localhost:4318/v1/traces"},
+		Retention:  Retention{IdempotencyKeys: services.DefaultIdempotencyRetention},
+		Validation: services.DefaultValidationRules(),
+	}
+}
+
+// logLevels, logFormats, tracingExporters, authModes and clientAuthModes are
+// the accepted values of log.level, log.format, tracing.exporter, auth.mode
+// and tls.client_auth
+var (
+	logLevels        = []string{"debug", "info", "warn", "error"}
+	logFormats       = []string{"json", "text"}
+	tracingExporters = []string{"none", "stdout", "file", "otlp"}
+	authModes        = []string{"api_key", "none"}
+	clientAuthModes  = []string{"optional", "require"}
+)
+
+// setting is one configurable value. Key names it in the file, as a flag and,
+// upper-cased with dots replaced by underscores and prefixed with CLOCK_, in
+// the environment.
+type setting struct {
+	key    string
+	usage  string
+	secret bool
+	ptr    interface{}
+}
+
+// settings lists every setting of c in the order they are printed
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "listen", usage: "address the HTTP server listens on", ptr: &c.Listen},
+		{key: "tls.cert_file", usage: "PEM certificate chain to serve HTTPS with; empty serves plain HTTP", ptr: &c.TLS.CertFile},
+		{key: "tls.key_file", usage: "PEM private key of tls.cert_file", ptr: &c.TLS.KeyFile},
+		{key: "tls.client_ca_file", usage: "PEM bundle of CAs client certificates are verified against; empty disables mutual TLS", ptr: &c.TLS.ClientCA},
+		{key: "tls.client_auth", usage: "whether clients must present a certificate: " + strings.Join(clientAuthModes, ", "), ptr: &c.TLS.ClientAuth},
+		{key: "tls.redirect_listen", usage: "address redirecting plain HTTP to HTTPS; empty disables it", ptr: &c.TLS.RedirectListen},
+		{key: "tls.reload", usage: "how often the TLS files are checked for changes", ptr: &c.TLS.Reload},
+		{key: "database", usage: "path of the SQLite database", ptr: &c.Database},
+		{key: "max_header_bytes", usage: "maximum size of request headers in bytes", ptr: &c.MaxHeaderBytes},
+		{key: "timeouts.read", usage: "maximum time to read a request", ptr: &c.Timeouts.Read},
+		{key: "timeouts.read_header", usage: "maximum time to read request headers", ptr: &c.Timeouts.ReadHeader},
+		{key: "timeouts.write", usage: "maximum time to write a response", ptr: &c.Timeouts.Write},
+		{key: "timeouts.idle", usage: "how long idle keep-alive connections are kept", ptr: &c.Timeouts.Idle},
+		{key: "timeouts.shutdown", usage: "how long shutdown waits for in-flight requests", ptr: &c.Timeouts.Shutdown},
+		{key: "log.level", usage: "minimum log level: " + strings.Join(logLevels, ", "), ptr: &c.Log.Level},
+		{key: "log.format", usage: "log output format: " + strings.Join(logFormats, ", "), ptr: &c.Log.Format},
+		{key: "cors.allowed_origins", usage: "comma-separated origins allowed by CORS, or *", ptr: &c.CORS.AllowedOrigins},
+		{key: "cors.allowed_methods", usage: "comma-separated methods browsers may use", ptr: &c.CORS.AllowedMethods},
+		{key: "cors.allowed_headers", usage: "comma-separated request headers browsers may send", ptr: &c.CORS.AllowedHeaders},
+		{key: "cors.exposed_headers", usage: "comma-separated response headers browser scripts may read", ptr: &c.CORS.ExposedHeaders},
+		{key: "cors.allow_credentials", usage: "let browsers send cookies and credentials; not allowed with *", ptr: &c.CORS.AllowCredentials},
+		{key: "cors.max_age", usage: "how long browsers may cache a preflight response", ptr: &c.CORS.MaxAge},
+		{key: "auth.mode", usage: "api_key to require an API key or JWT, none to serve without one", ptr: &c.Auth.Mode},
+		{key: "auth.api_keys", usage: "comma-separated API keys stored as admin keys at startup", secret: true, ptr: &c.Auth.APIKeys},
+		{key: "auth.jwt.jwks", usage: "file or http(s) URL of the JWKS that bearer JWTs are verified against; empty disables JWTs", ptr: &c.Auth.JWT.JWKS},
+		{key: "auth.jwt.refresh", usage: "how often the JWKS is read again", ptr: &c.Auth.JWT.Refresh},
+		{key: "auth.jwt.issuer", usage: "required iss claim, if set", ptr: &c.Auth.JWT.Issuer},
+		{key: "auth.jwt.audience", usage: "required aud claim entry, if set", ptr: &c.Auth.JWT.Audience},
+		{key: "auth.jwt.principal_claim", usage: "claim naming the caller, recorded as owner", ptr: &c.Auth.JWT.PrincipalClaim},
+		{key: "auth.jwt.scopes_claim", usage: "claim holding the caller's scopes", ptr: &c.Auth.JWT.ScopesClaim},
+		{key: "auth.jwt.scope_prefix", usage: "prefix of the identity provider's names for this service's scopes", ptr: &c.Auth.JWT.ScopePrefix},
+		{key: "auth.jwt.tenant_claim", usage: "claim binding the caller to a tenant; empty ignores tenants in tokens", ptr: &c.Auth.JWT.TenantClaim},
+		{key: "auth.jwt.groups_claim", usage: "claim listing the caller's groups for ACLs; empty ignores groups in tokens", ptr: &c.Auth.JWT.GroupsClaim},
+		{key: "rate_limit.reads_per_minute", usage: "GET requests each caller may make per minute, 0 for no limit", ptr: &c.RateLimit.ReadsPerMinute},
+		{key: "rate_limit.writes_per_minute", usage: "other requests each caller may make per minute, 0 for no limit", ptr: &c.RateLimit.WritesPerMinute},
+		{key: "rate_limit.per_ip_per_minute", usage: "requests each client IP may make per minute before authentication, 0 for no limit", ptr: &c.RateLimit.PerIPPerMinute},
+		{key: "scheduler.tick", usage: "how often the scheduler checks for due alarms", ptr: &c.Scheduler.Tick},
+		{key: "tracing.exporter", usage: "where spans are sent: " + strings.Join(tracingExporters, ", "), ptr: &c.Tracing.Exporter},
+		{key: "tracing.file", usage: "file spans are appended to by the file exporter", ptr: &c.Tracing.File},
+		{key: "tracing.otlp_endpoint", usage: "OTLP/HTTP traces URL of the collector", ptr: &c.Tracing.OTLPEndpoint},
+		{key: "retention.idempotency_keys", usage: "how long idempotent responses are replayed", ptr: &c.Retention.IdempotencyKeys},
+		{key: "retention.fired_alarms", usage: "how long fired alarms are kept, 0 for ever", ptr: &c.Retention.FiredAlarms},
+		{key: "validation.max_name_length", usage: "maximum name length in characters", ptr: &c.Validation.MaxNameLength},
+		{key: "validation.max_description_length", usage: "maximum description length in characters", ptr: &c.Validation.MaxDescriptionLength},
+		{key: "validation.max_target_horizon", usage: "how far ahead an alarm target may be", ptr: &c.Validation.MaxTargetHorizon},
+		{key: "validation.max_body_bytes", usage: "maximum request body size in bytes", ptr: &c.Validation.MaxBodyBytes},
+	}
+}
+
+// envName is the environment variable that sets key
+func envName(key string) string {
+	return "CLOCK_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// set parses v, a string or, from a file array, a []string, into s
+func (s setting) set(v interface{}) error {
+	if list, ok := v.([]string); ok {
+		ptr, ok := s.ptr.(*[]string)
+		if !ok {
+			return fmt.Errorf("expected a single value, got a list")
+		}
+		*ptr = list
+		return nil
+	}
+	raw := strings.TrimSpace(v.(string))
+	switch ptr := s.ptr.(type) {
+	case *string:
+		*ptr = raw
+	case *[]string:
+		*ptr = nil
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*ptr = append(*ptr, item)
+			}
+		}
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		*ptr = b
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		*ptr = n
+	case *int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		*ptr = n
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 5m", raw)
+		}
+		*ptr = d
+	default:
+		panic("config: unsupported setting type for " + s.key)
+	}
+	return nil
+}
+
+// Load builds the configuration from args and the environment. The file is
+// named by -config or CLOCK_CONFIG. The result has been validated; a
+// flag.ErrHelp error means -h was given and usage has been printed.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	c := Default()
+	c.sources = map[string]string{}
+	settings := c.settings()
+	byKey := map[string]setting{}
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+
+	// flags are applied last but parsed first, since one names the file
+	fs := flag.NewFlagSet("clock-service", flag.ContinueOnError)
+	configFile := fs.String("config", "", "TOML config file (env CLOCK_CONFIG)")
+	var flagged []string
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		s := s
+		usage := s.usage + " (env " + envName(s.key) + ")"
+		if !s.secret {
+			usage += "; default " + format(s.ptr, false)
+		}
+		fs.Func(s.key, usage, func(v string) error {
+			flagged = append(flagged, s.key)
+			flagValues[s.key] = v
+			return nil
+		})
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: clock-service [config print] [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if fs.NArg() > 0 {
+		return c, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	c.File = *configFile
+	if c.File == "" {
+		c.File, _ = lookupEnv("CLOCK_CONFIG")
+	}
+	if c.File != "" {
+		data, err := os.ReadFile(c.File)
+		if err != nil {
+			return c, fmt.Errorf("read config file: %w", err)
+		}
+		values, err := parseTOML(data)
+		if err != nil {
+			return c, fmt.Errorf("config file %s:%w", c.File, err)
+		}
+		for _, kv := range values {
+			s, ok := byKey[kv.key]
+			if !ok {
+				return c, fmt.Errorf("config file %s:%d: unknown setting %q", c.File, kv.line, kv.key)
+			}
+			if err := s.set(kv.value); err != nil {
+				return c, fmt.Errorf("config file %s:%d: %s: %v", c.File, kv.line, kv.key, err)
+			}
+			c.sources[kv.key] = "file " + c.File
+		}
+	}
+
+	for _, s := range settings {
+		name := envName(s.key)
+		if v, ok := lookupEnv(name); ok {
+			if err := s.set(v); err != nil {
+				return c, fmt.Errorf("%s: %v", name, err)
+			}
+			c.sources[s.key] = "env " + name
+		}
+	}
+
+	for _, key := range flagged {
+		if err := byKey[key].set(flagValues[key]); err != nil {
+			return c, fmt.Errorf("-%s: %v", key, err)
+		}
+		c.sources[key] = "flag -" + key
+	}
+
+	if err := c.Validate(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// Validate checks every setting and reports all problems at once
+func (c Config) Validate() error {
+	var problems []string
+	add := func(key, format string, args ...interface{}) {
+		problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	if _, port, err := net.SplitHostPort(c.Listen); err != nil {
+		add("listen", "%q is not a host:port address", c.Listen)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		add("listen", "%q is not a valid port", port)
+	}
+	if strings.TrimSpace(c.Database) == "" {
+		add("database", "must not be empty")
+	}
+	if c.MaxHeaderBytes <= 0 {
+		add("max_header_bytes", "must be positive")
+	}
+	for _, d := range []struct {
+		key string
+		d   time.Duration
+	}{
+		{"timeouts.read", c.Timeouts.Read},
+		{"timeouts.read_header", c.Timeouts.ReadHeader},
+		{"timeouts.write", c.Timeouts.Write},
+		{"timeouts.idle", c.Timeouts.Idle},
+		{"retention.fired_alarms", c.Retention.FiredAlarms},
+	} {
+		if d.d < 0 {
+			add(d.key, "must not be negative")
+		}
+	}
+	for _, d := range []struct {
+		key string
+		d   time.Duration
+	}{
+		{"timeouts.shutdown", c.Timeouts.Shutdown},
+		{"scheduler.tick", c.Scheduler.Tick},
+		{"retention.idempotency_keys", c.Retention.IdempotencyKeys},
+		{"validation.max_target_horizon", c.Validation.MaxTargetHorizon},
+	} {
+		if d.d <= 0 {
+			add(d.key, "must be positive")
+		}
+	}
+	for _, e := range []struct {
+		key, value string
+		allowed    []string
+	}{
+		{"log.level", c.Log.Level, logLevels},
+		{"log.format", c.Log.Format, logFormats},
+		{"tracing.exporter", c.Tracing.Exporter, tracingExporters},
+		{"auth.mode", c.Auth.Mode, authModes},
+		{"tls.client_auth", c.TLS.ClientAuth, clientAuthModes},
+	} {
+		valid := false
+		for _, a := range e.allowed {
+			valid = valid || e.value == a
+		}
+		if !valid {
+			add(e.key, "%q is not one of %s", e.value, strings.Join(e.allowed, ", "))
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		add("tls.key_file", "tls.cert_file and tls.key_file must be set together")
+	}
+	if c.TLS.CertFile == "" {
+		if c.TLS.ClientCA != "" {
+			add("tls.client_ca_file", "requires tls.cert_file")
+		}
+		if c.TLS.RedirectListen != "" {
+			add("tls.redirect_listen", "requires tls.cert_file")
+		}
+	} else if c.TLS.Reload <= 0 {
+		add("tls.reload", "must be positive")
+	}
+	if c.TLS.RedirectListen != "" {
+		if _, _, err := net.SplitHostPort(c.TLS.RedirectListen); err != nil {
+			add("tls.redirect_listen", "%q is not a host:port address", c.TLS.RedirectListen)
+		} else if c.TLS.RedirectListen == c.Listen {
+			add("tls.redirect_listen", "must differ from listen")
+		}
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			if c.CORS.AllowCredentials {
+				add("cors.allow_credentials", "cannot be combined with * in cors.allowed_origins")
+			}
+			continue
+		}
+		if services.ValidateOrigin(origin) != nil {
+			add("cors.allowed_origins", "%q is not * or an origin such as https://example.com", origin)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		add("cors.max_age", "must not be negative")
+	}
+	switch c.Tracing.Exporter {
+	case "file":
+		if c.Tracing.File == "" {
+			add("tracing.file", "is required by the file exporter")
+		}
+	case "otlp":
+		if u, err := url.Parse(c.Tracing.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("tracing.otlp_endpoint", "%q is not an http or https URL", c.Tracing.OTLPEndpoint)
+		}
+	}
+	if jwks := c.Auth.JWT.JWKS; jwks != "" {
+		if strings.Contains(jwks, "://") {
+			if u, err := url.Parse(jwks); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add("auth.jwt.jwks", "%q is not a file path or an http or https URL", jwks)
+			}
+		}
+		if c.Auth.JWT.Refresh <= 0 {
+			add("auth.jwt.refresh", "must be positive")
+		}
+		if c.Auth.JWT.PrincipalClaim == "" {
+			add("auth.jwt.principal_claim", "must not be empty")
+		}
+		if c.Auth.JWT.ScopesClaim == "" {
+			add("auth.jwt.scopes_claim", "must not be empty")
+		}
+	}
+	for i, key := range c.Auth.APIKeys {
+		if strings.ContainsAny(key, " \t") {
+			add("auth.api_keys", "key %d contains whitespace", i+1)
+		}
+	}
+	if c.RateLimit.ReadsPerMinute < 0 {
+		add("rate_limit.reads_per_minute", "must not be negative")
+	}
+	if c.RateLimit.WritesPerMinute < 0 {
+		add("rate_limit.writes_per_minute", "must not be negative")
+	}
+	if c.RateLimit.PerIPPerMinute < 0 {
+		add("rate_limit.per_ip_per_minute", "must not be negative")
+	}
+	if c.Validation.MaxNameLength <= 0 {
+		add("validation.max_name_length", "must be positive")
+	}
+	if c.Validation.MaxDescriptionLength <= 0 {
+		add("validation.max_description_length", "must be positive")
+	}
+	if c.Validation.MaxBodyBytes <= 0 {
+		add("validation.max_body_bytes", "must be positive")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Print writes the configuration as TOML with secrets redacted, noting where
+// each value came from
+func (c Config) Print(w io.Writer) {
+	fmt.Fprintln(w, "# effective configuration; secrets are redacted")
+	if c.File != "" {
+		fmt.Fprintf(w, "# read from %s\n", c.File)
+	}
+	section := ""
+	settings := c.settings()
+	sort.SliceStable(settings, func(i, j int) bool {
+		return !strings.Contains(settings[i].key, ".") && strings.Contains(settings[j].key, ".")
+	})
+	for _, s := range settings {
+		name := s.key
+		if i := strings.LastIndex(s.key, "."); i >= 0 {
+			if s.key[:i] != section {
+				section = s.key[:i]
+				fmt.Fprintf(w, "\n[%s]\n", section)
+			}
+			name = s.key[i+1:]
+		}
+		source := c.sources[s.key]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%s = %s  # %s\n", name, format(s.ptr, s.secret), source)
+	}
+}
+
+// format renders a setting value in TOML
+func format(ptr interface{}, secret bool) string {
+	switch v := ptr.(type) {
+	case *string:
+		if secret && *v != "" {
+			return `"<redacted>"`
+		}
+		return strconv.Quote(*v)
+	case *[]string:
+		items := make([]string, len(*v))
+		for i, item := range *v {
+			if secret {
+				item = "<redacted>"
+			}
+			items[i] = strconv.Quote(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *int:
+		return strconv.Itoa(*v)
+	case *int64:
+		return strconv.FormatInt(*v, 10)
+	case *time.Duration:
+		return strconv.Quote(v.String())
+	}
+	panic(fmt.Sprintf("config: unsupported setting type %T", ptr))
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clock.toml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	c, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	want := Default()
+	c.sources, want.sources = nil, nil
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("expected defaults, got %+v", c)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, `
+listen = ":7000"
+database = "file.db"
+
+[timeouts]
+read = "1s"
+write = "2s"
+`)
+	c, err := Load(
+		[]string{"-config", path, "-listen", ":9000"},
+		env(map[string]string{"CLOCK_LISTEN": ":8000", "CLOCK_TIMEOUTS_READ": "3s"}),
+	)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if c.Listen != ":9000" {
+		t.Errorf("flag should win over env and file, got %s", c.Listen)
+	}
+	if c.Timeouts.Read != 3*time.Second {
+		t.Errorf("env should win over file, got %s", c.Timeouts.Read)
+	}
+	if c.Database != "file.db" || c.Timeouts.Write != 2*time.Second {
+		t.Errorf("file should win over defaults, got %s, %s", c.Database, c.Timeouts.Write)
+	}
+	if c.Timeouts.Idle != Default().Timeouts.Idle {
+		t.Errorf("unset values should keep their default, got %s", c.Timeouts.Idle)
+	}
+}
+
+func TestLoad_ConfigFileFromEnv(t *testing.T) {
+	path := writeFile(t, "[cors]\nallowed_origins = [\"https://a.example\"]\n")
+	c, err := Load(nil, env(map[string]string{"CLOCK_CONFIG": path, "CLOCK_AUTH_API_KEYS": "one, two"}))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if !reflect.DeepEqual(c.CORS.AllowedOrigins, []string{"https://a.example"}) {
+		t.Errorf("unexpected origins %v", c.CORS.AllowedOrigins)
+	}
+	if !reflect.DeepEqual(c.Auth.APIKeys, []string{"one", "two"}) {
+		t.Errorf("unexpected keys %v", c.Auth.APIKeys)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+		want []string
+	}{
+		{name: "bad flag value", args: []string{"-timeouts.read", "soon"}, want: []string{`-timeouts.read: "soon" is not a duration`}},
+		{name: "bad env value", env: map[string]string{"CLOCK_VALIDATION_MAX_BODY_BYTES": "1MB"}, want: []string{`CLOCK_VALIDATION_MAX_BODY_BYTES: "1MB" is not an integer`}},
+		{name: "unknown file key", file: "[log]\ncolour = \"auto\"\n", want: []string{`:2: unknown setting "log.colour"`}},
+		{name: "list for a scalar", file: "listen = [\":1\"]\n", want: []string{":1: listen: expected a single value"}},
+		{name: "bad collector URL", args: []string{"-tracing.exporter", "otlp", "-tracing.otlp_endpoint", "localhost:4318"}, want: []string{`tracing.otlp_endpoint: "localhost:4318" is not an http or https URL`}},
+		{
+			name: "bad JWT settings",
+			file: "[auth.jwt]\njwks = \"ftp://idp.example/keys\"\nrefresh = \"0s\"\nprincipal_claim = \"\"\n",
+			want: []string{
+				`auth.jwt.jwks: "ftp://idp.example/keys" is not a file path or an http or https URL`,
+				"auth.jwt.refresh: must be positive",
+				"auth.jwt.principal_claim: must not be empty",
+			},
+		},
+		{name: "credentials with any origin", args: []string{"-cors.allowed_origins", "*", "-cors.allow_credentials", "true"}, want: []string{"cors.allow_credentials: cannot be combined with *"}},
+		{name: "bad boolean", env: map[string]string{"CLOCK_CORS_ALLOW_CREDENTIALS": "yes"}, want: []string{`CLOCK_CORS_ALLOW_CREDENTIALS: "yes" is not true or false`}},
+		{
+			name: "bad TLS settings",
+			args: []string{"-tls.client_ca_file", "ca.pem", "-tls.client_auth", "always", "-tls.redirect_listen", "80"},
+			want: []string{
+				`tls.client_auth: "always" is not one of optional, require`,
+				"tls.client_ca_file: requires tls.cert_file",
+				"tls.redirect_listen: requires tls.cert_file",
+				`tls.redirect_listen: "80" is not a host:port address`,
+			},
+		},
+		{name: "certificate without key", args: []string{"-tls.cert_file", "cert.pem"}, want: []string{"tls.key_file: tls.cert_file and tls.key_file must be set together"}},
+		{name: "negative rate limit", args: []string{"-rate_limit.writes_per_minute", "-1"}, want: []string{"rate_limit.writes_per_minute: must not be negative"}},
+		{name: "extra argument", args: []string{"serve"}, want: []string{`unexpected argument "serve"`}},
+		{
+			name: "every invalid value reported",
+			env: map[string]string{
+				"CLOCK_LISTEN":               "8080",
+				"CLOCK_LOG_LEVEL":            "verbose",
+				"CLOCK_LOG_FORMAT":           "xml",
+				"CLOCK_CORS_ALLOWED_ORIGINS": "https://a.example/path",
+				"CLOCK_SCHEDULER_TICK":       "0s",
+				"CLOCK_TRACING_EXPORTER":     "file",
+			},
+			want: []string{
+				`listen: "8080" is not a host:port address`,
+				`log.level: "verbose" is not one of`,
+				`log.format: "xml" is not one of json, text`,
+				`cors.allowed_origins: "https://a.example/path" is not * or an origin`,
+				"scheduler.tick: must be positive",
+				"tracing.file: is required by the file exporter",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			_, err := Load(args, env(tt.env))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected %q in %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad_Help(t *testing.T) {
+	_, err := Load([]string{"-h"}, env(nil))
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("expected flag.ErrHelp, got %v", err)
+	}
+}
+
+func TestPrint_RedactsSecretsAndNamesSources(t *testing.T) {
+	c, err := Load([]string{"-auth.api_keys", "s3cret"}, env(map[string]string{"CLOCK_DATABASE": "/var/lib/clock.db"}))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	var out bytes.Buffer
+	c.Print(&out)
+	text := out.String()
+	if strings.Contains(text, "s3cret") {
+		t.Fatalf("secret leaked:\n%s", text)
+	}
+	for _, want := range []string{
+		`database = "/var/lib/clock.db"  # env CLOCK_DATABASE`,
+		`api_keys = ["<redacted>"]  # flag -auth.api_keys`,
+		`listen = ":8080"  # default`,
+		"[timeouts]\nread = \"15s\"",
+		"[auth.jwt]\njwks = \"\"",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+
+	// the printed configuration is itself a valid config file
+	reloaded, err := Load([]string{"-config", writeFile(t, strings.ReplaceAll(text, `"<redacted>"`, `"s3cret"`))}, env(nil))
+	if err != nil {
+		t.Fatalf("printed config does not load: %v", err)
+	}
+	if reloaded.Database != "/var/lib/clock.db" || reloaded.Auth.APIKeys[0] != "s3cret" {
+		t.Errorf("unexpected reloaded config %+v", reloaded)
+	}
+}
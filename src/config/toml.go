@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keyValue is one assignment read from a config file
+type keyValue struct {
+	key   string
+	value interface{}
+	line  int
+}
+
+// parseTOML reads the subset of TOML the config file needs: [section] and
+// [dotted.section] headers, key = value pairs, # comments, basic and literal strings, bare
+// numbers and booleans, and single-line arrays of strings. Keys are returned
+// as section.key. Errors are prefixed with the line number.
+func parseTOML(data []byte) ([]keyValue, error) {
+	var values []keyValue
+	seen := map[string]bool{}
+	section := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		n := i + 1
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%d: unterminated section header", n)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			for _, part := range strings.Split(section, ".") {
+				if !isBareKey(part) {
+					return nil, fmt.Errorf("%d: invalid section name %q", n, section)
+				}
+			}
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("%d: expected key = value", n)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if !isBareKey(key) {
+			return nil, fmt.Errorf("%d: invalid key %q", n, key)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%d: %s is set twice", n, key)
+		}
+		seen[key] = true
+		value, err := parseValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%d: %s: %v", n, key, err)
+		}
+		values = append(values, keyValue{key: key, value: value, line: n})
+	}
+	return values, nil
+}
+
+// parseValue returns a string, or a []string for an array
+func parseValue(raw string) (interface{}, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+	if strings.HasPrefix(raw, "[") {
+		if !strings.HasSuffix(raw, "]") {
+			return nil, fmt.Errorf("arrays must be on one line")
+		}
+		list := []string{}
+		rest := strings.TrimSpace(raw[1 : len(raw)-1])
+		for rest != "" {
+			item, tail, err := parseString(rest)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			rest = strings.TrimSpace(tail)
+			if rest == "" {
+				break
+			}
+			if rest[0] != ',' {
+				return nil, fmt.Errorf("expected , between array items")
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+		return list, nil
+	}
+	if raw[0] == '"' || raw[0] == '\'' {
+		s, tail, err := parseString(raw)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(tail) != "" {
+			return nil, fmt.Errorf("unexpected %q after string", tail)
+		}
+		return s, nil
+	}
+	if strings.ContainsAny(raw, " \t\"'") {
+		return nil, fmt.Errorf("strings must be quoted")
+	}
+	return raw, nil
+}
+
+// parseString reads the quoted string at the start of s and returns it with
+// the remaining text
+func parseString(s string) (string, string, error) {
+	switch s[0] {
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				v, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return v, s[i+1:], nil
+			}
+		}
+		return "", "", fmt.Errorf("unterminated string")
+	}
+	return "", "", fmt.Errorf("expected a quoted string")
+}
+
+// stripComment removes a # comment that is not inside a string
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == 0 && c == '#':
+			return line[:i]
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case c == quote:
+			quote = 0
+		}
+	}
+	return line
+}
+
+func isBareKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
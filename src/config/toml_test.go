@@ -0,0 +1,59 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	data := `
+# server
+listen = ":9090" # trailing comment
+database = 'data/clock#1.db'
+
+[cors]
+allowed_origins = ["https://a.example", "http://b.example:8080"]
+
+[validation]
+max_name_length = 50
+
+[auth.jwt]
+issuer = "https://idp.example"
+`
+	values, err := parseTOML([]byte(data))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	want := []keyValue{
+		{key: "listen", value: ":9090", line: 3},
+		{key: "database", value: "data/clock#1.db", line: 4},
+		{key: "cors.allowed_origins", value: []string{"https://a.example", "http://b.example:8080"}, line: 7},
+		{key: "validation.max_name_length", value: "50", line: 10},
+		{key: "auth.jwt.issuer", value: "https://idp.example", line: 13},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("got %#v", values)
+	}
+}
+
+func TestParseTOML_Errors(t *testing.T) {
+	tests := []struct {
+		data, want string
+	}{
+		{"listen\n", "1: expected key = value"},
+		{"[log\n", "1: unterminated section header"},
+		{"[auth..jwt]\n", `1: invalid section name "auth..jwt"`},
+		{"a = 1\na = 2\n", "2: a is set twice"},
+		{`a = "open`, "1: a: unterminated string"},
+		{"a = [\"x\" \"y\"]", "1: a: expected , between array items"},
+		{"a = two words", "1: a: strings must be quoted"},
+		{"a b = 1", `1: invalid key "a b"`},
+	}
+	for _, tt := range tests {
+		_, err := parseTOML([]byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseTOML(%q): expected %q, got %v", tt.data, tt.want, err)
+		}
+	}
+}
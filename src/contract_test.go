@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+const v1ShapesPath = "testdata/v1_shapes.json"
+
+// shapeOf reduces a decoded JSON document to its structure: objects keep
+// their keys, arrays their first element and scalars become their type name
+func shapeOf(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		shape := map[string]interface{}{}
+		for k, e := range v {
+			shape[k] = shapeOf(e)
+		}
+		return shape
+	case []interface{}:
+		if len(v) == 0 {
+			return []interface{}{}
+		}
+		return []interface{}{shapeOf(v[0])}
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// TestV1Contract_ResponseShapes pins the v1 response bodies, which existing
+// integrations depend on, on both the unprefixed and /v1 paths. Run with
+// -update only when a v1 change is intended.
+func TestV1Contract_ResponseShapes(t *testing.T) {
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	type call struct {
+		name, method, path string
+		body               interface{}
+	}
+	calls := []call{
+		{"create alarm", "POST", "/alarms/create", map[string]interface{}{
+			"id": "wake", "name": "standup", "description": "daily", "target": future, "labels": map[string]string{"team": "ops"},
+		}},
+		{"countdown", "GET", "/alarms/countdown?id=wake", nil},
+		{"list alarms", "GET", "/alarms/list", nil},
+		{"labels", "GET", "/alarms/labels?id=wake", nil},
+		{"patch alarm", "PATCH", "/alarms/update?id=wake", map[string]interface{}{"name": "renamed"}},
+		{"create event", "POST", "/events/create", map[string]interface{}{"id": "deploy", "name": "deploy"}},
+		{"elapsed", "GET", "/events/elapsed?id=deploy", nil},
+		{"list events", "GET", "/events/list", nil},
+		{"search", "GET", "/search?q=renamed", nil},
+		{"batch", "POST", "/batch", map[string]interface{}{"operations": []interface{}{
+			map[string]interface{}{"op": "create", "type": "event", "data": map[string]interface{}{"name": "release"}},
+		}}},
+		{"delete", "DELETE", "/events/delete?id=deploy", nil},
+	}
+
+	for _, prefix := range []string{"", "/v1"} {
+		setupHandlersForTest(t)
+		mux := http.NewServeMux()
+		registerRoutes(mux)
+		etags := map[string]string{}
+		shapes := map[string]interface{}{}
+		for _, c := range calls {
+			var raw []byte
+			if c.body != nil {
+				raw, _ = json.Marshal(c.body)
+			}
+			req := httptest.NewRequest(c.method, prefix+c.path, bytes.NewReader(raw))
+			if c.body != nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if etag, ok := etags[req.URL.Query().Get("id")]; ok {
+				req.Header.Set("If-Match", etag)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code >= 300 {
+				t.Fatalf("%s%s: unexpected status %d: %s", prefix, c.path, w.Code, w.Body.String())
+			}
+			var doc interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("%s%s: response is not JSON: %v", prefix, c.path, err)
+			}
+			if obj, ok := doc.(map[string]interface{}); ok {
+				if id, ok := obj["ID"].(string); ok && w.Header().Get("ETag") != "" {
+					etags[id] = w.Header().Get("ETag")
+				}
+			}
+			shapes[c.name] = shapeOf(doc)
+		}
+
+		got, _ := json.MarshalIndent(shapes, "", "  ")
+		got = append(got, '\n')
+		if *updateGolden && prefix == "" {
+			if err := os.WriteFile(v1ShapesPath, got, 0o644); err != nil {
+				t.Fatalf("failed to write %s: %v", v1ShapesPath, err)
+			}
+		}
+		want, err := os.ReadFile(v1ShapesPath)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", v1ShapesPath, err)
+		}
+		if !bytes.Equal(want, got) {
+			var pinned map[string]interface{}
+			json.Unmarshal(want, &pinned)
+			var changed []string
+			for name, shape := range shapes {
+				a, _ := json.Marshal(shape)
+				b, _ := json.Marshal(pinned[name])
+				if !bytes.Equal(a, b) {
+					changed = append(changed, name)
+				}
+			}
+			sort.Strings(changed)
+			t.Errorf("%s: v1 response shapes changed for %v:\n%s", prefix, changed, got)
+		}
+	}
+}
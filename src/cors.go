@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/services"
+)
+
+// corsPolicy is the CORS configuration in the form the middleware uses
+type corsPolicy struct {
+	origins     map[string]bool
+	anyOrigin   bool
+	methods     string
+	headers     string
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+func newCORSPolicy(cfg config.CORS) *corsPolicy {
+	p := &corsPolicy{
+		origins:     map[string]bool{},
+		methods:     strings.Join(cfg.AllowedMethods, ", "),
+		headers:     strings.Join(cfg.AllowedHeaders, ", "),
+		exposed:     strings.Join(cfg.ExposedHeaders, ", "),
+		credentials: cfg.AllowCredentials,
+		maxAge:      strconv.Itoa(int(cfg.MaxAge.Seconds())),
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		p.origins[services.NormalizeOrigin(origin)] = true
+	}
+	return p
+}
+
+// cors is the policy in force, set from cors.* at startup
+var cors = newCORSPolicy(config.Default().CORS)
+
+// How an origin was allowed, recorded in the request context so withTenant
+// can confine origins that only a tenant allows to that tenant
+type corsGrant int
+
+const (
+	corsNotAllowed corsGrant = iota
+	corsGlobal
+	corsTenant
+)
+
+type corsGrantKey struct{}
+
+// corsGrantOf returns how the origin of r was allowed
+func corsGrantOf(r *http.Request) corsGrant {
+	g, _ := r.Context().Value(corsGrantKey{}).(corsGrant)
+	return g
+}
+
+// withCORS answers preflight requests for every route and adds CORS headers
+// to the responses of allowed origins. An origin is allowed by
+// cors.allowed_origins or by the allowed_origins of an active tenant. It
+// runs before withAuth, since browsers send preflights without credentials.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		grant, err := cors.grant(origin)
+		if err != nil {
+			writeStorageProblem(w, r, err, "")
+			return
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			if grant != corsNotAllowed {
+				cors.allowOrigin(h, origin)
+				h.Set("Access-Control-Allow-Methods", cors.methods)
+				h.Set("Access-Control-Allow-Headers", cors.headers)
+				h.Set("Access-Control-Max-Age", cors.maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if grant != corsNotAllowed {
+			cors.allowOrigin(h, origin)
+			if cors.exposed != "" {
+				h.Set("Access-Control-Expose-Headers", cors.exposed)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), corsGrantKey{}, grant)))
+	})
+}
+
+// grant reports how origin is allowed
+func (p *corsPolicy) grant(origin string) (corsGrant, error) {
+	if p.anyOrigin || p.origins[services.NormalizeOrigin(origin)] {
+		return corsGlobal, nil
+	}
+	if tenantStore == nil {
+		return corsNotAllowed, nil
+	}
+	ok, err := tenantStore.AllowsOrigin(origin)
+	if err != nil || !ok {
+		return corsNotAllowed, err
+	}
+	return corsTenant, nil
+}
+
+// allowOrigin sets the headers that let origin read the response
+func (p *corsPolicy) allowOrigin(h http.Header, origin string) {
+	if p.anyOrigin && !p.credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/services"
+)
+
+// enableCORS serves the authenticated routes behind withCORS with cfg
+func enableCORS(t *testing.T, cfg config.CORS) http.Handler {
+	t.Helper()
+	handler := enableAuth(t)
+	previous := cors
+	cors = newCORSPolicy(cfg)
+	t.Cleanup(func() { cors = previous })
+	return withCORS(handler)
+}
+
+func corsRequest(handler http.Handler, method, target, origin string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Origin", origin)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	cfg := config.Default().CORS
+	cfg.AllowedOrigins = []string{"https://dash.example/"}
+	handler := enableCORS(t, cfg)
+	preflight := map[string]string{"Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "if-match"}
+
+	w := corsRequest(handler, "OPTIONS", "/v1/alarms/update?id=a1", "https://dash.example", preflight)
+	h := w.Header()
+	if w.Code != http.StatusNoContent || h.Get("Access-Control-Allow-Origin") != "https://dash.example" ||
+		!strings.Contains(h.Get("Access-Control-Allow-Methods"), "PUT") ||
+		!strings.Contains(h.Get("Access-Control-Allow-Headers"), "If-Match") || h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("unexpected preflight response %d %v", w.Code, h)
+	}
+	if h.Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("expected credentials not to be allowed by default")
+	}
+	w = corsRequest(handler, "OPTIONS", "/v1/alarms/update?id=a1", "https://evil.example", preflight)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected an unknown origin to get no CORS headers, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCORS_ActualRequests(t *testing.T) {
+	cfg := config.Default().CORS
+	cfg.AllowedOrigins = []string{"*"}
+	handler := enableCORS(t, cfg)
+
+	w := corsRequest(handler, "GET", "/v1/alarms/list", "https://any.example", nil)
+	h := w.Header()
+	if w.Code != http.StatusUnauthorized || h.Get("Access-Control-Allow-Origin") != "*" ||
+		!strings.Contains(h.Get("Access-Control-Expose-Headers"), "ETag") {
+		t.Errorf("expected errors to be readable by scripts, got %d %v", w.Code, h)
+	}
+	if w := corsRequest(handler, "GET", "/healthz", "", nil); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("expected no CORS headers without an Origin")
+	}
+
+	cfg.AllowedOrigins, cfg.AllowCredentials = []string{"https://dash.example"}, true
+	cors = newCORSPolicy(cfg)
+	w = corsRequest(handler, "GET", "/healthz", "https://dash.example", nil)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://dash.example" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("expected the origin to be echoed with credentials, got %v", w.Header())
+	}
+}
+
+func TestCORS_TenantOrigins(t *testing.T) {
+	handler := enableCORS(t, config.Default().CORS)
+	admin := newKey(t, services.ScopeAdmin)
+	newTenant(t, handler, admin, `{"id":"acme","allowed_origins":["https://dash.acme.example"]}`)
+	acme := newTenantKey(t, "acme", services.ScopeAlarmsRead)
+	other := newKey(t, services.ScopeAlarmsRead)
+	origin := "https://dash.acme.example"
+	bearer := func(key string) map[string]string { return map[string]string{"Authorization": "Bearer " + key} }
+
+	w := corsRequest(handler, "OPTIONS", "/v1/alarms/list", origin, map[string]string{"Access-Control-Request-Method": "GET"})
+	if w.Header().Get("Access-Control-Allow-Origin") != origin {
+		t.Errorf("expected a tenant's origin to pass preflight, got %v", w.Header())
+	}
+	if w := corsRequest(handler, "GET", "/v1/alarms/list", origin, bearer(acme)); w.Code != http.StatusOK {
+		t.Errorf("expected the origin to call its tenant, got %d %s", w.Code, w.Body.String())
+	}
+	w = corsRequest(handler, "GET", "/v1/alarms/list", origin, bearer(other))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), codeOriginForbidden) {
+		t.Errorf("expected the origin to be confined to its tenant, got %d %s", w.Code, w.Body.String())
+	}
+	if w := corsRequest(handler, "GET", "/v1/alarms/list", "https://elsewhere.example", bearer(other)); w.Code != http.StatusOK {
+		t.Errorf("expected origins no tenant claims to be served as before, got %d", w.Code)
+	}
+
+	if w := authRequest(handler, "PUT", "/admin/tenants/cors?id=acme", admin, `{"allowed_origins":["*"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected * to be refused for a tenant, got %d", w.Code)
+	}
+	if w := authRequest(handler, "PUT", "/admin/tenants/cors?id=acme", admin, `{"allowed_origins":[]}`); w.Code != http.StatusOK {
+		t.Fatalf("clearing origins failed: %d %s", w.Code, w.Body.String())
+	}
+	w = corsRequest(handler, "OPTIONS", "/v1/alarms/list", origin, map[string]string{"Access-Control-Request-Method": "GET"})
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected a removed origin to be refused at once, got %v", w.Header())
+	}
+}
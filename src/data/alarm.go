@@ -9,4 +9,18 @@ type Alarm struct {
 	Description string
 	Target      time.Time
 	CreatedAt   time.Time
+	Labels      map[string]string
+	Version     int64
+	// Owner is the principal that created it, empty when authentication
+	// was off. v1 responses write this struct and predate the field, so it
+	// is reported by v2 only.
+	Owner string `json:"-"`
+	// FiredAt is when the scheduler fired it, nil while it has not or once a
+	// new target re-armed it. Like Owner it is reported by v2 only.
+	FiredAt *time.Time `json:"-"`
+	// Tenant is the namespace it lives in; it is never sent to clients
+	Tenant string `json:"-"`
+	// Viewers and Editors are who it is shared with; see services.ValidateACL
+	Viewers []string `json:"-"`
+	Editors []string `json:"-"`
 }
@@ -9,4 +9,15 @@ type Event struct {
 	Description string
 	StartedAt   time.Time
 	CreatedAt   time.Time
+	Labels      map[string]string
+	Version     int64
+	// Owner is the principal that created it, empty when authentication
+	// was off. v1 responses write this struct and predate the field, so it
+	// is reported by v2 only.
+	Owner string `json:"-"`
+	// Tenant is the namespace it lives in; it is never sent to clients
+	Tenant string `json:"-"`
+	// Viewers and Editors are who it is shared with; see services.ValidateACL
+	Viewers []string `json:"-"`
+	Editors []string `json:"-"`
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"ClockAsService/src/services"
+)
+
+// validationRules bounds every alarm and event payload the API accepts
+var validationRules = services.DefaultValidationRules()
+
+// errTrailingData is returned when a body holds more than one JSON value
+var errTrailingData = errors.New("request body must contain a single JSON object")
+
+// decodeJSON reads a JSON request body into dst. It requires an
+// application/json Content-Type, caps the body at validationRules.MaxBodyBytes
+// and rejects unknown fields. On failure the problem has been written and
+// false is returned.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeProblem(w, r, codeUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+	if validationRules.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, validationRules.MaxBodyBytes)
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		writeDecodeProblem(w, r, err)
+		return false
+	}
+	if dec.More() {
+		writeDecodeProblem(w, r, errTrailingData)
+		return false
+	}
+	return true
+}
+
+// decodeStrict unmarshals an embedded JSON document, such as the data of a
+// batch operation, rejecting unknown fields
+func decodeStrict(data []byte, dst interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(dst)
+}
+
+// unknownField extracts the field name from the error encoding/json returns
+// when DisallowUnknownFields rejects a field
+func unknownField(err error) (string, bool) {
+	const prefix = `json: unknown field "`
+	msg := err.Error()
+	if !strings.HasPrefix(msg, prefix) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(msg, prefix), `"`), true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateAlarm_RequiresJSONContentType(t *testing.T) {
+	setupHandlersForTest(t)
+
+	body := `{"name":"a","target":"2999-01-01T00:00:00Z"}`
+	req := httptest.NewRequest("POST", "/alarms/create", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	createAlarmHandler(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/events/create", strings.NewReader(`{"name":"a"}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	w = httptest.NewRecorder()
+	createEventHandler(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 with a charset parameter, got %d", w.Code)
+	}
+}
+
+func TestCreateAlarm_RejectsUnknownFields(t *testing.T) {
+	setupHandlersForTest(t)
+
+	body := `{"name":"a","target":"2999-01-01T00:00:00Z","taget":"x"}`
+	req := httptest.NewRequest("POST", "/alarms/create", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	createAlarmHandler(w, req)
+	p := decodeProblem(t, w)
+	if p.Code != codeUnknownField || len(p.Errors) != 1 || p.Errors[0].Field != "taget" {
+		t.Fatalf("expected unknown_field for taget, got %+v", p)
+	}
+}
+
+func TestCreateAlarm_CapsBodySize(t *testing.T) {
+	setupHandlersForTest(t)
+	defer func(limit int64) { validationRules.MaxBodyBytes = limit }(validationRules.MaxBodyBytes)
+	validationRules.MaxBodyBytes = 64
+
+	body := `{"name":"a","description":"` + strings.Repeat("x", 100) + `"}`
+	req := httptest.NewRequest("POST", "/alarms/create", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	createAlarmHandler(w, req)
+	if p := decodeProblem(t, w); p.Code != codeBodyTooLarge {
+		t.Fatalf("expected %s, got %s", codeBodyTooLarge, p.Code)
+	}
+}
+
+func TestCreateAlarm_RequiresNameAndTarget(t *testing.T) {
+	setupHandlersForTest(t)
+
+	req := httptest.NewRequest("POST", "/alarms/create", strings.NewReader(`{"description":"no name"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	createAlarmHandler(w, req)
+	p := decodeProblem(t, w)
+	if p.Code != codeValidationFailed || len(p.Errors) != 2 {
+		t.Fatalf("expected name and target to be reported together, got %+v", p)
+	}
+}
+
+func TestBatch_RejectsUnknownDataFields(t *testing.T) {
+	setupHandlersForTest(t)
+
+	_, resp := postBatch(t, map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"op": "create", "type": "event", "data": map[string]interface{}{"name": "a", "colour": "red"}},
+		},
+	})
+	result := resp.Results[0]
+	if result.Status != http.StatusBadRequest || result.Code != codeUnknownField {
+		t.Fatalf("expected unknown_field, got %+v", result)
+	}
+}
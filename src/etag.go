@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/services"
+)
+
+// etag renders a resource version as a strong entity tag
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseETags splits an If-Match or If-None-Match header into versions.
+// any is true when the header is "*".
+func parseETags(header string) (versions []int64, any bool, err error) {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return nil, true, nil
+		}
+		tag = strings.TrimPrefix(tag, "W/")
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			return nil, false, errors.New("malformed entity tag")
+		}
+		v, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+		if err != nil {
+			return nil, false, errors.New("malformed entity tag")
+		}
+		versions = append(versions, v)
+	}
+	return versions, false, nil
+}
+
+// resourceVersion returns the version of an alarm or event loaded from storage
+func resourceVersion(raw interface{}) int64 {
+	switch v := raw.(type) {
+	case datapkg.Alarm:
+		return v.Version
+	case datapkg.Event:
+		return v.Version
+	}
+	return 0
+}
+
+// requireIfMatch resolves the If-Match header of a write into the version the
+// storage layer should check. It writes the error response and returns false
+// when the header is missing (428), malformed (400), names a resource that
+// does not exist (404) or cannot match the current version (412).
+func requireIfMatch(w http.ResponseWriter, r *http.Request, find func(string) (interface{}, error), id, notFoundCode string) (int64, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		writeProblem(w, r, codePreconditionRequired, "")
+		return 0, false
+	}
+	versions, any, err := parseETags(header)
+	if err != nil {
+		writeProblem(w, r, codeInvalidETag, "Invalid If-Match header")
+		return 0, false
+	}
+	if !any && len(versions) == 1 {
+		return versions[0], true
+	}
+	raw, err := find(id)
+	if err != nil {
+		writeLookupProblem(w, r, err, notFoundCode)
+		return 0, false
+	}
+	current := resourceVersion(raw)
+	if any {
+		return current, true
+	}
+	for _, v := range versions {
+		if v == current {
+			return current, true
+		}
+	}
+	writeProblem(w, r, codeVersionMismatch, "")
+	return 0, false
+}
+
+// writeETag sets the ETag header and answers 304 when If-None-Match already
+// names the current version; it returns true when the response is complete
+func writeETag(w http.ResponseWriter, r *http.Request, version int64) bool {
+	w.Header().Set("ETag", etag(version))
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	versions, any, err := parseETags(header)
+	if err != nil {
+		return false
+	}
+	if any {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	for _, v := range versions {
+		if v == version {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// writeClockETag sets the ETag of a countdown or elapsed time so clients can
+// send it back in If-Match. If-None-Match is not honored: the body changes as
+// time passes even while the resource keeps its version.
+func writeClockETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", etag(version))
+	w.Header().Set("Cache-Control", "no-cache")
+}
+
+// writeVersionedWriteError maps storage errors from a versioned write
+func writeVersionedWriteError(w http.ResponseWriter, r *http.Request, err error, notFoundCode, failed string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeProblem(w, r, notFoundCode, "")
+	case errors.Is(err, services.ErrVersionMismatch):
+		writeProblem(w, r, codeVersionMismatch, "")
+	case errors.Is(err, services.ErrPermissionDenied):
+		writeProblem(w, r, codePermissionDenied, "")
+	default:
+		writeStorageProblem(w, r, err, failed)
+	}
+}
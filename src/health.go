@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"ClockAsService/src/services"
+)
+
+// storageDB is the database behind the stores, probed by /readyz
+var storageDB *sql.DB
+
+// readinessTimeout bounds each readiness check
+const readinessTimeout = 2 * time.Second
+
+// HealthResponse is returned by /healthz
+type HealthResponse struct {
+	Status string `json:"status" openapi:"enum=ok"`
+}
+
+// ReadinessResponse is returned by /readyz with 200 when every check passes
+// and 503 otherwise
+type ReadinessResponse struct {
+	Status string           `json:"status" openapi:"enum=ready|not_ready"`
+	Checks []ReadinessCheck `json:"checks,omitempty" doc:"Present with verbose=1"`
+}
+
+// ReadinessCheck is the outcome of one readiness check
+type ReadinessCheck struct {
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	LatencyMS float64 `json:"latency_ms"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// readinessCheck reports whether one dependency is ready to serve
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) (detail string, err error)
+}
+
+// readinessChecks are run by /readyz in order
+func readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{"draining", func(context.Context) (string, error) {
+			if serverDrain.draining() {
+				return "", fmt.Errorf("shutting down")
+			}
+			return "", nil
+		}},
+		{"database", func(ctx context.Context) (string, error) {
+			if storageDB == nil {
+				return "", fmt.Errorf("not opened")
+			}
+			return "", storageDB.PingContext(ctx)
+		}},
+		{"schema", func(ctx context.Context) (string, error) {
+			if storageDB == nil {
+				return "", fmt.Errorf("not opened")
+			}
+			version, err := services.SchemaVersionOf(storageDB)
+			if err != nil {
+				return "", err
+			}
+			if version != services.SchemaVersion {
+				return "", fmt.Errorf("version %d, want %d", version, services.SchemaVersion)
+			}
+			return fmt.Sprintf("version %d", version), nil
+		}},
+	}
+	if alarmScheduler != nil {
+		checks = append(checks, readinessCheck{"scheduler", alarmScheduler.check})
+	}
+	return checks
+}
+
+// healthzHandler reports that the process is alive
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
+}
+
+// readyzHandler reports whether the service can take traffic. It turns
+// unready as soon as shutdown starts so load balancers stop routing to it.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	resp := ReadinessResponse{Status: "ready"}
+	var checks []ReadinessCheck
+	for _, c := range readinessChecks() {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		start := time.Now()
+		detail, err := c.check(ctx)
+		cancel()
+		result := ReadinessCheck{
+			Name:      c.name,
+			OK:        err == nil,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			Detail:    detail,
+		}
+		if err != nil {
+			resp.Status = "not_ready"
+			result.Detail = err.Error()
+		}
+		checks = append(checks, result)
+	}
+	if r.URL.Query().Get("verbose") == "1" {
+		resp.Checks = checks
+	}
+	status := http.StatusOK
+	if resp.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Build details, set with
+// -ldflags "-X main.buildCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)".
+// The commit falls back to the VCS stamp Go embeds in the binary.
+var (
+	buildCommit string
+	buildTime   string
+)
+
+// BuildInfo is returned by /version
+type BuildInfo struct {
+	Commit        string `json:"commit"`
+	Modified      bool   `json:"modified" doc:"Built from a working tree with uncommitted changes"`
+	CommitTime    string `json:"commit_time"`
+	BuildTime     string `json:"build_time"`
+	GoVersion     string `json:"go_version"`
+	SchemaVersion int    `json:"schema_version" doc:"Database schema version this build migrates to"`
+}
+
+// currentBuildInfo describes the running binary
+func currentBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Commit:        buildCommit,
+		CommitTime:    "unknown",
+		BuildTime:     buildTime,
+		GoVersion:     runtime.Version(),
+		SchemaVersion: services.SchemaVersion,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				info.CommitTime = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
+
+// versionHandler reports the build of the running binary
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentBuildInfo())
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"ClockAsService/src/services"
+)
+
+func decodeReadiness(t *testing.T, target string) (int, ReadinessResponse) {
+	t.Helper()
+	w := serveRoutes(t, "GET", target, nil)
+	var resp ReadinessResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode %s: %v", w.Body.String(), err)
+	}
+	return w.Code, resp
+}
+
+func TestHealthz(t *testing.T) {
+	w := serveRoutes(t, "GET", "/healthz", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "{\"status\":\"ok\"}\n" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	setupHandlersForTest(t)
+
+	status, resp := decodeReadiness(t, "/readyz")
+	if status != http.StatusOK || resp.Status != "ready" || resp.Checks != nil {
+		t.Fatalf("expected a terse ready response, got %d %+v", status, resp)
+	}
+
+	status, resp = decodeReadiness(t, "/readyz?verbose=1")
+	if status != http.StatusOK || len(resp.Checks) != 3 {
+		t.Fatalf("expected every check, got %d %+v", status, resp)
+	}
+	for _, c := range resp.Checks {
+		if !c.OK || c.LatencyMS < 0 {
+			t.Errorf("unexpected check %+v", c)
+		}
+	}
+}
+
+func TestReadyz_NotReady(t *testing.T) {
+	tests := []struct {
+		name   string
+		breaks func(t *testing.T)
+		check  string
+	}{
+		{"draining", func(t *testing.T) {
+			previous := serverDrain
+			serverDrain = newDrainSignal()
+			serverDrain.start()
+			t.Cleanup(func() { serverDrain = previous })
+		}, "draining"},
+		{"migrations pending", func(t *testing.T) {
+			if _, err := storageDB.Exec("PRAGMA user_version = 0"); err != nil {
+				t.Fatalf("failed to reset schema version: %v", err)
+			}
+		}, "schema"},
+		{"database closed", func(t *testing.T) { storageDB.Close() }, "database"},
+		{"scheduler stalled", func(t *testing.T) {
+			alarmScheduler = newScheduler(alarmStore, time.Second)
+			t.Cleanup(func() { alarmScheduler = nil })
+		}, "scheduler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupHandlersForTest(t)
+			tt.breaks(t)
+			status, resp := decodeReadiness(t, "/readyz?verbose=1")
+			if status != http.StatusServiceUnavailable || resp.Status != "not_ready" {
+				t.Fatalf("expected 503 not_ready, got %d %+v", status, resp)
+			}
+			for _, c := range resp.Checks {
+				if c.Name == tt.check && (c.OK || c.Detail == "") {
+					t.Errorf("expected %s to fail with a detail, got %+v", tt.check, c)
+				}
+			}
+		})
+	}
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	w := serveRoutes(t, "GET", "/version", nil)
+	var info BuildInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("failed to decode %s: %v", w.Body.String(), err)
+	}
+	if info.GoVersion != runtime.Version() || info.SchemaVersion != services.SchemaVersion || info.Commit == "" {
+		t.Fatalf("unexpected build info %+v", info)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/services"
+)
+
+// historyStore reads the history that alarms and events are projected from
+var historyStore *services.HistoryStorage
+
+// HistoryResponse lists the domain events of an alarm or event
+type HistoryResponse struct {
+	ID     string                 `json:"id"`
+	Events []services.DomainEvent `json:"events"`
+}
+
+// asOfParam asks a GET operation for the state at a past instant
+var asOfParam = param{Name: "as_of", In: "query",
+	Description: "Answer as of this RFC 3339 time, reconstructed from the history; the response carries no ETag"}
+
+// asOf reads the as_of parameter, the zero time when it is missing. It
+// writes a problem and returns false if it is invalid.
+func asOf(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	raw := r.URL.Query().Get("as_of")
+	if raw == "" {
+		return time.Time{}, true
+	}
+	at, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		var verr services.ValidationError
+		verr.Add("as_of", codeInvalidParameter, "must be an RFC 3339 time such as 2030-01-01T09:00:00Z")
+		writeValidationProblem(w, r, verr.Err())
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// findAt looks up a resource with find, or as it was at when at is set
+func findAt(r *http.Request, find func(id string) (interface{}, error), resourceType, id string, at time.Time) (interface{}, error) {
+	if at.IsZero() {
+		return find(id)
+	}
+	return historyStore.WithContext(r.Context()).AsOf(resourceType, id, at)
+}
+
+// presentedAt is the instant countdowns and elapsed times are computed at
+func presentedAt(at time.Time) time.Time {
+	if at.IsZero() {
+		return time.Now()
+	}
+	return at
+}
+
+func alarmHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	historyHandler(w, r, services.AuditAlarm, codeAlarmNotFound)
+}
+
+func eventHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	historyHandler(w, r, services.AuditEvent, codeEventNotFound)
+}
+
+// historyHandler returns every domain event of an alarm or event, which
+// stays readable after it is deleted
+func historyHandler(w http.ResponseWriter, r *http.Request, resourceType, notFoundCode string) {
+	id := r.URL.Query().Get("id")
+	events, err := historyStore.WithContext(r.Context()).History(resourceType, id)
+	if err != nil {
+		writeLookupProblem(w, r, err, notFoundCode)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HistoryResponse{ID: id, Events: events})
+}
+
+// projectionsUsage describes the projections subcommand
+const projectionsUsage = `usage:
+  clock-service projections rebuild [flags]`
+
+// projectionsCommandLength returns how many of args belong to the
+// projections subcommand; the rest are configuration flags
+func projectionsCommandLength(args []string) (int, error) {
+	if len(args) == 0 || args[0] != "rebuild" {
+		return 0, errors.New(projectionsUsage)
+	}
+	return 1, nil
+}
+
+// runProjections rebuilds the alarms and events tables of the configured
+// database from their history
+func runProjections(cfg config.Config, args []string, out io.Writer) error {
+	db, err := openStorage(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	result, err := historyStore.Rebuild()
+	if err != nil {
+		return fmt.Errorf("rebuild projections: %w", err)
+	}
+	fmt.Fprintf(out, "replayed %d domain events into %d alarms and %d events\n",
+		result.DomainEvents, result.Alarms, result.Events)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ClockAsService/src/config"
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/services"
+)
+
+func TestHistory_ServesPastStates(t *testing.T) {
+	handler := enableAuth(t)
+	key := newKey(t, services.ScopeAlarmsRead, services.ScopeAlarmsWrite)
+	stranger := newKey(t, services.ScopeAlarmsRead)
+	target := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	w := authRequest(handler, "POST", "/v1/alarms/create", key,
+		`{"id":"a1","name":"standup","target":"`+target.Format(time.RFC3339)+`","labels":{"team":"ops"},"acl":{"viewers":[],"editors":[]}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	created := time.Now()
+	w = authRequest(withIfMatch(handler, `"1"`), "PUT", "/v1/alarms/update?id=a1", key,
+		`{"name":"retro","description":"","target":"`+target.Add(time.Hour).Format(time.RFC3339)+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update failed: %d %s", w.Code, w.Body.String())
+	}
+	asOf := "as_of=" + url.QueryEscape(created.Format(time.RFC3339Nano))
+
+	w = authRequest(handler, "GET", "/v1/alarms/countdown?id=a1&"+asOf, key, "")
+	var countdown AlarmCountdownResponse
+	json.NewDecoder(w.Body).Decode(&countdown)
+	if w.Code != http.StatusOK || countdown.Alarm.Name != "standup" || countdown.Alarm.Version != 1 || w.Header().Get("ETag") != "" {
+		t.Fatalf("expected the alarm as created without an ETag, got %d %+v %q", w.Code, countdown, w.Header().Get("ETag"))
+	}
+	if want := target.Sub(created).Seconds(); countdown.Countdown < want-1 || countdown.Countdown > want+1 {
+		t.Errorf("expected the countdown at as_of, about %v, got %v", want, countdown.Countdown)
+	}
+	w = authRequest(handler, "GET", "/v2/alarms/list?"+asOf, key, "")
+	if !strings.Contains(w.Body.String(), `"name":"standup"`) {
+		t.Errorf("expected the list as of creation, got %s", w.Body.String())
+	}
+	w = authRequest(handler, "GET", "/v1/alarms/labels?id=a1&"+asOf, key, "")
+	if !strings.Contains(w.Body.String(), `"team":"ops"`) {
+		t.Errorf("expected the labels as of creation, got %s", w.Body.String())
+	}
+	w = authRequest(handler, "GET", "/v1/alarms/countdown?id=a1&as_of="+url.QueryEscape(created.Add(-time.Hour).Format(time.RFC3339)), key, "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected no alarm before it was created, got %d", w.Code)
+	}
+	w = authRequest(handler, "GET", "/v1/alarms/acl?id=a1&as_of=yesterday", key, "")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), codeInvalidParameter) {
+		t.Errorf("expected a bad as_of to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := authRequest(withIfMatch(handler, `"2"`), "DELETE", "/v1/alarms/delete?id=a1", key, ""); w.Code != http.StatusOK {
+		t.Fatalf("delete failed: %d %s", w.Code, w.Body.String())
+	}
+	w = authRequest(handler, "GET", "/v1/alarms/history?id=a1", key, "")
+	var history HistoryResponse
+	json.NewDecoder(w.Body).Decode(&history)
+	var types []string
+	for _, e := range history.Events {
+		types = append(types, e.Type)
+	}
+	if w.Code != http.StatusOK || strings.Join(types, ",") != "AlarmCreated,AlarmDetailsChanged,AlarmTargetChanged,AlarmDeleted" {
+		t.Fatalf("expected the deleted alarm's history, got %d %v", w.Code, types)
+	}
+	if w := authRequest(handler, "GET", "/v1/alarms/history?id=a1", stranger, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected a private alarm's history to stay private, got %d", w.Code)
+	}
+}
+
+func TestProjectionsCommandLength(t *testing.T) {
+	if n, err := projectionsCommandLength([]string{"rebuild", "-database", "x.db"}); n != 1 || err != nil {
+		t.Errorf("expected rebuild to take one argument, got %d, %v", n, err)
+	}
+	for _, args := range [][]string{nil, {"replay"}, {"-database", "x.db"}} {
+		if _, err := projectionsCommandLength(args); err == nil {
+			t.Errorf("expected %v to be refused", args)
+		}
+	}
+}
+
+func TestRunProjections(t *testing.T) {
+	cfg := config.Default()
+	cfg.Database = filepath.Join(t.TempDir(), "history.db")
+	db, err := openStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventStore.Create(datapkg.Event{ID: "e1", Name: "deploy", StartedAt: time.Now()})
+	eventStore.Create(datapkg.Event{ID: "e2", Name: "release", StartedAt: time.Now()})
+	db.Exec("UPDATE events SET name = 'edited'")
+	db.Close()
+
+	var out bytes.Buffer
+	if err := runProjections(cfg, []string{"rebuild"}, &out); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	if out.String() != "replayed 2 domain events into 0 alarms and 2 events\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	db, err = openStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if raw, err := eventStore.FindByID("e1"); err != nil || raw.(datapkg.Event).Name != "deploy" {
+		t.Errorf("expected the rebuild to restore e1, got %+v, %v", raw, err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"ClockAsService/src/services"
+)
+
+var idempotencyStore *services.IdempotencyStorage
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header value
+const maxIdempotencyKeyLength = 255
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// withIdempotency replays the stored response when a request is retried with
+// the same Idempotency-Key header. Requests without the header are passed
+// through unchanged; server errors release the key so the client can retry.
+// Keys are scoped to operation, the unprefixed route path, and the API
+// version rather than the request path, so a retry sent to /v1/alarms/create
+// replays the response to /alarms/create.
+func withIdempotency(operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeProblem(w, r, codeIdempotencyKeyTooLong, "")
+			return
+		}
+		if validationRules.MaxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, validationRules.MaxBodyBytes)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeDecodeProblem(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		caller, _ := principalOf(r)
+		scope := services.IdempotencyScope(services.TenantOf(r.Context()).ID, caller.ID, r.Method+" "+apiVersionOf(r)+" "+operation)
+		sum := sha256.Sum256(append([]byte(scope+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+
+		stored, err := idempotencyStore.Reserve(key, scope, hash)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyMismatch):
+			writeProblem(w, r, codeIdempotencyKeyMismatch, "")
+			return
+		case errors.Is(err, services.ErrIdempotencyInProgress):
+			writeProblem(w, r, codeIdempotencyKeyInProgress, "")
+			return
+		case err != nil:
+			writeStorageProblem(w, r, err, "")
+			return
+		case stored != nil:
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			if stored.ETag != "" {
+				w.Header().Set("ETag", stored.ETag)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			releaseIdempotencyKey(r, key, scope)
+			return
+		}
+		if err := idempotencyStore.Complete(key, scope, services.IdempotentResponse{
+			Status:      rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			ETag:        rec.Header().Get("ETag"),
+			Body:        rec.body.Bytes(),
+		}); err != nil {
+			// a key left reserved would answer every retry with 409, so give
+			// it up; a retry then repeats the request instead of replaying it
+			requestLogger(r).Error("store idempotent response", "key", key, "error", err)
+			releaseIdempotencyKey(r, key, scope)
+		}
+	}
+}
+
+// releaseIdempotencyKey drops the reservation of key, logging a failure; the
+// reservation then expires with the retention period
+func releaseIdempotencyKey(r *http.Request, key, scope string) {
+	if err := idempotencyStore.Release(key, scope); err != nil {
+		requestLogger(r).Error("release idempotency key", "key", key, "error", err)
+	}
+}
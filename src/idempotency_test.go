@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ClockAsService/src/services"
+)
+
+func postAlarm(t *testing.T, payload map[string]interface{}, key string) *httptest.ResponseRecorder {
+	t.Helper()
+	raw, _ := json.Marshal(payload)
+	req := httptest.NewRequest("POST", "/alarms/create", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	w := httptest.NewRecorder()
+	withIdempotency("/alarms/create", createAlarmHandler)(w, req)
+	return w
+}
+
+func TestCreateAlarm_ClientIDConflict(t *testing.T) {
+	setupHandlersForTest(t)
+
+	payload := map[string]interface{}{
+		"id":     "alarm1",
+		"name":   "first",
+		"target": time.Now().Add(time.Hour).Format(time.RFC3339),
+	}
+	if w := postAlarm(t, payload, ""); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	payload["name"] = "second"
+	if w := postAlarm(t, payload, ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for duplicate id, got %d", w.Code)
+	}
+
+	payload["id"] = "bad id!"
+	if w := postAlarm(t, payload, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid id, got %d", w.Code)
+	}
+}
+
+func TestCreateAlarm_IdempotencyKeyReplays(t *testing.T) {
+	setupHandlersForTest(t)
+
+	payload := map[string]interface{}{
+		"name":   "retry",
+		"target": time.Now().Add(time.Hour).Format(time.RFC3339),
+	}
+	first := postAlarm(t, payload, "job-42")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", first.Code)
+	}
+	second := postAlarm(t, payload, "job-42")
+	if second.Code != http.StatusCreated {
+		t.Fatalf("expected replayed 201, got %d", second.Code)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected Idempotent-Replayed header on retry")
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("expected identical bodies, got %q and %q", first.Body.String(), second.Body.String())
+	}
+	if etag := second.Header().Get("ETag"); etag == "" || etag != first.Header().Get("ETag") {
+		t.Errorf("expected the ETag %q to be replayed, got %q", first.Header().Get("ETag"), etag)
+	}
+
+	list, err := alarmStore.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("expected 1 alarm after retry, got %d", len(list))
+	}
+
+	payload["name"] = "different"
+	if w := postAlarm(t, payload, "job-42"); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for reused key, got %d", w.Code)
+	}
+}
+
+func TestCreateAlarm_IdempotencyKeyIsPerCaller(t *testing.T) {
+	handler := enableAuth(t)
+	alice := newKey(t, services.ScopeAlarmsWrite)
+	bob := newKey(t, services.ScopeAlarmsWrite)
+	body := `{"name":"retry","target":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`
+	post := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/alarms/create", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("Idempotency-Key", "job-42")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post(alice)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", first.Code, first.Body.String())
+	}
+	second := post(bob)
+	if second.Code != http.StatusCreated || second.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected bob's request to run, got %d replayed=%q", second.Code, second.Header().Get("Idempotent-Replayed"))
+	}
+	if first.Body.String() == second.Body.String() {
+		t.Errorf("expected bob not to receive alice's alarm")
+	}
+	if replay := post(alice); replay.Header().Get("Idempotent-Replayed") != "true" || replay.Body.String() != first.Body.String() {
+		t.Errorf("expected alice's retry to replay the first response, got %d %s", replay.Code, replay.Body.String())
+	}
+}
+
+func TestCreateAlarm_IdempotencyKeyIgnoresVersionPrefix(t *testing.T) {
+	setupHandlersForTest(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	body := `{"name":"retry","target":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`
+	post := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "job-42")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("/alarms/create")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", first.Code, first.Body.String())
+	}
+	retry := post("/v1/alarms/create")
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != first.Body.String() {
+		t.Errorf("expected the prefixed retry to replay the first response, got %d %s", retry.Code, retry.Body.String())
+	}
+	// v2 answers in another shape, so its retry must not replay the v1 body
+	if v2 := post("/v2/alarms/create"); v2.Code != http.StatusCreated || v2.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected the v2 request to run, got %d replayed=%q", v2.Code, v2.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestCreateAlarm_IdempotencyKeyReleasedWhenStoringFails(t *testing.T) {
+	setupHandlersForTest(t)
+	idempotencyStore.DB.Exec(`CREATE TRIGGER fail_complete BEFORE UPDATE ON idempotency_keys
+		BEGIN SELECT RAISE(FAIL, 'disk full'); END`)
+
+	payload := map[string]interface{}{
+		"name":   "retry",
+		"target": time.Now().Add(time.Hour).Format(time.RFC3339),
+	}
+	if w := postAlarm(t, payload, "job-42"); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	if w := postAlarm(t, payload, "job-42"); w.Code != http.StatusCreated {
+		t.Fatalf("expected the retry to run again rather than find the key in progress, got %d %s", w.Code, w.Body.String())
+	}
+}
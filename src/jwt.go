@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/jwt"
+	"ClockAsService/src/services"
+)
+
+// tokenAuth validates bearer JWTs; nil unless auth.jwt.jwks is set
+var tokenAuth *jwtAuth
+
+// jwtLeeway absorbs clock skew between the identity provider and this host
+const jwtLeeway = time.Minute
+
+// jwtAuth maps verified tokens to principals
+type jwtAuth struct {
+	verifier       *jwt.Verifier
+	principalClaim string
+	scopesClaim    string
+	scopePrefix    string
+	tenantClaim    string
+	groupsClaim    string
+}
+
+// startJWT loads the JWKS so a bad source stops startup rather than
+// rejecting every token later
+func startJWT(ctx context.Context, cfg config.JWT) (*jwtAuth, error) {
+	keys := jwt.NewKeySet(cfg.JWKS, cfg.Refresh)
+	keys.OnError = func(err error) {
+		slog.Warn("JWKS reload failed; keeping the cached keys", "error", err)
+	}
+	if err := keys.Load(ctx); err != nil {
+		return nil, err
+	}
+	return &jwtAuth{
+		verifier: &jwt.Verifier{
+			Keys:     keys,
+			Issuer:   cfg.Issuer,
+			Audience: cfg.Audience,
+			Leeway:   jwtLeeway,
+		},
+		principalClaim: cfg.PrincipalClaim,
+		scopesClaim:    cfg.ScopesClaim,
+		scopePrefix:    cfg.ScopePrefix,
+		tenantClaim:    cfg.TenantClaim,
+		groupsClaim:    cfg.GroupsClaim,
+	}, nil
+}
+
+// isJWT tells a compact JWT from an API key, which never contains a dot
+func isJWT(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// jwtPrincipal names the caller of a token. It is prefixed like key: and
+// cert: principals, and qualified by the issuer, so a subject can neither
+// pass for an API key or certificate nor for the same subject of another
+// identity provider.
+func jwtPrincipal(issuer, subject string) string {
+	return "jwt:" + issuer + "|" + subject
+}
+
+// authenticate verifies token and maps its claims to a principal. Scopes
+// the identity provider grants for other services are ignored.
+func (a *jwtAuth) authenticate(ctx context.Context, token string) (principal, error) {
+	claims, err := a.verifier.Verify(ctx, token)
+	if err != nil {
+		return principal{}, err
+	}
+	id := claims.String(a.principalClaim)
+	if id == "" {
+		return principal{}, fmt.Errorf("%w: %s is required", jwt.ErrInvalidClaim, a.principalClaim)
+	}
+	p := principal{ID: jwtPrincipal(claims.String("iss"), id)}
+	if a.tenantClaim != "" {
+		p.Tenant = claims.String(a.tenantClaim)
+	}
+	if a.groupsClaim != "" {
+		p.Groups = claims.Strings(a.groupsClaim)
+	}
+	for _, scope := range claims.Strings(a.scopesClaim) {
+		if !strings.HasPrefix(scope, a.scopePrefix) {
+			continue
+		}
+		scope = strings.TrimPrefix(scope, a.scopePrefix)
+		if containsString(services.Scopes, scope) && !containsString(p.Scopes, scope) {
+			p.Scopes = append(p.Scopes, scope)
+		}
+	}
+	return p, nil
+}
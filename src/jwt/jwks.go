@@ -0,0 +1,277 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key is a verification key read from a JWKS
+type Key struct {
+	ID string
+	// Alg restricts the key to one algorithm when the JWKS names one
+	Alg    string
+	Public crypto.PublicKey
+}
+
+// jwk is one entry of a JWKS; only the public parts are read
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS reads the signing keys of a JSON Web Key Set, indexed by key ID.
+// Keys meant for encryption or of an unsupported type are skipped, so a set
+// shared with other uses still loads.
+func ParseJWKS(data []byte) (map[string]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwt: parse JWKS: %w", err)
+	}
+	keys := map[string]Key{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		public, err := k.public()
+		if err != nil {
+			return nil, fmt.Errorf("jwt: JWKS key %d (kid %q): %w", i, k.Kid, err)
+		}
+		if public == nil {
+			continue
+		}
+		keys[k.Kid] = Key{ID: k.Kid, Alg: k.Alg, Public: public}
+	}
+	return keys, nil
+}
+
+var curves = map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+
+// public decodes the key, returning nil for a type this package cannot verify
+func (k jwk) public() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %w", err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("e: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("unusable RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, nil
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("x is not an Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("not a base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// KeySet is a JWKS read from a file or an http(s) URL and cached. It is read
+// again once Refresh has passed, and early when a token names a key it does
+// not hold, so keys rotated by the identity provider are picked up. Reads
+// happen at most once per MinRefetch, and a failed read keeps the keys
+// already held. A read runs without holding the lock, and concurrent
+// lookups that need one share it.
+type KeySet struct {
+	Source     string
+	Refresh    time.Duration
+	MinRefetch time.Duration
+	Client     *http.Client
+	// OnError is called when a reload fails and the cached keys are kept
+	OnError func(error)
+	// Now is the clock, time.Now when nil
+	Now func() time.Time
+
+	mu       sync.Mutex
+	keys     map[string]Key
+	loadedAt time.Time
+	triedAt  time.Time
+	// loading is closed when the read in progress ends; nil when idle
+	loading chan struct{}
+}
+
+// maxJWKSBytes bounds the size of a fetched JWKS
+const maxJWKSBytes = 1 << 20
+
+// NewKeySet reads source, a file path or an http(s) URL, again every
+// refresh. Call Load before the first Key to report a bad source early.
+func NewKeySet(source string, refresh time.Duration) *KeySet {
+	return &KeySet{
+		Source:     source,
+		Refresh:    refresh,
+		MinRefetch: 30 * time.Second,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *KeySet) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Load reads the key set now
+func (s *KeySet) Load(ctx context.Context) error {
+	s.mu.Lock()
+	s.triedAt = s.now()
+	triedAt := s.triedAt
+	s.mu.Unlock()
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys, s.loadedAt = keys, triedAt
+	return nil
+}
+
+// fetch reads and parses the key set without touching the cache
+func (s *KeySet) fetch(ctx context.Context) (map[string]Key, error) {
+	data, err := s.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: read JWKS %s: %w", s.Source, err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwt: JWKS %s has no signing keys", s.Source)
+	}
+	return keys, nil
+}
+
+func (s *KeySet) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(s.Source, "http://") && !strings.HasPrefix(s.Source, "https://") {
+		return os.ReadFile(s.Source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes))
+}
+
+// Key returns the key with the given ID, reloading the set when it is stale
+// or does not hold the key
+func (s *KeySet) Key(ctx context.Context, id string) (Key, error) {
+	s.mu.Lock()
+	stale := s.keys == nil || s.now().Sub(s.loadedAt) >= s.Refresh
+	key, ok := s.keys[id]
+	s.mu.Unlock()
+	if stale || !ok {
+		s.reload(ctx)
+		s.mu.Lock()
+		key, ok = s.keys[id]
+		s.mu.Unlock()
+	}
+	if !ok {
+		return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// reload reads the set again unless it was tried within MinRefetch. When a
+// read is already in progress it waits for that one instead.
+func (s *KeySet) reload(ctx context.Context) {
+	s.mu.Lock()
+	if wait := s.loading; wait != nil {
+		s.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+		}
+		return
+	}
+	now := s.now()
+	if now.Sub(s.triedAt) < s.MinRefetch {
+		s.mu.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	s.loading, s.triedAt = done, now
+	s.mu.Unlock()
+
+	// the read is shared, so one caller giving up does not cancel it for
+	// the others; Client.Timeout bounds it
+	keys, err := s.fetch(context.WithoutCancel(ctx))
+	s.mu.Lock()
+	if err == nil {
+		s.keys, s.loadedAt = keys, now
+	}
+	s.loading = nil
+	close(done)
+	s.mu.Unlock()
+	if err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
@@ -0,0 +1,238 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jwkOf renders the public half of key as a JWK
+func jwkOf(kid string, key interface{}) map[string]string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return map[string]string{"kty": "EC", "kid": kid, "crv": k.Curve.Params().Name,
+			"x": b64(k.X.FillBytes(make([]byte, size))), "y": b64(k.Y.FillBytes(make([]byte, size)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(k)}
+	}
+	panic("unsupported key")
+}
+
+func jwksJSON(keys ...map[string]string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return data
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey := testRSAKey(t)
+	ecKey := mustECKey(t)
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	enc := jwkOf("enc", &rsaKey.PublicKey)
+	enc["use"] = "enc"
+	pinned := jwkOf("pinned", &rsaKey.PublicKey)
+	pinned["alg"] = "RS256"
+	keys, err := ParseJWKS(jwksJSON(
+		jwkOf("rsa", &rsaKey.PublicKey), jwkOf("ec", &ecKey.PublicKey), jwkOf("ed", edPub), pinned, enc,
+		map[string]string{"kty": "oct", "kid": "secret", "k": "c2VjcmV0"},
+	))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if len(keys) != 4 {
+		t.Fatalf("expected the four signing keys, got %v", keys)
+	}
+	if pub, ok := keys["rsa"].Public.(*rsa.PublicKey); !ok || !pub.Equal(&rsaKey.PublicKey) {
+		t.Errorf("RSA key did not round-trip: %#v", keys["rsa"])
+	}
+	if pub, ok := keys["ec"].Public.(*ecdsa.PublicKey); !ok || !pub.Equal(&ecKey.PublicKey) {
+		t.Errorf("EC key did not round-trip: %#v", keys["ec"])
+	}
+	if pub, ok := keys["ed"].Public.(ed25519.PublicKey); !ok || !pub.Equal(edPub) {
+		t.Errorf("Ed25519 key did not round-trip: %#v", keys["ed"])
+	}
+	if keys["pinned"].Alg != "RS256" {
+		t.Errorf("expected the key's alg to be kept, got %q", keys["pinned"].Alg)
+	}
+
+	bad := jwkOf("ec", &ecKey.PublicKey)
+	bad["y"] = bad["x"]
+	if _, err := ParseJWKS(jwksJSON(bad)); err == nil {
+		t.Error("expected a point off the curve to be rejected")
+	}
+	if _, err := ParseJWKS([]byte("not json")); err == nil {
+		t.Error("expected invalid JSON to be rejected")
+	}
+}
+
+func TestKeySet_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksJSON(jwkOf("k1", &testRSAKey(t).PublicKey)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	set := NewKeySet(path, time.Hour)
+	if err := set.Load(context.Background()); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if _, err := set.Key(context.Background(), "k1"); err != nil {
+		t.Errorf("expected k1, got %v", err)
+	}
+	if err := NewKeySet(filepath.Join(t.TempDir(), "missing.json"), time.Hour).Load(context.Background()); err == nil {
+		t.Error("expected a missing file to fail to load")
+	}
+	empty := filepath.Join(t.TempDir(), "empty.json")
+	os.WriteFile(empty, []byte(`{"keys":[]}`), 0o600)
+	if err := NewKeySet(empty, time.Hour).Load(context.Background()); err == nil {
+		t.Error("expected a set without signing keys to fail to load")
+	}
+}
+
+// jwksServer serves whatever keys it currently holds and counts fetches
+type jwksServer struct {
+	mu      sync.Mutex
+	body    []byte
+	status  int
+	fetches int
+	// hold, when set, delays every response until it is closed
+	hold chan struct{}
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.fetches++
+	hold := s.hold
+	s.mu.Unlock()
+	if hold != nil {
+		<-hold
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	w.Write(s.body)
+}
+
+func (s *jwksServer) set(body []byte, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body, s.status = body, status
+}
+
+func TestKeySet_SharesOneReadOutsideTheLock(t *testing.T) {
+	oldKey, newKey := mustECKey(t), mustECKey(t)
+	idp := &jwksServer{body: jwksJSON(jwkOf("old", &oldKey.PublicKey))}
+	srv := httptest.NewServer(idp)
+	defer srv.Close()
+	set := NewKeySet(srv.URL, time.Hour)
+	ctx := context.Background()
+	if err := set.Load(ctx); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	set.MinRefetch = 0
+
+	hold := make(chan struct{})
+	idp.mu.Lock()
+	idp.hold = hold
+	idp.body = jwksJSON(jwkOf("old", &oldKey.PublicKey), jwkOf("new", &newKey.PublicKey))
+	idp.mu.Unlock()
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := set.Key(ctx, "new")
+			errs <- err
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		idp.mu.Lock()
+		fetching := idp.fetches == 2
+		idp.mu.Unlock()
+		if fetching || time.Now().After(deadline) {
+			break
+		}
+	}
+	// cached keys are served while the read is held up
+	if _, err := set.Key(ctx, "old"); err != nil {
+		t.Errorf("expected the cached key during a reload, got %v", err)
+	}
+	close(hold)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected every waiter to get the new key, got %v", err)
+		}
+	}
+	if idp.fetches != 2 {
+		t.Errorf("expected concurrent lookups to share one read, got %d fetches", idp.fetches)
+	}
+}
+
+func TestKeySet_RotatesFromURL(t *testing.T) {
+	oldKey, newKey := mustECKey(t), mustECKey(t)
+	idp := &jwksServer{body: jwksJSON(jwkOf("old", &oldKey.PublicKey))}
+	srv := httptest.NewServer(idp)
+	defer srv.Close()
+
+	now := testNow
+	var reloadErrors []error
+	set := NewKeySet(srv.URL, time.Hour)
+	set.Now = func() time.Time { return now }
+	set.OnError = func(err error) { reloadErrors = append(reloadErrors, err) }
+	if err := set.Load(context.Background()); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	ctx := context.Background()
+
+	// the provider rotates; a token with the new kid makes the set refetch
+	idp.set(jwksJSON(jwkOf("new", &newKey.PublicKey)), 0)
+	now = now.Add(time.Minute)
+	if _, err := set.Key(ctx, "new"); err != nil {
+		t.Fatalf("expected the rotated key to be fetched, got %v", err)
+	}
+	if idp.fetches != 2 {
+		t.Errorf("expected one refetch, got %d fetches", idp.fetches)
+	}
+
+	// unknown kids cannot make the set refetch more than once per MinRefetch
+	now = now.Add(time.Minute)
+	for i := 0; i < 5; i++ {
+		if _, err := set.Key(ctx, "forged"); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("expected ErrUnknownKey, got %v", err)
+		}
+	}
+	if idp.fetches != 3 {
+		t.Errorf("expected refetches to be rate limited, got %d fetches", idp.fetches)
+	}
+
+	// once Refresh has passed a failed reload keeps the cached keys
+	idp.set(nil, http.StatusBadGateway)
+	now = now.Add(2 * time.Hour)
+	if _, err := set.Key(ctx, "new"); err != nil {
+		t.Errorf("expected the cached key to survive a failed reload, got %v", err)
+	}
+	if len(reloadErrors) != 1 {
+		t.Errorf("expected the failed reload to be reported once, got %v", reloadErrors)
+	}
+	if _, err := set.Key(ctx, "old"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected the rotated-out key to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+// Package jwt verifies JSON Web Tokens signed by an identity provider whose
+// public keys are published as a JWKS. Only asymmetric algorithms are
+// accepted: RS*, PS*, ES* and EdDSA. A shared-secret or unsigned token is
+// rejected, whatever its header says.
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Errors returned by Verify, wrapped with detail
+var (
+	ErrMalformed    = errors.New("jwt: malformed token")
+	ErrAlgorithm    = errors.New("jwt: unsupported algorithm")
+	ErrUnknownKey   = errors.New("jwt: unknown key")
+	ErrSignature    = errors.New("jwt: invalid signature")
+	ErrExpired      = errors.New("jwt: token expired")
+	ErrNotYetValid  = errors.New("jwt: token not valid yet")
+	ErrInvalidClaim = errors.New("jwt: invalid claim")
+)
+
+// algorithm describes how one JWS alg value is verified
+type algorithm struct {
+	hash crypto.Hash
+	// kind is the key type the algorithm needs: rsa, pss, ecdsa or eddsa
+	kind string
+	// size is the byte length of each of r and s in an ECDSA signature
+	size int
+}
+
+var algorithms = map[string]algorithm{
+	"RS256": {crypto.SHA256, "rsa", 0},
+	"RS384": {crypto.SHA384, "rsa", 0},
+	"RS512": {crypto.SHA512, "rsa", 0},
+	"PS256": {crypto.SHA256, "pss", 0},
+	"PS384": {crypto.SHA384, "pss", 0},
+	"PS512": {crypto.SHA512, "pss", 0},
+	"ES256": {crypto.SHA256, "ecdsa", 32},
+	"ES384": {crypto.SHA384, "ecdsa", 48},
+	"ES512": {crypto.SHA512, "ecdsa", 66},
+	"EdDSA": {0, "eddsa", 0},
+}
+
+// Claims is the decoded payload of a token. Numbers are float64, as
+// encoding/json decodes them.
+type Claims map[string]interface{}
+
+// String returns a string claim, or "" when it is absent or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim holding either a space-separated string, as OAuth
+// scope does, or an array of strings
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// time returns a NumericDate claim
+func (c Claims) time(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrInvalidClaim, name)
+	}
+	sec := int64(n)
+	return time.Unix(sec, int64((n-float64(sec))*1e9)), true, nil
+}
+
+// KeySource finds the key a token was signed with
+type KeySource interface {
+	Key(ctx context.Context, id string) (Key, error)
+}
+
+// Verifier checks the signature and registered claims of tokens
+type Verifier struct {
+	Keys KeySource
+	// Issuer, when set, must equal the iss claim
+	Issuer string
+	// Audience, when set, must be the aud claim or one of its entries
+	Audience string
+	// Leeway absorbs clock skew in the exp and nbf checks
+	Leeway time.Duration
+	// Now is the clock, time.Now when nil
+	Now func() time.Time
+}
+
+// Verify checks a compact-serialized token and returns its claims. The
+// token must carry an exp claim.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: want three dot-separated parts", ErrMalformed)
+	}
+	var header struct {
+		Alg  string   `json:"alg"`
+		Kid  string   `json:"kid"`
+		Crit []string `json:"crit"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+	}
+	alg, ok := algorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrAlgorithm, header.Alg)
+	}
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("%w: critical header %q is not understood", ErrMalformed, header.Crit[0])
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature is not base64url", ErrMalformed)
+	}
+	key, err := v.Keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.Alg != "" && key.Alg != header.Alg {
+		return nil, fmt.Errorf("%w: key %q is for %s, not %s", ErrAlgorithm, key.ID, key.Alg, header.Alg)
+	}
+	if err := verifySignature(alg, key.Public, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrMalformed, err)
+	}
+	return claims, v.checkClaims(claims)
+}
+
+func (v *Verifier) checkClaims(claims Claims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	exp, ok, err := claims.time("exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: exp is required", ErrInvalidClaim)
+	}
+	if !now.Before(exp.Add(v.Leeway)) {
+		return ErrExpired
+	}
+	nbf, ok, err := claims.time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.Leeway).Before(nbf) {
+		return ErrNotYetValid
+	}
+	if v.Issuer != "" && claims.String("iss") != v.Issuer {
+		return fmt.Errorf("%w: iss is %q, want %q", ErrInvalidClaim, claims.String("iss"), v.Issuer)
+	}
+	if v.Audience != "" {
+		found := false
+		for _, aud := range claims.Strings("aud") {
+			found = found || aud == v.Audience
+		}
+		if !found {
+			return fmt.Errorf("%w: aud does not include %q", ErrInvalidClaim, v.Audience)
+		}
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("not base64url")
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature checks sig over input with a key of the kind alg needs
+func verifySignature(alg algorithm, public crypto.PublicKey, input, sig []byte) error {
+	var digest []byte
+	if alg.hash != 0 {
+		h := alg.hash.New()
+		h.Write(input)
+		digest = h.Sum(nil)
+	}
+	wrongKey := fmt.Errorf("%w: key type does not match the algorithm", ErrAlgorithm)
+	valid := false
+	switch alg.kind {
+	case "rsa", "pss":
+		pub, ok := public.(*rsa.PublicKey)
+		if !ok {
+			return wrongKey
+		}
+		if alg.kind == "rsa" {
+			valid = rsa.VerifyPKCS1v15(pub, alg.hash, digest, sig) == nil
+		} else {
+			valid = rsa.VerifyPSS(pub, alg.hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case "ecdsa":
+		pub, ok := public.(*ecdsa.PublicKey)
+		if !ok || (pub.Curve.Params().BitSize+7)/8 != alg.size {
+			return wrongKey
+		}
+		if len(sig) != 2*alg.size {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(sig[:alg.size])
+		s := new(big.Int).SetBytes(sig[alg.size:])
+		valid = ecdsa.Verify(pub, digest, r, s)
+	case "eddsa":
+		pub, ok := public.(ed25519.PublicKey)
+		if !ok {
+			return wrongKey
+		}
+		valid = ed25519.Verify(pub, input, sig)
+	}
+	if !valid {
+		return ErrSignature
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	rsaOnce sync.Once
+	rsaKey  *rsa.PrivateKey
+)
+
+// testRSAKey is generated once since RSA key generation is slow
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	rsaOnce.Do(func() {
+		var err error
+		if rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic(err)
+		}
+	})
+	return rsaKey
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// sign builds a token the way an identity provider would
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(input))
+	case *ecdsa.PrivateKey:
+		h := algorithms[alg].hash.New()
+		h.Write([]byte(input))
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, k, h.Sum(nil)); err == nil {
+			size := algorithms[alg].size
+			sig = make([]byte, 2*size)
+			r.FillBytes(sig[:size])
+			s.FillBytes(sig[size:])
+		}
+	case *rsa.PrivateKey:
+		h := algorithms[alg].hash.New()
+		h.Write([]byte(input))
+		if strings.HasPrefix(alg, "PS") {
+			sig, err = rsa.SignPSS(rand.Reader, k, algorithms[alg].hash, h.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, algorithms[alg].hash, h.Sum(nil))
+		}
+	}
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	return input + "." + b64(sig)
+}
+
+// staticKeys is a KeySource over a fixed set of keys
+type staticKeys map[string]Key
+
+func (s staticKeys) Key(_ context.Context, id string) (Key, error) {
+	k, ok := s[id]
+	if !ok {
+		return Key{}, ErrUnknownKey
+	}
+	return k, nil
+}
+
+var testNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub": "svc-billing", "iss": "https://idp.example", "aud": []string{"clock", "other"},
+		"exp": testNow.Add(time.Hour).Unix(), "scope": "alarms:read alarms:write",
+	}
+}
+
+func TestVerify_Algorithms(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey := testRSAKey(t)
+	keys := staticKeys{
+		"rsa": {ID: "rsa", Public: &rsaKey.PublicKey},
+		"ec":  {ID: "ec", Public: &ecKey.PublicKey},
+		"ec3": {ID: "ec3", Public: &ec384.PublicKey},
+		"ed":  {ID: "ed", Public: edKey.Public()},
+	}
+	v := &Verifier{Keys: keys, Issuer: "https://idp.example", Audience: "clock", Now: func() time.Time { return testNow }}
+	for _, tc := range []struct {
+		alg, kid string
+		key      crypto.Signer
+	}{
+		{"RS256", "rsa", rsaKey}, {"RS512", "rsa", rsaKey}, {"PS256", "rsa", rsaKey},
+		{"ES256", "ec", ecKey}, {"ES384", "ec3", ec384}, {"EdDSA", "ed", edKey},
+	} {
+		claims, err := v.Verify(context.Background(), sign(t, tc.alg, tc.kid, tc.key, validClaims()))
+		if err != nil {
+			t.Errorf("%s: %v", tc.alg, err)
+			continue
+		}
+		if claims.String("sub") != "svc-billing" || strings.Join(claims.Strings("scope"), ",") != "alarms:read,alarms:write" {
+			t.Errorf("%s: unexpected claims %v", tc.alg, claims)
+		}
+	}
+}
+
+func TestVerify_Rejects(t *testing.T) {
+	rsaKey := testRSAKey(t)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := staticKeys{
+		"rsa":    {ID: "rsa", Public: &rsaKey.PublicKey},
+		"ec":     {ID: "ec", Public: &ecKey.PublicKey},
+		"pinned": {ID: "pinned", Alg: "PS256", Public: &rsaKey.PublicKey},
+	}
+	v := &Verifier{Keys: keys, Issuer: "https://idp.example", Audience: "clock", Leeway: time.Minute,
+		Now: func() time.Time { return testNow }}
+	with := func(name string, value interface{}) map[string]interface{} {
+		c := validClaims()
+		if value == nil {
+			delete(c, name)
+		} else {
+			c[name] = value
+		}
+		return c
+	}
+	good := sign(t, "RS256", "rsa", rsaKey, validClaims())
+	parts := strings.Split(good, ".")
+	tampered := parts[0] + "." + b64([]byte(`{"sub":"admin","exp":9999999999}`)) + "." + parts[2]
+	unsigned := b64([]byte(`{"alg":"none","kid":"rsa"}`)) + "." + parts[1] + "."
+	hmac := b64([]byte(`{"alg":"HS256","kid":"rsa"}`)) + "." + parts[1] + "." + parts[2]
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"two parts", parts[0] + "." + parts[1], ErrMalformed},
+		{"tampered payload", tampered, ErrSignature},
+		{"alg none", unsigned, ErrAlgorithm},
+		{"symmetric alg", hmac, ErrAlgorithm},
+		{"unknown kid", sign(t, "RS256", "gone", rsaKey, validClaims()), ErrUnknownKey},
+		{"key pinned to another alg", sign(t, "RS256", "pinned", rsaKey, validClaims()), ErrAlgorithm},
+		{"key type mismatch", sign(t, "ES256", "rsa", ecKey, validClaims()), ErrAlgorithm},
+		{"wrong key", sign(t, "ES256", "ec", mustECKey(t), validClaims()), ErrSignature},
+		{"expired", sign(t, "RS256", "rsa", rsaKey, with("exp", testNow.Add(-2*time.Minute).Unix())), ErrExpired},
+		{"no exp", sign(t, "RS256", "rsa", rsaKey, with("exp", nil)), ErrInvalidClaim},
+		{"not yet valid", sign(t, "RS256", "rsa", rsaKey, with("nbf", testNow.Add(5*time.Minute).Unix())), ErrNotYetValid},
+		{"issuer", sign(t, "RS256", "rsa", rsaKey, with("iss", "https://evil.example")), ErrInvalidClaim},
+		{"audience", sign(t, "RS256", "rsa", rsaKey, with("aud", "other")), ErrInvalidClaim},
+	}
+	for _, tc := range tests {
+		if _, err := v.Verify(context.Background(), tc.token); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestVerify_Leeway(t *testing.T) {
+	rsaKey := testRSAKey(t)
+	v := &Verifier{Keys: staticKeys{"rsa": {ID: "rsa", Public: &rsaKey.PublicKey}}, Leeway: time.Minute,
+		Now: func() time.Time { return testNow }}
+	claims := validClaims()
+	claims["exp"] = testNow.Add(-30 * time.Second).Unix()
+	claims["nbf"] = testNow.Add(30 * time.Second).Unix()
+	if _, err := v.Verify(context.Background(), sign(t, "RS256", "rsa", rsaKey, claims)); err != nil {
+		t.Errorf("expected skew within the leeway to be accepted, got %v", err)
+	}
+}
+
+func TestClaims_Strings(t *testing.T) {
+	c := Claims{"scope": " a  b ", "scp": []interface{}{"c", 1.0, "d"}, "n": 3.0}
+	if got := strings.Join(c.Strings("scope"), ","); got != "a,b" {
+		t.Errorf("expected space-separated scopes, got %q", got)
+	}
+	if got := strings.Join(c.Strings("scp"), ","); got != "c,d" {
+		t.Errorf("expected string array entries, got %q", got)
+	}
+	if c.Strings("n") != nil || c.Strings("missing") != nil || c.String("n") != "" {
+		t.Error("expected claims of other types to read as empty")
+	}
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
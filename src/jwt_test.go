@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/services"
+)
+
+// testIdP stands in for an identity provider: it signs tokens with a local
+// key whose JWKS is written to a file
+type testIdP struct {
+	key *ecdsa.PrivateKey
+}
+
+func b64url(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// enableJWT turns on authentication with API keys and JWTs from a new
+// testIdP until the test ends
+func enableJWT(t *testing.T, cfg config.JWT) (http.Handler, *testIdP) {
+	t.Helper()
+	handler := enableAuth(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "EC", "kid": "k1", "crv": "P-256", "alg": "ES256",
+		"x": b64url(key.X.FillBytes(make([]byte, 32))), "y": b64url(key.Y.FillBytes(make([]byte, 32))),
+	}}})
+	cfg.JWKS = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(cfg.JWKS, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defaults := config.Default().Auth.JWT
+	cfg.Refresh, cfg.PrincipalClaim, cfg.ScopesClaim = defaults.Refresh, defaults.PrincipalClaim, defaults.ScopesClaim
+	cfg.TenantClaim, cfg.GroupsClaim = defaults.TenantClaim, defaults.GroupsClaim
+	if tokenAuth, err = startJWT(context.Background(), cfg); err != nil {
+		t.Fatalf("startJWT failed: %v", err)
+	}
+	t.Cleanup(func() { tokenAuth = nil })
+	return handler, &testIdP{key: key}
+}
+
+// token signs claims, adding an exp an hour ahead unless one is given
+func (idp *testIdP) token(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64url(header) + "." + b64url(payload)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, idp.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + b64url(sig)
+}
+
+func TestJWT_MapsClaimsToPrincipalAndScopes(t *testing.T) {
+	handler, idp := enableJWT(t, config.JWT{Issuer: "https://idp.example", Audience: "clock", ScopePrefix: "clock/"})
+	claims := func(scope string) map[string]interface{} {
+		return map[string]interface{}{"sub": "svc-billing", "iss": "https://idp.example", "aud": "clock", "scope": scope}
+	}
+	reader := idp.token(t, claims("openid clock/alarms:read alarms:write"))
+	writer := idp.token(t, claims("clock/alarms:write clock/alarms:read"))
+
+	if w := authRequest(handler, "GET", "/v1/alarms/list", reader, ""); w.Code != http.StatusOK {
+		t.Errorf("expected a prefixed read scope to allow listing, got %d %s", w.Code, w.Body.String())
+	}
+	create := `{"id":"a1","name":"wake","target":"2030-01-01T00:00:00Z"}`
+	if w := authRequest(handler, "POST", "/v2/alarms/create", reader, create); w.Code != http.StatusForbidden {
+		t.Errorf("expected an unprefixed scope to be ignored, got %d", w.Code)
+	}
+	w := authRequest(handler, "POST", "/v2/alarms/create", writer, create)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"owner":"jwt:https://idp.example|svc-billing"`) {
+		t.Fatalf("expected the alarm to be owned by the token's subject, got %d %s", w.Code, w.Body.String())
+	}
+	batch := `{"operations":[{"op":"create","type":"alarm","data":{"id":"a2","name":"lunch","target":"2030-01-01T12:00:00Z"}}]}`
+	if w := authRequest(handler, "POST", "/v2/batch", writer, batch); !strings.Contains(w.Body.String(), `"owner":"jwt:https://idp.example|svc-billing"`) {
+		t.Errorf("expected batch creates to be owned by the caller too, got %d %s", w.Code, w.Body.String())
+	}
+	if w := authRequest(handler, "GET", "/v1/alarms/countdown?id=a1", writer, ""); strings.Contains(w.Body.String(), "svc-billing") {
+		t.Errorf("expected v1 responses to keep their shape, got %s", w.Body.String())
+	}
+}
+
+func TestJWT_RejectsInvalidTokens(t *testing.T) {
+	handler, idp := enableJWT(t, config.JWT{Audience: "clock"})
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	forger := &testIdP{key: other}
+	tests := []struct {
+		name, token, want string
+	}{
+		{"expired", idp.token(t, map[string]interface{}{"sub": "a", "aud": "clock", "exp": time.Now().Add(-time.Hour).Unix()}), "token expired"},
+		{"wrong audience", idp.token(t, map[string]interface{}{"sub": "a", "aud": "billing"}), "aud does not include"},
+		{"no subject", idp.token(t, map[string]interface{}{"aud": "clock", "scope": "admin"}), "sub is required"},
+		{"forged", forger.token(t, map[string]interface{}{"sub": "a", "aud": "clock", "scope": "admin"}), "invalid signature"},
+	}
+	for _, tc := range tests {
+		w := authRequest(handler, "GET", "/v1/alarms/list", tc.token, "")
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: expected 401 mentioning %q, got %d %s", tc.name, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestJWT_APIKeysStillWork(t *testing.T) {
+	handler, _ := enableJWT(t, config.JWT{})
+	key, secret, err := apiKeyStore.Create("ci", []string{services.ScopeEventsWrite}, "")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	w := authRequest(handler, "POST", "/v2/events/create", secret, `{"id":"e1","name":"deploy"}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"owner":"key:`+key.ID+`"`) {
+		t.Errorf("expected the event to be owned by the key, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestJWT_SubjectsCannotPassForOtherPrincipals(t *testing.T) {
+	handler, idp := enableJWT(t, config.JWT{})
+	key, secret, err := apiKeyStore.Create("ci", []string{services.ScopeEventsWrite, services.ScopeEventsRead}, "")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if w := authRequest(handler, "POST", "/v1/events/create", secret, `{"id":"e1","name":"deploy","acl":{"viewers":[],"editors":[]}}`); w.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	for _, sub := range []string{"key:" + key.ID, "cert:" + key.ID} {
+		token := idp.token(t, map[string]interface{}{"sub": sub, "scope": "events:read events:write"})
+		if w := authRequest(handler, "GET", "/v1/events/elapsed?id=e1", token, ""); w.Code != http.StatusNotFound {
+			t.Errorf("expected sub %q not to reach the key's private event, got %d", sub, w.Code)
+		}
+	}
+
+	alice := idp.token(t, map[string]interface{}{"sub": "alice", "iss": "https://a.example", "scope": "events:write events:read"})
+	mallory := idp.token(t, map[string]interface{}{"sub": "alice", "iss": "https://b.example", "scope": "events:read"})
+	if w := authRequest(handler, "POST", "/v1/events/create", alice, `{"id":"e2","name":"deploy","acl":{"viewers":[],"editors":[]}}`); w.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	if w := authRequest(handler, "GET", "/v1/events/elapsed?id=e2", mallory, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected the same subject at another issuer to be someone else, got %d", w.Code)
+	}
+}
+
+func TestStartJWT_FailsOnBadSource(t *testing.T) {
+	cfg := config.Default().Auth.JWT
+	cfg.JWKS = filepath.Join(t.TempDir(), "missing.json")
+	if _, err := startJWT(context.Background(), cfg); err == nil {
+		t.Error("expected a missing JWKS to stop startup")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/services"
+)
+
+// keysUsage describes the keys subcommand
+var keysUsage = `usage:
+  clock-service keys list [flags]
+  clock-service keys create <name> <scope>[,<scope>...] [<tenant>] [flags]
+  clock-service keys revoke <id> [flags]
+scopes: ` + strings.Join(services.Scopes, ", ")
+
+// keysCommandLength returns how many of args belong to the keys
+// subcommand; the rest are configuration flags
+func keysCommandLength(args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, errors.New(keysUsage)
+	}
+	n := map[string]int{"list": 1, "create": 3, "revoke": 2}[args[0]]
+	if n == 0 || len(args) < n {
+		return 0, errors.New(keysUsage)
+	}
+	// create takes an optional tenant before the flags
+	if args[0] == "create" && len(args) > n && !strings.HasPrefix(args[n], "-") {
+		n++
+	}
+	for _, arg := range args[1:n] {
+		if strings.HasPrefix(arg, "-") {
+			return 0, errors.New(keysUsage)
+		}
+	}
+	return n, nil
+}
+
+// runKeys lists, creates or revokes API keys in the configured database
+func runKeys(cfg config.Config, args []string, out io.Writer) error {
+	db, err := openStorage(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "create":
+		var tenant string
+		if len(args) > 3 {
+			tenant = args[3]
+			if _, err := tenantStore.Get(tenant); errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("no tenant with id %s", tenant)
+			} else if err != nil {
+				return err
+			}
+		}
+		key, secret, err := apiKeyStore.Create(args[1], strings.Split(args[2], ","), tenant)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "created key %s (%s) with scopes %s", key.ID, key.Name, strings.Join(key.Scopes, ","))
+		if key.Tenant != "" {
+			fmt.Fprintf(out, " in tenant %s", key.Tenant)
+		}
+		fmt.Fprintln(out)
+		fmt.Fprintf(out, "%s\n", secret)
+		fmt.Fprintln(out, "store it now; it cannot be shown again")
+	case "revoke":
+		if err := apiKeyStore.Revoke(args[1]); errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no active key with id %s", args[1])
+		} else if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "revoked key %s\n", args[1])
+	case "list":
+		keys, err := apiKeyStore.List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tTENANT\tCREATED\tLAST USED\tREQUESTS\tREVOKED")
+		for _, k := range keys {
+			tenant := k.Tenant
+			if tenant == "" {
+				tenant = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", k.ID, k.Name, strings.Join(k.Scopes, ","), tenant,
+				formatKeyTime(k.CreatedAt), formatKeyTime(k.LastUsedAt), k.RequestCount, formatKeyTime(k.RevokedAt))
+		}
+		return tw.Flush()
+	}
+	return nil
+}
+
+func formatKeyTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ClockAsService/src/config"
+)
+
+func TestKeysCommandLength(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+		ok   bool
+	}{
+		{[]string{"list", "-database", "x.db"}, 1, true},
+		{[]string{"create", "ci", "alarms:read", "-database", "x.db"}, 3, true},
+		{[]string{"create", "ci", "alarms:read", "acme", "-database", "x.db"}, 4, true},
+		{[]string{"revoke", "abc"}, 2, true},
+		{[]string{"create", "ci"}, 0, false},
+		{[]string{"create", "-database", "x.db"}, 0, false},
+		{[]string{"rotate", "abc"}, 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		n, err := keysCommandLength(tt.args)
+		if n != tt.want || (err == nil) != tt.ok {
+			t.Errorf("keysCommandLength(%v) = %d, %v", tt.args, n, err)
+		}
+	}
+}
+
+func TestRunKeys(t *testing.T) {
+	cfg := config.Default()
+	cfg.Database = filepath.Join(t.TempDir(), "keys.db")
+
+	var out bytes.Buffer
+	if err := runKeys(cfg, []string{"create", "ci", "alarms:read,events:read"}, &out); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	id := strings.Fields(lines[0])[2]
+	if !strings.HasPrefix(lines[1], "ck_") {
+		t.Fatalf("expected the secret to be printed once, got %q", out.String())
+	}
+
+	if err := runKeys(cfg, []string{"create", "bad", "alarms:delete"}, &out); err == nil {
+		t.Error("expected an unknown scope to be rejected")
+	}
+	if err := runKeys(cfg, []string{"create", "lost", "alarms:read", "nobody"}, &out); err == nil || !strings.Contains(err.Error(), "no tenant") {
+		t.Errorf("expected an unknown tenant to be rejected, got %v", err)
+	}
+	out.Reset()
+	if err := runKeys(cfg, []string{"create", "bound", "alarms:read", "default"}, &out); err != nil ||
+		!strings.Contains(out.String(), "in tenant default") {
+		t.Fatalf("create in a tenant failed: %q, %v", out.String(), err)
+	}
+	bound := strings.Fields(out.String())[2]
+	out.Reset()
+	if err := runKeys(cfg, []string{"revoke", id}, &out); err != nil || out.String() != "revoked key "+id+"\n" {
+		t.Fatalf("revoke failed: %q, %v", out.String(), err)
+	}
+	if err := runKeys(cfg, []string{"revoke", id}, &out); err == nil {
+		t.Error("expected a second revoke to fail")
+	}
+
+	out.Reset()
+	if err := runKeys(cfg, []string{"list"}, &out); err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	rows := map[string]string{}
+	for _, row := range strings.Split(strings.TrimSpace(out.String()), "\n")[1:] {
+		rows[strings.Fields(row)[0]] = row
+	}
+	if len(rows) != 2 || !strings.Contains(rows[id], "alarms:read,events:read") ||
+		strings.HasSuffix(rows[id], " -") || strings.Contains(out.String(), "ck_") {
+		t.Fatalf("unexpected list:\n%s", out.String())
+	}
+	if !strings.Contains(rows[bound], " default ") {
+		t.Errorf("expected the bound key's tenant to be listed:\n%s", out.String())
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/services"
+)
+
+// LabelsRequest replaces the full label set of an alarm or event
+type LabelsRequest struct {
+	Labels map[string]string `json:"labels"`
+}
+
+// LabelsResponse returns the current labels of an alarm or event
+type LabelsResponse struct {
+	ID     string            `json:"id"`
+	Labels map[string]string `json:"labels"`
+}
+
+// DeleteResponse reports how many resources a delete removed
+type DeleteResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// labelStore is the subset of storage used by the label and bulk delete handlers
+type labelStore interface {
+	FindByID(id string) (interface{}, error)
+	SetLabels(id string, labels map[string]string, expectedVersion int64) (int64, error)
+	RemoveVersion(id string, expectedVersion int64) error
+	RemoveSelected(sel services.Selector) (int, error)
+}
+
+func alarmLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	labelsHandler(w, r, alarmStore.WithContext(r.Context()), services.AuditAlarm, codeAlarmNotFound)
+}
+
+func eventLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	labelsHandler(w, r, eventStore.WithContext(r.Context()), services.AuditEvent, codeEventNotFound)
+}
+
+// labelsHandler returns labels on GET, as of a past time if asked, and
+// replaces them on PUT, which requires an If-Match header carrying the
+// current ETag
+func labelsHandler(w http.ResponseWriter, r *http.Request, store labelStore, resourceType, notFoundCode string) {
+	id := r.URL.Query().Get("id")
+	var at time.Time
+	switch r.Method {
+	case http.MethodGet:
+		var ok bool
+		if at, ok = asOf(w, r); !ok {
+			return
+		}
+	case http.MethodPut:
+		version, ok := requireIfMatch(w, r, store.FindByID, id, notFoundCode)
+		if !ok {
+			return
+		}
+		var req LabelsRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if err := services.ValidateLabels(req.Labels); err != nil {
+			writeProblem(w, r, codeInvalidLabels, err.Error(), services.FieldError{
+				Field: "labels", Code: codeInvalidLabels, Message: err.Error(),
+			})
+			return
+		}
+		if _, err := store.SetLabels(id, req.Labels, version); err != nil {
+			writeVersionedWriteError(w, r, err, notFoundCode, "Failed to update labels")
+			return
+		}
+	default:
+		writeProblem(w, r, codeMethodNotAllowed, "")
+		return
+	}
+	raw, err := findAt(r, store.FindByID, resourceType, id, at)
+	if err != nil {
+		writeLookupProblem(w, r, err, notFoundCode)
+		return
+	}
+	var labels map[string]string
+	switch v := raw.(type) {
+	case datapkg.Alarm:
+		labels = v.Labels
+	case datapkg.Event:
+		labels = v.Labels
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if !at.IsZero() {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LabelsResponse{ID: id, Labels: labels})
+		return
+	}
+	if r.Method == http.MethodGet && writeETag(w, r, resourceVersion(raw)) {
+		return
+	}
+	w.Header().Set("ETag", etag(resourceVersion(raw)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LabelsResponse{ID: id, Labels: labels})
+}
+
+func deleteAlarmsHandler(w http.ResponseWriter, r *http.Request) {
+	deleteHandler(w, r, alarmStore.WithContext(r.Context()), codeAlarmNotFound)
+}
+
+func deleteEventsHandler(w http.ResponseWriter, r *http.Request) {
+	deleteHandler(w, r, eventStore.WithContext(r.Context()), codeEventNotFound)
+}
+
+// deleteHandler removes a single resource by id, guarded by If-Match, or
+// every resource matching a selector
+func deleteHandler(w http.ResponseWriter, r *http.Request, store labelStore, notFoundCode string) {
+	if r.Method != http.MethodDelete && r.Method != http.MethodPost {
+		writeProblem(w, r, codeMethodNotAllowed, "")
+		return
+	}
+	query := r.URL.Query()
+	if id := query.Get("id"); id != "" {
+		version, ok := requireIfMatch(w, r, store.FindByID, id, notFoundCode)
+		if !ok {
+			return
+		}
+		if err := store.RemoveVersion(id, version); err != nil {
+			writeVersionedWriteError(w, r, err, notFoundCode, "Failed to delete")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DeleteResponse{Deleted: 1})
+		return
+	}
+	sel, err := services.ParseSelector(query.Get("selector"))
+	if err != nil {
+		writeProblem(w, r, codeInvalidSelector, err.Error())
+		return
+	}
+	deleted, err := store.RemoveSelected(sel)
+	if err != nil {
+		if errors.Is(err, services.ErrEmptySelector) {
+			writeProblem(w, r, codeEmptySelector, "")
+			return
+		}
+		writeStorageProblem(w, r, err, "Failed to delete")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeleteResponse{Deleted: deleted})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func TestAlarmLabels_PutAndSelect(t *testing.T) {
+	setupHandlersForTest(t)
+
+	createdRaw, err := alarmStore.Create(datapkg.Alarm{Name: "a", Target: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("failed to create alarm in storage: %v", err)
+	}
+	created := createdRaw.(datapkg.Alarm)
+
+	raw, _ := json.Marshal(map[string]interface{}{"labels": map[string]string{"team": "ops"}})
+	req := httptest.NewRequest("PUT", "/alarms/labels?id="+created.ID, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", etag(created.Version))
+	w := httptest.NewRecorder()
+	alarmLabelsHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if w.Header().Get("ETag") != etag(created.Version+1) {
+		t.Errorf("expected ETag %s, got %s", etag(created.Version+1), w.Header().Get("ETag"))
+	}
+
+	req = httptest.NewRequest("GET", "/alarms/list?selector=team%3Dops", nil)
+	w = httptest.NewRecorder()
+	listAlarmsHandler(w, req)
+	var alarms []datapkg.Alarm
+	if err := json.NewDecoder(w.Body).Decode(&alarms); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if len(alarms) != 1 || alarms[0].ID != created.ID {
+		t.Fatalf("expected the labelled alarm, got %v", alarms)
+	}
+
+	req = httptest.NewRequest("GET", "/alarms/list?selector=team%3D", nil)
+	w = httptest.NewRecorder()
+	listAlarmsHandler(w, req)
+	if err := json.NewDecoder(w.Body).Decode(&alarms); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if len(alarms) != 0 {
+		t.Fatalf("expected no alarms for team=\"\", got %v", alarms)
+	}
+}
+
+func TestAlarmLabels_RejectsInvalidLabels(t *testing.T) {
+	setupHandlersForTest(t)
+
+	raw, _ := json.Marshal(map[string]interface{}{
+		"name":   "bad",
+		"target": time.Now().Add(time.Hour).Format(time.RFC3339),
+		"labels": map[string]string{"team": "not valid"},
+	})
+	req := httptest.NewRequest("POST", "/alarms/create", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	createAlarmHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestDeleteEvents_BySelector(t *testing.T) {
+	setupHandlersForTest(t)
+
+	for _, env := range []string{"prod", "dev", "dev"} {
+		if _, err := eventStore.Create(datapkg.Event{Name: env, StartedAt: time.Now(), Labels: map[string]string{"env": env}}); err != nil {
+			t.Fatalf("failed to create event in storage: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest("DELETE", "/events/delete", nil)
+	w := httptest.NewRecorder()
+	deleteEventsHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without selector, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/events/delete?selector=env%3Ddev", nil)
+	w = httptest.NewRecorder()
+	deleteEventsHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body map[string]int
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if body["deleted"] != 2 {
+		t.Fatalf("expected 2 deleted, got %v", body["deleted"])
+	}
+}
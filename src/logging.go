@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"ClockAsService/src/tracing"
+)
+
+// logLevel is the minimum level logged, adjustable at runtime through
+// /admin/log-level
+var logLevel = new(slog.LevelVar)
+
+// newLogger writes records of at least logLevel to w as JSON or text
+func newLogger(w io.Writer, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	if format == "text" {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// parseLogLevel accepts the level names used in configuration
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// requestLogger returns the default logger with the request ID, and the
+// trace ID when the request is traced, attached
+func requestLogger(r *http.Request) *slog.Logger {
+	logger := slog.Default().With("request_id", requestID(r))
+	if span := tracing.FromContext(r.Context()); span != nil {
+		logger = logger.With("trace_id", span.Context().TraceID.String())
+	}
+	return logger
+}
+
+// logStorageError records the storage failure behind a request
+func logStorageError(r *http.Request, err error) {
+	requestLogger(r).Error("storage error", "method", r.Method, "path", r.URL.Path, "error", err)
+}
+
+// withAccessLog logs one record per request once it has been served
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if sw.status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		requestLogger(r).Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"bytes", sw.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote", r.RemoteAddr,
+		)
+	})
+}
+
+// LogLevel is the body of /admin/log-level
+type LogLevel struct {
+	Level string `json:"level" openapi:"required,enum=debug|info|warn|error"`
+}
+
+// logLevelHandler reports the log level on GET and changes it on PUT. The
+// change lasts until the process exits.
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var req LogLevel
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		level, err := parseLogLevel(req.Level)
+		if err != nil {
+			writeProblem(w, r, codeValidationFailed, err.Error())
+			return
+		}
+		if previous := logLevel.Level(); previous != level {
+			logLevel.Set(level)
+			requestLogger(r).Warn("log level changed", "from", levelName(previous), "to", levelName(level))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LogLevel{Level: levelName(logLevel.Level())})
+}
+
+// levelName is the configuration name of level
+func levelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends log records to the returned buffer as JSON until the
+// test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous, previousLevel := slog.Default(), logLevel.Level()
+	slog.SetDefault(newLogger(&buf, "json"))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		logLevel.Set(previousLevel)
+	})
+	return &buf
+}
+
+// logRecords decodes every record written to buf
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := captureLogs(t)
+	handler := withRequestID(withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})))
+	req := httptest.NewRequest("GET", "/pot?size=small", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	records := logRecords(t, buf)
+	if len(records) != 1 {
+		t.Fatalf("expected one access log record, got %v", records)
+	}
+	rec := records[0]
+	if rec["msg"] != "request" || rec["request_id"] != "req-1" || rec["method"] != "GET" || rec["path"] != "/pot" ||
+		rec["status"] != float64(http.StatusTeapot) || rec["bytes"] != float64(15) {
+		t.Errorf("unexpected record %v", rec)
+	}
+	if _, ok := rec["duration_ms"].(float64); !ok {
+		t.Errorf("expected a latency, got %v", rec)
+	}
+}
+
+func TestStorageErrorsAreLogged(t *testing.T) {
+	setupHandlersForTest(t)
+	buf := captureLogs(t)
+	storageDB.Close()
+
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	req := httptest.NewRequest("GET", "/v1/alarms/list", nil)
+	req.Header.Set("X-Request-ID", "req-2")
+	w := httptest.NewRecorder()
+	withRequestID(mux).ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "closed") {
+		t.Errorf("the storage error leaked to the client: %s", w.Body.String())
+	}
+	records := logRecords(t, buf)
+	if len(records) != 1 || records[0]["msg"] != "storage error" || records[0]["level"] != "ERROR" ||
+		records[0]["request_id"] != "req-2" || !strings.Contains(records[0]["error"].(string), "closed") {
+		t.Fatalf("expected the storage error with its request ID, got %v", records)
+	}
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	buf := captureLogs(t)
+	logLevel.Set(slog.LevelInfo)
+
+	w := serveRoutes(t, "GET", "/admin/log-level", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "{\"level\":\"info\"}\n" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	slog.Debug("hidden")
+	w = serveRoutes(t, "PUT", "/admin/log-level", map[string]string{"level": "debug"})
+	if w.Code != http.StatusOK || w.Body.String() != "{\"level\":\"debug\"}\n" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	slog.Debug("shown")
+	var messages []interface{}
+	for _, rec := range logRecords(t, buf) {
+		messages = append(messages, rec["msg"])
+	}
+	if len(messages) != 2 || messages[0] != "log level changed" || messages[1] != "shown" {
+		t.Errorf("expected the change and the debug record, got %v", messages)
+	}
+
+	w = serveRoutes(t, "PUT", "/admin/log-level", map[string]string{"level": "loud"})
+	if w.Code != http.StatusBadRequest || logLevel.Level() != slog.LevelDebug {
+		t.Errorf("expected an unknown level to be rejected, got %d with level %s", w.Code, logLevel.Level())
+	}
+}
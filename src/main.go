@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/services"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Exit statuses
+const (
+	exitFailure         = 1
+	exitBadConfig       = 2
+	exitShutdownTimeout = 3
+)
+
+func main() {
+	args := os.Args[1:]
+	printConfig := len(args) >= 2 && args[0] == "config" && args[1] == "print"
+	if printConfig {
+		args = args[2:]
+	}
+	var keysCommand []string
+	if len(args) >= 1 && args[0] == "keys" {
+		n, err := keysCommandLength(args[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "clock-service:", err)
+			os.Exit(exitBadConfig)
+		}
+		keysCommand, args = args[1:1+n], args[1+n:]
+	}
+	var projectionsCommand []string
+	if len(args) >= 1 && args[0] == "projections" {
+		n, err := projectionsCommandLength(args[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "clock-service:", err)
+			os.Exit(exitBadConfig)
+		}
+		projectionsCommand, args = args[1:1+n], args[1+n:]
+	}
+	cfg, err := config.Load(args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "clock-service:", err)
+		os.Exit(exitBadConfig)
+	}
+	if printConfig {
+		cfg.Print(os.Stdout)
+		return
+	}
+	if keysCommand != nil {
+		if err := runKeys(cfg, keysCommand, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "clock-service:", err)
+			os.Exit(exitFailure)
+		}
+		return
+	}
+	if projectionsCommand != nil {
+		if err := runProjections(cfg, projectionsCommand, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "clock-service:", err)
+			os.Exit(exitFailure)
+		}
+		return
+	}
+	level, err := parseLogLevel(cfg.Log.Level)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "clock-service:", err)
+		os.Exit(exitBadConfig)
+	}
+	logLevel.Set(level)
+	slog.SetDefault(newLogger(os.Stderr, cfg.Log.Format))
+
+	// the first SIGINT or SIGTERM drains; a second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if err := run(ctx, cfg); err != nil {
+		slog.Error("server stopped", "error", err)
+		if errors.Is(err, errShutdownTimeout) {
+			os.Exit(exitShutdownTimeout)
+		}
+		os.Exit(exitFailure)
+	}
+}
+
+// openStorage opens the database, creates the stores and migrates the
+// schema
+func openStorage(cfg config.Config) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", sqliteDSN(cfg.Database))
+	if err != nil {
+		return nil, fmt.Errorf("open database %s: %w", cfg.Database, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open database %s: %w", cfg.Database, err)
+	}
+	alarmStore = &services.AlarmStorage{DB: db}
+	eventStore = &services.EventStorage{DB: db}
+	searchStore = &services.SearchStorage{DB: db}
+	batchStore = &services.BatchStorage{DB: db}
+	idempotencyStore = &services.IdempotencyStorage{DB: db, Retention: cfg.Retention.IdempotencyKeys}
+	apiKeyStore = &services.APIKeyStorage{DB: db}
+	tenantStore = &services.TenantStorage{DB: db}
+	auditStore = &services.AuditStorage{DB: db}
+	historyStore = &services.HistoryStorage{DB: db}
+	if err := services.MigrateSchema(db, alarmStore, eventStore, searchStore, idempotencyStore, apiKeyStore, tenantStore, auditStore, historyStore); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// sqliteDSN opens path with immediate transactions. Stores read before they
+// write within a transaction; a deferred one only takes the write lock at
+// its first write, so two of them each holding a read lock fail with
+// "database is locked". An immediate one takes the write lock as it begins
+// and waits for it within the driver's busy timeout.
+func sqliteDSN(path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_txlock=immediate"
+}
+
+// run opens the database and serves the API until ctx is done or the server
+// fails, then shuts down gracefully
+func run(ctx context.Context, cfg config.Config) error {
+	db, err := openStorage(cfg)
+	if err != nil {
+		return err
+	}
+	// background workers are stopped, and their spans flushed, before the
+	// database they write to is closed
+	steps := []shutdownStep{
+		{"database", func(context.Context) error { return db.Close() }},
+	}
+	validationRules = cfg.Validation
+	callerLimits.Store(rateLimitsFromConfig(cfg.RateLimit))
+	ipPerMinute = cfg.RateLimit.PerIPPerMinute
+	cors = newCORSPolicy(cfg.CORS)
+	storageDB = db
+
+	authEnabled = cfg.Auth.Mode == "api_key"
+	for _, key := range cfg.Auth.APIKeys {
+		if err := apiKeyStore.Import("auth.api_keys", []string{services.ScopeAdmin}, key); err != nil {
+			db.Close()
+			return fmt.Errorf("import auth.api_keys: %w", err)
+		}
+	}
+	if cfg.Auth.JWT.JWKS != "" {
+		if tokenAuth, err = startJWT(ctx, cfg.Auth.JWT); err != nil {
+			db.Close()
+			return fmt.Errorf("load auth.jwt.jwks: %w", err)
+		}
+	}
+	if !authEnabled {
+		slog.Warn("authentication is disabled; every route is served without an API key")
+	}
+
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	// outermost first: request ID, span, access log, metrics, client IP
+	// rate limit, CORS, auth, tenant, caller and tenant rate limits,
+	// validation
+	var handler http.Handler = withSpecValidation(mux)
+	handler = withRateLimit(mux, routes(), handler)
+	handler = withTenant(mux, routes(), handler)
+	handler = withAuth(mux, routes(), handler)
+	handler = withCORS(handler)
+	handler = withIPRateLimit(mux, routes(), handler)
+	handler = withMetrics(mux, handler)
+	handler = withAccessLog(handler)
+	handler = withTracing(mux, handler)
+	handler = withRequestID(handler)
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadTimeout:       cfg.Timeouts.Read,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader,
+		WriteTimeout:      cfg.Timeouts.Write,
+		IdleTimeout:       cfg.Timeouts.Idle,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	if cfg.TLS.CertFile != "" {
+		if certs, err = newCertReloader(cfg.TLS); err != nil {
+			ln.Close()
+			db.Close()
+			return fmt.Errorf("load tls: %w", err)
+		}
+		srv.TLSConfig = certs.serverConfig()
+		ln = tls.NewListener(ln, srv.TLSConfig)
+	}
+	tracingStep, err := startTracing(cfg.Tracing)
+	if err != nil {
+		ln.Close()
+		db.Close()
+		return fmt.Errorf("start tracing: %w", err)
+	}
+	if tracingStep != nil {
+		steps = append([]shutdownStep{*tracingStep}, steps...)
+	}
+	// the redirect serves as soon as it starts, so it comes last among the
+	// steps that can fail and the earlier ones are undone if it does
+	if cfg.TLS.RedirectListen != "" {
+		redirectStep, err := startRedirect(cfg)
+		if err != nil {
+			ln.Close()
+			for _, step := range steps {
+				step.stop(context.Background())
+			}
+			return fmt.Errorf("listen tls.redirect_listen: %w", err)
+		}
+		steps = append([]shutdownStep{*redirectStep}, steps...)
+	}
+	slog.Info("listening", "address", ln.Addr().String(), "tls", certs != nil)
+	alarmScheduler = newScheduler(alarmStore, cfg.Scheduler.Tick)
+	alarmScheduler.retention = cfg.Retention.FiredAlarms
+	alarmScheduler.start()
+	steps = append([]shutdownStep{
+		{"scheduler", alarmScheduler.stop},
+		startKeyUsageFlush(apiKeyStore, keyUsageFlushInterval),
+	}, steps...)
+	return serve(ctx, srv, ln, serverDrain, cfg.Timeouts.Shutdown, steps)
+}
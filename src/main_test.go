@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"ClockAsService/src/config"
+	datapkg "ClockAsService/src/data"
+)
+
+// openFileStorageForTest opens a database file the way the server does, with
+// its pool of connections, unlike the single in-memory connection of
+// setupHandlersForTest
+func openFileStorageForTest(t *testing.T) {
+	t.Helper()
+	setupHandlersForTest(t)
+	cfg := config.Default()
+	cfg.Database = filepath.Join(t.TempDir(), "clock.db")
+	db, err := openStorage(cfg)
+	if err != nil {
+		t.Fatalf("openStorage failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	storageDB = db
+}
+
+// sendJSON serves a request with a JSON body and If-Match header through
+// handler, returning an error unless it answers 200
+func sendJSON(handler http.HandlerFunc, method, target, ifMatch string, body interface{}) error {
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, target, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", ifMatch)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		return fmt.Errorf("%s %s: %d %s", method, target, w.Code, w.Body.String())
+	}
+	return nil
+}
+
+func TestOpenStorage_ConcurrentWriters(t *testing.T) {
+	openFileStorageForTest(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 800)
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				// half of the alarms are due at once, for the scheduler below
+				target := time.Now().Add(time.Duration(i%2) * time.Hour)
+				alarm := datapkg.Alarm{ID: fmt.Sprintf("a%d-%d", g, i), Name: "standup", Target: target}
+				raw, err := alarmStore.Create(alarm)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				created := raw.(datapkg.Alarm)
+				if err := sendJSON(updateAlarmHandler, "PATCH", "/alarms/update?id="+created.ID, etag(created.Version),
+					map[string]interface{}{"description": "moved"}); err != nil {
+					errs <- err
+					continue
+				}
+				if err := sendJSON(alarmACLHandler, "PUT", "/alarms/acl?id="+created.ID, etag(created.Version+1),
+					map[string]interface{}{"viewers": []string{}, "editors": []string{"group:ops"}}); err != nil {
+					errs <- err
+				}
+			}
+		}(g)
+	}
+	done := make(chan struct{})
+	fired := make(chan int)
+	go func() {
+		n := 0
+		for {
+			due, err := alarmStore.FireDue(time.Now())
+			if err != nil {
+				errs <- err
+			}
+			n += len(due)
+			select {
+			case <-done:
+				fired <- n
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+	n := <-fired
+	due, err := alarmStore.FireDue(time.Now())
+	if err != nil {
+		t.Fatalf("FireDue failed: %v", err)
+	}
+	if n += len(due); n != 100 {
+		t.Errorf("expected every due alarm to fire once, got %d", n)
+	}
+	close(errs)
+	failed := 0
+	for err := range errs {
+		if failed == 0 {
+			t.Errorf("expected concurrent writes to succeed, got %v", err)
+		}
+		failed++
+	}
+	if failed > 0 {
+		t.Errorf("%d writes failed", failed)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ClockAsService/src/metrics"
+)
+
+var (
+	httpRequests = metrics.Default.NewCounterVec(
+		"clock_http_requests_total", "HTTP requests by route pattern, method and status.",
+		"route", "method", "status")
+	httpDuration = metrics.Default.NewHistogramVec(
+		"clock_http_request_duration_seconds", "HTTP request latency by route pattern, method and status.",
+		metrics.DefaultBuckets, "route", "method", "status")
+
+	_ = metrics.Default.NewGaugeFunc("clock_alarms", "Stored alarms by state: pending, due (target reached, not fired yet) or fired.",
+		[]string{"state"}, func(emit func(float64, ...string)) error {
+			if alarmStore == nil {
+				return errors.New("storage is not open")
+			}
+			counts, err := alarmStore.CountStates(time.Now())
+			if err != nil {
+				return err
+			}
+			emit(float64(counts.Pending), "pending")
+			emit(float64(counts.Due), "due")
+			emit(float64(counts.Fired), "fired")
+			return nil
+		})
+	_ = metrics.Default.NewGaugeFunc("clock_events", "Stored events, each an elapsed-time timer.",
+		nil, func(emit func(float64, ...string)) error {
+			if eventStore == nil {
+				return errors.New("storage is not open")
+			}
+			n, err := eventStore.Count()
+			if err != nil {
+				return err
+			}
+			emit(float64(n))
+			return nil
+		})
+)
+
+// metricsHandler serves the default registry in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics.Default.Handler().ServeHTTP(w, r)
+}
+
+// statusWriter remembers the status and body size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// routeOf is the mux pattern that serves r, or "unmatched". Metrics and
+// spans are labelled with it rather than the raw path to bound their
+// number.
+func routeOf(mux *http.ServeMux, r *http.Request) string {
+	if _, route := mux.Handler(r); route != "" {
+		return route
+	}
+	return "unmatched"
+}
+
+// metricMethods are the methods reported as themselves; any other is
+// counted as "other" so clients cannot create unbounded series
+var metricMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// withMetrics counts and times requests served by next, labelled by route
+func withMetrics(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeOf(mux, r)
+		method := r.Method
+		if !metricMethods[method] {
+			method = "other"
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		status := strconv.Itoa(sw.status)
+		httpRequests.Inc(route, method, status)
+		httpDuration.Observe(time.Since(start).Seconds(), route, method, status)
+	})
+}
@@ -0,0 +1,321 @@
+// Package metrics records counters, histograms and gauges and exposes them in
+// the Prometheus text format, version 0.0.4.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics by name
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// Default is the registry served by the application
+var Default = NewRegistry()
+
+type metric interface {
+	write(w io.Writer) error
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.metrics[name] = m
+}
+
+// Write writes every metric sorted by name
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		r.mu.Lock()
+		m := r.metrics[name]
+		r.mu.Unlock()
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the registry to a Prometheus scraper
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// series is the state of one label combination
+type series struct {
+	labels []string
+	value  float64
+	// histogram state
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// vec is the label handling shared by counters and histograms
+type vec struct {
+	name, help, kind string
+	labelNames       []string
+	mu               sync.Mutex
+	series           map[string]*series
+}
+
+// find returns the series with the given label values, or nil
+func (v *vec) find(values []string) *series {
+	if len(values) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labelNames), len(values)))
+	}
+	return v.series[strings.Join(values, "\xff")]
+}
+
+// get returns the series with the given label values, creating it
+func (v *vec) get(values []string) *series {
+	s := v.find(values)
+	if s == nil {
+		s = &series{labels: append([]string(nil), values...)}
+		v.series[strings.Join(values, "\xff")] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values
+func (v *vec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]*series, len(keys))
+	for i, key := range keys {
+		out[i] = v.series[key]
+	}
+	return out
+}
+
+func (v *vec) header(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+	return err
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec registers a counter with the given label names
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec{name: name, help: help, kind: "counter", labelNames: labelNames, series: map[string]*series{}}}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds one to the series with the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to a series
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.name + " cannot decrease")
+	}
+	c.mu.Lock()
+	c.get(labelValues).value += delta
+	c.mu.Unlock()
+}
+
+// Value returns the current value of a series
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s := c.find(labelValues); s != nil {
+		return s.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.header(w); err != nil {
+		return err
+	}
+	for _, s := range c.sorted() {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labelNames, s.labels, "", ""), formatFloat(s.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec counts observations into cumulative buckets, partitioned by
+// labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// DefaultBuckets suit request and query durations in seconds
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogramVec registers a histogram with the given upper bucket bounds
+// in increasing order
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not sorted")
+	}
+	h := &HistogramVec{vec{name: name, help: help, kind: "histogram", labelNames: labelNames, series: map[string]*series{}}, buckets}
+	r.register(name, h)
+	return h
+}
+
+// Observe records v in the series with the given label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Count returns the number of observations in a series
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s := h.find(labelValues); s != nil {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.header(w); err != nil {
+		return err
+	}
+	for _, s := range h.sorted() {
+		for i, bound := range h.buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labelNames, s.labels, "le", formatFloat(bound)), s.counts[i]); err != nil {
+				return err
+			}
+		}
+		labels := formatLabels(h.labelNames, s.labels, "", "")
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, formatLabels(h.labelNames, s.labels, "le", "+Inf"), s.count,
+			h.name, labels, formatFloat(s.sum),
+			h.name, labels, s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GaugeFunc is a gauge whose values are read when the registry is written
+type GaugeFunc struct {
+	name, help string
+	labelNames []string
+	collect    func(emit func(value float64, labelValues ...string)) error
+}
+
+// NewGaugeFunc registers a gauge computed by collect, which calls emit once
+// per series. When collect fails the gauge is left out of that scrape.
+func (r *Registry) NewGaugeFunc(name, help string, labelNames []string, collect func(emit func(value float64, labelValues ...string)) error) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labelNames: labelNames, collect: collect}
+	r.register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) error {
+	var lines []string
+	err := g.collect(func(value float64, labelValues ...string) {
+		if len(labelValues) != len(g.labelNames) {
+			panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", g.name, len(g.labelNames), len(labelValues)))
+		}
+		lines = append(lines, g.name+formatLabels(g.labelNames, labelValues, "", "")+" "+formatFloat(value)+"\n")
+	})
+	if err != nil {
+		return nil
+	}
+	sort.Strings(lines)
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, escapeHelp(g.help), g.name); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, strings.Join(lines, ""))
+	return err
+}
+
+// formatLabels renders {name="value",...}, appending extraName when set
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=\"%s\"", extraName, extraValue)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
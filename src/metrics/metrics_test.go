@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry_WritesTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests served.", "route", "status")
+	requests.Inc("/b", "200")
+	requests.Inc("/a", "404")
+	requests.Add(2, "/a", "200")
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(3, "/a")
+	r.NewGaugeFunc("test_active", "Active \"things\"\nper state.", []string{"state"}, func(emit func(float64, ...string)) error {
+		emit(2, "pending")
+		emit(1, `quo"te`)
+		return nil
+	})
+	r.NewGaugeFunc("test_broken", "Fails to collect.", nil, func(emit func(float64, ...string)) error {
+		return errors.New("database closed")
+	})
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	want := `# HELP test_active Active "things"\nper state.
+# TYPE test_active gauge
+test_active{state="pending"} 2
+test_active{state="quo\"te"} 1
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/a",le="0.1"} 1
+test_latency_seconds_bucket{route="/a",le="1"} 2
+test_latency_seconds_bucket{route="/a",le="+Inf"} 3
+test_latency_seconds_sum{route="/a"} 3.55
+test_latency_seconds_count{route="/a"} 3
+# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{route="/a",status="200"} 2
+test_requests_total{route="/a",status="404"} 1
+test_requests_total{route="/b",status="200"} 1
+`
+	if buf.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	if requests.Value("/a", "200") != 2 || latency.Count("/a") != 3 {
+		t.Errorf("unexpected values %v, %v", requests.Value("/a", "200"), latency.Count("/a"))
+	}
+	// reading a series that was never written must not add it
+	before := buf.String()
+	latency.Count("/b")
+	requests.Value("/c", "500")
+	buf.Reset()
+	r.Write(&buf)
+	if buf.String() != before {
+		t.Errorf("reading created series:\n%s", buf.String())
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Test.").Inc()
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if w.Body.String() != "# HELP test_total Test.\n# TYPE test_total counter\ntest_total 1\n" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestRegistry_Misuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test.", "route")
+	for name, f := range map[string]func(){
+		"duplicate name":    func() { r.NewCounterVec("test_total", "Again.") },
+		"wrong label count": func() { c.Inc("a", "b") },
+		"negative add":      func() { c.Add(-1, "a") },
+		"unsorted buckets":  func() { r.NewHistogramVec("test_seconds", "Test.", []float64{1, 0.5}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func TestWithMetrics_LabelsByRoutePattern(t *testing.T) {
+	setupHandlersForTest(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	handler := withMetrics(mux, mux)
+
+	before := httpRequests.Value("/v1/alarms/countdown", "GET", "404")
+	for _, target := range []string{"/v1/alarms/countdown?id=a", "/v1/alarms/countdown?id=b"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+	if got := httpRequests.Value("/v1/alarms/countdown", "GET", "404") - before; got != 2 {
+		t.Errorf("expected both lookups under one route, got %v", got)
+	}
+
+	before = httpRequests.Value("unmatched", "other", "404")
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/no/such/path", nil))
+	if got := httpRequests.Value("unmatched", "other", "404") - before; got != 1 {
+		t.Errorf("expected an unmatched request with an unknown method, got %v", got)
+	}
+	if httpDuration.Count("/v1/alarms/countdown", "GET", "404") < 2 {
+		t.Error("expected the lookups to be timed")
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	setupHandlersForTest(t)
+	now := time.Now()
+	for _, a := range []datapkg.Alarm{
+		{ID: "due", Name: "due", Target: now.Add(-time.Minute)},
+		{ID: "pending", Name: "pending", Target: now.Add(time.Hour)},
+	} {
+		if _, err := alarmStore.Create(a); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	w := serveRoutes(t, "GET", "/metrics", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{
+		`clock_alarms{state="due"} 1`,
+		`clock_alarms{state="pending"} 1`,
+		`clock_alarms{state="fired"} 0`,
+		"clock_events 0",
+		"# TYPE clock_http_requests_total counter",
+		"# TYPE clock_alarm_firing_lateness_seconds histogram",
+		`clock_storage_operation_duration_seconds_count{store="alarms",operation="create"}`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("expected %q in:\n%s", line, w.Body.String())
+		}
+	}
+}
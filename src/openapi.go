@@ -0,0 +1,371 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ClockAsService/src/services"
+)
+
+// The OpenAPI document is generated from the route table and the Go types it
+// names. Struct fields become properties under their encoding/json names and
+// two optional tags add detail:
+//
+//	doc:"..."                   property description
+//	openapi:"required,rule=name" required flag and a validation rule
+//	openapi:"enum=a|b"           allowed values
+//
+// Rules tie a property to validationRules so limits are documented as
+// configured: id, name, description, target and problem_code.
+
+//go:embed docs.html
+var docsPage []byte
+
+var (
+	specOnce sync.Once
+	specJSON []byte
+)
+
+// openAPISpec renders the document for the routes and validation rules in
+// effect the first time it is called
+func openAPISpec() []byte {
+	specOnce.Do(func() {
+		var err error
+		specJSON, err = json.MarshalIndent(buildOpenAPI(routes(), validationRules), "", "  ")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return specJSON
+}
+
+// openAPIHandler serves the generated OpenAPI document
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec())
+}
+
+// docsPolicy confines the documentation page to its own inline script and
+// style and to fetching the document from this server
+const docsPolicy = "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'"
+
+// docsHandler serves an API reference page that renders /openapi.json. The
+// page is embedded with its renderer and loads no third-party code.
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", docsPolicy)
+	w.Write(docsPage)
+}
+
+// jsonObject is a node of the OpenAPI document
+type jsonObject = map[string]interface{}
+
+// specBuilder accumulates component schemas while the paths are generated
+type specBuilder struct {
+	rules   services.ValidationRules
+	schemas jsonObject
+	types   map[string]reflect.Type
+}
+
+// buildOpenAPI generates the OpenAPI 3 document for rts
+func buildOpenAPI(rts []route, rules services.ValidationRules) jsonObject {
+	b := &specBuilder{rules: rules, schemas: jsonObject{}, types: map[string]reflect.Type{}}
+	problemRef := b.schemaFor(reflect.TypeOf(Problem{}))
+	problem := b.schemas["Problem"].(jsonObject)
+	problem["description"] = problemCatalogue()
+
+	paths := jsonObject{}
+	for _, rt := range rts {
+		item := jsonObject{}
+		for _, op := range rt.Ops {
+			if !rt.Public {
+				op.Errors = append(append([]string(nil), op.Errors...), codeUnauthorized, codeInsufficientScope, codeRateLimited)
+			}
+			if rt.Global {
+				op.Errors = append(op.Errors, codeTenantForbidden)
+			} else if !rt.Public {
+				op.Params = append(append([]param(nil), op.Params...), tenantParam)
+				op.Errors = append(op.Errors, tenantErrors...)
+			}
+			spec := b.operation(op, problemRef)
+			if rt.Public {
+				spec["security"] = []interface{}{}
+			} else if len(op.Scopes) > 0 {
+				spec["x-required-scopes"] = op.Scopes
+			}
+			if rt.Lifecycle.deprecated() {
+				spec["deprecated"] = true
+			}
+			item[strings.ToLower(op.Method)] = spec
+		}
+		paths[rt.Path] = item
+	}
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":       "ClockAsService API",
+			"version":     "1.0.0",
+			"description": "API for creating alarms and events and querying countdown/elapsed time",
+		},
+		"servers": []interface{}{jsonObject{"url": "http://localhost:8080"}},
+		// either scheme carries an API key; public operations override this
+		"security": []interface{}{jsonObject{"bearerAuth": []interface{}{}}, jsonObject{"apiKeyHeader": []interface{}{}}},
+		"paths":    paths,
+		"components": jsonObject{
+			"schemas": b.schemas,
+			"securitySchemes": jsonObject{
+				"bearerAuth":   jsonObject{"type": "http", "scheme": "bearer", "description": "Authorization: Bearer <API key>"},
+				"apiKeyHeader": jsonObject{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+func (b *specBuilder) operation(op operation, problemRef jsonObject) jsonObject {
+	out := jsonObject{"summary": op.Summary}
+	if op.Description != "" {
+		out["description"] = op.Description
+	}
+	if len(op.Params) > 0 {
+		var params []interface{}
+		for _, p := range op.Params {
+			params = append(params, paramSpec(p))
+		}
+		out["parameters"] = params
+	}
+	if op.Request != nil {
+		out["requestBody"] = jsonObject{
+			"required": true,
+			"content": jsonObject{
+				"application/json": jsonObject{"schema": b.schemaFor(reflect.TypeOf(op.Request))},
+			},
+		}
+	}
+
+	responses := jsonObject{}
+	success := jsonObject{"description": http.StatusText(op.Status)}
+	if op.Response != nil {
+		contentType := op.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		content := jsonObject{
+			contentType: jsonObject{"schema": b.schemaFor(reflect.TypeOf(op.Response))},
+		}
+		for mediaType, response := range op.Alternates {
+			content[mediaType] = jsonObject{"schema": b.schemaFor(reflect.TypeOf(response))}
+		}
+		success["content"] = content
+	}
+	responses[fmt.Sprint(op.Status)] = success
+	if op.NotModified {
+		responses["304"] = jsonObject{"description": "The resource still matches If-None-Match"}
+	}
+	for status, response := range op.Also {
+		responses[fmt.Sprint(status)] = jsonObject{
+			"description": http.StatusText(status),
+			"content": jsonObject{
+				"application/json": jsonObject{"schema": b.schemaFor(reflect.TypeOf(response))},
+			},
+		}
+	}
+
+	// problems sharing a status are documented as one response
+	byStatus := map[int][]string{}
+	for _, code := range append(op.Errors, codeInternalError) {
+		status := problemTypes[code].Status
+		if !containsString(byStatus[status], code) {
+			byStatus[status] = append(byStatus[status], code)
+		}
+	}
+	for status, codes := range byStatus {
+		sort.Strings(codes)
+		responses[fmt.Sprint(status)] = jsonObject{
+			"description":     http.StatusText(status) + ": " + strings.Join(codes, ", "),
+			"x-problem-codes": codes,
+			"content":         jsonObject{"application/problem+json": jsonObject{"schema": problemRef}},
+		}
+	}
+	out["responses"] = responses
+	return out
+}
+
+func paramSpec(p param) jsonObject {
+	schema := jsonObject{"type": "string"}
+	if p.Integer {
+		schema["type"] = "integer"
+	}
+	if len(p.Enum) > 0 {
+		schema["enum"] = p.Enum
+	}
+	if p.MaxLength > 0 {
+		schema["maxLength"] = p.MaxLength
+	}
+	out := jsonObject{"name": p.Name, "in": p.In, "required": p.Required, "schema": schema}
+	if p.Description != "" {
+		out["description"] = p.Description
+	}
+	return out
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaFor returns the schema of t. Named structs are added to the
+// components and referenced.
+func (b *specBuilder) schemaFor(t reflect.Type) jsonObject {
+	switch {
+	case t == timeType:
+		return jsonObject{"type": "string", "format": "date-time"}
+	case t == rawJSONType:
+		return jsonObject{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := b.schemaFor(t.Elem())
+		if _, isRef := schema["$ref"]; !isRef {
+			schema["nullable"] = true
+		}
+		return schema
+	case reflect.Interface:
+		return jsonObject{}
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return jsonObject{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return jsonObject{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		// encoding/json writes nil slices and maps as null
+		return jsonObject{"type": "array", "items": b.schemaFor(t.Elem()), "nullable": true}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": b.schemaFor(t.Elem()), "nullable": true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		ref := jsonObject{"$ref": "#/components/schemas/" + t.Name()}
+		if seen, ok := b.types[t.Name()]; ok {
+			if seen != t {
+				panic("openapi: two schemas named " + t.Name())
+			}
+			return ref
+		}
+		b.types[t.Name()] = t
+		b.schemas[t.Name()] = b.structSchema(t)
+		return ref
+	}
+	panic("openapi: unsupported type " + t.String())
+}
+
+func (b *specBuilder) structSchema(t reflect.Type) jsonObject {
+	properties := jsonObject{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		prop := b.schemaFor(f.Type)
+		if doc := f.Tag.Get("doc"); doc != "" {
+			prop = withDescription(prop, doc)
+		}
+		for _, opt := range strings.Split(f.Tag.Get("openapi"), ",") {
+			switch {
+			case opt == "required":
+				required = append(required, name)
+			case strings.HasPrefix(opt, "enum="):
+				prop["enum"] = strings.Split(strings.TrimPrefix(opt, "enum="), "|")
+			case strings.HasPrefix(opt, "rule="):
+				prop = b.applyRule(strings.TrimPrefix(opt, "rule="), prop)
+			}
+		}
+		properties[name] = prop
+	}
+	schema := jsonObject{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// applyRule documents the validation rule a property is checked against
+func (b *specBuilder) applyRule(rule string, prop jsonObject) jsonObject {
+	switch rule {
+	case "id":
+		prop["pattern"] = services.IDPattern
+		prop["x-error-code"] = services.CodeInvalidID
+	case "name":
+		prop["minLength"] = 1
+		if b.rules.MaxNameLength > 0 {
+			prop["maxLength"] = b.rules.MaxNameLength
+		}
+	case "description":
+		if b.rules.MaxDescriptionLength > 0 {
+			prop["maxLength"] = b.rules.MaxDescriptionLength
+		}
+	case "target":
+		if b.rules.MaxTargetHorizon > 0 {
+			prop = withDescription(prop, fmt.Sprintf("Must be in the future and at most %d days ahead", int(b.rules.MaxTargetHorizon.Hours()/24)))
+		}
+	case "problem_code":
+		prop["enum"] = problemCodes()
+	default:
+		panic("openapi: unknown rule " + rule)
+	}
+	return prop
+}
+
+// withDescription adds a description, wrapping references since siblings
+// of $ref are ignored in OpenAPI 3.0
+func withDescription(schema jsonObject, description string) jsonObject {
+	if _, isRef := schema["$ref"]; isRef {
+		return jsonObject{"allOf": []interface{}{schema}, "description": description}
+	}
+	schema["description"] = description
+	return schema
+}
+
+// problemCatalogue renders the problem code table for the Problem schema
+func problemCatalogue() string {
+	var sb strings.Builder
+	sb.WriteString("RFC 7807 problem details returned with application/problem+json for every error. ")
+	sb.WriteString("Branch on code; title and detail are for humans.\n\n")
+	sb.WriteString("| code | status | title |\n|------|--------|-------|\n")
+	for _, code := range problemCodes() {
+		pt := problemTypes[code]
+		fmt.Fprintf(&sb, "| `%s` | %d | %s |\n", code, pt.Status, pt.Title)
+	}
+	return sb.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
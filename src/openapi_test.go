@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"ClockAsService/src/services"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite openapi.json and the golden files in testdata")
+
+const specPath = "../openapi.json"
+
+func generatedSpec(t *testing.T) []byte {
+	t.Helper()
+	spec, err := json.MarshalIndent(buildOpenAPI(routes(), services.DefaultValidationRules()), "", "  ")
+	if err != nil {
+		t.Fatalf("failed to render spec: %v", err)
+	}
+	return append(spec, '\n')
+}
+
+// TestOpenAPISpec_UpToDate keeps the committed openapi.json in step with the
+// code. Run `go test ./src -run TestOpenAPISpec_UpToDate -update` after
+// changing routes or request and response types.
+func TestOpenAPISpec_UpToDate(t *testing.T) {
+	spec := generatedSpec(t)
+	if *updateGolden {
+		if err := os.WriteFile(specPath, spec, 0o644); err != nil {
+			t.Fatalf("failed to write spec: %v", err)
+		}
+	}
+	committed, err := os.ReadFile(specPath)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", specPath, err)
+	}
+	if !bytes.Equal(committed, spec) {
+		t.Fatalf("%s is out of date; rerun with -update", specPath)
+	}
+}
+
+// specCall is one request of the contract test. Requests run in order and
+// may use state recorded from earlier responses.
+type specCall struct {
+	method, path string
+	// key names the resource whose id and ETag are recorded in the state
+	key   string
+	build func(s map[string]string) (query string, headers map[string]string, body interface{})
+}
+
+// TestOpenAPISpec_MatchesHandlers exercises every documented operation
+// through the real mux and validation middleware. It fails when a handler
+// answers with a status the spec does not list, when a success body does not
+// match its schema, or when an operation has no call here.
+func TestOpenAPISpec_MatchesHandlers(t *testing.T) {
+	setupHandlersForTest(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	validator := newSpecValidator(generatedSpec(t))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		validator.serve(w, r, mux)
+	})
+
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	var calls []specCall
+	for _, prefix := range []string{"", "/v1", "/v2"} {
+		for _, kind := range []string{"alarm", "event"} {
+			key := prefix + "/" + kind
+			clock := map[string]string{"alarm": "countdown", "event": "elapsed"}[kind]
+			base := prefix + "/" + kind + "s"
+			byID := func(s map[string]string) (string, map[string]string, interface{}) {
+				return "id=" + s[key+".id"], nil, nil
+			}
+			versioned := func(body interface{}) func(s map[string]string) (string, map[string]string, interface{}) {
+				return func(s map[string]string) (string, map[string]string, interface{}) {
+					return "id=" + s[key+".id"], map[string]string{"If-Match": s[key+".etag"]}, body
+				}
+			}
+			create := map[string]interface{}{"name": "standup", "description": "daily", "labels": map[string]string{"team": "ops"}}
+			replace := map[string]interface{}{"name": "standup", "description": "moved"}
+			if kind == "alarm" {
+				create["target"] = future
+				replace["target"] = future
+			}
+			calls = append(calls,
+				specCall{"POST", base + "/create", key, func(s map[string]string) (string, map[string]string, interface{}) {
+					return "", nil, create
+				}},
+				specCall{"GET", base + "/" + clock, key, byID},
+				specCall{"GET", base + "/list", key, func(s map[string]string) (string, map[string]string, interface{}) {
+					return "selector=team%3Dops", nil, nil
+				}},
+				specCall{"GET", base + "/labels", key, byID},
+				specCall{"PUT", base + "/labels", key, versioned(map[string]interface{}{"labels": map[string]string{"team": "dev"}})},
+				specCall{"GET", base + "/acl", key, byID},
+				specCall{"PUT", base + "/acl", key, versioned(map[string]interface{}{"viewers": []string{"*"}, "editors": []string{"group:ops"}})},
+				specCall{"PUT", base + "/update", key, versioned(replace)},
+				specCall{"PATCH", base + "/update", key, versioned(map[string]interface{}{"name": "renamed"})},
+				specCall{"DELETE", base + "/delete", key, versioned(nil)},
+				specCall{"GET", base + "/history", key, byID},
+			)
+		}
+		calls = append(calls,
+			specCall{"GET", prefix + "/search", "", func(s map[string]string) (string, map[string]string, interface{}) {
+				return "q=standup&type=alarm&limit=5", nil, nil
+			}},
+			specCall{"POST", prefix + "/batch", "", func(s map[string]string) (string, map[string]string, interface{}) {
+				return "", nil, map[string]interface{}{"operations": []interface{}{
+					map[string]interface{}{"op": "create", "type": "event", "data": map[string]interface{}{"name": "deploy"}},
+				}}
+			}},
+		)
+	}
+	calls = append(calls,
+		specCall{"GET", "/alarms/list", "", func(s map[string]string) (string, map[string]string, interface{}) {
+			return "", map[string]string{"Accept": versionMediaType("v2")}, nil
+		}},
+		specCall{"GET", "/versions", "", func(s map[string]string) (string, map[string]string, interface{}) { return "", nil, nil }},
+		specCall{"GET", "/healthz", "", func(s map[string]string) (string, map[string]string, interface{}) { return "", nil, nil }},
+		specCall{"GET", "/readyz", "", func(s map[string]string) (string, map[string]string, interface{}) { return "verbose=1", nil, nil }},
+		specCall{"GET", "/version", "", func(s map[string]string) (string, map[string]string, interface{}) { return "", nil, nil }},
+		specCall{"GET", "/metrics", "", func(s map[string]string) (string, map[string]string, interface{}) { return "", nil, nil }},
+		specCall{"GET", "/admin/log-level", "", func(s map[string]string) (string, map[string]string, interface{}) { return "", nil, nil }},
+		specCall{"PUT", "/admin/log-level", "", func(s map[string]string) (string, map[string]string, interface{}) {
+			return "", nil, map[string]string{"level": "info"}
+		}},
+		specCall{"GET", "/admin/rate-limits", "", func(s map[string]string) (string, map[string]string, interface{}) { return "", nil, nil }},
+		specCall{"PUT", "/admin/rate-limits", "", func(s map[string]string) (string, map[string]string, interface{}) {
+			return "", nil, map[string]int{"reads_per_minute": 0, "writes_per_minute": 0}
+		}},
+		specCall{"POST", "/admin/tenants", "", func(s map[string]string) (string, map[string]string, interface{}) {
+			return "", nil, map[string]interface{}{"id": "acme", "max_active_alarms": 10}
+		}},
+		specCall{"GET", "/admin/tenants", "", func(s map[string]string) (string, map[string]string, interface{}) { return "", nil, nil }},
+		specCall{"POST", "/admin/tenants/suspend", "", func(s map[string]string) (string, map[string]string, interface{}) { return "id=acme", nil, nil }},
+		specCall{"POST", "/admin/tenants/resume", "", func(s map[string]string) (string, map[string]string, interface{}) { return "id=acme", nil, nil }},
+		specCall{"PUT", "/admin/tenants/cors", "", func(s map[string]string) (string, map[string]string, interface{}) {
+			return "id=acme", nil, map[string]interface{}{"allowed_origins": []string{"https://dash.acme.example"}}
+		}},
+		specCall{"DELETE", "/admin/tenants/delete", "", func(s map[string]string) (string, map[string]string, interface{}) { return "id=acme", nil, nil }},
+		specCall{"GET", "/audit", "", func(s map[string]string) (string, map[string]string, interface{}) {
+			return "resource_type=alarm&action=create&limit=2", nil, nil
+		}},
+		specCall{"GET", "/audit/export", "", func(s map[string]string) (string, map[string]string, interface{}) { return "after=1", nil, nil }},
+		specCall{"GET", "/admin/audit/verify", "", func(s map[string]string) (string, map[string]string, interface{}) { return "", nil, nil }},
+		specCall{"GET", "/openapi.json", "", func(s map[string]string) (string, map[string]string, interface{}) { return "", nil, nil }},
+		specCall{"GET", "/docs", "", func(s map[string]string) (string, map[string]string, interface{}) { return "", nil, nil }},
+	)
+
+	covered := map[string]bool{}
+	state := map[string]string{}
+	for _, call := range calls {
+		name := call.method + " " + call.path
+		covered[name] = true
+		query, headers, body := call.build(state)
+		target := call.path
+		if query != "" {
+			target += "?" + query
+		}
+		var reader *bytes.Reader
+		if body != nil {
+			raw, _ := json.Marshal(body)
+			reader = bytes.NewReader(raw)
+		} else {
+			reader = bytes.NewReader(nil)
+		}
+		req := httptest.NewRequest(call.method, target, reader)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		op := validator.paths[call.path].(map[string]interface{})[strings.ToLower(call.method)].(map[string]interface{})
+		responses := op["responses"].(map[string]interface{})
+		documented, ok := responses[fmt.Sprint(w.Code)].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: status %d is not documented (body %s)", name, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code >= 300 {
+			t.Errorf("%s: expected success, got %d (%s)", name, w.Code, w.Body.String())
+			continue
+		}
+		if etag := w.Header().Get("ETag"); etag != "" {
+			state[call.key+".etag"] = etag
+		}
+		content, _ := documented["content"].(map[string]interface{})
+		contentType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		media, ok := content[contentType].(map[string]interface{})
+		if !ok || !strings.HasSuffix(contentType, "json") {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(w.Body.Bytes()))
+		dec.UseNumber()
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			t.Errorf("%s: response is not JSON: %v", name, err)
+			continue
+		}
+		var verr services.ValidationError
+		validator.validate(&verr, "", media["schema"].(map[string]interface{}), doc)
+		if err := verr.Err(); err != nil {
+			t.Errorf("%s: response does not match the spec: %v", name, err)
+		}
+		if obj, ok := doc.(map[string]interface{}); ok && strings.HasSuffix(call.path, "/create") {
+			// v1 writes the storage struct, v2 the snake_case DTO
+			for _, field := range []string{"ID", "id"} {
+				if id, ok := obj[field].(string); ok {
+					state[call.key+".id"] = id
+				}
+			}
+		}
+	}
+
+	for path, item := range validator.paths {
+		for method := range item.(map[string]interface{}) {
+			if name := strings.ToUpper(method) + " " + path; !covered[name] {
+				t.Errorf("%s is documented but not exercised by this test", name)
+			}
+		}
+	}
+}
+
+func TestDocs_LoadsNoThirdPartyCode(t *testing.T) {
+	w := httptest.NewRecorder()
+	docsHandler(w, httptest.NewRequest("GET", "/docs", nil))
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "default-src 'none'") {
+		t.Errorf("expected a restrictive Content-Security-Policy, got %q", csp)
+	}
+	body := w.Body.String()
+	for _, external := range []string{"<script src", "http://", "https://"} {
+		if strings.Contains(body, external) {
+			t.Errorf("expected the docs page not to reference %q", external)
+		}
+	}
+}
+
+func TestSpecValidation_RejectsRequestsOutsideTheSpec(t *testing.T) {
+	setupHandlersForTest(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	handler := withSpecValidation(mux)
+
+	tests := []struct {
+		name, method, target, body string
+		status                     int
+		code                       string
+	}{
+		{"undeclared method", "GET", "/alarms/create", "", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"missing required parameter", "GET", "/alarms/countdown", "", http.StatusBadRequest, codeValidationFailed},
+		{"mistyped parameter", "GET", "/search?q=a&limit=many", "", http.StatusBadRequest, codeInvalidParameter},
+		{"parameter outside enum", "GET", "/search?q=a&type=timer", "", http.StatusBadRequest, codeInvalidParameter},
+		{"wrong body type", "POST", "/events/create", `{"name":["a"]}`, http.StatusBadRequest, codeInvalidFieldType},
+		{"nested unknown field", "POST", "/batch", `{"operations":[{"op":"create","type":"event","extra":1}]}`, http.StatusBadRequest, codeUnknownField},
+		{"id pattern", "POST", "/events/create", `{"id":"-x","name":"a"}`, http.StatusBadRequest, codeInvalidID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d (%s)", tt.status, w.Code, w.Body.String())
+			}
+			if p := decodeProblem(t, w); p.Code != tt.code {
+				t.Fatalf("expected %s, got %s (%+v)", tt.code, p.Code, p.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"ClockAsService/src/services"
+)
+
+// Stable problem codes. Clients should branch on these rather than on titles
+// or details, which may change.
+const (
+	codeInvalidJSON              = "invalid_json"
+	codeInvalidTimeFormat        = "invalid_time_format"
+	codeInvalidFieldType         = "invalid_field_type"
+	codeValidationFailed         = "validation_failed"
+	codeTargetInPast             = services.CodeTargetInPast
+	codeTargetTooFar             = services.CodeTargetTooFar
+	codeUnknownField             = "unknown_field"
+	codeInvalidID                = services.CodeInvalidID
+	codeInvalidLabels            = services.CodeInvalidLabels
+	codeInvalidACL               = services.CodeInvalidACL
+	codeInvalidSelector          = "invalid_selector"
+	codeEmptySelector            = "empty_selector"
+	codeInvalidQuery             = "invalid_query"
+	codeInvalidParameter         = "invalid_parameter"
+	codeInvalidETag              = "invalid_etag"
+	codeIdempotencyKeyTooLong    = "idempotency_key_too_long"
+	codeAlarmNotFound            = "alarm_not_found"
+	codeEventNotFound            = "event_not_found"
+	codeResourceNotFound         = "resource_not_found"
+	codeMethodNotAllowed         = "method_not_allowed"
+	codeAlreadyExists            = "already_exists"
+	codeIdempotencyKeyInProgress = "idempotency_key_in_progress"
+	codeVersionMismatch          = "version_mismatch"
+	codeBatchTooLarge            = "batch_too_large"
+	codeBodyTooLarge             = "body_too_large"
+	codeUnsupportedMediaType     = "unsupported_media_type"
+	codeUnsupportedVersion       = "unsupported_version"
+	codeBatchAborted             = "batch_aborted"
+	codeIdempotencyKeyMismatch   = "idempotency_key_mismatch"
+	codePreconditionRequired     = "precondition_required"
+	codeUnauthorized             = "unauthorized"
+	codeInsufficientScope        = "insufficient_scope"
+	codePermissionDenied         = "permission_denied"
+	codeTenantForbidden          = "tenant_forbidden"
+	codeTenantNotFound           = "tenant_not_found"
+	codeTenantSuspended          = "tenant_suspended"
+	codeRateLimited              = "rate_limited"
+	codeOriginForbidden          = "origin_forbidden"
+	codeQuotaExceeded            = "quota_exceeded"
+	codeInternalError            = "internal_error"
+	codeStorageUnavailable       = "storage_unavailable"
+)
+
+// problemType is the fixed status and title of a problem code
+type problemType struct {
+	Status int
+	Title  string
+}
+
+// problemTypes is the catalogue of every code the API can return
+var problemTypes = map[string]problemType{
+	codeInvalidJSON:              {http.StatusBadRequest, "Request body is not valid JSON"},
+	codeInvalidTimeFormat:        {http.StatusBadRequest, "Time value is not in RFC 3339 format"},
+	codeInvalidFieldType:         {http.StatusBadRequest, "Field has the wrong JSON type"},
+	codeValidationFailed:         {http.StatusBadRequest, "Request failed validation"},
+	codeTargetInPast:             {http.StatusBadRequest, "Target must be in the future"},
+	codeTargetTooFar:             {http.StatusBadRequest, "Target is too far in the future"},
+	codeUnknownField:             {http.StatusBadRequest, "Request body has an unknown field"},
+	codeInvalidID:                {http.StatusBadRequest, "Invalid id"},
+	codeInvalidLabels:            {http.StatusBadRequest, "Invalid labels"},
+	codeInvalidACL:               {http.StatusBadRequest, "Invalid access control list"},
+	codeInvalidSelector:          {http.StatusBadRequest, "Invalid label selector"},
+	codeEmptySelector:            {http.StatusBadRequest, "An id or a non-empty selector is required"},
+	codeInvalidQuery:             {http.StatusBadRequest, "Invalid search query"},
+	codeInvalidParameter:         {http.StatusBadRequest, "Invalid query parameter"},
+	codeInvalidETag:              {http.StatusBadRequest, "Malformed entity tag"},
+	codeIdempotencyKeyTooLong:    {http.StatusBadRequest, "Idempotency-Key is too long"},
+	codeAlarmNotFound:            {http.StatusNotFound, "Alarm not found"},
+	codeEventNotFound:            {http.StatusNotFound, "Event not found"},
+	codeResourceNotFound:         {http.StatusNotFound, "Resource not found"},
+	codeMethodNotAllowed:         {http.StatusMethodNotAllowed, "Method not allowed"},
+	codeAlreadyExists:            {http.StatusConflict, "A resource with this id already exists"},
+	codeIdempotencyKeyInProgress: {http.StatusConflict, "A request with this Idempotency-Key is in progress"},
+	codeVersionMismatch:          {http.StatusPreconditionFailed, "Resource has been modified"},
+	codeBatchTooLarge:            {http.StatusRequestEntityTooLarge, "Batch has too many operations"},
+	codeBodyTooLarge:             {http.StatusRequestEntityTooLarge, "Request body is too large"},
+	codeUnsupportedMediaType:     {http.StatusUnsupportedMediaType, "Unsupported Content-Type"},
+	codeUnsupportedVersion:       {http.StatusNotAcceptable, "Accept names no served API version"},
+	codeBatchAborted:             {http.StatusFailedDependency, "Not applied because another operation in the atomic batch failed"},
+	codeIdempotencyKeyMismatch:   {http.StatusUnprocessableEntity, "Idempotency-Key was used with a different request"},
+	codePreconditionRequired:     {http.StatusPreconditionRequired, "If-Match header is required"},
+	codeUnauthorized:             {http.StatusUnauthorized, "A valid API key is required"},
+	codeInsufficientScope:        {http.StatusForbidden, "API key lacks a required scope"},
+	codePermissionDenied:         {http.StatusForbidden, "Caller may view but not change this resource"},
+	codeTenantForbidden:          {http.StatusForbidden, "Caller may not act in this tenant"},
+	codeTenantNotFound:           {http.StatusNotFound, "Tenant not found"},
+	codeTenantSuspended:          {http.StatusForbidden, "Tenant is suspended"},
+	codeOriginForbidden:          {http.StatusForbidden, "Origin may not call this tenant"},
+	codeRateLimited:              {http.StatusTooManyRequests, "Tenant request rate limit exceeded"},
+	codeQuotaExceeded:            {http.StatusForbidden, "Tenant has reached its limit of active alarms"},
+	codeInternalError:            {http.StatusInternalServerError, "Internal error"},
+	codeStorageUnavailable:       {http.StatusServiceUnavailable, "Storage is unavailable"},
+}
+
+// problemTypeURI is the RFC 7807 type for a code
+func problemTypeURI(code string) string {
+	return "urn:clock:problem:" + code
+}
+
+// problemCodes returns every catalogued code in a stable order
+func problemCodes() []string {
+	codes := make([]string, 0, len(problemTypes))
+	for code := range problemTypes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Problem is an RFC 7807 problem details body extended with a stable code,
+// the request ID and field-level validation errors
+type Problem struct {
+	Type      string                `json:"type" openapi:"required" doc:"urn:clock:problem:<code>"`
+	Title     string                `json:"title" openapi:"required"`
+	Status    int                   `json:"status" openapi:"required"`
+	Detail    string                `json:"detail,omitempty"`
+	Instance  string                `json:"instance,omitempty" doc:"Request path"`
+	Code      string                `json:"code" openapi:"required,rule=problem_code"`
+	RequestID string                `json:"request_id,omitempty" doc:"Value of the X-Request-ID response header"`
+	Errors    []services.FieldError `json:"errors,omitempty"`
+}
+
+// writeProblem sends an application/problem+json response for a catalogued code
+func writeProblem(w http.ResponseWriter, r *http.Request, code, detail string, fieldErrors ...services.FieldError) {
+	pt, ok := problemTypes[code]
+	if !ok {
+		code, pt = codeInternalError, problemTypes[codeInternalError]
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(pt.Status)
+	json.NewEncoder(w).Encode(Problem{
+		Type:      problemTypeURI(code),
+		Title:     pt.Title,
+		Status:    pt.Status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: requestID(r),
+		Errors:    fieldErrors,
+	})
+}
+
+// writeValidationProblem reports every failed field at once. A single failure
+// is reported under its own code so clients can match it directly.
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var verr *services.ValidationError
+	if !errors.As(err, &verr) {
+		writeProblem(w, r, codeValidationFailed, err.Error())
+		return
+	}
+	code := codeValidationFailed
+	if len(verr.Errors) == 1 {
+		if _, ok := problemTypes[verr.Errors[0].Code]; ok {
+			code = verr.Errors[0].Code
+		}
+	}
+	writeProblem(w, r, code, verr.Error(), verr.Errors...)
+}
+
+// writeDecodeProblem classifies a JSON decoding failure
+func writeDecodeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var timeErr *time.ParseError
+	var typeErr *json.UnmarshalTypeError
+	var sizeErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &sizeErr):
+		writeProblem(w, r, codeBodyTooLarge, fmt.Sprintf("request body must be at most %d bytes", sizeErr.Limit))
+	case errors.As(err, &timeErr):
+		writeProblem(w, r, codeInvalidTimeFormat, err.Error())
+	case errors.As(err, &typeErr):
+		writeProblem(w, r, codeInvalidFieldType, err.Error(), services.FieldError{
+			Field:   typeErr.Field,
+			Code:    codeInvalidFieldType,
+			Message: "expected " + typeErr.Type.String(),
+		})
+	default:
+		if field, ok := unknownField(err); ok {
+			writeProblem(w, r, codeUnknownField, err.Error(), services.FieldError{
+				Field:   field,
+				Code:    codeUnknownField,
+				Message: "unknown field",
+			})
+			return
+		}
+		writeProblem(w, r, codeInvalidJSON, err.Error())
+	}
+}
+
+// writeStorageProblem logs the storage error behind a request and reports
+// it to the client without the underlying detail
+func writeStorageProblem(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	logStorageError(r, err)
+	writeProblem(w, r, codeStorageUnavailable, detail)
+}
+
+// writeLookupProblem distinguishes a missing resource from a storage failure
+func writeLookupProblem(w http.ResponseWriter, r *http.Request, err error, notFoundCode string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeProblem(w, r, notFoundCode, "")
+		return
+	}
+	writeStorageProblem(w, r, err, "")
+}
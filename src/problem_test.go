@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder) Problem {
+	t.Helper()
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("expected application/problem+json, got %q", ct)
+	}
+	var p Problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatalf("failed to decode problem: %v", err)
+	}
+	if p.Status != w.Code {
+		t.Fatalf("problem status %d does not match response status %d", p.Status, w.Code)
+	}
+	return p
+}
+
+func TestProblemCatalogue(t *testing.T) {
+	for _, code := range problemCodes() {
+		pt := problemTypes[code]
+		if pt.Status < 400 || pt.Title == "" {
+			t.Errorf("code %s has status %d and title %q", code, pt.Status, pt.Title)
+		}
+	}
+}
+
+func TestProblemCatalogue_Documented(t *testing.T) {
+	spec, err := os.ReadFile(specPath)
+	if err != nil {
+		t.Fatalf("failed to read openapi.json: %v", err)
+	}
+	for _, code := range problemCodes() {
+		if !strings.Contains(string(spec), "`"+code+"`") {
+			t.Errorf("code %s is missing from the Problem schema in openapi.json", code)
+		}
+	}
+}
+
+func TestCreateAlarm_DecodeProblems(t *testing.T) {
+	setupHandlersForTest(t)
+
+	cases := map[string]string{
+		`{"name":`: codeInvalidJSON,
+		`{"name":"a","description":"b","target":"soon"}`: codeInvalidTimeFormat,
+		`{"name":1,"description":"b"}`:                   codeInvalidFieldType,
+	}
+	for body, code := range cases {
+		req := httptest.NewRequest("POST", "/alarms/create", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		createAlarmHandler(w, req)
+		if p := decodeProblem(t, w); p.Code != code {
+			t.Errorf("body %s: expected %s, got %s", body, code, p.Code)
+		}
+	}
+}
+
+func TestCreateAlarm_ReportsAllFieldErrors(t *testing.T) {
+	setupHandlersForTest(t)
+
+	body := `{"id":"-bad","name":"a","description":"b","target":"2000-01-01T00:00:00Z","labels":{"":"x"}}`
+	req := httptest.NewRequest("POST", "/alarms/create", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	createAlarmHandler(w, req)
+	p := decodeProblem(t, w)
+	if p.Code != codeValidationFailed {
+		t.Fatalf("expected %s, got %s", codeValidationFailed, p.Code)
+	}
+	fields := map[string]bool{}
+	for _, fe := range p.Errors {
+		fields[fe.Field] = true
+	}
+	for _, field := range []string{"id", "labels", "target"} {
+		if !fields[field] {
+			t.Errorf("expected a field error for %s, got %+v", field, p.Errors)
+		}
+	}
+}
+
+func TestLookupProblem_DistinguishesStorageFailure(t *testing.T) {
+	setupHandlersForTest(t)
+
+	req := httptest.NewRequest("GET", "/alarms/countdown?id=missing", nil)
+	w := httptest.NewRecorder()
+	getAlarmCountdownHandler(w, req)
+	if p := decodeProblem(t, w); p.Code != codeAlarmNotFound {
+		t.Fatalf("expected %s, got %s", codeAlarmNotFound, p.Code)
+	}
+
+	alarmStore.DB.Close()
+	w = httptest.NewRecorder()
+	getAlarmCountdownHandler(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 with a closed database, got %d", w.Code)
+	}
+	if p := decodeProblem(t, w); p.Code != codeStorageUnavailable {
+		t.Fatalf("expected %s, got %s", codeStorageUnavailable, p.Code)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/metrics"
+	"ClockAsService/src/services"
+)
+
+// RateLimits are the requests each caller may make per minute, by route
+// class. They start from rate_limit.* and change through /admin/rate-limits.
+type RateLimits struct {
+	ReadsPerMinute  int `json:"reads_per_minute" doc:"GET requests each caller may make per minute; 0 is unlimited"`
+	WritesPerMinute int `json:"writes_per_minute" doc:"Other requests each caller may make per minute; 0 is unlimited"`
+}
+
+// perMinute is the limit of a route class
+func (l RateLimits) perMinute(class string) int {
+	if class == classRead {
+		return l.ReadsPerMinute
+	}
+	return l.WritesPerMinute
+}
+
+// Route classes, limited separately so polling cannot starve writes
+const (
+	classRead  = "read"
+	classWrite = "write"
+)
+
+// routeClass is the class of r's method
+func routeClass(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return classRead
+	}
+	return classWrite
+}
+
+// rateLimitsVar holds the current RateLimits, like slog.LevelVar holds a
+// level
+type rateLimitsVar struct {
+	mu     sync.RWMutex
+	limits RateLimits
+}
+
+func (v *rateLimitsVar) Load() RateLimits {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.limits
+}
+
+func (v *rateLimitsVar) Store(l RateLimits) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.limits = l
+}
+
+// callerLimits are the per-caller limits in force; zero until run applies
+// the configuration
+var callerLimits = &rateLimitsVar{}
+
+// rateLimitsFromConfig converts the rate_limit settings
+func rateLimitsFromConfig(cfg config.RateLimit) RateLimits {
+	return RateLimits{ReadsPerMinute: cfg.ReadsPerMinute, WritesPerMinute: cfg.WritesPerMinute}
+}
+
+// ipPerMinute is rate_limit.per_ip_per_minute, set by run
+var ipPerMinute int
+
+// limiter holds the token buckets of every client IP, caller and tenant
+var limiter = newRateLimiter()
+
+var (
+	rateLimited = metrics.Default.NewCounterVec(
+		"clock_rate_limited_total", "Requests refused with 429 by the limit they exceeded (ip, caller or tenant) and route class.",
+		"scope", "class")
+	_ = metrics.Default.NewGaugeFunc("clock_rate_limit_per_minute", "Requests each caller may make per minute by route class; 0 is unlimited.",
+		[]string{"class"}, func(emit func(float64, ...string)) error {
+			limits := callerLimits.Load()
+			emit(float64(limits.ReadsPerMinute), classRead)
+			emit(float64(limits.WritesPerMinute), classWrite)
+			return nil
+		})
+	_ = metrics.Default.NewGaugeFunc("clock_rate_limit_buckets", "Client IPs, callers and tenants whose rate limit is partly spent.",
+		nil, func(emit func(float64, ...string)) error {
+			emit(float64(limiter.size()))
+			return nil
+		})
+)
+
+// withIPRateLimit spends a token of the client IP's bucket before anything
+// else looks at the request, so unauthenticated requests and CORS
+// preflights are limited without costing a database lookup. Requests over
+// the limit get 429 with Retry-After. Public routes are not limited so
+// probes keep working. It runs outside withCORS and withAuth.
+func withIPRateLimit(mux *http.ServeMux, rts []route, next http.Handler) http.Handler {
+	byPath := map[string]route{}
+	for _, rt := range rts {
+		byPath[rt.Path] = rt
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rt, known := byPath[routeOf(mux, r)]; known && rt.Public {
+			next.ServeHTTP(w, r)
+			return
+		}
+		state := limiter.take(ipBucket(r), ipPerMinute, time.Now())
+		if state.Wait > 0 {
+			rateLimited.Inc("ip", routeClass(r))
+			setRateLimitHeaders(w, state)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(state.Wait.Seconds()))))
+			writeProblem(w, r, codeRateLimited, fmt.Sprintf("each client IP may make %d requests per minute", ipPerMinute))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withRateLimit spends a token of the caller's bucket for the route class
+// and, if its tenant sets requests_per_minute, of the tenant's bucket.
+// Requests over either limit get 429 with Retry-After; the others carry
+// RateLimit-* headers describing the tighter of the two. Public routes are
+// not limited so probes keep working. It runs after withTenant.
+func withRateLimit(mux *http.ServeMux, rts []route, next http.Handler) http.Handler {
+	byPath := map[string]route{}
+	for _, rt := range rts {
+		byPath[rt.Path] = rt
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rt, known := byPath[routeOf(mux, r)]; known && rt.Public {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		class := routeClass(r)
+		tenant := services.TenantOf(r.Context())
+		checks := []struct {
+			scope, bucket string
+			perMinute     int
+			refusal       string
+		}{
+			{"caller", class + " " + callerOf(r), callerLimits.Load().perMinute(class),
+				"callers may make %d " + class + " requests per minute"},
+			{"tenant", tenantBucket(tenant.ID), tenant.RequestsPerMinute,
+				"tenant " + tenant.ID + " allows %d requests per minute"},
+		}
+		var shown limitState
+		for _, c := range checks {
+			state := limiter.take(c.bucket, c.perMinute, now)
+			if state.Limit == 0 {
+				continue
+			}
+			if state.Wait > 0 {
+				rateLimited.Inc(c.scope, class)
+				setRateLimitHeaders(w, state)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(state.Wait.Seconds()))))
+				writeProblem(w, r, codeRateLimited, fmt.Sprintf(c.refusal, c.perMinute))
+				return
+			}
+			if shown.Limit == 0 || state.Remaining < shown.Remaining {
+				shown = state
+			}
+		}
+		if shown.Limit > 0 {
+			setRateLimitHeaders(w, shown)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setRateLimitHeaders describes state with the RateLimit header fields of
+// the IETF draft: the limit, what is left and seconds until it is refilled
+func setRateLimitHeaders(w http.ResponseWriter, state limitState) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(state.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(state.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(state.Reset.Seconds()))))
+	h.Set("RateLimit-Policy", strconv.Itoa(state.Limit)+";w=60")
+}
+
+// callerOf identifies the caller a bucket belongs to: its principal, or its
+// IP when authentication is off
+func callerOf(r *http.Request) string {
+	if p, ok := principalOf(r); ok && p.ID != "" {
+		return p.ID
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP is the address r came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+// ipBucket names the bucket of rate_limit.per_ip_per_minute. Caller
+// buckets start with a route class, so the two never share a name.
+func ipBucket(r *http.Request) string {
+	return "ip " + clientIP(r)
+}
+
+// tenantBucket names the bucket of a tenant's requests_per_minute
+func tenantBucket(id string) string {
+	return "tenant " + id
+}
+
+// rateLimiter keeps token buckets that hold a minute of requests and refill
+// continuously, so a caller may burst up to its limit. Buckets live in
+// memory; every replica enforces the limits on its own.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	perMinute int
+	tokens    float64
+	last      time.Time
+}
+
+// limitState is a bucket after a take. Limit is zero when there is no limit.
+type limitState struct {
+	Limit, Remaining int
+	// Reset is how long until the bucket is full again, and Wait how long
+	// until a refused request would be accepted
+	Reset, Wait time.Duration
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*bucket{}}
+}
+
+// take spends a token of the named bucket at now, unless it is empty, in
+// which case the state says how long to wait. A perMinute of zero or less
+// is unlimited.
+func (l *rateLimiter) take(name string, perMinute int, now time.Time) limitState {
+	if perMinute <= 0 {
+		return limitState{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b := l.buckets[name]
+	// a changed limit starts over with a full bucket
+	if b == nil || b.perMinute != perMinute {
+		b = &bucket{perMinute: perMinute, tokens: float64(perMinute), last: now}
+		l.buckets[name] = b
+	}
+	perSecond := float64(perMinute) / 60
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(perMinute), b.tokens+elapsed*perSecond)
+		b.last = now
+	}
+	state := limitState{Limit: perMinute}
+	if b.tokens < 1 {
+		state.Wait = time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	} else {
+		b.tokens--
+	}
+	state.Remaining = int(b.tokens)
+	state.Reset = time.Duration((float64(perMinute) - b.tokens) / perSecond * float64(time.Second))
+	return state
+}
+
+// sweep drops, once a minute, the buckets untouched for a minute. They have
+// refilled, so they are the same as no bucket.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	for name, b := range l.buckets {
+		if now.Sub(b.last) >= time.Minute {
+			delete(l.buckets, name)
+		}
+	}
+	l.swept = now
+}
+
+// forget drops a bucket, such as that of a deleted tenant
+func (l *rateLimiter) forget(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, name)
+}
+
+func (l *rateLimiter) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// rateLimitsHandler returns the per-caller limits on GET and replaces them
+// on PUT until the server restarts
+func rateLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var req RateLimits
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		var verr services.ValidationError
+		if req.ReadsPerMinute < 0 {
+			verr.Add("reads_per_minute", codeValidationFailed, "must not be negative")
+		}
+		if req.WritesPerMinute < 0 {
+			verr.Add("writes_per_minute", codeValidationFailed, "must not be negative")
+		}
+		if err := verr.Err(); err != nil {
+			writeValidationProblem(w, r, err)
+			return
+		}
+		if previous := callerLimits.Load(); previous != req {
+			callerLimits.Store(req)
+			requestLogger(r).Warn("rate limits changed",
+				"reads_per_minute", req.ReadsPerMinute, "writes_per_minute", req.WritesPerMinute,
+				"previous_reads_per_minute", previous.ReadsPerMinute, "previous_writes_per_minute", previous.WritesPerMinute)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(callerLimits.Load())
+}
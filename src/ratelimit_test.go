@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ClockAsService/src/services"
+)
+
+func TestRateLimiter_Refills(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Now()
+	for i := 0; i < 60; i++ {
+		if state := l.take("t", 60, now); state.Wait != 0 || state.Remaining != 59-i {
+			t.Fatalf("request %d within the burst got %+v", i, state)
+		}
+	}
+	state := l.take("t", 60, now)
+	if state.Wait != time.Second || state.Remaining != 0 || state.Reset != time.Minute {
+		t.Errorf("expected to wait a second for the next token, got %+v", state)
+	}
+	if state := l.take("t", 60, now.Add(time.Second)); state.Wait != 0 {
+		t.Errorf("expected a token after a second, got %+v", state)
+	}
+	if state := l.take("t", 120, now.Add(time.Second)); state.Wait != 0 || state.Remaining != 119 {
+		t.Errorf("expected a raised limit to start with a full bucket, got %+v", state)
+	}
+	if state := l.take("unlimited", 0, now); state != (limitState{}) {
+		t.Errorf("expected no limit without a rate, got %+v", state)
+	}
+	l.take("t", 120, now.Add(2*time.Minute))
+	if n := l.size(); n != 1 {
+		t.Errorf("expected idle buckets to be swept, got %d", n)
+	}
+}
+
+func TestRateLimit_LimitsEachCallerByRouteClass(t *testing.T) {
+	handler := enableAuth(t)
+	callerLimits.Store(RateLimits{ReadsPerMinute: 2, WritesPerMinute: 1})
+	key := newKey(t, services.ScopeAlarmsRead, services.ScopeAlarmsWrite)
+
+	w := authRequest(handler, "GET", "/v1/alarms/list", key, "")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("expected RateLimit headers, got %d %v", w.Code, w.Header())
+	}
+	authRequest(handler, "GET", "/v1/alarms/list", key, "")
+	w = authRequest(handler, "GET", "/v1/alarms/list", key, "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" ||
+		!strings.Contains(w.Body.String(), codeRateLimited) {
+		t.Errorf("expected the third read to be limited, got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if w := authRequest(handler, "POST", "/v1/alarms/create", key, `{"name":"x","target":"2030-01-01T09:00:00Z"}`); w.Code != http.StatusCreated {
+		t.Errorf("expected writes to keep their own budget, got %d", w.Code)
+	}
+	if w := authRequest(handler, "GET", "/v1/alarms/list", newKey(t, services.ScopeAlarmsRead), ""); w.Code != http.StatusOK {
+		t.Errorf("expected other callers to keep their own budget, got %d", w.Code)
+	}
+	if w := authRequest(handler, "GET", "/healthz", "", ""); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("expected public routes not to be limited, got %d %v", w.Code, w.Header())
+	}
+	if got := rateLimited.Value("caller", classRead); got < 1 {
+		t.Errorf("expected the refusal to be counted, got %v", got)
+	}
+}
+
+func TestRateLimitsHandler_AdjustsAtRuntime(t *testing.T) {
+	handler := enableAuth(t)
+	admin := newKey(t, services.ScopeAdmin)
+	w := authRequest(handler, "PUT", "/admin/rate-limits", admin, `{"reads_per_minute":1,"writes_per_minute":0}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"reads_per_minute":1`) {
+		t.Fatalf("PUT failed: %d %s", w.Code, w.Body.String())
+	}
+	authRequest(handler, "GET", "/admin/rate-limits", admin, "")
+	if w := authRequest(handler, "GET", "/admin/rate-limits", admin, ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the new limit to apply at once, got %d", w.Code)
+	}
+	w = authRequest(handler, "PUT", "/admin/rate-limits", admin, `{"reads_per_minute":-1}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected a negative limit to be refused, got %d", w.Code)
+	}
+}
+
+func TestCallerOf_FallsBackToTheClientIP(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/v1/alarms/list", nil)
+	r.RemoteAddr = "192.0.2.7:5123"
+	if got := callerOf(r); got != "ip:192.0.2.7" {
+		t.Errorf("callerOf = %q", got)
+	}
+}
+
+func TestIPRateLimit_RefusesBeforeAuthentication(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	handler := withIPRateLimit(mux, routes(), enableAuth(t))
+	ipPerMinute = 2
+	key := newKey(t, services.ScopeAlarmsRead)
+
+	for i := 0; i < 2; i++ {
+		if w := authRequest(handler, "GET", "/v1/alarms/list", "ck_wrong", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+	// once the IP's budget is spent, credentials are not even looked up
+	storageDB.Close()
+	w := authRequest(handler, "GET", "/v1/alarms/list", key, "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" ||
+		!strings.Contains(w.Body.String(), "each client IP may make 2 requests per minute") {
+		t.Errorf("expected the client IP to be limited, got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if w := authRequest(handler, "GET", "/healthz", "", ""); w.Code == http.StatusTooManyRequests {
+		t.Errorf("expected public routes to stay unlimited, got %d", w.Code)
+	}
+}
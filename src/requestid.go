@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+
+	"ClockAsService/src/services"
+
+	"github.com/google/uuid"
+)
+
+// maxRequestIDLength bounds client-supplied X-Request-ID values
+const maxRequestIDLength = 128
+
+// withRequestID accepts an X-Request-ID from the client or generates one,
+// echoes it on the response and makes it available through requestID and to
+// the stores, which record it in the audit log
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(services.WithRequestID(r.Context(), id)))
+	})
+}
+
+// requestID returns the ID assigned by withRequestID, or the raw header when
+// a handler is called directly
+func requestID(r *http.Request) string {
+	if id := services.RequestIDOf(r.Context()); id != "" {
+		return id
+	}
+	return r.Header.Get("X-Request-ID")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithRequestID_EchoesClientID(t *testing.T) {
+	setupHandlersForTest(t)
+
+	handler := withRequestID(http.HandlerFunc(getAlarmCountdownHandler))
+	req := httptest.NewRequest("GET", "/alarms/countdown?id=missing", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("X-Request-ID"); got != "req-123" {
+		t.Fatalf("expected X-Request-ID req-123, got %q", got)
+	}
+	if p := decodeProblem(t, w); p.RequestID != "req-123" {
+		t.Fatalf("expected request_id req-123 in problem, got %q", p.RequestID)
+	}
+}
+
+func TestWithRequestID_GeneratesID(t *testing.T) {
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestID(r) == "" {
+			t.Error("expected a request ID in the context")
+		}
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("X-Request-ID") == "" {
+		t.Fatal("expected a generated X-Request-ID")
+	}
+}
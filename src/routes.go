@@ -0,0 +1,479 @@
+package main
+
+import (
+	"net/http"
+
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/services"
+)
+
+// route is one path of the API. The route table is the single source for
+// both the mux and the OpenAPI document, so the two cannot drift apart.
+type route struct {
+	Path    string
+	Handler http.HandlerFunc
+	Ops     []operation
+	// Lifecycle deprecates the route ahead of its version
+	Lifecycle lifecycle
+	// Public routes are served without an API key
+	Public bool
+	// Global routes act on the whole service rather than one tenant; they
+	// take no X-Tenant and are refused to callers bound to a tenant
+	Global bool
+}
+
+// operation describes one method of a route
+type operation struct {
+	Method      string
+	Summary     string
+	Description string
+	Params      []param
+	// Request is a zero value of the JSON body type, or nil for no body
+	Request interface{}
+	// Status is the success status and Response a zero value of its body.
+	// ContentType defaults to application/json.
+	Status      int
+	Response    interface{}
+	ContentType string
+	// Alternates maps other media types to their response body, for
+	// operations whose response depends on content negotiation
+	Alternates map[string]interface{}
+	// NotModified documents a 304 reply to If-None-Match
+	NotModified bool
+	// Also documents other JSON responses that are not problems, by status
+	Also map[int]interface{}
+	// Errors lists the problem codes the operation can return
+	Errors []string
+	// Scopes must all be granted by the API key. Without scopes any valid
+	// key is accepted and the handler checks what the request touches.
+	Scopes []string
+}
+
+// param is a query or header parameter
+type param struct {
+	Name        string
+	In          string
+	Required    bool
+	Integer     bool
+	Enum        []string
+	MaxLength   int
+	Description string
+}
+
+var (
+	idParam = func(kind string) param {
+		return param{Name: "id", In: "query", Required: true, Description: kind + " ID"}
+	}
+	ifMatchParam = param{
+		Name: "If-Match", In: "header",
+		Description: "Current ETag of the resource; the request is rejected with 428 when it is missing",
+	}
+	ifNoneMatchParam = param{
+		Name: "If-None-Match", In: "header",
+		Description: "Returns 304 when the resource still has this ETag",
+	}
+	idempotencyKeyParam = param{
+		Name: "Idempotency-Key", In: "header", MaxLength: maxIdempotencyKeyLength,
+		Description: "Replays the first response when a create request is retried",
+	}
+	selectorParam = param{
+		Name: "selector", In: "query",
+		Description: "Label selector such as team=ops,env!=prod",
+	}
+)
+
+// bodyErrors can be returned by any operation that reads a JSON body
+var bodyErrors = []string{
+	codeInvalidJSON, codeInvalidTimeFormat, codeInvalidFieldType, codeUnknownField,
+	codeValidationFailed, codeBodyTooLarge, codeUnsupportedMediaType,
+}
+
+// routes returns the API served by the application
+func routes() []route {
+	var all []route
+	byVersion := map[string][]route{}
+	for _, v := range apiVersions {
+		byVersion[v.Name] = versionRoutes(v)
+		all = append(all, mount(v, "/"+v.Name, byVersion[v.Name])...)
+	}
+	all = append(all, negotiatedRoutes(byVersion)...)
+	all = append(all, tenantRoutes()...)
+	all = append(all, auditRoutes()...)
+	return append(all,
+		route{Path: "/versions", Handler: versionsHandler, Public: true, Ops: []operation{{
+			Method:   http.MethodGet,
+			Summary:  "API versions served and their deprecation schedule",
+			Status:   http.StatusOK,
+			Response: VersionsResponse{},
+		}}},
+		route{Path: "/healthz", Handler: healthzHandler, Public: true, Ops: []operation{{
+			Method:   http.MethodGet,
+			Summary:  "Liveness: the process is up",
+			Status:   http.StatusOK,
+			Response: HealthResponse{},
+		}}},
+		route{Path: "/readyz", Handler: readyzHandler, Public: true, Ops: []operation{{
+			Method:  http.MethodGet,
+			Summary: "Readiness: the database answers, migrations are applied, the scheduler is keeping up and the server is not draining",
+			Params: []param{
+				{Name: "verbose", In: "query", Enum: []string{"0", "1"}, Description: "Include each check with its latency"},
+			},
+			Status:   http.StatusOK,
+			Response: ReadinessResponse{},
+			Also:     map[int]interface{}{http.StatusServiceUnavailable: ReadinessResponse{}},
+		}}},
+		route{Path: "/version", Handler: versionHandler, Public: true, Ops: []operation{{
+			Method:   http.MethodGet,
+			Summary:  "Build information of the running server",
+			Status:   http.StatusOK,
+			Response: BuildInfo{},
+		}}},
+		route{Path: "/metrics", Handler: metricsHandler, Global: true, Ops: []operation{{
+			Method:      http.MethodGet,
+			Summary:     "Metrics in the Prometheus text format",
+			Scopes:      []string{services.ScopeAdmin},
+			Status:      http.StatusOK,
+			Response:    "",
+			ContentType: "text/plain",
+		}}},
+		route{Path: "/admin/log-level", Handler: logLevelHandler, Global: true, Ops: []operation{
+			{
+				Method:   http.MethodGet,
+				Summary:  "Current minimum log level",
+				Scopes:   []string{services.ScopeAdmin},
+				Status:   http.StatusOK,
+				Response: LogLevel{},
+			},
+			{
+				Method:      http.MethodPut,
+				Summary:     "Change the minimum log level",
+				Scopes:      []string{services.ScopeAdmin},
+				Description: "The change lasts until the server restarts; log.level sets the level at startup.",
+				Request:     LogLevel{},
+				Status:      http.StatusOK,
+				Response:    LogLevel{},
+				Errors:      bodyErrors,
+			},
+		}},
+		route{Path: "/admin/rate-limits", Handler: rateLimitsHandler, Global: true, Ops: []operation{
+			{
+				Method:   http.MethodGet,
+				Summary:  "Requests each caller may make per minute",
+				Scopes:   []string{services.ScopeAdmin},
+				Status:   http.StatusOK,
+				Response: RateLimits{},
+			},
+			{
+				Method:      http.MethodPut,
+				Summary:     "Change the requests each caller may make per minute",
+				Scopes:      []string{services.ScopeAdmin},
+				Description: "The change lasts until the server restarts; rate_limit.* sets the limits at startup.",
+				Request:     RateLimits{},
+				Status:      http.StatusOK,
+				Response:    RateLimits{},
+				Errors:      bodyErrors,
+			},
+		}},
+		route{Path: "/openapi.json", Handler: openAPIHandler, Public: true, Ops: []operation{{
+			Method:   http.MethodGet,
+			Summary:  "This OpenAPI document",
+			Status:   http.StatusOK,
+			Response: map[string]interface{}{},
+		}}},
+		route{Path: "/docs", Handler: docsHandler, Public: true, Ops: []operation{{
+			Method:      http.MethodGet,
+			Summary:     "Browsable API documentation",
+			Status:      http.StatusOK,
+			Response:    "",
+			ContentType: "text/html",
+		}}},
+	)
+}
+
+// mount places the routes of version v under prefix. Handlers learn the
+// version through apiVersionOf and advertise the route's lifecycle, falling
+// back to the version's.
+func mount(v apiVersion, prefix string, rts []route) []route {
+	mounted := make([]route, len(rts))
+	for i, rt := range rts {
+		versionLifecycle := v.Lifecycle
+		if v.Successor != "" {
+			versionLifecycle.Successor = "/" + v.Successor + rt.Path
+		}
+		rt.Lifecycle = rt.Lifecycle.over(versionLifecycle)
+		rt.Path = prefix + rt.Path
+		rt.Handler = withLifecycle(rt.Lifecycle, withAPIVersion(v.Name, rt.Handler))
+		mounted[i] = rt
+	}
+	return mounted
+}
+
+// negotiatedRoutes serves the unprefixed paths, which predate versioning.
+// They answer with the default version unless Accept names the media type
+// of another; the alternatives are documented as extra response media types.
+func negotiatedRoutes(byVersion map[string][]route) []route {
+	var rts []route
+	for i, rt := range mount(apiVersionByName(defaultAPIVersion), "", byVersion[defaultAPIVersion]) {
+		handlers := map[string]http.HandlerFunc{}
+		ops := append([]operation(nil), rt.Ops...)
+		for j := range ops {
+			ops[j].Alternates = map[string]interface{}{}
+			ops[j].Errors = append(append([]string(nil), ops[j].Errors...), codeUnsupportedVersion)
+		}
+		for _, v := range apiVersions {
+			vr := mount(v, "", byVersion[v.Name][i:i+1])[0]
+			if vr.Path != rt.Path {
+				panic("routes: " + v.Name + " serves " + vr.Path + " where " + defaultAPIVersion + " serves " + rt.Path)
+			}
+			handlers[v.Name] = vr.Handler
+			for j := range ops {
+				ops[j].Alternates[versionMediaType(v.Name)] = vr.Ops[j].Response
+			}
+		}
+		rt.Handler = negotiate(handlers)
+		rt.Ops = ops
+		rts = append(rts, rt)
+	}
+	return rts
+}
+
+// apiVersionByName returns the served version called name
+func apiVersionByName(name string) apiVersion {
+	for _, v := range apiVersions {
+		if v.Name == name {
+			return v
+		}
+	}
+	panic("routes: unknown API version " + name)
+}
+
+// versionRoutes returns the unprefixed routes of version v
+func versionRoutes(v apiVersion) []route {
+	var rts []route
+	for _, kind := range v.Kinds() {
+		rts = append(rts, resourceRoutes(kind)...)
+	}
+	rts = append(rts,
+		route{Path: "/search", Handler: searchHandler, Ops: []operation{{
+			Method:  http.MethodGet,
+			Summary: "Full-text search over alarm and event names and descriptions",
+			Description: "Terms are combined with AND; a trailing * makes a term a prefix match. " +
+				"Requires the read scope of each type searched: both unless type is given.",
+			Params: []param{
+				{Name: "q", In: "query", Required: true, Description: "Search terms"},
+				{Name: "type", In: "query", Enum: []string{"alarm", "event"}, Description: "Restrict results to one resource type"},
+				{Name: "limit", In: "query", Integer: true, Description: "Maximum number of results"},
+			},
+			Status:   http.StatusOK,
+			Response: []services.SearchResult{},
+			Errors:   []string{codeInvalidQuery, codeInvalidParameter, codeValidationFailed, codeStorageUnavailable},
+		}}},
+		route{Path: "/batch", Handler: batchHandler, Ops: []operation{{
+			Method:  http.MethodPost,
+			Summary: "Apply up to 100 alarm and event operations in one transaction",
+			Description: "With atomic true nothing is applied unless every operation succeeds. " +
+				"Per-operation failures are reported in results; the response status is 200 unless an atomic batch failed. " +
+				"Requires the write scope of every type the batch touches.",
+			Request:  BatchRequest{},
+			Status:   http.StatusOK,
+			Response: BatchResponse{},
+			Errors:   append([]string{codeBatchTooLarge, codeStorageUnavailable}, bodyErrors...),
+		}}},
+	)
+	return rts
+}
+
+// resourceKind holds what differs between the alarm and event routes
+type resourceKind struct {
+	Name, Plural, Clock string
+	NotFound            string
+	Resource            interface{}
+	Resources           interface{}
+	Create, Update      interface{}
+	Clocks              interface{}
+	CreateHandler       http.HandlerFunc
+	ClockHandler        http.HandlerFunc
+	ListHandler         http.HandlerFunc
+	LabelsHandler       http.HandlerFunc
+	ACLHandler          http.HandlerFunc
+	HistoryHandler      http.HandlerFunc
+	UpdateHandler       http.HandlerFunc
+	DeleteHandler       http.HandlerFunc
+	ClockSummary        string
+	CreateErrors        []string
+	// QuotaErrors are returned by create only
+	QuotaErrors []string
+	// ReadScope is required by GET operations and WriteScope by the others
+	ReadScope, WriteScope string
+}
+
+var (
+	alarmKind = resourceKind{
+		Name: "Alarm", Plural: "alarms", Clock: "countdown",
+		NotFound: codeAlarmNotFound, Resource: datapkg.Alarm{}, Resources: []datapkg.Alarm{},
+		Create: AlarmRequest{}, Update: AlarmUpdateRequest{}, Clocks: AlarmCountdownResponse{},
+		CreateHandler: createAlarmHandler, ClockHandler: getAlarmCountdownHandler,
+		ListHandler: listAlarmsHandler, LabelsHandler: alarmLabelsHandler, ACLHandler: alarmACLHandler,
+		UpdateHandler: updateAlarmHandler, DeleteHandler: deleteAlarmsHandler, HistoryHandler: alarmHistoryHandler,
+		ClockSummary: "Get countdown (seconds) until alarm target",
+		CreateErrors: []string{codeTargetInPast, codeTargetTooFar},
+		QuotaErrors:  []string{codeQuotaExceeded},
+		ReadScope:    services.ScopeAlarmsRead, WriteScope: services.ScopeAlarmsWrite,
+	}
+	eventKind = resourceKind{
+		Name: "Event", Plural: "events", Clock: "elapsed",
+		NotFound: codeEventNotFound, Resource: datapkg.Event{}, Resources: []datapkg.Event{},
+		Create: EventRequest{}, Update: EventUpdateRequest{}, Clocks: EventElapsedResponse{},
+		CreateHandler: createEventHandler, ClockHandler: getEventElapsedHandler,
+		ListHandler: listEventsHandler, LabelsHandler: eventLabelsHandler, ACLHandler: eventACLHandler,
+		UpdateHandler: updateEventHandler, DeleteHandler: deleteEventsHandler, HistoryHandler: eventHistoryHandler,
+		ClockSummary: "Get elapsed time (seconds) since event start",
+		ReadScope:    services.ScopeEventsRead, WriteScope: services.ScopeEventsWrite,
+	}
+)
+
+func resourceRoutes(k resourceKind) []route {
+	rts := resourceOperations(k)
+	for _, rt := range rts {
+		for i := range rt.Ops {
+			rt.Ops[i].Scopes = []string{k.WriteScope}
+			if rt.Ops[i].Method == http.MethodGet {
+				rt.Ops[i].Scopes = []string{k.ReadScope}
+			}
+		}
+	}
+	return rts
+}
+
+// resourceOperations describes the routes of a resource kind
+func resourceOperations(k resourceKind) []route {
+	base := "/" + k.Plural
+	updateOp := func(method, summary string) operation {
+		return operation{
+			Method: method, Summary: summary,
+			Params:   []param{idParam(k.Name), ifMatchParam},
+			Request:  k.Update,
+			Status:   http.StatusOK,
+			Response: k.Resource,
+			Errors: append([]string{
+				k.NotFound, codePreconditionRequired, codeInvalidETag, codeVersionMismatch, codePermissionDenied,
+				codeStorageUnavailable,
+			}, append(bodyErrors, k.CreateErrors...)...),
+		}
+	}
+	return []route{
+		{Path: base + "/create", Handler: withIdempotency(base+"/create", k.CreateHandler), Ops: []operation{{
+			Method:   http.MethodPost,
+			Summary:  "Create a new " + k.Name,
+			Params:   []param{idempotencyKeyParam},
+			Request:  k.Create,
+			Status:   http.StatusCreated,
+			Response: k.Resource,
+			Errors: append([]string{
+				codeInvalidID, codeInvalidLabels, codeInvalidACL, codeAlreadyExists, codeIdempotencyKeyTooLong,
+				codeIdempotencyKeyInProgress, codeIdempotencyKeyMismatch, codeStorageUnavailable,
+			}, append(append(bodyErrors, k.CreateErrors...), k.QuotaErrors...)...),
+		}}},
+		{Path: base + "/" + k.Clock, Handler: k.ClockHandler, Ops: []operation{{
+			Method:      http.MethodGet,
+			Summary:     k.ClockSummary,
+			Description: "The ETag can be sent back in If-Match; If-None-Match is ignored because the response changes every second.",
+			Params:      []param{idParam(k.Name), asOfParam},
+			Status:      http.StatusOK,
+			Response:    k.Clocks,
+			Errors:      []string{k.NotFound, codeInvalidParameter, codeValidationFailed, codeStorageUnavailable},
+		}}},
+		{Path: base + "/list", Handler: k.ListHandler, Ops: []operation{{
+			Method:   http.MethodGet,
+			Summary:  "List " + k.Plural + ", optionally filtered by a label selector",
+			Params:   []param{selectorParam, asOfParam},
+			Status:   http.StatusOK,
+			Response: k.Resources,
+			Errors:   []string{codeInvalidSelector, codeInvalidParameter, codeValidationFailed, codeStorageUnavailable},
+		}}},
+		{Path: base + "/labels", Handler: k.LabelsHandler, Ops: []operation{
+			{
+				Method:      http.MethodGet,
+				Summary:     "Get the labels of an " + k.Name,
+				Params:      []param{idParam(k.Name), ifNoneMatchParam, asOfParam},
+				Status:      http.StatusOK,
+				Response:    LabelsResponse{},
+				NotModified: true,
+				Errors:      []string{k.NotFound, codeInvalidParameter, codeValidationFailed, codeStorageUnavailable},
+			},
+			{
+				Method:   http.MethodPut,
+				Summary:  "Replace the labels of an " + k.Name,
+				Params:   []param{idParam(k.Name), ifMatchParam},
+				Request:  LabelsRequest{},
+				Status:   http.StatusOK,
+				Response: LabelsResponse{},
+				Errors: append([]string{
+					k.NotFound, codeInvalidLabels, codePreconditionRequired, codeInvalidETag,
+					codeVersionMismatch, codePermissionDenied, codeStorageUnavailable,
+				}, bodyErrors...),
+			},
+		}},
+		{Path: base + "/acl", Handler: k.ACLHandler, Ops: []operation{
+			{
+				Method:      http.MethodGet,
+				Summary:     "Get who an " + k.Name + " is shared with",
+				Params:      []param{idParam(k.Name), ifNoneMatchParam, asOfParam},
+				Status:      http.StatusOK,
+				Response:    ACLResponse{},
+				NotModified: true,
+				Errors:      []string{k.NotFound, codeInvalidParameter, codeValidationFailed, codeStorageUnavailable},
+			},
+			{
+				Method:      http.MethodPut,
+				Summary:     "Replace who an " + k.Name + " is shared with",
+				Description: "Only the owner and admins may change the ACL. An empty ACL makes the " + k.Name + " private to its owner.",
+				Params:      []param{idParam(k.Name), ifMatchParam},
+				Request:     ACLRequest{},
+				Status:      http.StatusOK,
+				Response:    ACLResponse{},
+				Errors: append([]string{
+					k.NotFound, codeInvalidACL, codePreconditionRequired, codeInvalidETag,
+					codeVersionMismatch, codePermissionDenied, codeStorageUnavailable,
+				}, bodyErrors...),
+			},
+		}},
+		{Path: base + "/history", Handler: k.HistoryHandler, Ops: []operation{{
+			Method:  http.MethodGet,
+			Summary: "Every change of an " + k.Name + ", oldest first, as domain events",
+			Description: "The history is the source of truth the " + k.Plural + " are projected from. It stays readable " +
+				"after the " + k.Name + " is deleted, to whoever could view it last. Resources that predate the history " +
+				"start with an Imported event.",
+			Params:   []param{idParam(k.Name)},
+			Status:   http.StatusOK,
+			Response: HistoryResponse{},
+			Errors:   []string{k.NotFound, codeValidationFailed, codeStorageUnavailable},
+		}}},
+		{Path: base + "/update", Handler: k.UpdateHandler, Ops: []operation{
+			updateOp(http.MethodPut, "Replace an "+k.Name+"; every field is required"),
+			updateOp(http.MethodPatch, "Update the fields present in the body"),
+		}},
+		{Path: base + "/delete", Handler: k.DeleteHandler, Ops: []operation{{
+			Method:  http.MethodDelete,
+			Summary: "Delete one " + k.Name + " by id, or every " + k.Name + " matching a selector",
+			Params: []param{
+				{Name: "id", In: "query", Description: k.Name + " ID; requires If-Match"},
+				ifMatchParam,
+				selectorParam,
+			},
+			Status:   http.StatusOK,
+			Response: DeleteResponse{},
+			Errors: []string{
+				k.NotFound, codeInvalidSelector, codeEmptySelector, codePreconditionRequired,
+				codeInvalidETag, codeVersionMismatch, codePermissionDenied, codeStorageUnavailable,
+			},
+		}}},
+	}
+}
+
+// registerRoutes adds every route to mux
+func registerRoutes(mux *http.ServeMux) {
+	for _, rt := range routes() {
+		mux.HandleFunc(rt.Path, rt.Handler)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ClockAsService/src/metrics"
+	"ClockAsService/src/services"
+	"ClockAsService/src/tracing"
+)
+
+var (
+	alarmFirings = metrics.Default.NewCounterVec(
+		"clock_alarm_firings_total", "Alarms fired by the scheduler.")
+	alarmLateness = metrics.Default.NewHistogramVec(
+		"clock_alarm_firing_lateness_seconds", "Time from an alarm's target to when the scheduler fired it.",
+		[]float64{.1, .25, .5, 1, 2, 5, 10, 30, 60, 300, 3600})
+)
+
+// alarmScheduler is the running scheduler, checked by /readyz
+var alarmScheduler *scheduler
+
+// scheduler fires alarms whose target has passed, checking once per tick,
+// and deletes those that fired more than retention ago when it is set
+type scheduler struct {
+	alarms    *services.AlarmStorage
+	tick      time.Duration
+	retention time.Duration
+	now       func() time.Time
+
+	// lastPass is the Unix time in nanoseconds of the last completed pass
+	lastPass atomic.Int64
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newScheduler(alarms *services.AlarmStorage, tick time.Duration) *scheduler {
+	return &scheduler{alarms: alarms, tick: tick, now: time.Now}
+}
+
+// start runs passes in the background until stop is called
+func (s *scheduler) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.lastPass.Store(s.now().UnixNano())
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.tick)
+		defer ticker.Stop()
+		for {
+			s.pass()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stop ends the background passes, waiting for one in progress until ctx
+// is done
+func (s *scheduler) stop(ctx context.Context) error {
+	s.stopOnce.Do(s.cancel)
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pass fires every due alarm, records how late each one was and purges
+// the alarms past their retention
+func (s *scheduler) pass() {
+	now := s.now()
+	ctx, span := tracing.Start(context.Background(), "scheduler.pass", tracing.KindInternal)
+	defer span.End()
+	fired, err := s.alarms.WithContext(ctx).FireDue(now)
+	if err != nil {
+		span.SetError(err)
+		slog.Error("scheduler failed to fire due alarms", "error", err)
+		return
+	}
+	span.SetAttr("alarms.fired", len(fired))
+	for _, alarm := range fired {
+		lateness := now.Sub(alarm.Target)
+		_, fire := tracing.Start(ctx, "alarm.fire", tracing.KindInternal,
+			tracing.Attr{Key: "alarm.id", Value: alarm.ID},
+			tracing.Attr{Key: "alarm.lateness_seconds", Value: lateness.Seconds()},
+		)
+		slog.Debug("alarm fired", "id", alarm.ID, "target", alarm.Target)
+		alarmFirings.Inc()
+		alarmLateness.Observe(lateness.Seconds())
+		fire.End()
+	}
+	// a failed purge is retried on the next pass and does not stall firing
+	if s.retention > 0 {
+		purged, err := s.alarms.WithContext(ctx).PurgeFired(now.Add(-s.retention))
+		if err != nil {
+			span.SetError(err)
+			slog.Error("scheduler failed to purge fired alarms", "error", err)
+		}
+		span.SetAttr("alarms.purged", purged)
+	}
+	s.lastPass.Store(now.UnixNano())
+}
+
+// lag is how long ago the last pass completed
+func (s *scheduler) lag() time.Duration {
+	return s.now().Sub(time.Unix(0, s.lastPass.Load()))
+}
+
+// check reports an error when passes have stopped completing on time
+func (s *scheduler) check(context.Context) (string, error) {
+	lag := s.lag()
+	if lag > 3*s.tick {
+		return "", fmt.Errorf("last pass %s ago, tick is %s", lag.Round(time.Millisecond), s.tick)
+	}
+	return "lag " + lag.Round(time.Millisecond).String(), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/services"
+	"ClockAsService/src/tracing"
+)
+
+func TestScheduler_FiresDueAlarms(t *testing.T) {
+	setupHandlersForTest(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, a := range []datapkg.Alarm{
+		{ID: "late", Name: "late", Target: now.Add(-90 * time.Second)},
+		{ID: "later", Name: "later", Target: now.Add(time.Hour)},
+	} {
+		if _, err := alarmStore.Create(a); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	firings, observed := alarmFirings.Value(), alarmLateness.Count()
+	s := newScheduler(alarmStore, time.Second)
+	s.now = func() time.Time { return now }
+	s.pass()
+	s.pass()
+	if got := alarmFirings.Value() - firings; got != 1 {
+		t.Fatalf("expected one firing, got %v", got)
+	}
+	if got := alarmLateness.Count() - observed; got != 1 {
+		t.Fatalf("expected one lateness observation, got %d", got)
+	}
+	if _, err := s.check(context.Background()); err != nil {
+		t.Fatalf("expected a healthy scheduler, got %v", err)
+	}
+
+	s.now = func() time.Time { return now.Add(time.Minute) }
+	if _, err := s.check(context.Background()); err == nil {
+		t.Fatal("expected a stalled scheduler to fail its check")
+	}
+}
+
+func TestScheduler_PurgesFiredAlarms(t *testing.T) {
+	setupHandlersForTest(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, a := range []datapkg.Alarm{
+		{ID: "fired", Name: "fired", Target: now.Add(-time.Minute)},
+		{ID: "pending", Name: "pending", Target: now.Add(3 * time.Hour)},
+	} {
+		if _, err := alarmStore.Create(a); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+	s := newScheduler(alarmStore, time.Second)
+	s.retention = time.Hour
+	passAt := func(at time.Time) {
+		s.now = func() time.Time { return at }
+		s.pass()
+	}
+
+	passAt(now)
+	passAt(now.Add(30 * time.Minute))
+	if _, err := alarmStore.FindByID("fired"); err != nil {
+		t.Fatalf("expected a fired alarm to be kept within its retention, got %v", err)
+	}
+	passAt(now.Add(2 * time.Hour))
+	if _, err := alarmStore.FindByID("fired"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected the fired alarm to be purged, got %v", err)
+	}
+	if _, err := alarmStore.FindByID("pending"); err != nil {
+		t.Fatalf("expected a pending alarm to be kept, got %v", err)
+	}
+	entries, err := auditStore.List(services.AuditFilter{ResourceID: "fired", Action: services.AuditDelete})
+	if err != nil || len(entries) != 1 || entries[0].Actor != services.SchedulerActor {
+		t.Errorf("expected the purge in the audit log, got %+v, %v", entries, err)
+	}
+	if _, err := historyStore.Rebuild(); err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+	if _, err := alarmStore.FindByID("fired"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a rebuild to keep the purged alarm deleted, got %v", err)
+	}
+}
+
+func TestScheduler_Stop(t *testing.T) {
+	setupHandlersForTest(t)
+	s := newScheduler(alarmStore, time.Millisecond)
+	s.start()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.stop(ctx); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+	if err := s.stop(ctx); err != nil {
+		t.Fatalf("a second stop failed: %v", err)
+	}
+}
+
+func TestScheduler_TracesFirings(t *testing.T) {
+	setupHandlersForTest(t)
+	now := time.Now().UTC()
+	if _, err := alarmStore.Create(datapkg.Alarm{ID: "due", Name: "due", Target: now.Add(-time.Second)}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	spans := recordSpans(t)
+	s := newScheduler(alarmStore, time.Second)
+	s.now = func() time.Time { return now }
+	s.pass()
+
+	got := spans()
+	names := map[string]tracing.SpanData{}
+	for _, span := range got {
+		names[span.Name] = span
+	}
+	pass, query, fire := names["scheduler.pass"], names["alarms.fire_due"], names["alarm.fire"]
+	if len(got) != 3 || query.Parent != pass.Context.SpanID || fire.Parent != pass.Context.SpanID {
+		t.Fatalf("expected the query and firing under the pass, got %+v", got)
+	}
+	if attr(fire, "alarm.id") != "due" || attr(pass, "alarms.fired") != 1 {
+		t.Errorf("unexpected attributes %+v %+v", fire.Attrs, pass.Attrs)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ClockAsService/src/services"
+)
+
+var searchStore *services.SearchStorage
+
+// defaultSearchLimit caps the number of hits returned when no limit is given
+const defaultSearchLimit = 50
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	kind := query.Get("type")
+	if kind != "" && kind != "alarm" && kind != "event" {
+		writeProblem(w, r, codeInvalidParameter, "type must be alarm or event", services.FieldError{
+			Field: "type", Code: codeInvalidParameter, Message: "must be alarm or event",
+		})
+		return
+	}
+	scopes := map[string][]string{
+		"":      {services.ScopeAlarmsRead, services.ScopeEventsRead},
+		"alarm": {services.ScopeAlarmsRead},
+		"event": {services.ScopeEventsRead},
+	}
+	if !requireScopes(w, r, scopes[kind]...) {
+		return
+	}
+	limit := defaultSearchLimit
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			writeProblem(w, r, codeInvalidParameter, "limit must be a positive integer", services.FieldError{
+				Field: "limit", Code: codeInvalidParameter, Message: "must be a positive integer",
+			})
+			return
+		}
+		limit = n
+	}
+	results, err := searchStore.WithContext(r.Context()).Search(query.Get("q"), kind, limit)
+	if err != nil {
+		if errors.Is(err, services.ErrEmptyQuery) {
+			writeProblem(w, r, codeInvalidQuery, err.Error())
+			return
+		}
+		writeStorageProblem(w, r, err, "Search failed")
+		return
+	}
+	if results == nil {
+		results = []services.SearchResult{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
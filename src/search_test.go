@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/services"
+)
+
+func TestSearch_MixedResults(t *testing.T) {
+	setupHandlersForTest(t)
+
+	if _, err := alarmStore.Create(datapkg.Alarm{Name: "Standup", Description: "daily", Target: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("failed to create alarm in storage: %v", err)
+	}
+	if _, err := eventStore.Create(datapkg.Event{Name: "Daily build", Description: "nightly", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to create event in storage: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/search?q=daily", nil)
+	w := httptest.NewRecorder()
+	searchHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var results []services.SearchResult
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	req = httptest.NewRequest("GET", "/search?q=daily&type=timer", nil)
+	w = httptest.NewRecorder()
+	searchHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown type, got %d", w.Code)
+	}
+}
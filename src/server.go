@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errShutdownTimeout is returned when in-flight requests or shutdown steps
+// are still running when the shutdown deadline passes
+var errShutdownTimeout = errors.New("shutdown deadline exceeded; remaining connections were closed")
+
+// drainSignal announces that the server has started shutting down
+type drainSignal struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func newDrainSignal() *drainSignal {
+	return &drainSignal{ch: make(chan struct{})}
+}
+
+func (d *drainSignal) start() {
+	d.once.Do(func() { close(d.ch) })
+}
+
+// Done is closed once draining starts. Streaming handlers select on it to
+// send their client a close notice and return, since Shutdown does not wait
+// for or interrupt hijacked connections.
+func (d *drainSignal) Done() <-chan struct{} {
+	return d.ch
+}
+
+// draining reports whether shutdown has started
+func (d *drainSignal) draining() bool {
+	select {
+	case <-d.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// serverDrain is the drain signal of the running server
+var serverDrain = newDrainSignal()
+
+// shutdownStep releases one resource once the server has drained
+type shutdownStep struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// serve runs srv on ln until ctx is done, then shuts down within grace:
+// it signals drain, stops accepting connections, waits for in-flight
+// requests and runs steps in order. Steps run even when draining times out
+// so the database is always closed.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, drain *drainSignal, grace time.Duration, steps []shutdownStep) error {
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	var err error
+	select {
+	case err = <-served:
+		err = fmt.Errorf("serve: %w", err)
+	case <-ctx.Done():
+		slog.Info("shutting down; draining connections", "grace", grace.String())
+		drain.start()
+		deadline, cancel := context.WithTimeout(context.Background(), grace)
+		defer cancel()
+		if err = srv.Shutdown(deadline); errors.Is(err, context.DeadlineExceeded) {
+			srv.Close()
+			err = errShutdownTimeout
+		}
+		<-served
+		ctx = deadline
+	}
+
+	for _, step := range steps {
+		if stepErr := step.stop(ctx); stepErr != nil && err == nil {
+			err = fmt.Errorf("stop %s: %w", step.name, stepErr)
+		}
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && err == nil {
+		err = errShutdownTimeout
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startServer serves handler on a local port until the returned cancel is
+// called. The result of serve is sent on the returned channel.
+func startServer(t *testing.T, handler http.HandlerFunc, drain *drainSignal, grace time.Duration, steps []shutdownStep) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, &http.Server{Handler: handler}, ln, drain, grace, steps)
+	}()
+	return "http://" + ln.Addr().String(), cancel, done
+}
+
+func TestServe_DrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	drain := newDrainSignal()
+	var stopped []string
+	steps := []shutdownStep{
+		{"scheduler", func(context.Context) error { stopped = append(stopped, "scheduler"); return nil }},
+		{"database", func(context.Context) error { stopped = append(stopped, "database"); return nil }},
+	}
+	url, cancel, done := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "finished")
+	}, drain, 5*time.Second, steps)
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responses <- result{body: string(body)}
+	}()
+
+	<-started
+	cancel()
+	for !drain.draining() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("expected new connections to be refused while draining")
+	}
+	close(release)
+
+	if res := <-responses; res.err != nil || res.body != "finished" {
+		t.Fatalf("in-flight request was not completed: %q, %v", res.body, res.err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("expected a clean shutdown, got %v", err)
+	}
+	if len(stopped) != 2 || stopped[0] != "scheduler" || stopped[1] != "database" {
+		t.Errorf("expected the steps to run in order, got %v", stopped)
+	}
+}
+
+func TestServe_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	closed := false
+	url, cancel, done := startServer(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}, newDrainSignal(), 50*time.Millisecond, []shutdownStep{
+		{"database", func(context.Context) error { closed = true; return nil }},
+	})
+
+	go http.Get(url)
+	<-started
+	cancel()
+	if err := <-done; !errors.Is(err, errShutdownTimeout) {
+		t.Fatalf("expected errShutdownTimeout, got %v", err)
+	}
+	if !closed {
+		t.Error("expected the database to be closed after a timed-out drain")
+	}
+}
+
+func TestServe_ReportsServeErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ln.Close()
+	closed := false
+	err = serve(context.Background(), &http.Server{}, ln, newDrainSignal(), time.Second, []shutdownStep{
+		{"database", func(context.Context) error { closed = true; return nil }},
+	})
+	if err == nil || errors.Is(err, errShutdownTimeout) {
+		t.Fatalf("expected the serve error, got %v", err)
+	}
+	if !closed {
+		t.Error("expected the steps to run after a serve error")
+	}
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sharing is recorded per resource in these join tables, keyed by tenant and
+// resource ID, one row per entry with its role. An entry is Everyone,
+// user:<principal> or group:<name>.
+const (
+	alarmACLTable = "alarm_acl"
+	eventACLTable = "event_acl"
+)
+
+// Roles granted by an ACL. Editors may also view; only the owner, or an
+// admin, may change who the resource is shared with.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// Everyone is the ACL entry matching every caller in the tenant
+const Everyone = "*"
+
+// maxACLEntries bounds each list of an ACL
+const maxACLEntries = 100
+
+// ErrPermissionDenied is returned when the caller may see a resource but not
+// change it
+var ErrPermissionDenied = errors.New("caller may not change this resource")
+
+// Actor is the caller that storage operations are checked against. Without
+// one in the context, as when authentication is off or for background jobs,
+// every resource is accessible.
+type Actor struct {
+	// ID is the principal recorded as the owner of what the actor creates
+	ID     string
+	Groups []string
+	// Admin bypasses every ACL
+	Admin bool
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose storage operations are restricted to
+// what a may access
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// ActorOf returns the actor of ctx, if any
+func ActorOf(ctx context.Context) (Actor, bool) {
+	if ctx == nil {
+		return Actor{}, false
+	}
+	a, ok := ctx.Value(actorKey{}).(Actor)
+	return a, ok
+}
+
+// entries are the ACL entries that match the actor
+func (a Actor) entries() []string {
+	entries := []string{Everyone, "user:" + a.ID}
+	for _, g := range a.Groups {
+		entries = append(entries, "group:"+g)
+	}
+	return entries
+}
+
+// DefaultACL shares a resource with everyone in its tenant, as resources
+// were before ACLs existed
+func DefaultACL() (viewers, editors []string) {
+	return []string{Everyone}, []string{Everyone}
+}
+
+// ValidateACL checks the entries of one list of an ACL
+func ValidateACL(entries []string) error {
+	if len(entries) > maxACLEntries {
+		return fmt.Errorf("at most %d entries are allowed", maxACLEntries)
+	}
+	for _, e := range entries {
+		if e == Everyone {
+			continue
+		}
+		kind, name, ok := strings.Cut(e, ":")
+		if !ok || (kind != "user" && kind != "group") || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("entry %q must be %s, user:<principal> or group:<name>", e, Everyone)
+		}
+	}
+	return nil
+}
+
+// authorize reports whether the actor of ctx holds role on a resource. A
+// caller that may not even view it gets sql.ErrNoRows, so private resources
+// look like missing ones; one that may view it gets ErrPermissionDenied.
+func authorize(ctx context.Context, owner string, viewers, editors []string, role string) error {
+	actor, ok := ActorOf(ctx)
+	if !ok || actor.Admin || (owner != "" && owner == actor.ID) {
+		return nil
+	}
+	mine := map[string]bool{}
+	for _, e := range actor.entries() {
+		mine[e] = true
+	}
+	holds := func(entries []string) bool {
+		for _, e := range entries {
+			if mine[e] {
+				return true
+			}
+		}
+		return false
+	}
+	canEdit := holds(editors)
+	if !canEdit && !holds(viewers) {
+		return sql.ErrNoRows
+	}
+	if role == RoleOwner || (role == RoleEditor && !canEdit) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// visibleCondition is the SQL form of authorize for RoleViewer: a condition
+// on the rows of resourceTable and its arguments
+func visibleCondition(ctx context.Context, aclTable, resourceColumn, resourceTable string) (string, []interface{}) {
+	actor, ok := ActorOf(ctx)
+	if !ok || actor.Admin {
+		return "1 = 1", nil
+	}
+	entries := actor.entries()
+	args := []interface{}{actor.ID}
+	for _, e := range entries {
+		args = append(args, e)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(entries)), ", ")
+	return "(owner = ? OR EXISTS (SELECT 1 FROM " + aclTable + " acl WHERE acl.tenant = " + resourceTable + ".tenant AND acl." +
+		resourceColumn + " = " + resourceTable + ".id AND acl.entry IN (" + placeholders + ")))", args
+}
+
+// createACLTable creates the ACL of a resource table. Resources stored
+// before it existed are shared with everyone, as they were, and entries
+// stored before it had a tenant column move to the tenant of their resource.
+func createACLTable(db *sql.DB, aclTable, resourceColumn, resourceTable string) error {
+	var existing int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", aclTable).Scan(&existing); err != nil {
+		return err
+	}
+	table := `CREATE TABLE IF NOT EXISTS ` + aclTable + ` (
+		tenant TEXT NOT NULL,
+		` + resourceColumn + ` TEXT NOT NULL,
+		entry TEXT NOT NULL,
+		role TEXT NOT NULL,
+		PRIMARY KEY (tenant, ` + resourceColumn + `, entry)
+	);`
+	if _, err := db.Exec(table); err != nil {
+		return err
+	}
+	if existing > 0 {
+		return keyByTenant(db, aclTable, table,
+			"INSERT INTO "+aclTable+" (tenant, "+resourceColumn+", entry, role) SELECT r.tenant, a."+resourceColumn+", a.entry, a.role FROM "+
+				aclTable+"_unscoped a JOIN "+resourceTable+" r ON r.id = a."+resourceColumn)
+	}
+	_, err := db.Exec("INSERT INTO "+aclTable+" (tenant, "+resourceColumn+", entry, role) SELECT tenant, id, ?, ? FROM "+resourceTable,
+		Everyone, RoleEditor)
+	return err
+}
+
+// saveACL replaces the ACL of a resource. An entry listed as both viewer
+// and editor is stored once, as editor.
+func saveACL(db dbtx, aclTable, resourceColumn, tenant, id string, viewers, editors []string) error {
+	if err := deleteACL(db, aclTable, resourceColumn, tenant, id); err != nil {
+		return err
+	}
+	for _, list := range []struct {
+		role    string
+		entries []string
+	}{{RoleViewer, viewers}, {RoleEditor, editors}} {
+		for _, e := range list.entries {
+			if _, err := db.Exec(
+				"INSERT OR REPLACE INTO "+aclTable+" (tenant, "+resourceColumn+", entry, role) VALUES (?, ?, ?, ?)", tenant, id, e, list.role,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadACL returns the viewers and editors of a resource, sorted. Both are
+// non-nil so a private resource reads back as empty lists.
+func loadACL(db dbtx, aclTable, resourceColumn, tenant, id string) (viewers, editors []string, err error) {
+	rows, err := db.Query("SELECT entry, role FROM "+aclTable+" WHERE tenant = ? AND "+resourceColumn+" = ?", tenant, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	viewers, editors = []string{}, []string{}
+	for rows.Next() {
+		var entry, role string
+		if err := rows.Scan(&entry, &role); err != nil {
+			return nil, nil, err
+		}
+		if role == RoleEditor {
+			editors = append(editors, entry)
+		} else {
+			viewers = append(viewers, entry)
+		}
+	}
+	sort.Strings(viewers)
+	sort.Strings(editors)
+	return viewers, editors, rows.Err()
+}
+
+func deleteACL(db dbtx, aclTable, resourceColumn, tenant, id string) error {
+	_, err := db.Exec("DELETE FROM "+aclTable+" WHERE tenant = ? AND "+resourceColumn+" = ?", tenant, id)
+	return err
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func TestValidateACL(t *testing.T) {
+	for _, entries := range [][]string{nil, {Everyone}, {"user:key:abc", "group:ops"}} {
+		if err := ValidateACL(entries); err != nil {
+			t.Errorf("ValidateACL(%q) = %v, want nil", entries, err)
+		}
+	}
+	for _, entries := range [][]string{{"ops"}, {"team:ops"}, {"group:"}, {"user: "}, make([]string, maxACLEntries+1)} {
+		if err := ValidateACL(entries); err == nil {
+			t.Errorf("ValidateACL(%q) = nil, want an error", entries)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	as := func(a Actor) context.Context { return WithActor(context.Background(), a) }
+	viewers, editors := []string{"group:ops"}, []string{"user:bob"}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		role string
+		want error
+	}{
+		{"no actor", context.Background(), RoleOwner, nil},
+		{"admin", as(Actor{ID: "eve", Admin: true}), RoleOwner, nil},
+		{"owner", as(Actor{ID: "alice"}), RoleOwner, nil},
+		{"editor edits", as(Actor{ID: "bob"}), RoleEditor, nil},
+		{"editor shares", as(Actor{ID: "bob"}), RoleOwner, ErrPermissionDenied},
+		{"group viewer views", as(Actor{ID: "carol", Groups: []string{"ops"}}), RoleViewer, nil},
+		{"group viewer edits", as(Actor{ID: "carol", Groups: []string{"ops"}}), RoleEditor, ErrPermissionDenied},
+		{"stranger", as(Actor{ID: "dave"}), RoleViewer, sql.ErrNoRows},
+	}
+	for _, tc := range tests {
+		if err := authorize(tc.ctx, "alice", viewers, editors, tc.role); !errors.Is(err, tc.want) {
+			t.Errorf("%s: authorize = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestACL_RestrictsAlarms(t *testing.T) {
+	s := setupTenantStores(t)
+	alice := WithActor(context.Background(), Actor{ID: "alice"})
+	bob := WithActor(context.Background(), Actor{ID: "bob", Groups: []string{"ops"}})
+	dave := WithActor(context.Background(), Actor{ID: "dave"})
+	target := time.Now().Add(time.Hour)
+
+	s.alarms.WithContext(alice).Create(datapkg.Alarm{ID: "shared", Name: "standup", Target: target, Owner: "alice"})
+	if _, err := s.alarms.WithContext(alice).Create(datapkg.Alarm{
+		ID: "private", Name: "standup", Target: target, Owner: "alice", Viewers: []string{"group:ops"}, Editors: []string{},
+	}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if list, err := s.alarms.WithContext(dave).List(); err != nil || len(list) != 1 {
+		t.Errorf("expected dave to list only the shared alarm, got %v, %v", list, err)
+	}
+	if _, err := s.alarms.WithContext(dave).FindByID("private"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the private alarm to look missing, got %v", err)
+	}
+	if hits, err := s.search.WithContext(dave).Search("standup", "", 10); err != nil || len(hits) != 1 {
+		t.Errorf("expected dave to find only the shared alarm, got %v, %v", hits, err)
+	}
+	raw, err := s.alarms.WithContext(bob).FindByID("private")
+	if err != nil {
+		t.Fatalf("expected bob's group to see the alarm, got %v", err)
+	}
+	if a := raw.(datapkg.Alarm); !reflect.DeepEqual(a.Viewers, []string{"group:ops"}) || len(a.Editors) != 0 {
+		t.Errorf("unexpected ACL %q %q", a.Viewers, a.Editors)
+	}
+	if _, err := s.alarms.WithContext(bob).SetLabels("private", map[string]string{"k": "v"}, 1); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected a viewer not to relabel, got %v", err)
+	}
+	if err := s.alarms.WithContext(bob).RemoveVersion("private", 1); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected a viewer not to delete, got %v", err)
+	}
+	results, err := s.batch.WithContext(bob).Execute([]BatchOperation{{Op: BatchDelete, Type: BatchAlarm, ID: "private", Version: 1}}, false)
+	if err != nil || !errors.Is(results[0].Err, ErrPermissionDenied) {
+		t.Errorf("expected a batch to respect the ACL, got %+v, %v", results, err)
+	}
+	if _, err := s.alarms.WithContext(bob).SetACL("shared", nil, nil, 1); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected an editor not to share, got %v", err)
+	}
+
+	version, err := s.alarms.WithContext(alice).SetACL("private", []string{}, []string{"user:bob"}, 1)
+	if err != nil || version != 2 {
+		t.Fatalf("SetACL = %d, %v", version, err)
+	}
+	if err := s.alarms.WithContext(bob).RemoveVersion("private", 2); err != nil {
+		t.Errorf("expected bob to delete once made an editor, got %v", err)
+	}
+	var rows int
+	s.alarms.DB.QueryRow("SELECT COUNT(*) FROM " + alarmACLTable + " WHERE alarm_id = 'private'").Scan(&rows)
+	if rows != 0 {
+		t.Errorf("expected the ACL to be deleted with the alarm, got %d rows", rows)
+	}
+}
+
+func TestACL_RemoveSelectedSkipsReadOnly(t *testing.T) {
+	s := setupTenantStores(t)
+	bob := WithActor(context.Background(), Actor{ID: "bob"})
+	labels := map[string]string{"team": "ops"}
+	s.events.Create(datapkg.Event{ID: "open", Name: "x", StartedAt: time.Now(), Labels: labels})
+	s.events.Create(datapkg.Event{ID: "read-only", Name: "x", StartedAt: time.Now(), Labels: labels,
+		Viewers: []string{Everyone}, Editors: []string{}})
+
+	sel, _ := ParseSelector("team=ops")
+	if removed, err := s.events.WithContext(bob).RemoveSelected(sel); err != nil || removed != 1 {
+		t.Errorf("expected only the editable event to be removed, got %d, %v", removed, err)
+	}
+	if _, err := s.events.FindByID("read-only"); err != nil {
+		t.Errorf("expected the read-only event to remain, got %v", err)
+	}
+}
+
+func TestACL_BackfillsExistingResources(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE alarms (id TEXT NOT NULL, tenant TEXT NOT NULL, PRIMARY KEY (tenant, id))"); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("INSERT INTO alarms (id, tenant) VALUES ('old', 'default')")
+	if err := createACLTable(db, alarmACLTable, "alarm_id", "alarms"); err != nil {
+		t.Fatalf("createACLTable failed: %v", err)
+	}
+	viewers, editors, err := loadACL(db, alarmACLTable, "alarm_id", DefaultTenant, "old")
+	if err != nil || len(viewers) != 0 || !reflect.DeepEqual(editors, []string{Everyone}) {
+		t.Errorf("expected an existing alarm to stay shared with everyone, got %q %q, %v", viewers, editors, err)
+	}
+	// a second run must not share resources made private since
+	saveACL(db, alarmACLTable, "alarm_id", DefaultTenant, "old", []string{}, []string{})
+	createACLTable(db, alarmACLTable, "alarm_id", "alarms")
+	if _, editors, _ := loadACL(db, alarmACLTable, "alarm_id", DefaultTenant, "old"); len(editors) != 0 {
+		t.Errorf("expected the backfill to run once, got editors %q", editors)
+	}
+}
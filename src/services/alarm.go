@@ -2,7 +2,9 @@ package services
 
 import (
 	datapkg "ClockAsService/src/data"
+	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,72 +12,544 @@ import (
 
 type AlarmStorage struct {
 	DB *sql.DB
+	// ctx parents the spans of storage operations; see WithContext
+	ctx context.Context
 }
 
+// WithContext returns a copy of the storage whose operations are traced as
+// part of the request or job in ctx and confined to its tenant; see WithTenant
+func (a *AlarmStorage) WithContext(ctx context.Context) *AlarmStorage {
+	c := *a
+	c.ctx = ctx
+	return &c
+}
+
+const alarmColumns = "id, name, description, target, created_at, version, owner, tenant, fired_at"
+
 func (a *AlarmStorage) CreateTable() error {
 	alarmTable := `CREATE TABLE IF NOT EXISTS alarms (
-		id TEXT PRIMARY KEY,
+		id TEXT NOT NULL,
 		name TEXT NOT NULL,
 		description TEXT NOT NULL,
 		target INTEGER NOT NULL,
-		created_at INTEGER NOT NULL
+		created_at INTEGER NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1,
+		fired_at INTEGER,
+		owner TEXT NOT NULL DEFAULT '',
+		tenant TEXT NOT NULL DEFAULT 'default',
+		PRIMARY KEY (tenant, id)
 	);`
-	_, err := a.DB.Exec(alarmTable)
-	return err
+	if _, err := a.DB.Exec(alarmTable); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(a.DB, "alarms", "version", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(a.DB, "alarms", "fired_at", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(a.DB, "alarms", "owner", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(a.DB, "alarms", "tenant", "TEXT NOT NULL DEFAULT 'default'"); err != nil {
+		return err
+	}
+	if err := keyByTenant(a.DB, "alarms", alarmTable, "INSERT INTO alarms ("+alarmColumns+") SELECT "+
+		alarmColumns+" FROM alarms_unscoped ORDER BY rowid"); err != nil {
+		return err
+	}
+	if _, err := a.DB.Exec("CREATE INDEX IF NOT EXISTS alarms_tenant ON alarms (tenant)"); err != nil {
+		return err
+	}
+	if err := createLabelTables(a.DB, alarmLabelsTable, "alarm_id", "alarms"); err != nil {
+		return err
+	}
+	if err := createAuditTable(a.DB); err != nil {
+		return err
+	}
+	if err := createHistoryTable(a.DB); err != nil {
+		return err
+	}
+	return createACLTable(a.DB, alarmACLTable, "alarm_id", "alarms")
 }
 
-func (a *AlarmStorage) Create(raw interface{}) (interface{}, error) {
+func (a *AlarmStorage) Create(raw interface{}) (_ interface{}, err error) {
+	defer observe(a.ctx, "alarms", "create")(&err)
 	alarm, ok := raw.(datapkg.Alarm)
 	if !ok {
 		return nil, sql.ErrConnDone
 	}
-	id := uuid.New().String()
-	created := time.Now().UTC()
-	_, err := a.DB.Exec(
-		"INSERT OR REPLACE INTO alarms (id, name, description, target, created_at) VALUES (?, ?, ?, ?, ?)",
-		id, alarm.Name, alarm.Description, alarm.Target.Unix(), created.Unix(),
-	)
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	alarm, err = insertAlarm(tx, TenantOf(a.ctx), alarm)
 	if err != nil {
 		return nil, err
 	}
-	alarm.ID = id
-	alarm.CreatedAt = created
+	if err := recordChange(a.ctx, tx, AuditCreate, nil, alarm); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return alarm, nil
 }
 
-func (a *AlarmStorage) Remove(id string) error {
-	_, err := a.DB.Exec("DELETE FROM alarms WHERE id = ?", id)
-	return err
+func (a *AlarmStorage) Remove(id string) (err error) {
+	defer observe(a.ctx, "alarms", "remove")(&err)
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	before, err := authorizeAlarm(a.ctx, tx, id, RoleEditor)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	tenant := TenantOf(a.ctx).ID
+	if _, err := tx.Exec("DELETE FROM alarms WHERE id = ? AND tenant = ?", id, tenant); err != nil {
+		return err
+	}
+	if err := deleteLabels(tx, alarmLabelsTable, "alarm_id", tenant, id); err != nil {
+		return err
+	}
+	if err := deleteACL(tx, alarmACLTable, "alarm_id", tenant, id); err != nil {
+		return err
+	}
+	if err := recordChange(a.ctx, tx, AuditDelete, before, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (a *AlarmStorage) List() ([]interface{}, error) {
-	rows, err := a.DB.Query("SELECT id, name, description, target, created_at FROM alarms")
+func (a *AlarmStorage) List() (_ []interface{}, err error) {
+	defer observe(a.ctx, "alarms", "list")(&err)
+	visible, args := visibleCondition(a.ctx, alarmACLTable, "alarm_id", "alarms")
+	rows, err := a.DB.Query("SELECT "+alarmColumns+" FROM alarms WHERE tenant = ? AND "+visible,
+		append([]interface{}{TenantOf(a.ctx).ID}, args...)...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var alarms []interface{}
+	var alarms []datapkg.Alarm
 	for rows.Next() {
-		var alarm datapkg.Alarm
-		var targetUnix, createdUnix int64
-		if err := rows.Scan(&alarm.ID, &alarm.Name, &alarm.Description, &targetUnix, &createdUnix); err != nil {
+		alarm, err := scanAlarm(rows)
+		if err != nil {
+			rows.Close()
 			return nil, err
 		}
-		alarm.Target = time.Unix(targetUnix, 0).UTC()
-		alarm.CreatedAt = time.Unix(createdUnix, 0).UTC()
 		alarms = append(alarms, alarm)
 	}
+	rows.Close()
+	// labels are loaded after the cursor is closed so the same connection is reused
+	var result []interface{}
+	for _, alarm := range alarms {
+		if alarm.Labels, err = loadLabels(a.DB, alarmLabelsTable, "alarm_id", alarm.Tenant, alarm.ID); err != nil {
+			return nil, err
+		}
+		if alarm.Viewers, alarm.Editors, err = loadACL(a.DB, alarmACLTable, "alarm_id", alarm.Tenant, alarm.ID); err != nil {
+			return nil, err
+		}
+		result = append(result, alarm)
+	}
+	return result, nil
+}
+
+// ListSelected returns the alarms whose labels match the selector
+func (a *AlarmStorage) ListSelected(sel Selector) (_ []interface{}, err error) {
+	defer observe(a.ctx, "alarms", "list_selected")(&err)
+	all, err := a.List()
+	if err != nil {
+		return nil, err
+	}
+	var alarms []interface{}
+	for _, raw := range all {
+		if sel.Matches(raw.(datapkg.Alarm).Labels) {
+			alarms = append(alarms, raw)
+		}
+	}
 	return alarms, nil
 }
 
-func (a *AlarmStorage) FindByID(id string) (interface{}, error) {
-	row := a.DB.QueryRow("SELECT id, name, description, target, created_at FROM alarms WHERE id = ?", id)
+// RemoveSelected deletes every alarm matching a non-empty selector that the
+// caller may edit and returns how many were removed
+func (a *AlarmStorage) RemoveSelected(sel Selector) (_ int, err error) {
+	defer observe(a.ctx, "alarms", "remove_selected")(&err)
+	if sel.Empty() {
+		return 0, ErrEmptySelector
+	}
+	matched, err := a.ListSelected(sel)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, raw := range matched {
+		v := raw.(datapkg.Alarm)
+		if authorize(a.ctx, v.Owner, v.Viewers, v.Editors, RoleEditor) != nil {
+			continue
+		}
+		if err := a.Remove(v.ID); err != nil {
+			return 0, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (a *AlarmStorage) FindByID(id string) (_ interface{}, err error) {
+	defer observe(a.ctx, "alarms", "find_by_id")(&err)
+	alarm, err := findAlarm(a.DB, TenantOf(a.ctx).ID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(a.ctx, alarm.Owner, alarm.Viewers, alarm.Editors, RoleViewer); err != nil {
+		return nil, err
+	}
+	return alarm, nil
+}
+
+// SetLabels replaces the labels of an existing alarm whose version is
+// expectedVersion and returns the new version
+func (a *AlarmStorage) SetLabels(id string, labels map[string]string, expectedVersion int64) (_ int64, err error) {
+	defer observe(a.ctx, "alarms", "set_labels")(&err)
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	before, err := authorizeAlarm(a.ctx, tx, id, RoleEditor)
+	if err != nil {
+		return 0, err
+	}
+	version, err := bumpVersion(tx, "alarms", TenantOf(a.ctx).ID, id, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+	if err := saveLabels(tx, alarmLabelsTable, "alarm_id", before.Tenant, id, labels); err != nil {
+		return 0, err
+	}
+	after := before
+	after.Labels, after.Version = labels, version
+	if err := recordChange(a.ctx, tx, AuditRelabel, before, after); err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// Update overwrites the name, description and target of an alarm whose
+// version is expectedVersion. The check and the increment happen in a single
+// UPDATE so concurrent writers cannot both succeed.
+func (a *AlarmStorage) Update(raw interface{}, expectedVersion int64) (_ interface{}, err error) {
+	defer observe(a.ctx, "alarms", "update")(&err)
+	alarm, ok := raw.(datapkg.Alarm)
+	if !ok {
+		return nil, sql.ErrConnDone
+	}
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	before, err := authorizeAlarm(a.ctx, tx, alarm.ID, RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := updateAlarm(tx, TenantOf(a.ctx).ID, alarm, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordChange(a.ctx, tx, AuditUpdate, before, updated); err != nil {
+		return nil, err
+	}
+	return updated, tx.Commit()
+}
+
+// RemoveVersion deletes an alarm only if its version is expectedVersion
+func (a *AlarmStorage) RemoveVersion(id string, expectedVersion int64) (err error) {
+	defer observe(a.ctx, "alarms", "remove_version")(&err)
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	before, err := authorizeAlarm(a.ctx, tx, id, RoleEditor)
+	if err != nil {
+		return err
+	}
+	if err := deleteAlarm(tx, TenantOf(a.ctx).ID, id, expectedVersion); err != nil {
+		return err
+	}
+	if err := recordChange(a.ctx, tx, AuditDelete, before, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FireDue marks every alarm whose target is at or before now as fired at now
+// and returns them, without labels. An alarm fires once; moving its target
+// with Update arms it again. It fires the alarms of every tenant, recording
+// each firing in the audit log and the history as done by SchedulerActor.
+func (a *AlarmStorage) FireDue(now time.Time) (_ []datapkg.Alarm, err error) {
+	defer observe(a.ctx, "alarms", "fire_due")(&err)
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT "+alarmColumns+" FROM alarms WHERE fired_at IS NULL AND target <= ?", now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	var due []datapkg.Alarm
+	for rows.Next() {
+		alarm, err := scanAlarm(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, alarm)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	ctx := a.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = WithActor(ctx, Actor{ID: SchedulerActor})
+	fired := time.Unix(now.Unix(), 0).UTC()
+	firedAt := map[string]AuditChange{"fired_at": {After: auditValue(fired)}}
+	for i, alarm := range due {
+		due[i].FiredAt = &fired
+		if _, err := tx.Exec("UPDATE alarms SET fired_at = ? WHERE id = ? AND tenant = ?", now.Unix(), alarm.ID, alarm.Tenant); err != nil {
+			return nil, err
+		}
+		tenantCtx := WithTenant(ctx, Tenant{ID: alarm.Tenant})
+		if err := recordAudit(tenantCtx, tx, AuditFire, AuditAlarm, alarm.ID, firedAt); err != nil {
+			return nil, err
+		}
+		e := DomainEvent{Type: AlarmFired, Version: alarm.Version, Data: DomainEventData{FiredAt: &fired}}
+		if err := appendHistory(tenantCtx, tx, AuditAlarm, alarm.ID, []DomainEvent{e}); err != nil {
+			return nil, err
+		}
+	}
+	return due, tx.Commit()
+}
+
+// PurgeFired deletes the alarms of every tenant that fired before cutoff,
+// with their labels and ACLs, and returns how many it removed. Each removal
+// is recorded in the audit log and the history as done by SchedulerActor,
+// so a rebuild does not bring the alarm back.
+func (a *AlarmStorage) PurgeFired(cutoff time.Time) (_ int, err error) {
+	defer observe(a.ctx, "alarms", "purge_fired")(&err)
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT tenant, id FROM alarms WHERE fired_at < ? ORDER BY rowid", cutoff.Unix())
+	if err != nil {
+		return 0, err
+	}
+	var expired [][2]string
+	for rows.Next() {
+		var tenant, id string
+		if err := rows.Scan(&tenant, &id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, [2]string{tenant, id})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	ctx := a.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = WithActor(ctx, Actor{ID: SchedulerActor})
+	for _, e := range expired {
+		alarm, err := findAlarm(tx, e[0], e[1])
+		if err != nil {
+			return 0, err
+		}
+		if err := deleteAlarm(tx, alarm.Tenant, alarm.ID, alarm.Version); err != nil {
+			return 0, err
+		}
+		if err := recordChange(WithTenant(ctx, Tenant{ID: alarm.Tenant}), tx, AuditDelete, alarm, nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), tx.Commit()
+}
+
+// AlarmCounts splits the stored alarms by firing state
+type AlarmCounts struct {
+	// Pending alarms have a target in the future
+	Pending int
+	// Due alarms have reached their target but not been fired yet
+	Due int
+	// Fired alarms were fired by the scheduler
+	Fired int
+}
+
+// CountStates counts the alarms of every tenant in each firing state at now
+func (a *AlarmStorage) CountStates(now time.Time) (_ AlarmCounts, err error) {
+	defer observe(a.ctx, "alarms", "count_states")(&err)
+	var c AlarmCounts
+	err = a.DB.QueryRow(`SELECT
+			COALESCE(SUM(fired_at IS NULL AND target > ?), 0),
+			COALESCE(SUM(fired_at IS NULL AND target <= ?), 0),
+			COALESCE(SUM(fired_at IS NOT NULL), 0)
+		FROM alarms`, now.Unix(), now.Unix()).Scan(&c.Pending, &c.Due, &c.Fired)
+	return c, err
+}
+
+func scanAlarm(row scanner) (datapkg.Alarm, error) {
 	var alarm datapkg.Alarm
 	var targetUnix, createdUnix int64
-	if err := row.Scan(&alarm.ID, &alarm.Name, &alarm.Description, &targetUnix, &createdUnix); err != nil {
-		return nil, err
+	var firedUnix sql.NullInt64
+	if err := row.Scan(&alarm.ID, &alarm.Name, &alarm.Description, &targetUnix, &createdUnix, &alarm.Version, &alarm.Owner, &alarm.Tenant, &firedUnix); err != nil {
+		return alarm, err
+	}
+	alarm.Target = time.Unix(targetUnix, 0).UTC()
+	alarm.CreatedAt = time.Unix(createdUnix, 0).UTC()
+	if firedUnix.Valid {
+		fired := time.Unix(firedUnix.Int64, 0).UTC()
+		alarm.FiredAt = &fired
 	}
-	alarm.Target = time.Unix(targetUnix, 0)
-	alarm.CreatedAt = time.Unix(createdUnix, 0)
 	return alarm, nil
 }
+
+// The helpers below take a dbtx so they can run on their own or as one step
+// of a larger transaction such as a batch.
+
+// insertAlarm stores alarm in tenant unless that would exceed the tenant's
+// quota of active alarms. IDs are unique within a tenant, so whether another
+// tenant uses an ID is never revealed.
+func insertAlarm(db dbtx, tenant Tenant, alarm datapkg.Alarm) (datapkg.Alarm, error) {
+	if alarm.ID == "" {
+		alarm.ID = uuid.New().String()
+	}
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM alarms WHERE id = ? AND tenant = ?", alarm.ID, tenant.ID).Scan(&exists); err != nil {
+		return alarm, err
+	}
+	if exists > 0 {
+		return alarm, ErrAlreadyExists
+	}
+	if tenant.MaxActiveAlarms > 0 {
+		var active int
+		if err := db.QueryRow("SELECT COUNT(*) FROM alarms WHERE tenant = ? AND fired_at IS NULL", tenant.ID).Scan(&active); err != nil {
+			return alarm, err
+		}
+		if active >= tenant.MaxActiveAlarms {
+			return alarm, ErrQuotaExceeded
+		}
+	}
+	alarm.Tenant = tenant.ID
+	alarm.CreatedAt = time.Now().UTC()
+	alarm.Version = 1
+	_, err := db.Exec(
+		"INSERT INTO alarms (id, name, description, target, created_at, version, owner, tenant) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		alarm.ID, alarm.Name, alarm.Description, alarm.Target.Unix(), alarm.CreatedAt.Unix(), alarm.Version, alarm.Owner, alarm.Tenant,
+	)
+	if err != nil {
+		return alarm, err
+	}
+	if alarm.Viewers == nil && alarm.Editors == nil {
+		alarm.Viewers, alarm.Editors = DefaultACL()
+	}
+	if err := saveACL(db, alarmACLTable, "alarm_id", alarm.Tenant, alarm.ID, alarm.Viewers, alarm.Editors); err != nil {
+		return alarm, err
+	}
+	return alarm, saveLabels(db, alarmLabelsTable, "alarm_id", alarm.Tenant, alarm.ID, alarm.Labels)
+}
+
+func findAlarm(db dbtx, tenant, id string) (datapkg.Alarm, error) {
+	alarm, err := scanAlarm(db.QueryRow("SELECT "+alarmColumns+" FROM alarms WHERE id = ? AND tenant = ?", id, tenant))
+	if err != nil {
+		return alarm, err
+	}
+	if alarm.Labels, err = loadLabels(db, alarmLabelsTable, "alarm_id", tenant, id); err != nil {
+		return alarm, err
+	}
+	alarm.Viewers, alarm.Editors, err = loadACL(db, alarmACLTable, "alarm_id", tenant, id)
+	return alarm, err
+}
+
+// authorizeAlarm checks that the actor of ctx holds role on an alarm of its
+// tenant and returns the alarm; see authorize
+func authorizeAlarm(ctx context.Context, db dbtx, id, role string) (datapkg.Alarm, error) {
+	alarm, err := findAlarm(db, TenantOf(ctx).ID, id)
+	if err != nil {
+		return alarm, err
+	}
+	return alarm, authorize(ctx, alarm.Owner, alarm.Viewers, alarm.Editors, role)
+}
+
+// SetACL replaces who an alarm is shared with, if its version is
+// expectedVersion and the caller owns it, and returns the new version
+func (a *AlarmStorage) SetACL(id string, viewers, editors []string, expectedVersion int64) (_ int64, err error) {
+	defer observe(a.ctx, "alarms", "set_acl")(&err)
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	before, err := authorizeAlarm(a.ctx, tx, id, RoleOwner)
+	if err != nil {
+		return 0, err
+	}
+	version, err := bumpVersion(tx, "alarms", TenantOf(a.ctx).ID, id, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+	if err := saveACL(tx, alarmACLTable, "alarm_id", before.Tenant, id, viewers, editors); err != nil {
+		return 0, err
+	}
+	after := before
+	after.Viewers, after.Editors, after.Version = viewers, editors, version
+	if err := recordChange(a.ctx, tx, AuditShare, before, after); err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+func updateAlarm(db dbtx, tenant string, alarm datapkg.Alarm, expectedVersion int64) (datapkg.Alarm, error) {
+	res, err := db.Exec(
+		// a new target re-arms the alarm; the CASE sees the old target
+		`UPDATE alarms SET name = ?, description = ?,
+			fired_at = CASE WHEN target = ? THEN fired_at ELSE NULL END,
+			target = ?, version = version + 1
+		WHERE id = ? AND tenant = ? AND version = ?`,
+		alarm.Name, alarm.Description, alarm.Target.Unix(), alarm.Target.Unix(), alarm.ID, tenant, expectedVersion,
+	)
+	if err != nil {
+		return alarm, err
+	}
+	if err := checkVersionedWrite(db, res, "alarms", tenant, alarm.ID); err != nil {
+		return alarm, err
+	}
+	return findAlarm(db, tenant, alarm.ID)
+}
+
+func deleteAlarm(db dbtx, tenant, id string, expectedVersion int64) error {
+	res, err := db.Exec("DELETE FROM alarms WHERE id = ? AND tenant = ? AND version = ?", id, tenant, expectedVersion)
+	if err != nil {
+		return err
+	}
+	if err := checkVersionedWrite(db, res, "alarms", tenant, id); err != nil {
+		return err
+	}
+	if err := deleteACL(db, alarmACLTable, "alarm_id", tenant, id); err != nil {
+		return err
+	}
+	return deleteLabels(db, alarmLabelsTable, "alarm_id", tenant, id)
+}
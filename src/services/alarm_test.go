@@ -101,3 +101,112 @@ func TestAlarmStorage_Create_WrongType(t *testing.T) {
 		t.Fatalf("expected error when creating with wrong type, got nil")
 	}
 }
+
+func TestAlarmStorage_Create_ClientIDConflict(t *testing.T) {
+	s := setupAlarmStorage(t)
+	alarm := datapkg.Alarm{ID: "alarm1", Name: "first", Target: time.Now().Add(time.Hour)}
+	createdRaw, err := s.Create(alarm)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if createdRaw.(datapkg.Alarm).ID != "alarm1" {
+		t.Errorf("expected client id to be kept, got %s", createdRaw.(datapkg.Alarm).ID)
+	}
+	alarm.Name = "second"
+	if _, err := s.Create(alarm); err != ErrAlreadyExists {
+		t.Fatalf("expected ErrAlreadyExists, got %v", err)
+	}
+	foundRaw, err := s.FindByID("alarm1")
+	if err != nil {
+		t.Fatalf("FindByID failed: %v", err)
+	}
+	if foundRaw.(datapkg.Alarm).Name != "first" {
+		t.Errorf("expected original alarm to be kept, got %q", foundRaw.(datapkg.Alarm).Name)
+	}
+}
+
+func TestAlarmStorage_FireDue(t *testing.T) {
+	s := setupAlarmStorage(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, a := range []datapkg.Alarm{
+		{ID: "past", Name: "past", Target: now.Add(-time.Minute)},
+		{ID: "now", Name: "now", Target: now},
+		{ID: "later", Name: "later", Target: now.Add(time.Hour)},
+	} {
+		if _, err := s.Create(a); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	fired, err := s.FireDue(now)
+	if err != nil || len(fired) != 2 {
+		t.Fatalf("expected two alarms to fire, got %v, %v", fired, err)
+	}
+	if again, err := s.FireDue(now.Add(time.Second)); err != nil || len(again) != 0 {
+		t.Fatalf("expected alarms to fire once, got %v, %v", again, err)
+	}
+	counts, err := s.CountStates(now)
+	if err != nil || counts != (AlarmCounts{Pending: 1, Fired: 2}) {
+		t.Fatalf("unexpected counts %+v, %v", counts, err)
+	}
+	if counts, _ := s.CountStates(now.Add(2 * time.Hour)); counts.Due != 1 {
+		t.Fatalf("expected the later alarm to be due, got %+v", counts)
+	}
+
+	// moving the target re-arms a fired alarm; other edits do not
+	raw, _ := s.FindByID("past")
+	alarm := raw.(datapkg.Alarm)
+	alarm.Name = "renamed"
+	if _, err := s.Update(alarm, alarm.Version); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if counts, _ := s.CountStates(now); counts.Fired != 2 {
+		t.Fatalf("a rename must not re-arm the alarm, got %+v", counts)
+	}
+	alarm.Target = now.Add(30 * time.Minute)
+	if _, err := s.Update(alarm, alarm.Version+1); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if counts, _ := s.CountStates(now); counts != (AlarmCounts{Pending: 2, Fired: 1}) {
+		t.Fatalf("expected the moved alarm to be pending again, got %+v", counts)
+	}
+}
+
+func TestAlarmStorage_KeepsOwner(t *testing.T) {
+	s := setupAlarmStorage(t)
+	if _, err := s.Create(datapkg.Alarm{ID: "a1", Name: "wake", Target: time.Now().Add(time.Hour), Owner: "svc-billing"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.Update(datapkg.Alarm{ID: "a1", Name: "renamed", Target: time.Now().Add(2 * time.Hour)}, 1); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	raw, err := s.FindByID("a1")
+	if err != nil {
+		t.Fatalf("FindByID failed: %v", err)
+	}
+	if owner := raw.(datapkg.Alarm).Owner; owner != "svc-billing" {
+		t.Errorf("expected the owner to survive an update, got %q", owner)
+	}
+}
+
+func TestAlarmStorage_MigratesOwner(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory db: %v", err)
+	}
+	defer db.Close()
+	// the layout before owners were recorded
+	if _, err := db.Exec(`CREATE TABLE alarms (id TEXT PRIMARY KEY, name TEXT NOT NULL, description TEXT NOT NULL,
+		target INTEGER NOT NULL, created_at INTEGER NOT NULL, version INTEGER NOT NULL DEFAULT 1, fired_at INTEGER);
+		INSERT INTO alarms (id, name, description, target, created_at) VALUES ('old', 'old', '', 0, 0)`); err != nil {
+		t.Fatalf("failed to create legacy table: %v", err)
+	}
+	s := &AlarmStorage{DB: db}
+	if err := s.CreateTable(); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	raw, err := s.FindByID("old")
+	if err != nil || raw.(datapkg.Alarm).Owner != "" {
+		t.Errorf("expected the existing alarm without an owner, got %+v, %v", raw, err)
+	}
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes grant access to parts of the API. ScopeAdmin grants every scope.
+const (
+	ScopeAlarmsRead  = "alarms:read"
+	ScopeAlarmsWrite = "alarms:write"
+	ScopeEventsRead  = "events:read"
+	ScopeEventsWrite = "events:write"
+	ScopeAuditRead   = "audit:read"
+	ScopeAdmin       = "admin"
+)
+
+// Scopes lists every scope in the order they are documented
+var Scopes = []string{ScopeAlarmsRead, ScopeAlarmsWrite, ScopeEventsRead, ScopeEventsWrite, ScopeAuditRead, ScopeAdmin}
+
+// ErrInvalidScope is returned when a key is created with an unknown scope
+var ErrInvalidScope = errors.New("unknown scope; want one of " + strings.Join(Scopes, ", "))
+
+// apiKeyPrefix starts every key so leaked keys are easy to search for
+const apiKeyPrefix = "ck_"
+
+// APIKey describes a key without its secret
+type APIKey struct {
+	ID           string
+	Name         string
+	Scopes       []string
+	CreatedAt    time.Time
+	LastUsedAt   time.Time
+	RequestCount int64
+	RevokedAt    time.Time
+	// Tenant binds the key to one tenant. Keys without one act in the
+	// default tenant, or, with ScopeAdmin, in any tenant they name.
+	Tenant string
+}
+
+// Has reports whether the key grants scope
+func (k APIKey) Has(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyStorage keeps API keys as SHA-256 hashes, so the database never
+// holds a usable key. Keys are random, which makes a fast hash sufficient.
+type APIKeyStorage struct {
+	DB *sql.DB
+
+	// usage counts requests per key ID since the last FlushUsage, so
+	// authenticating does not write to the database
+	mu    sync.Mutex
+	usage map[string]keyUsage
+	// flushMu keeps two flushes from writing the same use twice
+	flushMu sync.Mutex
+}
+
+// keyUsage is the use of a key not yet written by FlushUsage
+type keyUsage struct {
+	lastUsed time.Time
+	count    int64
+}
+
+func (s *APIKeyStorage) CreateTable() error {
+	table := `CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		last_used_at INTEGER,
+		request_count INTEGER NOT NULL DEFAULT 0,
+		revoked_at INTEGER,
+		tenant TEXT NOT NULL DEFAULT ''
+	);`
+	if _, err := s.DB.Exec(table); err != nil {
+		return err
+	}
+	return addColumnIfMissing(s.DB, "api_keys", "tenant", "TEXT NOT NULL DEFAULT ''")
+}
+
+// HashAPIKey is the form in which a key is stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// validScopes checks scopes against Scopes
+func validScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required: %w", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		known := false
+		for _, s := range Scopes {
+			known = known || s == scope
+		}
+		if !known {
+			return fmt.Errorf("%q: %w", scope, ErrInvalidScope)
+		}
+	}
+	return nil
+}
+
+// Create generates a key with the given scopes, bound to tenant unless it is
+// empty. The returned secret is the only copy of the key; it cannot be
+// recovered later.
+func (s *APIKeyStorage) Create(name string, scopes []string, tenant string) (APIKey, string, error) {
+	if err := validScopes(scopes); err != nil {
+		return APIKey{}, "", err
+	}
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return APIKey{}, "", err
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	key, err := s.insert(name, scopes, tenant, secret)
+	return key, secret, err
+}
+
+// Import stores a key that was generated elsewhere, such as one listed in
+// the configuration. A key that is already stored, even revoked, is left
+// unchanged.
+func (s *APIKeyStorage) Import(name string, scopes []string, secret string) error {
+	if err := validScopes(scopes); err != nil {
+		return err
+	}
+	var exists bool
+	err := s.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM api_keys WHERE hash = ?)", HashAPIKey(secret)).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = s.insert(name, scopes, "", secret)
+	return err
+}
+
+func (s *APIKeyStorage) insert(name string, scopes []string, tenant, secret string) (APIKey, error) {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return APIKey{}, err
+	}
+	key := APIKey{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Tenant:    tenant,
+	}
+	_, err := s.DB.Exec(
+		"INSERT INTO api_keys (id, name, hash, scopes, created_at, tenant) VALUES (?, ?, ?, ?, ?, ?)",
+		key.ID, key.Name, HashAPIKey(secret), strings.Join(scopes, " "), key.CreatedAt.Unix(), key.Tenant,
+	)
+	return key, err
+}
+
+const apiKeyColumns = "id, name, scopes, created_at, last_used_at, request_count, revoked_at, tenant"
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	var created int64
+	var lastUsed, revoked sql.NullInt64
+	if err := row.Scan(&key.ID, &key.Name, &scopes, &created, &lastUsed, &key.RequestCount, &revoked, &key.Tenant); err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	key.CreatedAt = time.Unix(created, 0).UTC()
+	if lastUsed.Valid {
+		key.LastUsedAt = time.Unix(lastUsed.Int64, 0).UTC()
+	}
+	if revoked.Valid {
+		key.RevokedAt = time.Unix(revoked.Int64, 0).UTC()
+	}
+	return key, nil
+}
+
+// List returns every key, revoked ones included, oldest first
+func (s *APIKeyStorage) List() ([]APIKey, error) {
+	rows, err := s.DB.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, s.withUsage(key))
+	}
+	return keys, rows.Err()
+}
+
+// withUsage adds the use of key not yet flushed to what was stored
+func (s *APIKeyStorage) withUsage(key APIKey) APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.usage[key.ID]; ok {
+		key.RequestCount += u.count
+		if u.lastUsed.After(key.LastUsedAt) {
+			key.LastUsedAt = u.lastUsed
+		}
+	}
+	return key
+}
+
+// Revoke disables a key. It returns sql.ErrNoRows for an unknown or
+// already revoked key.
+func (s *APIKeyStorage) Revoke(id string) error {
+	res, err := s.DB.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// Authenticate returns the key matching secret and records its use at now.
+// It returns sql.ErrNoRows for an unknown or revoked key. The use is kept in
+// memory until FlushUsage, so authenticating only reads the database.
+func (s *APIKeyStorage) Authenticate(secret string, now time.Time) (APIKey, error) {
+	key, err := scanAPIKey(s.DB.QueryRow(
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE hash = ? AND revoked_at IS NULL", HashAPIKey(secret)))
+	if err != nil {
+		return APIKey{}, err
+	}
+	s.mu.Lock()
+	if s.usage == nil {
+		s.usage = map[string]keyUsage{}
+	}
+	u := s.usage[key.ID]
+	u.count++
+	if at := now.UTC().Truncate(time.Second); at.After(u.lastUsed) {
+		u.lastUsed = at
+	}
+	s.usage[key.ID] = u
+	s.mu.Unlock()
+	return s.withUsage(key), nil
+}
+
+// FlushUsage writes the use recorded by Authenticate since the last flush
+// in one transaction. On failure the use is kept for the next flush.
+func (s *APIKeyStorage) FlushUsage() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.mu.Lock()
+	flushed := make(map[string]keyUsage, len(s.usage))
+	for id, u := range s.usage {
+		flushed[id] = u
+	}
+	s.mu.Unlock()
+	if len(flushed) == 0 {
+		return nil
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, u := range flushed {
+		if _, err := tx.Exec(
+			"UPDATE api_keys SET last_used_at = MAX(COALESCE(last_used_at, 0), ?), request_count = request_count + ? WHERE id = ?",
+			u.lastUsed.Unix(), u.count, id,
+		); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// requests authenticated during the flush stay pending
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, u := range flushed {
+		pending := s.usage[id]
+		pending.count -= u.count
+		if pending.count == 0 {
+			delete(s.usage, id)
+		} else {
+			s.usage[id] = pending
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupAPIKeyStorage(t *testing.T) *APIKeyStorage {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := &APIKeyStorage{DB: db}
+	if err := s.CreateTable(); err != nil {
+		t.Fatalf("failed to create api_keys table: %v", err)
+	}
+	return s
+}
+
+func TestAPIKeyStorage_CreateAuthenticateRevoke(t *testing.T) {
+	s := setupAPIKeyStorage(t)
+	key, secret, err := s.Create("ci", []string{ScopeAlarmsRead, ScopeAlarmsWrite}, "")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, "ck_") || len(secret) < 32 {
+		t.Fatalf("unexpected secret %q", secret)
+	}
+	var stored string
+	s.DB.QueryRow("SELECT hash FROM api_keys WHERE id = ?", key.ID).Scan(&stored)
+	if stored != HashAPIKey(secret) || strings.Contains(stored, secret) {
+		t.Fatalf("expected only the hash to be stored, got %q", stored)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 1; i <= 2; i++ {
+		got, err := s.Authenticate(secret, now)
+		if err != nil || got.ID != key.ID || got.RequestCount != int64(i) || !got.LastUsedAt.Equal(now) {
+			t.Fatalf("Authenticate #%d = %+v, %v", i, got, err)
+		}
+	}
+	if !key.Has(ScopeAlarmsWrite) || key.Has(ScopeEventsRead) {
+		t.Errorf("unexpected scopes %v", key.Scopes)
+	}
+	if _, err := s.Authenticate("ck_wrong", now); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected an unknown key to be rejected, got %v", err)
+	}
+
+	if err := s.Revoke(key.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if err := s.Revoke(key.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a second revoke to fail, got %v", err)
+	}
+	if _, err := s.Authenticate(secret, now); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a revoked key to be rejected, got %v", err)
+	}
+	keys, err := s.List()
+	if err != nil || len(keys) != 1 || keys[0].RevokedAt.IsZero() || keys[0].RequestCount != 2 {
+		t.Fatalf("unexpected list %+v, %v", keys, err)
+	}
+}
+
+func TestAPIKeyStorage_FlushesUsage(t *testing.T) {
+	s := setupAPIKeyStorage(t)
+	key, secret, _ := s.Create("ci", []string{ScopeAlarmsRead}, "")
+	stored := func() (count int64) {
+		s.DB.QueryRow("SELECT request_count FROM api_keys WHERE id = ?", key.ID).Scan(&count)
+		return count
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	s.Authenticate(secret, now.Add(-time.Minute))
+	s.Authenticate(secret, now)
+	if n := stored(); n != 0 {
+		t.Fatalf("expected authenticating not to write, got %d stored requests", n)
+	}
+
+	s.DB.Exec("CREATE TRIGGER fail_flush BEFORE UPDATE ON api_keys BEGIN SELECT RAISE(FAIL, 'disk full'); END")
+	if err := s.FlushUsage(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	s.DB.Exec("DROP TRIGGER fail_flush")
+	if err := s.FlushUsage(); err != nil {
+		t.Fatalf("FlushUsage failed: %v", err)
+	}
+	if n := stored(); n != 2 {
+		t.Errorf("expected the use kept across a failed flush, got %d stored requests", n)
+	}
+	if err := s.FlushUsage(); err != nil || stored() != 2 {
+		t.Errorf("expected a second flush to write nothing, got %d, %v", stored(), err)
+	}
+	keys, _ := (&APIKeyStorage{DB: s.DB}).List()
+	if len(keys) != 1 || keys[0].RequestCount != 2 || !keys[0].LastUsedAt.Equal(now) {
+		t.Errorf("expected the flushed use to be stored, got %+v", keys)
+	}
+}
+
+func TestAPIKeyStorage_Import(t *testing.T) {
+	s := setupAPIKeyStorage(t)
+	for i := 0; i < 2; i++ {
+		if err := s.Import("config", []string{ScopeAdmin}, "static-key"); err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+	}
+	keys, _ := s.List()
+	if len(keys) != 1 || !keys[0].Has(ScopeEventsWrite) {
+		t.Fatalf("expected one admin key, got %+v", keys)
+	}
+	// a revoked key stays revoked when it is imported again
+	s.Revoke(keys[0].ID)
+	s.Import("config", []string{ScopeAdmin}, "static-key")
+	if _, err := s.Authenticate("static-key", time.Now()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the revoked key to stay revoked, got %v", err)
+	}
+}
+
+func TestAPIKeyStorage_RejectsUnknownScopes(t *testing.T) {
+	s := setupAPIKeyStorage(t)
+	for _, scopes := range [][]string{nil, {"alarms:delete"}} {
+		if _, _, err := s.Create("bad", scopes, ""); !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("Create(%v) = %v, want ErrInvalidScope", scopes, err)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditRelabel = "relabel"
+	AuditShare   = "share"
+	AuditDelete  = "delete"
+	AuditFire    = "fire"
+)
+
+// AuditActions lists every action in the order they are documented
+var AuditActions = []string{AuditCreate, AuditUpdate, AuditRelabel, AuditShare, AuditDelete, AuditFire}
+
+// Resource types recorded in the audit log
+const (
+	AuditAlarm = "alarm"
+	AuditEvent = "event"
+)
+
+// SchedulerActor is the actor recorded for alarms fired by the scheduler
+const SchedulerActor = "system:scheduler"
+
+// MaxAuditPage bounds the entries returned by one List call
+const MaxAuditPage = 1000
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose changes are recorded in the audit
+// log as made by the request with this ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDOf returns the request ID of ctx, empty without one
+func RequestIDOf(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// AuditChange is the value of a field before and after a change. Before is
+// missing for a field that was empty or did not exist, and After for one
+// that was emptied or deleted.
+type AuditChange struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditEntry records one change of an alarm or event. Entries form a hash
+// chain: each hash covers the previous one, so editing or removing an entry
+// breaks every later link.
+type AuditEntry struct {
+	Seq          int64                  `json:"seq" doc:"Position in the log; pass the last one seen as after to read on"`
+	At           time.Time              `json:"at"`
+	Tenant       string                 `json:"tenant"`
+	Actor        string                 `json:"actor" doc:"Principal that made the change, system:scheduler for firings, empty if authentication was off"`
+	Action       string                 `json:"action" openapi:"enum=create|update|relabel|share|delete|fire"`
+	ResourceType string                 `json:"resource_type" openapi:"enum=alarm|event"`
+	ResourceID   string                 `json:"resource_id"`
+	Changes      map[string]AuditChange `json:"changes" doc:"Fields that changed, with their values before and after"`
+	RequestID    string                 `json:"request_id" doc:"X-Request-ID of the request that made the change; empty for the scheduler"`
+	PrevHash     string                 `json:"prev_hash" doc:"Hash of the previous entry of the whole log, in any tenant; empty for the first"`
+	Hash         string                 `json:"hash" doc:"SHA-256 of prev_hash and the fields of this entry"`
+}
+
+// AuditFilter selects audit entries. Zero fields match everything.
+type AuditFilter struct {
+	ResourceType string
+	ResourceID   string
+	Actor        string
+	Action       string
+	RequestID    string
+	// Since and Until bound At, inclusive and exclusive
+	Since, Until time.Time
+	// After skips entries up to and including this Seq
+	After int64
+	// Limit caps the entries returned by List; zero or more than
+	// MaxAuditPage is MaxAuditPage
+	Limit int
+}
+
+// AuditVerification is the outcome of checking the hash chain
+type AuditVerification struct {
+	Entries int64 `json:"entries" doc:"Entries checked"`
+	Valid   bool  `json:"valid"`
+	// BrokenAt is the first entry whose link does not match
+	BrokenAt int64  `json:"broken_at,omitempty" doc:"Seq of the first entry that does not match its hash or predecessor"`
+	Head     string `json:"head" doc:"Hash of the last entry; record it elsewhere to detect removal of later entries"`
+}
+
+const auditTable = "audit_log"
+
+const auditColumns = "seq, at, tenant, actor, action, resource_type, resource_id, changes, request_id, prev_hash, hash"
+
+// AuditStorage reads the append-only audit log. The alarm, event, batch and
+// tenant stores append to it in the transaction of each change they make,
+// so a change is never stored without its entry.
+type AuditStorage struct {
+	DB *sql.DB
+	// ctx parents the spans of storage operations and selects the tenant
+	// whose entries are read; see WithContext
+	ctx context.Context
+}
+
+// WithContext returns a copy of the storage whose operations are traced as
+// part of the request in ctx and confined to its tenant
+func (s *AuditStorage) WithContext(ctx context.Context) *AuditStorage {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// CreateTable creates the log; see createAuditTable
+func (s *AuditStorage) CreateTable() error {
+	return createAuditTable(s.DB)
+}
+
+// createAuditTable creates the log for the stores that append to it.
+// Triggers refuse to update or delete its rows, so the service cannot
+// rewrite history by mistake; the hash chain shows whether anything else did.
+func createAuditTable(db dbtx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS ` + auditTable + ` (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			at INTEGER NOT NULL,
+			tenant TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			resource_type TEXT NOT NULL,
+			resource_id TEXT NOT NULL,
+			changes TEXT NOT NULL,
+			request_id TEXT NOT NULL,
+			prev_hash TEXT NOT NULL UNIQUE,
+			hash TEXT NOT NULL
+		)`,
+		"CREATE INDEX IF NOT EXISTS audit_log_resource ON " + auditTable + " (tenant, resource_type, resource_id)",
+		"CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON " + auditTable +
+			" BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END",
+		"CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON " + auditTable +
+			" BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the entries of the tenant matching f in the order they were
+// recorded
+func (s *AuditStorage) List(f AuditFilter) (_ []AuditEntry, err error) {
+	defer observe(s.ctx, "audit", "list")(&err)
+	if f.Limit <= 0 || f.Limit > MaxAuditPage {
+		f.Limit = MaxAuditPage
+	}
+	entries := []AuditEntry{}
+	err = s.each(f, f.Limit, func(e AuditEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// Export calls fn with every entry of the tenant matching f, ignoring its
+// Limit, in the order they were recorded. It stops at the first error fn
+// returns.
+func (s *AuditStorage) Export(f AuditFilter, fn func(AuditEntry) error) (err error) {
+	defer observe(s.ctx, "audit", "export")(&err)
+	return s.each(f, -1, fn)
+}
+
+// each reads the entries of the tenant recorded since it was created, so a
+// tenant never sees those of a deleted one with the same ID; see
+// addAuditAfter. An actor that is not an admin only reads the entries of
+// alarms and events it may view now, as entries hold their fields.
+func (s *AuditStorage) each(f AuditFilter, limit int, fn func(AuditEntry) error) error {
+	tenant := TenantOf(s.ctx).ID
+	where := []string{"tenant = ?", "seq > ?", "seq > (SELECT audit_after FROM tenants WHERE id = ?)"}
+	args := []interface{}{tenant, f.After, tenant}
+	if actor, ok := ActorOf(s.ctx); ok && !actor.Admin {
+		alarmsVisible, alarmArgs := visibleCondition(s.ctx, alarmACLTable, "alarm_id", "alarms")
+		eventsVisible, eventArgs := visibleCondition(s.ctx, eventACLTable, "event_id", "events")
+		where = append(where, "(resource_type = '"+AuditAlarm+"' AND EXISTS (SELECT 1 FROM alarms WHERE alarms.tenant = "+auditTable+
+			".tenant AND alarms.id = "+auditTable+".resource_id AND "+alarmsVisible+") OR resource_type = '"+AuditEvent+
+			"' AND EXISTS (SELECT 1 FROM events WHERE events.tenant = "+auditTable+".tenant AND events.id = "+auditTable+
+			".resource_id AND "+eventsVisible+"))")
+		args = append(append(args, alarmArgs...), eventArgs...)
+	}
+	for _, c := range []struct{ column, value string }{
+		{"resource_type", f.ResourceType},
+		{"resource_id", f.ResourceID},
+		{"actor", f.Actor},
+		{"action", f.Action},
+		{"request_id", f.RequestID},
+	} {
+		if c.value != "" {
+			where = append(where, c.column+" = ?")
+			args = append(args, c.value)
+		}
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "at >= ?")
+		args = append(args, f.Since.UnixNano())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "at < ?")
+		args = append(args, f.Until.UnixNano())
+	}
+	rows, err := s.DB.Query("SELECT "+auditColumns+" FROM "+auditTable+" WHERE "+strings.Join(where, " AND ")+
+		" ORDER BY seq LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, _, err := scanAuditEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Verify walks the whole log, across tenants, and checks every link of the
+// hash chain
+func (s *AuditStorage) Verify() (_ AuditVerification, err error) {
+	defer observe(s.ctx, "audit", "verify")(&err)
+	rows, err := s.DB.Query("SELECT " + auditColumns + " FROM " + auditTable + " ORDER BY seq")
+	if err != nil {
+		return AuditVerification{}, err
+	}
+	defer rows.Close()
+	v := AuditVerification{Valid: true}
+	for rows.Next() {
+		e, changes, err := scanAuditEntry(rows)
+		if err != nil {
+			return v, err
+		}
+		v.Entries++
+		if v.Valid && (e.PrevHash != v.Head || auditHash(e, changes) != e.Hash) {
+			v.Valid, v.BrokenAt = false, e.Seq
+		}
+		v.Head = e.Hash
+	}
+	return v, rows.Err()
+}
+
+func scanAuditEntry(row scanner) (AuditEntry, string, error) {
+	var e AuditEntry
+	var at int64
+	var changes string
+	if err := row.Scan(&e.Seq, &at, &e.Tenant, &e.Actor, &e.Action, &e.ResourceType, &e.ResourceID,
+		&changes, &e.RequestID, &e.PrevHash, &e.Hash); err != nil {
+		return e, "", err
+	}
+	e.At = time.Unix(0, at).UTC()
+	if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+		return e, "", err
+	}
+	return e, changes, nil
+}
+
+// auditHash chains e to its predecessor. It covers changes as stored, so
+// verifying does not depend on how they would be encoded again.
+func auditHash(e AuditEntry, changes string) string {
+	fields, _ := json.Marshal([]interface{}{
+		e.PrevHash, e.At.UnixNano(), e.Tenant, e.Actor, e.Action, e.ResourceType, e.ResourceID, e.RequestID,
+	})
+	sum := sha256.Sum256([]byte(string(fields) + "\n" + changes))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordAudit appends a change of a resource in the tenant of ctx, made by
+// its actor, to the log within db, the transaction that made the change.
+// The database serialises writers, so the last entry read here stays the
+// last until the transaction commits; the unique prev_hash guarantees the
+// chain cannot fork if it did not.
+func recordAudit(ctx context.Context, db dbtx, action, resourceType, id string, changes map[string]AuditChange) error {
+	actor, _ := ActorOf(ctx)
+	e := AuditEntry{
+		At:           time.Now().UTC(),
+		Tenant:       TenantOf(ctx).ID,
+		Actor:        actor.ID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   id,
+		RequestID:    RequestIDOf(ctx),
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	err = db.QueryRow("SELECT hash FROM " + auditTable + " ORDER BY seq DESC LIMIT 1").Scan(&e.PrevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	e.Hash = auditHash(e, string(encoded))
+	_, err = db.Exec("INSERT INTO "+auditTable+" (at, tenant, actor, action, resource_type, resource_id, changes, request_id, prev_hash, hash)"+
+		" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		e.At.UnixNano(), e.Tenant, e.Actor, e.Action, e.ResourceType, e.ResourceID, string(encoded), e.RequestID, e.PrevHash, e.Hash)
+	return err
+}
+
+// auditDiff returns the fields whose values differ between two states;
+// either may be nil for a resource that did not exist
+func auditDiff(before, after map[string]interface{}) map[string]AuditChange {
+	changes := map[string]AuditChange{}
+	for _, state := range []map[string]interface{}{before, after} {
+		for field := range state {
+			b, a := auditValue(before[field]), auditValue(after[field])
+			if !bytes.Equal(b, a) {
+				changes[field] = AuditChange{Before: b, After: a}
+			}
+		}
+	}
+	return changes
+}
+
+// auditValue encodes a field value, nil for one that is empty
+func auditValue(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	switch string(encoded) {
+	case `""`, "null", "{}", "[]":
+		return nil
+	}
+	return encoded
+}
+
+// alarmAuditState lists the fields of an alarm the audit log compares
+func alarmAuditState(a datapkg.Alarm) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        a.Name,
+		"description": a.Description,
+		"target":      time.Unix(a.Target.Unix(), 0).UTC(),
+		"labels":      a.Labels,
+		"owner":       a.Owner,
+		"viewers":     a.Viewers,
+		"editors":     a.Editors,
+		"version":     a.Version,
+	}
+}
+
+// eventAuditState lists the fields of an event the audit log compares
+func eventAuditState(e datapkg.Event) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        e.Name,
+		"description": e.Description,
+		"started_at":  time.Unix(e.StartedAt.Unix(), 0).UTC(),
+		"labels":      e.Labels,
+		"owner":       e.Owner,
+		"viewers":     e.Viewers,
+		"editors":     e.Editors,
+		"version":     e.Version,
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func TestAudit_RecordsEveryChange(t *testing.T) {
+	s := setupTenantStores(t)
+	ctx := WithRequestID(WithActor(context.Background(), Actor{ID: "alice"}), "req-1")
+	alarms := s.alarms.WithContext(ctx)
+	target := time.Now().Add(time.Hour)
+
+	if _, err := alarms.Create(datapkg.Alarm{ID: "a1", Name: "standup", Target: target, Owner: "alice"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := alarms.Update(datapkg.Alarm{ID: "a1", Name: "retro", Target: target}, 1); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := alarms.SetLabels("a1", map[string]string{"team": "ops"}, 2); err != nil {
+		t.Fatalf("SetLabels failed: %v", err)
+	}
+	if _, err := alarms.SetACL("a1", []string{"group:ops"}, []string{}, 3); err != nil {
+		t.Fatalf("SetACL failed: %v", err)
+	}
+	if err := alarms.RemoveVersion("a1", 4); err != nil {
+		t.Fatalf("RemoveVersion failed: %v", err)
+	}
+	// a failed change leaves no entry
+	if err := alarms.RemoveVersion("a1", 4); err == nil {
+		t.Fatal("expected removing a deleted alarm to fail")
+	}
+
+	entries, err := s.audit.List(AuditFilter{ResourceID: "a1"})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+		if e.Actor != "alice" || e.RequestID != "req-1" || e.Tenant != DefaultTenant || e.ResourceType != AuditAlarm {
+			t.Errorf("unexpected entry %+v", e)
+		}
+	}
+	if want := []string{AuditCreate, AuditUpdate, AuditRelabel, AuditShare, AuditDelete}; len(actions) != len(want) {
+		t.Fatalf("expected actions %v, got %v", want, actions)
+	}
+	rename := entries[1].Changes["name"]
+	if string(rename.Before) != `"standup"` || string(rename.After) != `"retro"` {
+		t.Errorf("expected the rename in the diff, got %+v", entries[1].Changes)
+	}
+	if _, ok := entries[1].Changes["target"]; ok {
+		t.Error("expected an unchanged field to be left out of the diff")
+	}
+	if labels := entries[2].Changes["labels"]; labels.Before != nil || string(labels.After) != `{"team":"ops"}` {
+		t.Errorf("unexpected label change %+v", labels)
+	}
+	if deleted := entries[4].Changes["name"]; string(deleted.Before) != `"retro"` || deleted.After != nil {
+		t.Errorf("expected a deletion to record the last values, got %+v", entries[4].Changes)
+	}
+	if v, err := s.audit.Verify(); err != nil || !v.Valid || v.Entries != 5 || v.Head != entries[4].Hash {
+		t.Errorf("Verify = %+v, %v", v, err)
+	}
+}
+
+func TestAudit_RecordsBatchesAndFirings(t *testing.T) {
+	s := setupTenantStores(t)
+	ctx := WithActor(context.Background(), Actor{ID: "ci"})
+	ops := []BatchOperation{
+		{Op: BatchCreate, Type: BatchAlarm, Resource: datapkg.Alarm{ID: "due", Name: "x", Target: time.Now().Add(-time.Second)}},
+		{Op: BatchCreate, Type: BatchEvent, Resource: datapkg.Event{ID: "e1", Name: "deploy", StartedAt: time.Now()}},
+		{Op: BatchDelete, Type: BatchEvent, ID: "missing", Version: 1},
+	}
+	if _, err := s.batch.WithContext(ctx).Execute(ops, false); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if _, err := s.alarms.FireDue(time.Now()); err != nil {
+		t.Fatalf("FireDue failed: %v", err)
+	}
+
+	entries, _ := s.audit.List(AuditFilter{})
+	if len(entries) != 3 {
+		t.Fatalf("expected two creations and a firing, got %+v", entries)
+	}
+	if e := entries[1]; e.ResourceType != AuditEvent || e.Actor != "ci" {
+		t.Errorf("unexpected batch entry %+v", e)
+	}
+	if e := entries[2]; e.Action != AuditFire || e.Actor != SchedulerActor || e.Changes["fired_at"].After == nil {
+		t.Errorf("unexpected firing entry %+v", e)
+	}
+}
+
+func TestAudit_FiltersAndPages(t *testing.T) {
+	s := setupTenantStores(t)
+	s.tenants.Create(Tenant{ID: "acme"})
+	acme := s.in(t, "acme")
+	for _, id := range []string{"e1", "e2", "e3"} {
+		s.events.Create(datapkg.Event{ID: id, Name: "deploy", StartedAt: time.Now()})
+	}
+	s.events.WithContext(acme).Create(datapkg.Event{ID: "acme-1", Name: "deploy", StartedAt: time.Now()})
+
+	page, err := s.audit.List(AuditFilter{Limit: 2})
+	if err != nil || len(page) != 2 || page[0].ResourceID != "e1" {
+		t.Fatalf("expected the first page, got %+v, %v", page, err)
+	}
+	if rest, _ := s.audit.List(AuditFilter{After: page[1].Seq}); len(rest) != 1 || rest[0].ResourceID != "e3" {
+		t.Errorf("expected the page after to hold e3 only, got %+v", rest)
+	}
+	if theirs, _ := s.audit.WithContext(acme).List(AuditFilter{}); len(theirs) != 1 || theirs[0].ResourceID != "acme-1" {
+		t.Errorf("expected each tenant to see its own entries, got %+v", theirs)
+	}
+	if none, _ := s.audit.List(AuditFilter{Since: time.Now().Add(time.Minute)}); len(none) != 0 {
+		t.Errorf("expected no entries in the future, got %+v", none)
+	}
+	var exported int
+	s.audit.Export(AuditFilter{Action: AuditCreate, Limit: 1}, func(AuditEntry) error { exported++; return nil })
+	if exported != 3 {
+		t.Errorf("expected Export to ignore Limit, got %d entries", exported)
+	}
+}
+
+func TestAudit_FollowsACLs(t *testing.T) {
+	s := setupTenantStores(t)
+	alice := WithActor(context.Background(), Actor{ID: "alice"})
+	target := time.Now().Add(time.Hour)
+	s.alarms.WithContext(alice).Create(datapkg.Alarm{ID: "private", Name: "secret", Target: target, Owner: "alice",
+		Viewers: []string{}, Editors: []string{}})
+	s.alarms.WithContext(alice).Create(datapkg.Alarm{ID: "shared", Name: "standup", Target: target, Owner: "alice"})
+	s.events.WithContext(alice).Create(datapkg.Event{ID: "team", Name: "launch", StartedAt: time.Now(), Owner: "alice",
+		Viewers: []string{"group:ops"}, Editors: []string{}})
+	s.alarms.WithContext(alice).Create(datapkg.Alarm{ID: "gone", Name: "old", Target: target, Owner: "alice"})
+	s.alarms.WithContext(alice).RemoveVersion("gone", 1)
+
+	ids := func(ctx context.Context) []string {
+		t.Helper()
+		entries, err := s.audit.WithContext(ctx).List(AuditFilter{})
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		var ids []string
+		for _, e := range entries {
+			ids = append(ids, e.ResourceID)
+		}
+		return ids
+	}
+	if got := ids(WithActor(context.Background(), Actor{ID: "bob", Groups: []string{"ops"}})); !reflect.DeepEqual(got, []string{"shared", "team"}) {
+		t.Errorf("expected a viewer to read only the entries of what it may view, got %v", got)
+	}
+	if got := ids(alice); !reflect.DeepEqual(got, []string{"private", "shared", "team"}) {
+		t.Errorf("expected the owner to read the entries of its resources, got %v", got)
+	}
+	if got := ids(WithActor(context.Background(), Actor{ID: "root", Admin: true})); len(got) != 5 {
+		t.Errorf("expected an admin to read every entry, deleted resources included, got %v", got)
+	}
+}
+
+func TestAudit_DetectsTampering(t *testing.T) {
+	s := setupTenantStores(t)
+	for _, id := range []string{"e1", "e2", "e3"} {
+		s.events.Create(datapkg.Event{ID: id, Name: "deploy", StartedAt: time.Now()})
+	}
+	if _, err := s.audit.DB.Exec("UPDATE audit_log SET actor = 'mallory' WHERE seq = 2"); err == nil {
+		t.Fatal("expected the audit log to refuse updates")
+	}
+	if _, err := s.audit.DB.Exec("DELETE FROM audit_log"); err == nil {
+		t.Fatal("expected the audit log to refuse deletes")
+	}
+	s.audit.DB.Exec("DROP TRIGGER audit_log_no_update")
+	if _, err := s.audit.DB.Exec("UPDATE audit_log SET actor = 'mallory' WHERE seq = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.audit.Verify(); err != nil || v.Valid || v.BrokenAt != 2 || v.Entries != 3 {
+		t.Errorf("expected the edit to break the chain at 2, got %+v, %v", v, err)
+	}
+}
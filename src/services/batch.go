@@ -0,0 +1,196 @@
+package services
+
+import (
+	datapkg "ClockAsService/src/data"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Batch operation kinds and resource types
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+
+	BatchAlarm = "alarm"
+	BatchEvent = "event"
+)
+
+// ErrBatchAborted marks operations that were not applied because another
+// operation in an atomic batch failed
+var ErrBatchAborted = errors.New("not applied because another operation in the atomic batch failed")
+
+// BatchOperation is one step of a batch.
+//
+// For create, Resource holds the datapkg.Alarm or datapkg.Event to insert.
+// For update, Apply receives the current resource and returns the replacement,
+// so field merging and validation stay with the caller. Update and delete are
+// guarded by Version like their single-resource counterparts.
+type BatchOperation struct {
+	Op       string
+	Type     string
+	ID       string
+	Version  int64
+	Resource interface{}
+	Apply    func(current interface{}) (interface{}, error)
+}
+
+// BatchResult is the outcome of one operation: the resulting resource on
+// success, or the error that stopped it
+type BatchResult struct {
+	Resource interface{}
+	Err      error
+}
+
+// BatchStorage runs batches of alarm and event writes in one transaction
+type BatchStorage struct {
+	DB *sql.DB
+	// ctx confines the batch to a tenant; see WithContext
+	ctx context.Context
+}
+
+// WithContext returns a copy of the storage whose batches run in the tenant
+// of ctx
+func (b *BatchStorage) WithContext(ctx context.Context) *BatchStorage {
+	c := *b
+	c.ctx = ctx
+	return &c
+}
+
+// Execute runs ops in a single transaction. When atomic is true the first
+// failure rolls everything back and every other operation reports
+// ErrBatchAborted. Otherwise each operation runs under its own savepoint so a
+// failure only undoes that operation. The returned error is reserved for
+// failures of the transaction itself.
+func (b *BatchStorage) Execute(ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ops))
+	tx, err := b.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, op := range ops {
+		if atomic {
+			results[i].Resource, results[i].Err = runBatchOperation(b.ctx, tx, op)
+			if results[i].Err != nil {
+				for j := range results {
+					if j != i {
+						results[j] = BatchResult{Err: ErrBatchAborted}
+					}
+				}
+				return results, nil
+			}
+			continue
+		}
+		if _, err := tx.Exec("SAVEPOINT batch_op"); err != nil {
+			return nil, err
+		}
+		results[i].Resource, results[i].Err = runBatchOperation(b.ctx, tx, op)
+		if results[i].Err != nil {
+			if _, err := tx.Exec("ROLLBACK TO batch_op"); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := tx.Exec("RELEASE batch_op"); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// runBatchOperation runs op in the tenant of ctx, subject to the ACLs of
+// the resources it changes
+func runBatchOperation(ctx context.Context, tx dbtx, op BatchOperation) (interface{}, error) {
+	tenant := TenantOf(ctx)
+	switch op.Type + ":" + op.Op {
+	case BatchAlarm + ":" + BatchCreate:
+		alarm, ok := op.Resource.(datapkg.Alarm)
+		if !ok {
+			return nil, sql.ErrConnDone
+		}
+		created, err := insertAlarm(tx, tenant, alarm)
+		if err != nil {
+			return nil, err
+		}
+		return created, recordChange(ctx, tx, AuditCreate, nil, created)
+	case BatchAlarm + ":" + BatchUpdate:
+		current, err := findAlarm(tx, tenant.ID, op.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := authorize(ctx, current.Owner, current.Viewers, current.Editors, RoleEditor); err != nil {
+			return nil, err
+		}
+		next, err := op.Apply(current)
+		if err != nil {
+			return nil, err
+		}
+		alarm, ok := next.(datapkg.Alarm)
+		if !ok {
+			return nil, sql.ErrConnDone
+		}
+		alarm.ID = op.ID
+		updated, err := updateAlarm(tx, tenant.ID, alarm, op.Version)
+		if err != nil {
+			return nil, err
+		}
+		return updated, recordChange(ctx, tx, AuditUpdate, current, updated)
+	case BatchAlarm + ":" + BatchDelete:
+		current, err := authorizeAlarm(ctx, tx, op.ID, RoleEditor)
+		if err != nil {
+			return nil, err
+		}
+		if err := deleteAlarm(tx, tenant.ID, op.ID, op.Version); err != nil {
+			return nil, err
+		}
+		return nil, recordChange(ctx, tx, AuditDelete, current, nil)
+	case BatchEvent + ":" + BatchCreate:
+		event, ok := op.Resource.(datapkg.Event)
+		if !ok {
+			return nil, sql.ErrConnDone
+		}
+		created, err := insertEvent(tx, tenant.ID, event)
+		if err != nil {
+			return nil, err
+		}
+		return created, recordChange(ctx, tx, AuditCreate, nil, created)
+	case BatchEvent + ":" + BatchUpdate:
+		current, err := findEvent(tx, tenant.ID, op.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := authorize(ctx, current.Owner, current.Viewers, current.Editors, RoleEditor); err != nil {
+			return nil, err
+		}
+		next, err := op.Apply(current)
+		if err != nil {
+			return nil, err
+		}
+		event, ok := next.(datapkg.Event)
+		if !ok {
+			return nil, sql.ErrConnDone
+		}
+		event.ID = op.ID
+		updated, err := updateEvent(tx, tenant.ID, event, op.Version)
+		if err != nil {
+			return nil, err
+		}
+		return updated, recordChange(ctx, tx, AuditUpdate, current, updated)
+	case BatchEvent + ":" + BatchDelete:
+		current, err := authorizeEvent(ctx, tx, op.ID, RoleEditor)
+		if err != nil {
+			return nil, err
+		}
+		if err := deleteEvent(tx, tenant.ID, op.ID, op.Version); err != nil {
+			return nil, err
+		}
+		return nil, recordChange(ctx, tx, AuditDelete, current, nil)
+	}
+	return nil, fmt.Errorf("unsupported batch operation %q on %q", op.Op, op.Type)
+}
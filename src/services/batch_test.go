@@ -0,0 +1,81 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func TestBatchStorage_AtomicRollsBack(t *testing.T) {
+	alarms := setupAlarmStorage(t)
+	b := &BatchStorage{DB: alarms.DB}
+
+	ops := []BatchOperation{
+		{Op: BatchCreate, Type: BatchAlarm, Resource: datapkg.Alarm{ID: "a1", Name: "one", Target: time.Now().Add(time.Hour)}},
+		{Op: BatchCreate, Type: BatchAlarm, Resource: datapkg.Alarm{ID: "a1", Name: "dup", Target: time.Now().Add(time.Hour)}},
+	}
+	results, err := b.Execute(ops, true)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if results[0].Err != ErrBatchAborted {
+		t.Errorf("expected first op aborted, got %v", results[0].Err)
+	}
+	if results[1].Err != ErrAlreadyExists {
+		t.Errorf("expected second op to fail with ErrAlreadyExists, got %v", results[1].Err)
+	}
+	list, err := alarms.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("expected nothing committed, got %d alarms", len(list))
+	}
+}
+
+func TestBatchStorage_BestEffort(t *testing.T) {
+	alarms := setupAlarmStorage(t)
+	b := &BatchStorage{DB: alarms.DB}
+
+	existingRaw, err := alarms.Create(datapkg.Alarm{Name: "existing", Target: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	existing := existingRaw.(datapkg.Alarm)
+
+	ops := []BatchOperation{
+		{Op: BatchCreate, Type: BatchAlarm, Resource: datapkg.Alarm{ID: "a1", Name: "one", Target: time.Now().Add(time.Hour)}},
+		{Op: BatchCreate, Type: BatchAlarm, Resource: datapkg.Alarm{ID: "a1", Name: "dup", Target: time.Now().Add(time.Hour)}},
+		{Op: BatchUpdate, Type: BatchAlarm, ID: existing.ID, Version: existing.Version, Apply: func(current interface{}) (interface{}, error) {
+			alarm := current.(datapkg.Alarm)
+			alarm.Name = "renamed"
+			return alarm, nil
+		}},
+		{Op: BatchDelete, Type: BatchAlarm, ID: existing.ID, Version: existing.Version},
+	}
+	results, err := b.Execute(ops, false)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if results[0].Err != nil {
+		t.Errorf("expected create to succeed, got %v", results[0].Err)
+	}
+	if results[1].Err != ErrAlreadyExists {
+		t.Errorf("expected duplicate create to fail, got %v", results[1].Err)
+	}
+	if results[2].Err != nil || results[2].Resource.(datapkg.Alarm).Name != "renamed" {
+		t.Errorf("expected update to succeed, got %+v", results[2])
+	}
+	// the update moved the version on, so a delete against the old one fails
+	if results[3].Err != ErrVersionMismatch {
+		t.Errorf("expected stale delete to fail, got %v", results[3].Err)
+	}
+	list, err := alarms.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 alarms committed, got %d", len(list))
+	}
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrVersionMismatch is returned when a versioned write targets a stale version
+var ErrVersionMismatch = errors.New("resource version does not match")
+
+// bumpVersion increments the version of a tenant's row only if it still has
+// expectedVersion, returning the new version
+func bumpVersion(db dbtx, table, tenant, id string, expectedVersion int64) (int64, error) {
+	res, err := db.Exec("UPDATE "+table+" SET version = version + 1 WHERE id = ? AND tenant = ? AND version = ?", id, tenant, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkVersionedWrite(db, res, table, tenant, id); err != nil {
+		return 0, err
+	}
+	return expectedVersion + 1, nil
+}
+
+// checkVersionedWrite turns a versioned write that touched no rows into
+// sql.ErrNoRows when the tenant has no such row or ErrVersionMismatch when
+// it moved on
+func checkVersionedWrite(db dbtx, res sql.Result, table, tenant, id string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	var exists int
+	if err := db.QueryRow("SELECT 1 FROM "+table+" WHERE id = ? AND tenant = ?", id, tenant).Scan(&exists); err != nil {
+		return err
+	}
+	return ErrVersionMismatch
+}
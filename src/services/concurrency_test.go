@@ -0,0 +1,76 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func TestAlarmStorage_UpdateVersioned(t *testing.T) {
+	s := setupAlarmStorage(t)
+
+	createdRaw, err := s.Create(datapkg.Alarm{Name: "a", Target: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	created := createdRaw.(datapkg.Alarm)
+	if created.Version != 1 {
+		t.Fatalf("expected version 1 after create, got %d", created.Version)
+	}
+
+	created.Name = "renamed"
+	updatedRaw, err := s.Update(created, 1)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	updated := updatedRaw.(datapkg.Alarm)
+	if updated.Version != 2 || updated.Name != "renamed" {
+		t.Fatalf("expected renamed alarm at version 2, got %+v", updated)
+	}
+
+	// a second writer still holding version 1 loses
+	created.Name = "clobbered"
+	if _, err := s.Update(created, 1); err != ErrVersionMismatch {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+	if err := s.RemoveVersion(created.ID, 1); err != ErrVersionMismatch {
+		t.Fatalf("expected ErrVersionMismatch on stale delete, got %v", err)
+	}
+	if err := s.RemoveVersion(created.ID, 2); err != nil {
+		t.Fatalf("RemoveVersion failed: %v", err)
+	}
+	if err := s.RemoveVersion(created.ID, 2); err != sql.ErrNoRows {
+		t.Fatalf("expected sql.ErrNoRows for missing alarm, got %v", err)
+	}
+}
+
+func TestEventStorage_UpdateVersioned(t *testing.T) {
+	s := setupEventStorage(t)
+
+	createdRaw, err := s.Create(datapkg.Event{Name: "e", StartedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	created := createdRaw.(datapkg.Event)
+	created.Description = "updated"
+	if _, err := s.Update(created, created.Version); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := s.Update(created, created.Version); err != ErrVersionMismatch {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+}
+
+func TestAddColumnIfMissing(t *testing.T) {
+	s := setupAlarmStorage(t)
+	if _, err := s.DB.Exec("CREATE TABLE legacy (id TEXT PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create legacy table: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := addColumnIfMissing(s.DB, "legacy", "version", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+			t.Fatalf("addColumnIfMissing failed on pass %d: %v", i, err)
+		}
+	}
+}
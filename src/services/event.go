@@ -2,7 +2,9 @@ package services
 
 import (
 	datapkg "ClockAsService/src/data"
+	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,74 +12,407 @@ import (
 
 type EventStorage struct {
 	DB *sql.DB
+	// ctx parents the spans of storage operations; see WithContext
+	ctx context.Context
 }
 
+// WithContext returns a copy of the storage whose operations are traced as
+// part of the request or job in ctx and confined to its tenant; see WithTenant
+func (e *EventStorage) WithContext(ctx context.Context) *EventStorage {
+	c := *e
+	c.ctx = ctx
+	return &c
+}
+
+const eventColumns = "id, name, description, started_at, created_at, version, owner, tenant"
+
 func (e *EventStorage) CreateTable() error {
 	eventTable := `CREATE TABLE IF NOT EXISTS events (
-		id TEXT PRIMARY KEY,
+		id TEXT NOT NULL,
 		name TEXT NOT NULL,
 		description TEXT NOT NULL,
 		started_at INTEGER NOT NULL,
-		created_at INTEGER NOT NULL
+		created_at INTEGER NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1,
+		owner TEXT NOT NULL DEFAULT '',
+		tenant TEXT NOT NULL DEFAULT 'default',
+		PRIMARY KEY (tenant, id)
 	);`
-	_, err := e.DB.Exec(eventTable)
-	return err
+	if _, err := e.DB.Exec(eventTable); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(e.DB, "events", "version", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(e.DB, "events", "owner", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(e.DB, "events", "tenant", "TEXT NOT NULL DEFAULT 'default'"); err != nil {
+		return err
+	}
+	if err := keyByTenant(e.DB, "events", eventTable, "INSERT INTO events ("+eventColumns+") SELECT "+
+		eventColumns+" FROM events_unscoped ORDER BY rowid"); err != nil {
+		return err
+	}
+	if _, err := e.DB.Exec("CREATE INDEX IF NOT EXISTS events_tenant ON events (tenant)"); err != nil {
+		return err
+	}
+	if err := createLabelTables(e.DB, eventLabelsTable, "event_id", "events"); err != nil {
+		return err
+	}
+	if err := createAuditTable(e.DB); err != nil {
+		return err
+	}
+	if err := createHistoryTable(e.DB); err != nil {
+		return err
+	}
+	return createACLTable(e.DB, eventACLTable, "event_id", "events")
 }
 
-func (e *EventStorage) Create(raw interface{}) (interface{}, error) {
+func (e *EventStorage) Create(raw interface{}) (_ interface{}, err error) {
+	defer observe(e.ctx, "events", "create")(&err)
 	event, ok := raw.(datapkg.Event)
 	if !ok {
 		return nil, sql.ErrConnDone
 	}
-
-	id := uuid.New().String()
-	created := time.Now()
-	_, err := e.DB.Exec(
-		"INSERT OR REPLACE INTO events (id, name, description, started_at, created_at) VALUES (?, ?, ?, ?, ?)",
-		id, event.Name, event.Description, event.StartedAt.Unix(), created.Unix(),
-	)
+	tx, err := e.DB.Begin()
 	if err != nil {
 		return nil, err
 	}
-	event.ID = id
-	// store created time on the returned object so callers see it
-	event.CreatedAt = created
+	defer tx.Rollback()
+	event, err = insertEvent(tx, TenantOf(e.ctx).ID, event)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordChange(e.ctx, tx, AuditCreate, nil, event); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return event, nil
 }
 
-func (e *EventStorage) Remove(id string) error {
-	_, err := e.DB.Exec("DELETE FROM events WHERE id = ?", id)
-	return err
+func (e *EventStorage) Remove(id string) (err error) {
+	defer observe(e.ctx, "events", "remove")(&err)
+	tx, err := e.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	before, err := authorizeEvent(e.ctx, tx, id, RoleEditor)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	tenant := TenantOf(e.ctx).ID
+	if _, err := tx.Exec("DELETE FROM events WHERE id = ? AND tenant = ?", id, tenant); err != nil {
+		return err
+	}
+	if err := deleteLabels(tx, eventLabelsTable, "event_id", tenant, id); err != nil {
+		return err
+	}
+	if err := deleteACL(tx, eventACLTable, "event_id", tenant, id); err != nil {
+		return err
+	}
+	if err := recordChange(e.ctx, tx, AuditDelete, before, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (e *EventStorage) List() ([]interface{}, error) {
-	rows, err := e.DB.Query("SELECT id, name, description, started_at, created_at FROM events")
+func (e *EventStorage) List() (_ []interface{}, err error) {
+	defer observe(e.ctx, "events", "list")(&err)
+	visible, args := visibleCondition(e.ctx, eventACLTable, "event_id", "events")
+	rows, err := e.DB.Query("SELECT "+eventColumns+" FROM events WHERE tenant = ? AND "+visible,
+		append([]interface{}{TenantOf(e.ctx).ID}, args...)...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var events []interface{}
+	var events []datapkg.Event
 	for rows.Next() {
-		var event datapkg.Event
-		var startedUnix, createdUnix int64
-		if err := rows.Scan(&event.ID, &event.Name, &event.Description, &startedUnix, &createdUnix); err != nil {
+		event, err := scanEvent(rows)
+		if err != nil {
+			rows.Close()
 			return nil, err
 		}
-		event.StartedAt = time.Unix(startedUnix, 0)
-		event.CreatedAt = time.Unix(createdUnix, 0)
 		events = append(events, event)
 	}
+	rows.Close()
+	// labels are loaded after the cursor is closed so the same connection is reused
+	var result []interface{}
+	for _, event := range events {
+		if event.Labels, err = loadLabels(e.DB, eventLabelsTable, "event_id", event.Tenant, event.ID); err != nil {
+			return nil, err
+		}
+		if event.Viewers, event.Editors, err = loadACL(e.DB, eventACLTable, "event_id", event.Tenant, event.ID); err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+// ListSelected returns the events whose labels match the selector
+func (e *EventStorage) ListSelected(sel Selector) (_ []interface{}, err error) {
+	defer observe(e.ctx, "events", "list_selected")(&err)
+	all, err := e.List()
+	if err != nil {
+		return nil, err
+	}
+	var events []interface{}
+	for _, raw := range all {
+		if sel.Matches(raw.(datapkg.Event).Labels) {
+			events = append(events, raw)
+		}
+	}
 	return events, nil
 }
 
-func (e *EventStorage) FindByID(id string) (interface{}, error) {
-	row := e.DB.QueryRow("SELECT id, name, description, started_at, created_at FROM events WHERE id = ?", id)
+// RemoveSelected deletes every event matching a non-empty selector that the
+// caller may edit and returns how many were removed
+func (e *EventStorage) RemoveSelected(sel Selector) (_ int, err error) {
+	defer observe(e.ctx, "events", "remove_selected")(&err)
+	if sel.Empty() {
+		return 0, ErrEmptySelector
+	}
+	matched, err := e.ListSelected(sel)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, raw := range matched {
+		v := raw.(datapkg.Event)
+		if authorize(e.ctx, v.Owner, v.Viewers, v.Editors, RoleEditor) != nil {
+			continue
+		}
+		if err := e.Remove(v.ID); err != nil {
+			return 0, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (e *EventStorage) FindByID(id string) (_ interface{}, err error) {
+	defer observe(e.ctx, "events", "find_by_id")(&err)
+	event, err := findEvent(e.DB, TenantOf(e.ctx).ID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(e.ctx, event.Owner, event.Viewers, event.Editors, RoleViewer); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// SetLabels replaces the labels of an existing event whose version is
+// expectedVersion and returns the new version
+func (e *EventStorage) SetLabels(id string, labels map[string]string, expectedVersion int64) (_ int64, err error) {
+	defer observe(e.ctx, "events", "set_labels")(&err)
+	tx, err := e.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	before, err := authorizeEvent(e.ctx, tx, id, RoleEditor)
+	if err != nil {
+		return 0, err
+	}
+	version, err := bumpVersion(tx, "events", TenantOf(e.ctx).ID, id, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+	if err := saveLabels(tx, eventLabelsTable, "event_id", before.Tenant, id, labels); err != nil {
+		return 0, err
+	}
+	after := before
+	after.Labels, after.Version = labels, version
+	if err := recordChange(e.ctx, tx, AuditRelabel, before, after); err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// Update overwrites the name, description and start time of an event whose
+// version is expectedVersion. The check and the increment happen in a single
+// UPDATE so concurrent writers cannot both succeed.
+func (e *EventStorage) Update(raw interface{}, expectedVersion int64) (_ interface{}, err error) {
+	defer observe(e.ctx, "events", "update")(&err)
+	event, ok := raw.(datapkg.Event)
+	if !ok {
+		return nil, sql.ErrConnDone
+	}
+	tx, err := e.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	before, err := authorizeEvent(e.ctx, tx, event.ID, RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := updateEvent(tx, TenantOf(e.ctx).ID, event, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordChange(e.ctx, tx, AuditUpdate, before, updated); err != nil {
+		return nil, err
+	}
+	return updated, tx.Commit()
+}
+
+// RemoveVersion deletes an event only if its version is expectedVersion
+func (e *EventStorage) RemoveVersion(id string, expectedVersion int64) (err error) {
+	defer observe(e.ctx, "events", "remove_version")(&err)
+	tx, err := e.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	before, err := authorizeEvent(e.ctx, tx, id, RoleEditor)
+	if err != nil {
+		return err
+	}
+	if err := deleteEvent(tx, TenantOf(e.ctx).ID, id, expectedVersion); err != nil {
+		return err
+	}
+	if err := recordChange(e.ctx, tx, AuditDelete, before, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Count returns the number of stored events across every tenant
+func (e *EventStorage) Count() (n int, err error) {
+	defer observe(e.ctx, "events", "count")(&err)
+	err = e.DB.QueryRow("SELECT COUNT(*) FROM events").Scan(&n)
+	return n, err
+}
+
+func scanEvent(row scanner) (datapkg.Event, error) {
 	var event datapkg.Event
 	var startedUnix, createdUnix int64
-	if err := row.Scan(&event.ID, &event.Name, &event.Description, &startedUnix, &createdUnix); err != nil {
-		return nil, err
+	if err := row.Scan(&event.ID, &event.Name, &event.Description, &startedUnix, &createdUnix, &event.Version, &event.Owner, &event.Tenant); err != nil {
+		return event, err
 	}
 	event.StartedAt = time.Unix(startedUnix, 0)
 	event.CreatedAt = time.Unix(createdUnix, 0)
 	return event, nil
 }
+
+// The helpers below take a dbtx so they can run on their own or as one step
+// of a larger transaction such as a batch.
+
+// insertEvent stores event in tenant. IDs are unique within a tenant, so
+// whether another tenant uses an ID is never revealed.
+func insertEvent(db dbtx, tenant string, event datapkg.Event) (datapkg.Event, error) {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM events WHERE id = ? AND tenant = ?", event.ID, tenant).Scan(&exists); err != nil {
+		return event, err
+	}
+	if exists > 0 {
+		return event, ErrAlreadyExists
+	}
+	event.Tenant = tenant
+	// store created time on the returned object so callers see it
+	event.CreatedAt = time.Now()
+	event.Version = 1
+	_, err := db.Exec(
+		"INSERT INTO events (id, name, description, started_at, created_at, version, owner, tenant) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		event.ID, event.Name, event.Description, event.StartedAt.Unix(), event.CreatedAt.Unix(), event.Version, event.Owner, event.Tenant,
+	)
+	if err != nil {
+		return event, err
+	}
+	if event.Viewers == nil && event.Editors == nil {
+		event.Viewers, event.Editors = DefaultACL()
+	}
+	if err := saveACL(db, eventACLTable, "event_id", tenant, event.ID, event.Viewers, event.Editors); err != nil {
+		return event, err
+	}
+	return event, saveLabels(db, eventLabelsTable, "event_id", tenant, event.ID, event.Labels)
+}
+
+func findEvent(db dbtx, tenant, id string) (datapkg.Event, error) {
+	event, err := scanEvent(db.QueryRow("SELECT "+eventColumns+" FROM events WHERE id = ? AND tenant = ?", id, tenant))
+	if err != nil {
+		return event, err
+	}
+	if event.Labels, err = loadLabels(db, eventLabelsTable, "event_id", tenant, id); err != nil {
+		return event, err
+	}
+	event.Viewers, event.Editors, err = loadACL(db, eventACLTable, "event_id", tenant, id)
+	return event, err
+}
+
+// authorizeEvent checks that the actor of ctx holds role on an event of its
+// tenant and returns the event; see authorize
+func authorizeEvent(ctx context.Context, db dbtx, id, role string) (datapkg.Event, error) {
+	event, err := findEvent(db, TenantOf(ctx).ID, id)
+	if err != nil {
+		return event, err
+	}
+	return event, authorize(ctx, event.Owner, event.Viewers, event.Editors, role)
+}
+
+// SetACL replaces who an event is shared with, if its version is
+// expectedVersion and the caller owns it, and returns the new version
+func (e *EventStorage) SetACL(id string, viewers, editors []string, expectedVersion int64) (_ int64, err error) {
+	defer observe(e.ctx, "events", "set_acl")(&err)
+	tx, err := e.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	before, err := authorizeEvent(e.ctx, tx, id, RoleOwner)
+	if err != nil {
+		return 0, err
+	}
+	version, err := bumpVersion(tx, "events", TenantOf(e.ctx).ID, id, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+	if err := saveACL(tx, eventACLTable, "event_id", before.Tenant, id, viewers, editors); err != nil {
+		return 0, err
+	}
+	after := before
+	after.Viewers, after.Editors, after.Version = viewers, editors, version
+	if err := recordChange(e.ctx, tx, AuditShare, before, after); err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+func updateEvent(db dbtx, tenant string, event datapkg.Event, expectedVersion int64) (datapkg.Event, error) {
+	res, err := db.Exec(
+		"UPDATE events SET name = ?, description = ?, started_at = ?, version = version + 1 WHERE id = ? AND tenant = ? AND version = ?",
+		event.Name, event.Description, event.StartedAt.Unix(), event.ID, tenant, expectedVersion,
+	)
+	if err != nil {
+		return event, err
+	}
+	if err := checkVersionedWrite(db, res, "events", tenant, event.ID); err != nil {
+		return event, err
+	}
+	return findEvent(db, tenant, event.ID)
+}
+
+func deleteEvent(db dbtx, tenant, id string, expectedVersion int64) error {
+	res, err := db.Exec("DELETE FROM events WHERE id = ? AND tenant = ? AND version = ?", id, tenant, expectedVersion)
+	if err != nil {
+		return err
+	}
+	if err := checkVersionedWrite(db, res, "events", tenant, id); err != nil {
+		return err
+	}
+	if err := deleteACL(db, eventACLTable, "event_id", tenant, id); err != nil {
+		return err
+	}
+	return deleteLabels(db, eventLabelsTable, "event_id", tenant, id)
+}
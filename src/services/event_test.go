@@ -101,3 +101,40 @@ func TestEventStorage_Create_WrongType(t *testing.T) {
 		t.Fatalf("expected error when creating with wrong type, got nil")
 	}
 }
+
+func TestEventStorage_Create_ClientIDConflict(t *testing.T) {
+	s := setupEventStorage(t)
+	event := datapkg.Event{ID: "event1", Name: "first", StartedAt: time.Now()}
+	if _, err := s.Create(event); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.Create(event); err != ErrAlreadyExists {
+		t.Fatalf("expected ErrAlreadyExists, got %v", err)
+	}
+}
+
+func TestEventStorage_Count(t *testing.T) {
+	s := setupEventStorage(t)
+	for i := 0; i < 3; i++ {
+		if _, err := s.Create(datapkg.Event{Name: "event"}); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+	if n, err := s.Count(); err != nil || n != 3 {
+		t.Fatalf("expected 3 events, got %d, %v", n, err)
+	}
+}
+
+func TestEventStorage_KeepsOwner(t *testing.T) {
+	s := setupEventStorage(t)
+	if _, err := s.Create(datapkg.Event{ID: "e1", Name: "deploy", StartedAt: time.Now(), Owner: "key:0a1b2c"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	all, err := s.List()
+	if err != nil || len(all) != 1 {
+		t.Fatalf("List failed: %v, %v", all, err)
+	}
+	if owner := all[0].(datapkg.Event).Owner; owner != "key:0a1b2c" {
+		t.Errorf("expected the owner to be stored, got %q", owner)
+	}
+}
@@ -0,0 +1,642 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+// Types of the domain events an alarm's history is made of
+const (
+	AlarmCreated        = "AlarmCreated"
+	AlarmImported       = "AlarmImported"
+	AlarmDetailsChanged = "AlarmDetailsChanged"
+	AlarmTargetChanged  = "AlarmTargetChanged"
+	AlarmLabelsChanged  = "AlarmLabelsChanged"
+	AlarmShared         = "AlarmShared"
+	AlarmFired          = "AlarmFired"
+	AlarmDeleted        = "AlarmDeleted"
+)
+
+// Types of the domain events an event's history is made of
+const (
+	EventCreated        = "EventCreated"
+	EventImported       = "EventImported"
+	EventDetailsChanged = "EventDetailsChanged"
+	EventStartChanged   = "EventStartChanged"
+	EventLabelsChanged  = "EventLabelsChanged"
+	EventShared         = "EventShared"
+	EventDeleted        = "EventDeleted"
+)
+
+// MigrationActor is the actor recorded for resources imported into the
+// history when it was introduced
+const MigrationActor = "system:migration"
+
+// DomainEventData holds the fields a domain event sets. Created and
+// imported events set every field of the resource; the others only the
+// fields they change.
+type DomainEventData struct {
+	Name        *string            `json:"name,omitempty"`
+	Description *string            `json:"description,omitempty"`
+	Target      *time.Time         `json:"target,omitempty" doc:"Alarms only"`
+	StartedAt   *time.Time         `json:"started_at,omitempty" doc:"Events only"`
+	CreatedAt   *time.Time         `json:"created_at,omitempty"`
+	FiredAt     *time.Time         `json:"fired_at,omitempty" doc:"Alarms only; set when the scheduler fired it"`
+	Owner       *string            `json:"owner,omitempty"`
+	Labels      *map[string]string `json:"labels,omitempty"`
+	Viewers     *[]string          `json:"viewers,omitempty"`
+	Editors     *[]string          `json:"editors,omitempty"`
+}
+
+// DomainEvent is one change in the history of an alarm or event. The
+// history is the source of truth: the alarms and events tables are
+// projections of it, kept in step in the transaction of every change and
+// rebuilt from it by Rebuild.
+type DomainEvent struct {
+	Seq          int64           `json:"seq"`
+	At           time.Time       `json:"at"`
+	Type         string          `json:"type" doc:"What happened; see DomainEventData for the fields each type sets"`
+	ResourceType string          `json:"resource_type" openapi:"enum=alarm|event"`
+	ResourceID   string          `json:"resource_id"`
+	Version      int64           `json:"version" doc:"Version of the resource after the change"`
+	Actor        string          `json:"actor" doc:"Principal that made the change, system:scheduler for firings, system:migration for imports"`
+	RequestID    string          `json:"request_id"`
+	Data         DomainEventData `json:"data"`
+	Tenant       string          `json:"-"`
+}
+
+// RebuildResult counts what Rebuild replayed and projected
+type RebuildResult struct {
+	DomainEvents int
+	Alarms       int
+	Events       int
+}
+
+const historyTable = "domain_events"
+
+const historyColumns = "seq, at, tenant, actor, request_id, resource_type, resource_id, type, version, data"
+
+// HistoryStorage reads the history of alarms and events and rebuilds the
+// tables projected from it. The alarm, event and batch stores append to it
+// alongside the audit log; see recordChange.
+type HistoryStorage struct {
+	DB *sql.DB
+	// ctx parents the spans of storage operations and selects the tenant
+	// and actor that reads are confined to; see WithContext
+	ctx context.Context
+}
+
+// WithContext returns a copy of the storage whose operations are traced as
+// part of the request in ctx and confined to its tenant and actor
+func (h *HistoryStorage) WithContext(ctx context.Context) *HistoryStorage {
+	c := *h
+	c.ctx = ctx
+	return &c
+}
+
+// CreateTable creates the history and imports every alarm and event stored
+// without one, as they are at migration time. It must run after the alarms
+// and events tables exist.
+func (h *HistoryStorage) CreateTable() error {
+	if err := createHistoryTable(h.DB); err != nil {
+		return err
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ctx := WithActor(context.Background(), Actor{ID: MigrationActor})
+	for _, resourceType := range []string{AuditAlarm, AuditEvent} {
+		table := resourceType + "s"
+		rows, err := tx.Query("SELECT id, tenant FROM "+table+" r WHERE NOT EXISTS (SELECT 1 FROM "+historyTable+
+			" h WHERE h.resource_type = ? AND h.tenant = r.tenant AND h.resource_id = r.id) ORDER BY rowid", resourceType)
+		if err != nil {
+			return err
+		}
+		var missing [][2]string
+		for rows.Next() {
+			var id, tenant string
+			if err := rows.Scan(&id, &tenant); err != nil {
+				rows.Close()
+				return err
+			}
+			missing = append(missing, [2]string{id, tenant})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, m := range missing {
+			state, err := loadHistoryState(tx, resourceType, m[1], m[0])
+			if err != nil {
+				return err
+			}
+			e := state.snapshot(resourceType)
+			e.Type = historyTypes[resourceType].imported
+			if err := appendHistory(WithTenant(ctx, Tenant{ID: m[1]}), tx, resourceType, m[0], []DomainEvent{e}); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func createHistoryTable(db dbtx) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS ` + historyTable + ` (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			at INTEGER NOT NULL,
+			tenant TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			request_id TEXT NOT NULL,
+			resource_type TEXT NOT NULL,
+			resource_id TEXT NOT NULL,
+			type TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		"CREATE INDEX IF NOT EXISTS domain_events_resource ON " + historyTable + " (resource_type, resource_id, seq)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History returns the domain events of an alarm or event of the tenant,
+// oldest first, including those of a deleted one. Whether the caller may
+// see them follows the last ACL the resource had.
+func (h *HistoryStorage) History(resourceType, id string) (_ []DomainEvent, err error) {
+	defer observe(h.ctx, "history", "history")(&err)
+	return h.stream(resourceType, id)
+}
+
+// AsOf returns an alarm or event of the tenant as it was at, reconstructed
+// from its history: a datapkg.Alarm or datapkg.Event. It returns
+// sql.ErrNoRows if the resource did not exist then, including before it was
+// imported into the history.
+func (h *HistoryStorage) AsOf(resourceType, id string, at time.Time) (_ interface{}, err error) {
+	defer observe(h.ctx, "history", "as_of")(&err)
+	events, err := h.stream(resourceType, id)
+	if err != nil {
+		return nil, err
+	}
+	state := foldHistory(events, at)
+	if !state.Exists {
+		return nil, sql.ErrNoRows
+	}
+	return state.resource(resourceType, id), nil
+}
+
+// ListAsOf returns the alarms or events of the tenant that existed at and
+// whose labels then matched sel, as they were at, in the order they were
+// created
+func (h *HistoryStorage) ListAsOf(resourceType string, sel Selector, at time.Time) (_ []interface{}, err error) {
+	defer observe(h.ctx, "history", "list_as_of")(&err)
+	streams, order, err := readHistory(h.DB, "tenant = ? AND resource_type = ?", TenantOf(h.ctx).ID, resourceType)
+	if err != nil {
+		return nil, err
+	}
+	var result []interface{}
+	for _, id := range order {
+		events := streams[id]
+		latest := foldHistory(events, time.Time{})
+		if authorize(h.ctx, latest.Owner, latest.Viewers, latest.Editors, RoleViewer) != nil {
+			continue
+		}
+		if state := foldHistory(events, at); state.Exists && sel.Matches(state.Labels) {
+			result = append(result, state.resource(resourceType, id))
+		}
+	}
+	return result, nil
+}
+
+// stream returns the domain events of one resource of the tenant, checking
+// that the actor may view it as it last was
+func (h *HistoryStorage) stream(resourceType, id string) ([]DomainEvent, error) {
+	streams, _, err := readHistory(h.DB, "tenant = ? AND resource_type = ? AND resource_id = ?",
+		TenantOf(h.ctx).ID, resourceType, id)
+	if err != nil {
+		return nil, err
+	}
+	events := streams[id]
+	if len(events) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	latest := foldHistory(events, time.Time{})
+	if err := authorize(h.ctx, latest.Owner, latest.Viewers, latest.Editors, RoleViewer); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Rebuild replaces the alarms and events of every tenant, with their labels
+// and ACLs, by replaying the whole history in one transaction. The search
+// index follows through its triggers.
+func (h *HistoryStorage) Rebuild() (_ RebuildResult, err error) {
+	defer observe(h.ctx, "history", "rebuild")(&err)
+	var result RebuildResult
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"alarms", "events", alarmLabelsTable, eventLabelsTable, alarmACLTable, eventACLTable} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return result, err
+		}
+	}
+	// IDs are unique within a tenant, so each tenant is replayed on its own
+	tenants, err := historyTenants(tx)
+	if err != nil {
+		return result, err
+	}
+	for _, tenant := range tenants {
+		for _, resourceType := range []string{AuditAlarm, AuditEvent} {
+			streams, order, err := readHistory(tx, "tenant = ? AND resource_type = ?", tenant, resourceType)
+			if err != nil {
+				return result, err
+			}
+			for _, id := range order {
+				result.DomainEvents += len(streams[id])
+				state := foldHistory(streams[id], time.Time{})
+				if !state.Exists {
+					continue
+				}
+				if err := state.project(tx, resourceType, id); err != nil {
+					return result, err
+				}
+				if resourceType == AuditAlarm {
+					result.Alarms++
+				} else {
+					result.Events++
+				}
+			}
+		}
+	}
+	return result, tx.Commit()
+}
+
+// historyTenants returns the tenants with a history, in the order their
+// first domain event was recorded
+func historyTenants(db dbtx) ([]string, error) {
+	rows, err := db.Query("SELECT tenant FROM " + historyTable + " GROUP BY tenant ORDER BY MIN(seq)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tenants []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+// readHistory returns the domain events matching where, grouped by resource
+// ID, and the IDs in the order their first event was recorded. where must
+// select a single tenant, as IDs are only unique within one.
+func readHistory(db dbtx, where string, args ...interface{}) (map[string][]DomainEvent, []string, error) {
+	rows, err := db.Query("SELECT "+historyColumns+" FROM "+historyTable+" WHERE "+where+" ORDER BY seq", args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	streams := map[string][]DomainEvent{}
+	var order []string
+	for rows.Next() {
+		var e DomainEvent
+		var at int64
+		var data string
+		if err := rows.Scan(&e.Seq, &at, &e.Tenant, &e.Actor, &e.RequestID, &e.ResourceType, &e.ResourceID,
+			&e.Type, &e.Version, &data); err != nil {
+			return nil, nil, err
+		}
+		e.At = time.Unix(0, at).UTC()
+		if err := json.Unmarshal([]byte(data), &e.Data); err != nil {
+			return nil, nil, err
+		}
+		if _, seen := streams[e.ResourceID]; !seen {
+			order = append(order, e.ResourceID)
+		}
+		streams[e.ResourceID] = append(streams[e.ResourceID], e)
+	}
+	return streams, order, rows.Err()
+}
+
+// appendHistory appends events of a resource in the tenant of ctx, made by
+// its actor, within db, the transaction that made the change
+func appendHistory(ctx context.Context, db dbtx, resourceType, id string, events []DomainEvent) error {
+	actor, _ := ActorOf(ctx)
+	at := time.Now().UTC().UnixNano()
+	for _, e := range events {
+		data, err := json.Marshal(e.Data)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec("INSERT INTO "+historyTable+
+			" (at, tenant, actor, request_id, resource_type, resource_id, type, version, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			at, TenantOf(ctx).ID, actor.ID, RequestIDOf(ctx), resourceType, id, e.Type, e.Version, string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordChange records the change of a resource from before to after in
+// the audit log and the history, within db, the transaction that made it.
+// Either state is nil for a resource that did not exist.
+func recordChange(ctx context.Context, db dbtx, action string, before, after interface{}) error {
+	resourceType, id := AuditAlarm, ""
+	for _, state := range []interface{}{before, after} {
+		switch v := state.(type) {
+		case datapkg.Alarm:
+			resourceType, id = AuditAlarm, v.ID
+		case datapkg.Event:
+			resourceType, id = AuditEvent, v.ID
+		}
+	}
+	if err := recordAudit(ctx, db, action, resourceType, id, auditDiff(auditState(before), auditState(after))); err != nil {
+		return err
+	}
+	return appendHistory(ctx, db, resourceType, id, historyDiff(resourceType, historyStateOf(before), historyStateOf(after)))
+}
+
+// auditState lists the fields of a resource the audit log compares, nil
+// for none
+func auditState(resource interface{}) map[string]interface{} {
+	switch v := resource.(type) {
+	case datapkg.Alarm:
+		return alarmAuditState(v)
+	case datapkg.Event:
+		return eventAuditState(v)
+	}
+	return nil
+}
+
+// historyTypeSet names the domain event types of one resource type
+type historyTypeSet struct {
+	created, imported, details, clock, labels, shared, deleted string
+}
+
+var historyTypes = map[string]historyTypeSet{
+	AuditAlarm: {AlarmCreated, AlarmImported, AlarmDetailsChanged, AlarmTargetChanged, AlarmLabelsChanged, AlarmShared, AlarmDeleted},
+	AuditEvent: {EventCreated, EventImported, EventDetailsChanged, EventStartChanged, EventLabelsChanged, EventShared, EventDeleted},
+}
+
+// historyState is a resource as the history folds it. Clock is the target
+// of an alarm and the start of an event.
+type historyState struct {
+	Exists            bool
+	Tenant            string
+	Name, Description string
+	Clock, CreatedAt  time.Time
+	FiredAt           *time.Time
+	Owner             string
+	Labels            map[string]string
+	Viewers, Editors  []string
+	Version           int64
+}
+
+// historyStateOf converts an alarm or event, nil for none
+func historyStateOf(resource interface{}) *historyState {
+	var s historyState
+	switch v := resource.(type) {
+	case datapkg.Alarm:
+		s = historyState{Tenant: v.Tenant, Name: v.Name, Description: v.Description, Clock: v.Target,
+			CreatedAt: v.CreatedAt, Owner: v.Owner, Labels: v.Labels, Viewers: v.Viewers, Editors: v.Editors, Version: v.Version}
+	case datapkg.Event:
+		s = historyState{Tenant: v.Tenant, Name: v.Name, Description: v.Description, Clock: v.StartedAt,
+			CreatedAt: v.CreatedAt, Owner: v.Owner, Labels: v.Labels, Viewers: v.Viewers, Editors: v.Editors, Version: v.Version}
+	default:
+		return nil
+	}
+	s.Exists = true
+	s.normalize()
+	return &s
+}
+
+// normalize brings a state to the form storage reads back: times at second
+// precision, labels and ACLs never nil, and ACLs sorted with an entry that
+// is both viewer and editor listed as editor only
+func (s *historyState) normalize() {
+	s.Clock = historySecond(s.Clock)
+	s.CreatedAt = historySecond(s.CreatedAt)
+	if s.Labels == nil {
+		s.Labels = map[string]string{}
+	}
+	editors := map[string]bool{}
+	for _, e := range s.Editors {
+		editors[e] = true
+	}
+	viewers := map[string]bool{}
+	for _, v := range s.Viewers {
+		if !editors[v] {
+			viewers[v] = true
+		}
+	}
+	s.Viewers, s.Editors = sortedKeys(viewers), sortedKeys(editors)
+}
+
+func historySecond(t time.Time) time.Time {
+	return time.Unix(t.Unix(), 0).UTC()
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// snapshot is a domain event setting every field of s, without a type
+func (s *historyState) snapshot(resourceType string) DomainEvent {
+	name, description, owner := s.Name, s.Description, s.Owner
+	clock, created := s.Clock, s.CreatedAt
+	labels, viewers, editors := s.Labels, s.Viewers, s.Editors
+	data := DomainEventData{Name: &name, Description: &description, CreatedAt: &created, FiredAt: s.FiredAt,
+		Owner: &owner, Labels: &labels, Viewers: &viewers, Editors: &editors}
+	if resourceType == AuditAlarm {
+		data.Target = &clock
+	} else {
+		data.StartedAt = &clock
+	}
+	return DomainEvent{Version: s.Version, Data: data}
+}
+
+// historyDiff returns the domain events that take a resource from before to
+// after; either is nil for a resource that did not exist
+func historyDiff(resourceType string, before, after *historyState) []DomainEvent {
+	types := historyTypes[resourceType]
+	switch {
+	case after == nil:
+		return []DomainEvent{{Type: types.deleted, Version: before.Version}}
+	case before == nil:
+		e := after.snapshot(resourceType)
+		e.Type = types.created
+		return []DomainEvent{e}
+	}
+	var events []DomainEvent
+	if before.Name != after.Name || before.Description != after.Description {
+		name, description := after.Name, after.Description
+		events = append(events, DomainEvent{Type: types.details, Data: DomainEventData{Name: &name, Description: &description}})
+	}
+	if !before.Clock.Equal(after.Clock) {
+		clock := after.Clock
+		e := DomainEvent{Type: types.clock}
+		if resourceType == AuditAlarm {
+			e.Data.Target = &clock
+		} else {
+			e.Data.StartedAt = &clock
+		}
+		events = append(events, e)
+	}
+	if !reflect.DeepEqual(before.Labels, after.Labels) {
+		labels := after.Labels
+		events = append(events, DomainEvent{Type: types.labels, Data: DomainEventData{Labels: &labels}})
+	}
+	if !reflect.DeepEqual(before.Viewers, after.Viewers) || !reflect.DeepEqual(before.Editors, after.Editors) {
+		viewers, editors := after.Viewers, after.Editors
+		events = append(events, DomainEvent{Type: types.shared, Data: DomainEventData{Viewers: &viewers, Editors: &editors}})
+	}
+	// an update that changed nothing still took a version
+	if len(events) == 0 && after.Version != before.Version {
+		name, description := after.Name, after.Description
+		events = append(events, DomainEvent{Type: types.details, Data: DomainEventData{Name: &name, Description: &description}})
+	}
+	for i := range events {
+		events[i].Version = after.Version
+	}
+	return events
+}
+
+// foldHistory replays events recorded at or before at, or every event for
+// a zero at
+func foldHistory(events []DomainEvent, at time.Time) historyState {
+	var s historyState
+	for _, e := range events {
+		if !at.IsZero() && e.At.After(at) {
+			break
+		}
+		s.apply(e)
+	}
+	return s
+}
+
+// apply folds one domain event into s
+func (s *historyState) apply(e DomainEvent) {
+	switch e.Type {
+	case AlarmCreated, AlarmImported, EventCreated, EventImported:
+		*s = historyState{Exists: true}
+	case AlarmDeleted, EventDeleted:
+		s.Exists = false
+	case AlarmTargetChanged:
+		// a new target re-arms the alarm, as updateAlarm does
+		s.FiredAt = nil
+	}
+	s.Tenant, s.Version = e.Tenant, e.Version
+	d := e.Data
+	if d.Name != nil {
+		s.Name = *d.Name
+	}
+	if d.Description != nil {
+		s.Description = *d.Description
+	}
+	if d.Target != nil {
+		s.Clock = *d.Target
+	}
+	if d.StartedAt != nil {
+		s.Clock = *d.StartedAt
+	}
+	if d.CreatedAt != nil {
+		s.CreatedAt = *d.CreatedAt
+	}
+	if d.FiredAt != nil {
+		firedAt := *d.FiredAt
+		s.FiredAt = &firedAt
+	}
+	if d.Owner != nil {
+		s.Owner = *d.Owner
+	}
+	if d.Labels != nil {
+		s.Labels = *d.Labels
+	}
+	if d.Viewers != nil {
+		s.Viewers = *d.Viewers
+	}
+	if d.Editors != nil {
+		s.Editors = *d.Editors
+	}
+}
+
+// resource converts s to the datapkg.Alarm or datapkg.Event it describes,
+// with times as storage reads them back
+func (s *historyState) resource(resourceType, id string) interface{} {
+	if resourceType == AuditAlarm {
+		return datapkg.Alarm{ID: id, Name: s.Name, Description: s.Description, Target: s.Clock, CreatedAt: s.CreatedAt,
+			Labels: s.Labels, Version: s.Version, Owner: s.Owner, Tenant: s.Tenant, Viewers: s.Viewers, Editors: s.Editors,
+			FiredAt: s.FiredAt}
+	}
+	return datapkg.Event{ID: id, Name: s.Name, Description: s.Description,
+		StartedAt: time.Unix(s.Clock.Unix(), 0), CreatedAt: time.Unix(s.CreatedAt.Unix(), 0),
+		Labels: s.Labels, Version: s.Version, Owner: s.Owner, Tenant: s.Tenant, Viewers: s.Viewers, Editors: s.Editors}
+}
+
+// project writes s as the stored row of a resource, with its labels and ACL
+func (s *historyState) project(db dbtx, resourceType, id string) error {
+	labelsTable, aclTable, column := alarmLabelsTable, alarmACLTable, "alarm_id"
+	if resourceType == AuditAlarm {
+		var firedAt interface{}
+		if s.FiredAt != nil {
+			firedAt = s.FiredAt.Unix()
+		}
+		if _, err := db.Exec("INSERT INTO alarms (id, name, description, target, created_at, version, fired_at, owner, tenant)"+
+			" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", id, s.Name, s.Description, s.Clock.Unix(), s.CreatedAt.Unix(),
+			s.Version, firedAt, s.Owner, s.Tenant); err != nil {
+			return err
+		}
+	} else {
+		labelsTable, aclTable, column = eventLabelsTable, eventACLTable, "event_id"
+		if _, err := db.Exec("INSERT INTO events (id, name, description, started_at, created_at, version, owner, tenant)"+
+			" VALUES (?, ?, ?, ?, ?, ?, ?, ?)", id, s.Name, s.Description, s.Clock.Unix(), s.CreatedAt.Unix(),
+			s.Version, s.Owner, s.Tenant); err != nil {
+			return err
+		}
+	}
+	if err := saveACL(db, aclTable, column, s.Tenant, id, s.Viewers, s.Editors); err != nil {
+		return err
+	}
+	return saveLabels(db, labelsTable, column, s.Tenant, id, s.Labels)
+}
+
+// loadHistoryState reads the stored state of a resource, including when an
+// alarm fired
+func loadHistoryState(db dbtx, resourceType, tenant, id string) (*historyState, error) {
+	if resourceType == AuditEvent {
+		event, err := findEvent(db, tenant, id)
+		if err != nil {
+			return nil, err
+		}
+		return historyStateOf(event), nil
+	}
+	alarm, err := findAlarm(db, tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	s := historyStateOf(alarm)
+	s.FiredAt = alarm.FiredAt
+	return s, nil
+}
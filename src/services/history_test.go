@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func TestHistory_FoldsChangesAsOf(t *testing.T) {
+	s := setupTenantStores(t)
+	ctx := WithRequestID(WithActor(context.Background(), Actor{ID: "alice"}), "req-1")
+	alarms := s.alarms.WithContext(ctx)
+	history := s.history.WithContext(ctx)
+	target := time.Now().Add(-time.Second).Truncate(time.Second).UTC()
+
+	alarms.Create(datapkg.Alarm{ID: "a1", Name: "standup", Target: target, Owner: "alice"})
+	created := time.Now()
+	alarms.SetLabels("a1", map[string]string{"team": "ops"}, 1)
+	s.alarms.FireDue(time.Now())
+	fired := time.Now()
+	moved := target.Add(time.Hour)
+	if _, err := alarms.Update(datapkg.Alarm{ID: "a1", Name: "retro", Target: moved}, 2); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	updated := time.Now()
+	if err := alarms.RemoveVersion("a1", 3); err != nil {
+		t.Fatalf("RemoveVersion failed: %v", err)
+	}
+
+	events, err := history.History(AuditAlarm, "a1")
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	want := []string{AlarmCreated, AlarmLabelsChanged, AlarmFired, AlarmDetailsChanged, AlarmTargetChanged, AlarmDeleted}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("expected %v, got %v", want, types)
+	}
+	if e := events[0]; e.Actor != "alice" || e.RequestID != "req-1" || e.Version != 1 || *e.Data.Name != "standup" {
+		t.Errorf("unexpected creation %+v", e)
+	}
+	if e := events[2]; e.Actor != SchedulerActor || e.Data.FiredAt == nil || e.Version != 2 {
+		t.Errorf("unexpected firing %+v", e)
+	}
+	if e := events[4]; e.Version != 3 || !e.Data.Target.Equal(moved) {
+		t.Errorf("unexpected target change %+v", e)
+	}
+
+	raw, err := history.AsOf(AuditAlarm, "a1", created)
+	if a, _ := raw.(datapkg.Alarm); err != nil || a.Name != "standup" || len(a.Labels) != 0 || a.Version != 1 || !a.Target.Equal(target) {
+		t.Errorf("expected the alarm as created, got %+v, %v", raw, err)
+	}
+	raw, _ = history.AsOf(AuditAlarm, "a1", fired)
+	if a := raw.(datapkg.Alarm); a.Labels["team"] != "ops" || a.Version != 2 {
+		t.Errorf("expected the relabelled alarm, got %+v", a)
+	}
+	raw, _ = history.AsOf(AuditAlarm, "a1", updated)
+	if a := raw.(datapkg.Alarm); a.Name != "retro" || !a.Target.Equal(moved) || a.Version != 3 {
+		t.Errorf("expected the updated alarm, got %+v", a)
+	}
+	if _, err := history.AsOf(AuditAlarm, "a1", time.Now()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the deleted alarm to be missing now, got %v", err)
+	}
+	if _, err := history.AsOf(AuditAlarm, "a1", created.Add(-time.Hour)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the alarm to be missing before it was created, got %v", err)
+	}
+	if _, err := history.History(AuditAlarm, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no history for a missing alarm, got %v", err)
+	}
+}
+
+func TestHistory_FollowsTheLastACL(t *testing.T) {
+	s := setupTenantStores(t)
+	alice := WithActor(context.Background(), Actor{ID: "alice"})
+	bob := WithActor(context.Background(), Actor{ID: "bob"})
+	events := s.events.WithContext(alice)
+	events.Create(datapkg.Event{ID: "e1", Name: "deploy", StartedAt: time.Now(), Owner: "alice",
+		Viewers: []string{"user:bob"}, Editors: []string{}})
+	shared := time.Now()
+	events.Create(datapkg.Event{ID: "e2", Name: "private", StartedAt: time.Now(), Owner: "alice",
+		Viewers: []string{}, Editors: []string{}})
+	if _, err := events.SetACL("e1", []string{}, []string{}, 1); err != nil {
+		t.Fatalf("SetACL failed: %v", err)
+	}
+
+	if _, err := s.history.WithContext(bob).AsOf(AuditEvent, "e1", shared); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected revoking access to hide the past too, got %v", err)
+	}
+	if list, err := s.history.WithContext(alice).ListAsOf(AuditEvent, Selector{}, shared); err != nil || len(list) != 1 {
+		t.Errorf("expected only e1 to exist at the time, got %+v, %v", list, err)
+	}
+	if list, _ := s.history.WithContext(bob).ListAsOf(AuditEvent, Selector{}, time.Now()); len(list) != 0 {
+		t.Errorf("expected bob to see nothing, got %+v", list)
+	}
+	if _, err := s.history.WithContext(s.in(t, DefaultTenant)).History(AuditEvent, "e2"); err != nil {
+		t.Errorf("expected a caller without an actor to read every history, got %v", err)
+	}
+}
+
+// projection is everything stored for the alarms and events of a tenant
+type projection struct {
+	Alarms, Events []interface{}
+	States         AlarmCounts
+}
+
+func projectionOf(t *testing.T, s tenantStores, ctx context.Context) projection {
+	t.Helper()
+	alarms, err := s.alarms.WithContext(ctx).List()
+	if err != nil {
+		t.Fatalf("List alarms failed: %v", err)
+	}
+	events, err := s.events.WithContext(ctx).List()
+	if err != nil {
+		t.Fatalf("List events failed: %v", err)
+	}
+	states, err := s.alarms.CountStates(time.Now())
+	if err != nil {
+		t.Fatalf("CountStates failed: %v", err)
+	}
+	return projection{alarms, events, states}
+}
+
+func TestHistory_RebuildReproducesProjections(t *testing.T) {
+	s := setupTenantStores(t)
+	s.tenants.Create(Tenant{ID: "acme"})
+	acme := s.in(t, "acme")
+	start := time.Now().Add(-time.Minute)
+
+	s.alarms.Create(datapkg.Alarm{ID: "due", Name: "x", Target: time.Now().Add(-time.Second)})
+	s.alarms.Create(datapkg.Alarm{ID: "a2", Name: "standup", Target: time.Now().Add(time.Hour), Labels: map[string]string{"team": "ops"}})
+	s.alarms.FireDue(time.Now())
+	s.alarms.Update(datapkg.Alarm{ID: "a2", Name: "standup", Description: "daily", Target: time.Now().Add(2 * time.Hour)}, 1)
+	s.alarms.SetACL("a2", []string{"group:ops", "user:carol"}, []string{"user:carol"}, 2)
+	s.alarms.Create(datapkg.Alarm{ID: "gone", Name: "x", Target: time.Now().Add(time.Hour)})
+	s.alarms.Remove("gone")
+	s.events.Create(datapkg.Event{ID: "e1", Name: "deploy", StartedAt: start})
+	s.events.WithContext(acme).Create(datapkg.Event{ID: "acme-1", Name: "deploy", StartedAt: start})
+	ops := []BatchOperation{
+		{Op: BatchCreate, Type: BatchEvent, Resource: datapkg.Event{ID: "e2", Name: "release", StartedAt: start}},
+		{Op: BatchUpdate, Type: BatchEvent, ID: "e1", Version: 1, Apply: func(current interface{}) (interface{}, error) {
+			e := current.(datapkg.Event)
+			e.StartedAt = start.Add(time.Second)
+			return e, nil
+		}},
+	}
+	if _, err := s.batch.Execute(ops, true); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	s.events.SetLabels("e2", map[string]string{"env": "prod"}, 1)
+
+	before := []projection{projectionOf(t, s, s.in(t, DefaultTenant)), projectionOf(t, s, acme)}
+	// projections lost or edited outside the service come back
+	s.alarms.DB.Exec("UPDATE alarms SET name = 'edited', fired_at = NULL")
+	s.events.DB.Exec("DELETE FROM event_labels")
+	result, err := s.history.Rebuild()
+	if err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+	if result.Alarms != 2 || result.Events != 3 || result.DomainEvents != 13 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	after := []projection{projectionOf(t, s, s.in(t, DefaultTenant)), projectionOf(t, s, acme)}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("expected the rebuild to reproduce\n%+v\ngot\n%+v", before, after)
+	}
+	if hits, err := s.search.Search("deploy", "", 10); err != nil || len(hits) != 1 {
+		t.Errorf("expected the search index to follow the rebuild, got %+v, %v", hits, err)
+	}
+}
+
+func TestHistory_ImportsStoredResources(t *testing.T) {
+	s := setupTenantStores(t)
+	s.alarms.DB.Exec("INSERT INTO alarms (id, name, description, target, created_at, fired_at) VALUES ('old', 'legacy', '', 100, 50, 100)")
+	s.alarms.DB.Exec("INSERT INTO alarm_acl (tenant, alarm_id, entry, role) VALUES ('default', 'old', '*', 'editor')")
+	for i := 0; i < 2; i++ {
+		if err := s.history.CreateTable(); err != nil {
+			t.Fatalf("CreateTable %d failed: %v", i+1, err)
+		}
+	}
+	events, err := s.history.History(AuditAlarm, "old")
+	if err != nil || len(events) != 1 {
+		t.Fatalf("expected a single import, got %+v, %v", events, err)
+	}
+	if e := events[0]; e.Type != AlarmImported || e.Actor != MigrationActor || e.Data.FiredAt == nil || *e.Data.Name != "legacy" {
+		t.Errorf("unexpected import %+v", e)
+	}
+	if _, err := s.history.Rebuild(); err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+	if c, _ := s.alarms.CountStates(time.Now()); c.Fired != 1 {
+		t.Errorf("expected the imported alarm to stay fired, got %+v", c)
+	}
+}
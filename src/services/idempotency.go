@@ -0,0 +1,119 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrIdempotencyInProgress is returned when a key is reserved by a request
+// that has not completed yet
+var ErrIdempotencyInProgress = errors.New("a request with this idempotency key is in progress")
+
+// ErrIdempotencyMismatch is returned when a key is reused for a different request
+var ErrIdempotencyMismatch = errors.New("idempotency key was used with a different request")
+
+// DefaultIdempotencyRetention is how long stored responses are replayed
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+// IdempotentResponse is a stored response replayed for a retried request
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	ETag        string
+	Body        []byte
+}
+
+// IdempotencyStorage records the first response for each Idempotency-Key so
+// retries can be answered without repeating the side effect
+type IdempotencyStorage struct {
+	DB        *sql.DB
+	Retention time.Duration
+}
+
+func (s *IdempotencyStorage) CreateTable() error {
+	table := `CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		content_type TEXT NOT NULL,
+		body BLOB,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (key, scope)
+	);`
+	if _, err := s.DB.Exec(table); err != nil {
+		return err
+	}
+	return addColumnIfMissing(s.DB, "idempotency_keys", "etag", "TEXT NOT NULL DEFAULT ''")
+}
+
+func (s *IdempotencyStorage) retention() time.Duration {
+	if s.Retention > 0 {
+		return s.Retention
+	}
+	return DefaultIdempotencyRetention
+}
+
+// Reserve claims a key for a request. It returns the stored response when the
+// key was already completed for the same request, ErrIdempotencyInProgress
+// while the first request is still running, and ErrIdempotencyMismatch when
+// the key was used with a different request hash. A nil response and nil
+// error mean the caller owns the key and must Complete or Release it.
+func (s *IdempotencyStorage) Reserve(key, scope, requestHash string) (*IdempotentResponse, error) {
+	now := time.Now().UTC()
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM idempotency_keys WHERE created_at < ?", now.Add(-s.retention()).Unix()); err != nil {
+		return nil, err
+	}
+	// claim the key in one statement so two retries arriving together cannot
+	// both see it free; only the one whose insert took effect owns it
+	res, err := tx.Exec(
+		"INSERT INTO idempotency_keys (key, scope, request_hash, status, content_type, body, created_at) VALUES (?, ?, ?, 0, '', NULL, ?) ON CONFLICT (key, scope) DO NOTHING",
+		key, scope, requestHash, now.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 1 {
+		return nil, tx.Commit()
+	}
+	var hash, contentType, etag string
+	var status int
+	var body []byte
+	err = tx.QueryRow(
+		"SELECT request_hash, status, content_type, etag, body FROM idempotency_keys WHERE key = ? AND scope = ?",
+		key, scope,
+	).Scan(&hash, &status, &contentType, &etag, &body)
+	switch {
+	case err != nil:
+		return nil, err
+	case hash != requestHash:
+		return nil, ErrIdempotencyMismatch
+	case status == 0:
+		return nil, ErrIdempotencyInProgress
+	}
+	return &IdempotentResponse{Status: status, ContentType: contentType, ETag: etag, Body: body}, nil
+}
+
+// Complete stores the response for a reserved key
+func (s *IdempotencyStorage) Complete(key, scope string, resp IdempotentResponse) error {
+	_, err := s.DB.Exec(
+		"UPDATE idempotency_keys SET status = ?, content_type = ?, etag = ?, body = ? WHERE key = ? AND scope = ?",
+		resp.Status, resp.ContentType, resp.ETag, resp.Body, key, scope,
+	)
+	return err
+}
+
+// Release drops a reservation so the request can be retried, used when the
+// first attempt failed with a server error
+func (s *IdempotencyStorage) Release(key, scope string) error {
+	_, err := s.DB.Exec("DELETE FROM idempotency_keys WHERE key = ? AND scope = ?", key, scope)
+	return err
+}
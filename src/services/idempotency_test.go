@@ -0,0 +1,115 @@
+package services
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupIdempotencyStorage(t *testing.T) *IdempotencyStorage {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := &IdempotencyStorage{DB: db}
+	if err := s.CreateTable(); err != nil {
+		t.Fatalf("failed to create idempotency table: %v", err)
+	}
+	return s
+}
+
+func TestIdempotencyStorage_ReserveCompleteReplay(t *testing.T) {
+	s := setupIdempotencyStorage(t)
+
+	stored, err := s.Reserve("k1", "POST /alarms/create", "hash")
+	if err != nil || stored != nil {
+		t.Fatalf("expected fresh reservation, got %v, %v", stored, err)
+	}
+	if _, err := s.Reserve("k1", "POST /alarms/create", "hash"); err != ErrIdempotencyInProgress {
+		t.Fatalf("expected ErrIdempotencyInProgress, got %v", err)
+	}
+	if err := s.Complete("k1", "POST /alarms/create", IdempotentResponse{Status: 201, ContentType: "application/json", Body: []byte(`{}`)}); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	stored, err = s.Reserve("k1", "POST /alarms/create", "hash")
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if stored == nil || stored.Status != 201 || string(stored.Body) != `{}` {
+		t.Fatalf("expected stored response to be replayed, got %+v", stored)
+	}
+	if _, err := s.Reserve("k1", "POST /alarms/create", "other"); err != ErrIdempotencyMismatch {
+		t.Fatalf("expected ErrIdempotencyMismatch, got %v", err)
+	}
+	// the same key in another scope is independent
+	if stored, err := s.Reserve("k1", "POST /events/create", "hash"); err != nil || stored != nil {
+		t.Fatalf("expected fresh reservation in another scope, got %v, %v", stored, err)
+	}
+}
+
+func TestIdempotencyStorage_ConcurrentReserve(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "clock.db")+"?_txlock=immediate")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := &IdempotencyStorage{DB: db}
+	if err := s.CreateTable(); err != nil {
+		t.Fatalf("failed to create idempotency table: %v", err)
+	}
+
+	const retries = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, retries)
+	for i := 0; i < retries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Reserve("k1", "scope", "hash")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	owners := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			owners++
+		case ErrIdempotencyInProgress:
+		default:
+			t.Errorf("Reserve failed: %v", err)
+		}
+	}
+	if owners != 1 {
+		t.Errorf("expected exactly one retry to own the key, got %d", owners)
+	}
+}
+
+func TestIdempotencyStorage_ReleaseAndExpiry(t *testing.T) {
+	s := setupIdempotencyStorage(t)
+
+	if _, err := s.Reserve("k1", "scope", "hash"); err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if err := s.Release("k1", "scope"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if stored, err := s.Reserve("k1", "scope", "other"); err != nil || stored != nil {
+		t.Fatalf("expected released key to be reusable, got %v, %v", stored, err)
+	}
+
+	s.Retention = time.Second
+	if _, err := s.DB.Exec("UPDATE idempotency_keys SET created_at = ?", time.Now().Add(-time.Minute).Unix()); err != nil {
+		t.Fatalf("failed to age key: %v", err)
+	}
+	if stored, err := s.Reserve("k1", "scope", "third"); err != nil || stored != nil {
+		t.Fatalf("expected expired key to be reusable, got %v, %v", stored, err)
+	}
+}
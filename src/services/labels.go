@@ -0,0 +1,101 @@
+package services
+
+import (
+	"database/sql"
+)
+
+// Labels are stored once per distinct key/value pair in the labels table and
+// attached to alarms and events through per-resource join tables, keyed by
+// tenant and resource ID as the resources are.
+const (
+	alarmLabelsTable = "alarm_labels"
+	eventLabelsTable = "event_labels"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// createLabelTables creates the labels table and the join table of
+// resourceTable, moving the labels stored before the join table had a
+// tenant column to the tenant of their resource
+func createLabelTables(db *sql.DB, joinTable, resourceColumn, resourceTable string) error {
+	labelTable := `CREATE TABLE IF NOT EXISTS labels (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		UNIQUE (key, value)
+	);`
+	if _, err := db.Exec(labelTable); err != nil {
+		return err
+	}
+	join := `CREATE TABLE IF NOT EXISTS ` + joinTable + ` (
+		tenant TEXT NOT NULL,
+		` + resourceColumn + ` TEXT NOT NULL,
+		label_id INTEGER NOT NULL REFERENCES labels(id),
+		PRIMARY KEY (tenant, ` + resourceColumn + `, label_id)
+	);`
+	if _, err := db.Exec(join); err != nil {
+		return err
+	}
+	return keyByTenant(db, joinTable, join,
+		"INSERT INTO "+joinTable+" (tenant, "+resourceColumn+", label_id) SELECT r.tenant, j."+resourceColumn+", j.label_id FROM "+
+			joinTable+"_unscoped j JOIN "+resourceTable+" r ON r.id = j."+resourceColumn)
+}
+
+// saveLabels replaces the labels attached to a resource
+func saveLabels(db dbtx, joinTable, resourceColumn, tenant, id string, labels map[string]string) error {
+	if err := deleteLabels(db, joinTable, resourceColumn, tenant, id); err != nil {
+		return err
+	}
+	for k, v := range labels {
+		if _, err := db.Exec("INSERT OR IGNORE INTO labels (key, value) VALUES (?, ?)", k, v); err != nil {
+			return err
+		}
+		var labelID int64
+		if err := db.QueryRow("SELECT id FROM labels WHERE key = ? AND value = ?", k, v).Scan(&labelID); err != nil {
+			return err
+		}
+		if _, err := db.Exec("INSERT INTO "+joinTable+" (tenant, "+resourceColumn+", label_id) VALUES (?, ?, ?)", tenant, id, labelID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadLabels returns the labels attached to a resource, or nil when it has none
+func loadLabels(db dbtx, joinTable, resourceColumn, tenant, id string) (map[string]string, error) {
+	rows, err := db.Query(
+		"SELECT l.key, l.value FROM labels l JOIN "+joinTable+" j ON j.label_id = l.id WHERE j.tenant = ? AND j."+resourceColumn+" = ?",
+		tenant, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var labels map[string]string
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[k] = v
+	}
+	return labels, rows.Err()
+}
+
+func deleteLabels(db dbtx, joinTable, resourceColumn, tenant, id string) error {
+	_, err := db.Exec("DELETE FROM "+joinTable+" WHERE tenant = ? AND "+resourceColumn+" = ?", tenant, id)
+	return err
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func TestAlarmStorage_Labels(t *testing.T) {
+	s := setupAlarmStorage(t)
+
+	opsRaw, err := s.Create(datapkg.Alarm{
+		Name:   "ops",
+		Target: time.Now().Add(time.Hour),
+		Labels: map[string]string{"team": "ops", "env": "prod"},
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	ops := opsRaw.(datapkg.Alarm)
+	if _, err := s.Create(datapkg.Alarm{
+		Name:   "dev",
+		Target: time.Now().Add(time.Hour),
+		Labels: map[string]string{"team": "dev", "env": "prod"},
+	}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	foundRaw, err := s.FindByID(ops.ID)
+	if err != nil {
+		t.Fatalf("FindByID failed: %v", err)
+	}
+	if found := foundRaw.(datapkg.Alarm); found.Labels["team"] != "ops" || found.Labels["env"] != "prod" {
+		t.Errorf("expected labels to round trip, got %v", found.Labels)
+	}
+
+	sel, _ := ParseSelector("team=ops")
+	selected, err := s.ListSelected(sel)
+	if err != nil {
+		t.Fatalf("ListSelected failed: %v", err)
+	}
+	if len(selected) != 1 || selected[0].(datapkg.Alarm).ID != ops.ID {
+		t.Fatalf("expected only the ops alarm, got %v", selected)
+	}
+
+	version, err := s.SetLabels(ops.ID, map[string]string{"team": "dev"}, ops.Version)
+	if err != nil {
+		t.Fatalf("SetLabels failed: %v", err)
+	}
+	if version != ops.Version+1 {
+		t.Errorf("expected version %d after relabel, got %d", ops.Version+1, version)
+	}
+	if _, err := s.SetLabels(ops.ID, nil, ops.Version); err != ErrVersionMismatch {
+		t.Errorf("expected ErrVersionMismatch for stale version, got %v", err)
+	}
+	sel, _ = ParseSelector("team=dev")
+	selected, err = s.ListSelected(sel)
+	if err != nil {
+		t.Fatalf("ListSelected failed: %v", err)
+	}
+	if len(selected) != 2 {
+		t.Fatalf("expected 2 alarms after relabel, got %d", len(selected))
+	}
+
+	if _, err := s.SetLabels("missing", nil, 1); err == nil {
+		t.Errorf("expected error setting labels on missing alarm, got nil")
+	}
+
+	if _, err := s.RemoveSelected(nil); err != ErrEmptySelector {
+		t.Errorf("expected ErrEmptySelector, got %v", err)
+	}
+	sel, _ = ParseSelector("env=prod")
+	removed, err := s.RemoveSelected(sel)
+	if err != nil {
+		t.Fatalf("RemoveSelected failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 alarm removed, got %d", removed)
+	}
+}
+
+func TestEventStorage_Labels(t *testing.T) {
+	s := setupEventStorage(t)
+
+	createdRaw, err := s.Create(datapkg.Event{
+		Name:      "deploy",
+		StartedAt: time.Now(),
+		Labels:    map[string]string{"team": "ops"},
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	created := createdRaw.(datapkg.Event)
+
+	sel, _ := ParseSelector("team in (ops,dev)")
+	selected, err := s.ListSelected(sel)
+	if err != nil {
+		t.Fatalf("ListSelected failed: %v", err)
+	}
+	if len(selected) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(selected))
+	}
+
+	if err := s.Remove(created.ID); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	labels, err := loadLabels(s.DB, eventLabelsTable, "event_id", DefaultTenant, created.ID)
+	if err != nil {
+		t.Fatalf("loadLabels failed: %v", err)
+	}
+	if len(labels) != 0 {
+		t.Errorf("expected labels removed with event, got %v", labels)
+	}
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"ClockAsService/src/metrics"
+	"ClockAsService/src/tracing"
+)
+
+var (
+	storageDuration = metrics.Default.NewHistogramVec(
+		"clock_storage_operation_duration_seconds", "Duration of alarm and event storage operations.",
+		metrics.DefaultBuckets, "store", "operation")
+	storageErrors = metrics.Default.NewCounterVec(
+		"clock_storage_errors_total", "Storage operations that failed, not counting not-found and conflict outcomes.",
+		"store", "operation")
+)
+
+// observe starts timing and tracing a storage operation. Defer the returned
+// function with a pointer to the operation's error result to record its
+// duration and outcome.
+func observe(ctx context.Context, store, operation string) func(err *error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := tracing.Start(ctx, store+"."+operation, tracing.KindInternal,
+		tracing.Attr{Key: "db.system", Value: "sqlite"}, tracing.Attr{Key: "db.operation", Value: operation})
+	start := time.Now()
+	return func(err *error) {
+		storageDuration.Observe(time.Since(start).Seconds(), store, operation)
+		if *err != nil && !expectedError(*err) {
+			storageErrors.Inc(store, operation)
+			span.SetError(*err)
+		}
+		span.End()
+	}
+}
+
+// expectedError reports errors that describe the request rather than a
+// storage failure
+func expectedError(err error) bool {
+	for _, expected := range []error{sql.ErrNoRows, ErrAlreadyExists, ErrVersionMismatch, ErrEmptySelector} {
+		if errors.Is(err, expected) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/tracing"
+)
+
+func TestObserve_CountsOnlyStorageFailures(t *testing.T) {
+	before := storageErrors.Value("test", "op")
+	for _, err := range []error{nil, ErrVersionMismatch, ErrAlreadyExists, errors.New("disk I/O error")} {
+		err := err
+		observe(nil, "test", "op")(&err)
+	}
+	if got := storageErrors.Value("test", "op") - before; got != 1 {
+		t.Fatalf("expected one counted failure, got %v", got)
+	}
+	if storageDuration.Count("test", "op") < 4 {
+		t.Fatalf("expected every call to be timed")
+	}
+}
+
+// spanRecorder keeps exported spans in memory
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(_ context.Context, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestStorage_TracesOperationsUnderTheCaller(t *testing.T) {
+	s := setupAlarmStorage(t)
+	rec := &spanRecorder{}
+	p := tracing.NewProvider(rec, time.Hour, 1000)
+	tracing.SetProvider(p)
+	defer tracing.SetProvider(nil)
+
+	ctx, request := tracing.Start(context.Background(), "request", tracing.KindServer)
+	alarms := s.WithContext(ctx)
+	if _, err := alarms.Create(datapkg.Alarm{ID: "a", Name: "a", Target: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := alarms.Create(datapkg.Alarm{ID: "a", Name: "a", Target: time.Now().Add(time.Hour)}); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected ErrAlreadyExists, got %v", err)
+	}
+	request.End()
+	p.Shutdown(context.Background())
+
+	if len(rec.spans) != 3 {
+		t.Fatalf("expected two storage spans and the request, got %+v", rec.spans)
+	}
+	for _, span := range rec.spans[:2] {
+		if span.Name != "alarms.create" || span.Parent != request.Context().SpanID || span.Error {
+			t.Errorf("unexpected storage span %+v", span)
+		}
+	}
+	if s.ctx != nil {
+		t.Error("WithContext must not change the original storage")
+	}
+}
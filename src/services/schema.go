@@ -0,0 +1,49 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SchemaVersion is the database layout this release creates. It is recorded
+// in PRAGMA user_version once every table has been created or migrated, so a
+// database at this version is ready to serve.
+// Version 2 added alarms.fired_at, version 3 the api_keys table, version 4
+// the owner of alarms and events, version 5 tenants, version 6 the audit
+// log, version 7 the history of alarms and events, version 8 keyed
+// alarms and events, with their labels, ACLs and search entries, by tenant,
+// version 9 where each tenant's audit log starts and version 10 the ETag of
+// stored idempotent responses.
+const SchemaVersion = 10
+
+// Table is a store that creates, or migrates, its own tables
+type Table interface {
+	CreateTable() error
+}
+
+// MigrateSchema creates or migrates tables in order and records
+// SchemaVersion. It refuses a database written by a newer release.
+func MigrateSchema(db *sql.DB, tables ...Table) error {
+	current, err := SchemaVersionOf(db)
+	if err != nil {
+		return err
+	}
+	if current > SchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than this release supports (%d)", current, SchemaVersion)
+	}
+	for _, t := range tables {
+		if err := t.CreateTable(); err != nil {
+			return fmt.Errorf("migrate %T: %w", t, err)
+		}
+	}
+	_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion))
+	return err
+}
+
+// SchemaVersionOf returns the schema version recorded in db, 0 for a
+// database that was never migrated
+func SchemaVersionOf(db dbtx) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
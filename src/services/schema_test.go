@@ -0,0 +1,101 @@
+package services
+
+import (
+	datapkg "ClockAsService/src/data"
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMigrateSchema(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if v, err := SchemaVersionOf(db); err != nil || v != 0 {
+		t.Fatalf("expected a new database at version 0, got %d, %v", v, err)
+	}
+	tables := []Table{&AlarmStorage{DB: db}, &EventStorage{DB: db}, &SearchStorage{DB: db}}
+	for i := 0; i < 2; i++ {
+		if err := MigrateSchema(db, tables...); err != nil {
+			t.Fatalf("migration %d failed: %v", i+1, err)
+		}
+	}
+	if v, err := SchemaVersionOf(db); err != nil || v != SchemaVersion {
+		t.Fatalf("expected version %d, got %d, %v", SchemaVersion, v, err)
+	}
+
+	if _, err := db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatalf("failed to set version: %v", err)
+	}
+	if err := MigrateSchema(db, tables...); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("expected a newer database to be refused, got %v", err)
+	}
+}
+
+func TestMigrateSchema_KeysResourcesByTenant(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// the layout of version 7, where IDs were unique across tenants
+	for _, stmt := range []string{
+		`CREATE TABLE alarms (id TEXT PRIMARY KEY, name TEXT NOT NULL, description TEXT NOT NULL, target INTEGER NOT NULL,
+			created_at INTEGER NOT NULL, version INTEGER NOT NULL DEFAULT 1, fired_at INTEGER,
+			owner TEXT NOT NULL DEFAULT '', tenant TEXT NOT NULL DEFAULT 'default')`,
+		`CREATE TABLE labels (id INTEGER PRIMARY KEY AUTOINCREMENT, key TEXT NOT NULL, value TEXT NOT NULL, UNIQUE (key, value))`,
+		`CREATE TABLE alarm_labels (alarm_id TEXT NOT NULL, label_id INTEGER NOT NULL REFERENCES labels(id), PRIMARY KEY (alarm_id, label_id))`,
+		`CREATE TABLE alarm_acl (alarm_id TEXT NOT NULL, entry TEXT NOT NULL, role TEXT NOT NULL, PRIMARY KEY (alarm_id, entry))`,
+		`CREATE VIRTUAL TABLE search_index USING fts4(resource_id, kind, name, description,
+			notindexed=resource_id, notindexed=kind, tokenize=porter)`,
+		`CREATE TRIGGER alarms_search_insert AFTER INSERT ON alarms BEGIN
+			INSERT INTO search_index (resource_id, kind, name, description) VALUES (new.id, 'alarm', new.name, new.description);
+		END`,
+		`INSERT INTO alarms (id, name, description, target, created_at, fired_at, tenant) VALUES ('old', 'standup', '', 100, 50, 100, 'acme')`,
+		`INSERT INTO labels (key, value) VALUES ('team', 'ops')`,
+		`INSERT INTO alarm_labels (alarm_id, label_id) VALUES ('old', 1)`,
+		`INSERT INTO alarm_acl (alarm_id, entry, role) VALUES ('old', 'group:ops', 'editor')`,
+		"PRAGMA user_version = 7",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("failed to set up version 7: %v", err)
+		}
+	}
+	alarms, search := &AlarmStorage{DB: db}, &SearchStorage{DB: db}
+	for i := 0; i < 2; i++ {
+		if err := MigrateSchema(db, alarms, &EventStorage{DB: db}, search); err != nil {
+			t.Fatalf("migration %d failed: %v", i+1, err)
+		}
+	}
+
+	acme := WithTenant(context.Background(), Tenant{ID: "acme"})
+	raw, err := alarms.WithContext(acme).FindByID("old")
+	if err != nil {
+		t.Fatalf("expected the alarm to survive the migration, got %v", err)
+	}
+	if a := raw.(datapkg.Alarm); a.Labels["team"] != "ops" || !reflect.DeepEqual(a.Editors, []string{"group:ops"}) {
+		t.Errorf("expected the labels and ACL to follow the alarm, got %+v", a)
+	}
+	if c, _ := alarms.CountStates(time.Now()); c.Fired != 1 {
+		t.Errorf("expected the alarm to stay fired, got %+v", c)
+	}
+	if _, err := alarms.Create(datapkg.Alarm{ID: "old", Name: "standup", Target: time.Now()}); err != nil {
+		t.Fatalf("expected the default tenant to reuse the ID, got %v", err)
+	}
+	for _, ctx := range []context.Context{acme, context.Background()} {
+		if hits, err := search.WithContext(ctx).Search("standup", "", 10); err != nil || len(hits) != 1 {
+			t.Errorf("expected each tenant to find its own alarm, got %+v, %v", hits, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrEmptyQuery is returned when a search query has no searchable terms
+var ErrEmptyQuery = errors.New("search query must contain at least one term")
+
+// SearchResult is a single ranked hit from the full-text index
+type SearchResult struct {
+	Type        string  `json:"type"`
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Snippet     string  `json:"snippet" doc:"HTML: the matching text, escaped, with matches wrapped in <mark>"`
+	Score       float64 `json:"score"`
+}
+
+// SearchStorage maintains an FTS4 index over alarm and event names and
+// descriptions. FTS4 is used rather than FTS5 because go-sqlite3 only
+// compiles FTS5 in with the sqlite_fts5 build tag.
+type SearchStorage struct {
+	DB *sql.DB
+	// ctx confines searches to a tenant; see WithContext
+	ctx context.Context
+}
+
+// WithContext returns a copy of the storage that only finds the alarms and
+// events of the tenant of ctx
+func (s *SearchStorage) WithContext(ctx context.Context) *SearchStorage {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// nameWeight boosts matches in the name column over the description
+const nameWeight = 2.0
+
+// Snippets are asked of FTS with these private-use characters around
+// matches, then HTML-escaped, and only then are they replaced by <mark>
+// tags, so stored text can never inject markup into a snippet
+const (
+	snippetOpen  = "\uE000"
+	snippetClose = "\uE001"
+)
+
+// snippetHTML turns an FTS snippet built with the sentinels into HTML
+func snippetHTML(snippet string) string {
+	return strings.NewReplacer(snippetOpen, "<mark>", snippetClose, "</mark>").Replace(html.EscapeString(snippet))
+}
+
+// CreateTable creates the index and the triggers that keep it in sync. It must
+// run after the alarms and events tables exist; rows created before the index
+// are backfilled the first time it is created. An index written before it
+// recorded the tenant of each row is dropped and rebuilt.
+func (s *SearchStorage) CreateTable() error {
+	var existing int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'search_index'").Scan(&existing); err != nil {
+		return err
+	}
+	tables := []struct{ table, kind string }{{"alarms", "alarm"}, {"events", "event"}}
+	var statements []string
+	if existing > 0 {
+		columns, err := tableColumns(s.DB, "search_index")
+		if err != nil {
+			return err
+		}
+		if _, ok := columns["tenant"]; !ok {
+			existing = 0
+			statements = append(statements, "DROP TABLE search_index;")
+			for _, t := range tables {
+				for _, trigger := range []string{"insert", "update", "delete"} {
+					statements = append(statements, "DROP TRIGGER IF EXISTS "+t.table+"_search_"+trigger+";")
+				}
+			}
+		}
+	}
+	statements = append(statements,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts4(
+			resource_id, kind, name, description, tenant,
+			notindexed=resource_id, notindexed=kind, notindexed=tenant, tokenize=porter
+		);`,
+	)
+	for _, t := range tables {
+		statements = append(statements,
+			`CREATE TRIGGER IF NOT EXISTS `+t.table+`_search_insert AFTER INSERT ON `+t.table+` BEGIN
+				INSERT INTO search_index (resource_id, kind, name, description, tenant) VALUES (new.id, '`+t.kind+`', new.name, new.description, new.tenant);
+			END;`,
+			`CREATE TRIGGER IF NOT EXISTS `+t.table+`_search_update AFTER UPDATE OF name, description ON `+t.table+` BEGIN
+				UPDATE search_index SET name = new.name, description = new.description
+					WHERE resource_id = old.id AND kind = '`+t.kind+`' AND tenant = old.tenant;
+			END;`,
+			`CREATE TRIGGER IF NOT EXISTS `+t.table+`_search_delete AFTER DELETE ON `+t.table+` BEGIN
+				DELETE FROM search_index WHERE resource_id = old.id AND kind = '`+t.kind+`' AND tenant = old.tenant;
+			END;`,
+		)
+		if existing == 0 {
+			statements = append(statements,
+				`INSERT INTO search_index (resource_id, kind, name, description, tenant) SELECT id, '`+t.kind+`', name, description, tenant FROM `+t.table+`;`,
+			)
+		}
+	}
+	for _, stmt := range statements {
+		if _, err := s.DB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search returns index hits ranked by relevance. Terms are ANDed together and
+// a trailing '*' on a term makes it a prefix match. kind filters to "alarm" or
+// "event" when non-empty.
+func (s *SearchStorage) Search(query, kind string, limit int) ([]SearchResult, error) {
+	match := buildMatchExpression(query)
+	if match == "" {
+		return nil, ErrEmptyQuery
+	}
+	alarmsVisible, alarmArgs := visibleCondition(s.ctx, alarmACLTable, "alarm_id", "alarms")
+	eventsVisible, eventArgs := visibleCondition(s.ctx, eventACLTable, "event_id", "events")
+	sqlQuery := `SELECT resource_id, kind, name, description,
+			snippet(search_index, ?, ?, '…', -1, 12),
+			matchinfo(search_index, 'pcx')
+		FROM search_index WHERE search_index MATCH ? AND tenant = ?
+			AND (kind = 'alarm' AND resource_id IN (SELECT id FROM alarms WHERE tenant = ? AND ` + alarmsVisible + `)
+				OR kind = 'event' AND resource_id IN (SELECT id FROM events WHERE tenant = ? AND ` + eventsVisible + `))`
+	tenant := TenantOf(s.ctx).ID
+	args := append(append([]interface{}{snippetOpen, snippetClose, match, tenant, tenant}, alarmArgs...), tenant)
+	args = append(args, eventArgs...)
+	if kind != "" {
+		sqlQuery += " AND kind = ?"
+		args = append(args, kind)
+	}
+	rows, err := s.DB.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		var info []byte
+		if err := rows.Scan(&r.ID, &r.Type, &r.Name, &r.Description, &r.Snippet, &info); err != nil {
+			return nil, err
+		}
+		r.Snippet = snippetHTML(r.Snippet)
+		r.Score = rankMatchInfo(info)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// buildMatchExpression quotes every term so user input can never produce an
+// FTS syntax error, keeping a trailing '*' as a prefix query
+func buildMatchExpression(query string) string {
+	var terms []string
+	for _, field := range strings.Fields(query) {
+		prefix := strings.HasSuffix(field, "*")
+		term := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, field)
+		if term == "" {
+			continue
+		}
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, `"`+term+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// rankMatchInfo scores a row from matchinfo 'pcx' output: for each phrase and
+// indexed column, hits in this row relative to hits across all rows. The
+// resource_id, kind and tenant columns are never indexed so they never
+// contribute.
+// matchinfo is in native byte order, which is little-endian on every platform
+// the service is built for.
+func rankMatchInfo(info []byte) float64 {
+	if len(info) < 8 {
+		return 0
+	}
+	value := func(i int) float64 {
+		return float64(binary.LittleEndian.Uint32(info[i*4:]))
+	}
+	phrases, columns := int(value(0)), int(value(1))
+	if len(info) < (2+phrases*columns*3)*4 {
+		return 0
+	}
+	weights := []float64{0, 0, nameWeight, 1}
+	score := 0.0
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < columns && c < len(weights); c++ {
+			base := 2 + (p*columns+c)*3
+			hitsHere, hitsAll := value(base), value(base+1)
+			if hitsHere > 0 {
+				score += weights[c] * hitsHere / hitsAll
+			}
+		}
+	}
+	return score
+}
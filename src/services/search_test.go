@@ -0,0 +1,103 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupSearchStorage(t *testing.T) (*SearchStorage, *AlarmStorage, *EventStorage) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	alarms := &AlarmStorage{DB: db}
+	events := &EventStorage{DB: db}
+	if err := alarms.CreateTable(); err != nil {
+		t.Fatalf("failed to create alarms table: %v", err)
+	}
+	if err := events.CreateTable(); err != nil {
+		t.Fatalf("failed to create events table: %v", err)
+	}
+	// created before the index so the backfill is exercised
+	if _, err := alarms.Create(datapkg.Alarm{Name: "Deploy freeze", Description: "no deploys until release", Target: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	s := &SearchStorage{DB: db}
+	if err := s.CreateTable(); err != nil {
+		t.Fatalf("failed to create search index: %v", err)
+	}
+	return s, alarms, events
+}
+
+func TestSearchStorage_Search(t *testing.T) {
+	s, alarms, events := setupSearchStorage(t)
+
+	if _, err := events.Create(datapkg.Event{Name: "Incident", Description: "deploy broke checkout", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	lunchRaw, err := alarms.Create(datapkg.Alarm{Name: "Lunch", Description: "eat", Target: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	results, err := s.Search("deploy", "", 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Type != "alarm" || results[0].Name != "Deploy freeze" {
+		t.Errorf("expected the alarm with deploy in its name ranked first, got %+v", results[0])
+	}
+	if results[1].Snippet == "" {
+		t.Errorf("expected a highlighted snippet, got empty string")
+	}
+
+	results, err = s.Search("deplo*", "event", 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Type != "event" {
+		t.Fatalf("expected 1 event for prefix query, got %+v", results)
+	}
+
+	if err := alarms.Remove(lunchRaw.(datapkg.Alarm).ID); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	results, err = s.Search("lunch", "", 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected removed alarm to leave the index, got %+v", results)
+	}
+
+	if _, err := s.Search(`"*()`, "", 0); err != ErrEmptyQuery {
+		t.Errorf("expected ErrEmptyQuery, got %v", err)
+	}
+}
+
+func TestSearchStorage_EscapesSnippets(t *testing.T) {
+	s, alarms, _ := setupSearchStorage(t)
+	if _, err := alarms.Create(datapkg.Alarm{Name: `<img src=x onerror=alert(1)> launch`, Target: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	results, err := s.Search("launch", "", 0)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("expected one result, got %+v, %v", results, err)
+	}
+	if want := "&lt;img src=x onerror=alert(1)&gt; <mark>launch</mark>"; results[0].Snippet != want {
+		t.Errorf("expected the markup to be escaped, got %q, want %q", results[0].Snippet, want)
+	}
+	if results[0].Name != `<img src=x onerror=alert(1)> launch` {
+		t.Errorf("expected the name to be returned as stored, got %q", results[0].Name)
+	}
+}
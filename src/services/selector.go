@@ -0,0 +1,193 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Selector operators, modelled on Kubernetes label selectors.
+const (
+	OpEquals       = "="
+	OpNotEquals    = "!="
+	OpIn           = "in"
+	OpNotIn        = "notin"
+	OpExists       = "exists"
+	OpDoesNotExist = "!"
+)
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.\-/]{0,61}[A-Za-z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9_.\-]{0,61}[A-Za-z0-9])?)?$`)
+	setTermPattern    = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+// ErrEmptySelector is returned by bulk operations that refuse to match every resource
+var ErrEmptySelector = errors.New("selector must not be empty")
+
+// Requirement is a single term of a selector, e.g. "team=ops" or "env notin (prod,staging)"
+type Requirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// Selector is a conjunction of requirements; an empty selector matches everything
+type Selector []Requirement
+
+// ParseSelector parses a comma separated selector string.
+// Supported terms:
+//   - key=value, key==value, key!=value
+//   - key in (a,b), key notin (a,b)
+//   - key (label present), !key (label absent)
+func ParseSelector(raw string) (Selector, error) {
+	var sel Selector
+	for _, term := range splitSelectorTerms(raw) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		req, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// splitSelectorTerms splits on commas that are not inside a set's parentheses
+func splitSelectorTerms(raw string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range raw {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, raw[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, raw[start:])
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	if m := setTermPattern.FindStringSubmatch(term); m != nil {
+		var values []string
+		for _, v := range strings.Split(m[3], ",") {
+			v = strings.TrimSpace(v)
+			if !labelValuePattern.MatchString(v) {
+				return Requirement{}, fmt.Errorf("invalid label value %q in selector", v)
+			}
+			values = append(values, v)
+		}
+		return newRequirement(m[1], m[2], values)
+	}
+	if strings.HasPrefix(term, "!") {
+		return newRequirement(strings.TrimSpace(term[1:]), OpDoesNotExist, nil)
+	}
+	for _, op := range []string{"!=", "==", "="} {
+		if i := strings.Index(term, op); i >= 0 {
+			key := strings.TrimSpace(term[:i])
+			value := strings.TrimSpace(term[i+len(op):])
+			if !labelValuePattern.MatchString(value) {
+				return Requirement{}, fmt.Errorf("invalid label value %q in selector", value)
+			}
+			if op == "==" {
+				op = OpEquals
+			}
+			return newRequirement(key, op, []string{value})
+		}
+	}
+	return newRequirement(term, OpExists, nil)
+}
+
+func newRequirement(key, op string, values []string) (Requirement, error) {
+	if !labelKeyPattern.MatchString(key) {
+		return Requirement{}, fmt.Errorf("invalid label key %q in selector", key)
+	}
+	return Requirement{Key: key, Operator: op, Values: values}, nil
+}
+
+// Matches reports whether the requirement holds for the given labels
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, has := labels[r.Key]
+	switch r.Operator {
+	case OpExists:
+		return has
+	case OpDoesNotExist:
+		return !has
+	case OpEquals, OpIn:
+		return has && contains(r.Values, value)
+	case OpNotEquals, OpNotIn:
+		return !has || !contains(r.Values, value)
+	}
+	return false
+}
+
+// Matches reports whether every requirement holds for the given labels
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty reports whether the selector has no requirements
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+// String renders the selector back into its canonical textual form
+func (s Selector) String() string {
+	terms := make([]string, 0, len(s))
+	for _, r := range s {
+		switch r.Operator {
+		case OpExists:
+			terms = append(terms, r.Key)
+		case OpDoesNotExist:
+			terms = append(terms, "!"+r.Key)
+		case OpIn, OpNotIn:
+			terms = append(terms, fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ",")))
+		default:
+			terms = append(terms, r.Key+r.Operator+r.Values[0])
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// ValidateLabels checks label keys and values against the allowed format
+func ValidateLabels(labels map[string]string) error {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !labelKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if !labelValuePattern.MatchString(labels[k]) {
+			return fmt.Errorf("invalid label value %q for key %q", labels[k], k)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, candidate := range values {
+		if candidate == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package services
+
+import "testing"
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		labels   map[string]string
+		expected bool
+	}{
+		{name: "empty matches everything", selector: "", labels: nil, expected: true},
+		{name: "equals", selector: "team=ops", labels: map[string]string{"team": "ops"}, expected: true},
+		{name: "double equals", selector: "team==ops", labels: map[string]string{"team": "dev"}, expected: false},
+		{name: "not equals matches missing key", selector: "env!=prod", labels: nil, expected: true},
+		{name: "not equals", selector: "env!=prod", labels: map[string]string{"env": "prod"}, expected: false},
+		{name: "conjunction", selector: "team=ops,env!=prod", labels: map[string]string{"team": "ops", "env": "dev"}, expected: true},
+		{name: "in", selector: "env in (dev, staging)", labels: map[string]string{"env": "staging"}, expected: true},
+		{name: "notin", selector: "env notin (dev,staging),team=ops", labels: map[string]string{"env": "dev", "team": "ops"}, expected: false},
+		{name: "exists", selector: "team", labels: map[string]string{"team": ""}, expected: true},
+		{name: "does not exist", selector: "!team", labels: map[string]string{"team": "ops"}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("ParseSelector(%q) failed: %v", tt.selector, err)
+			}
+			if got := sel.Matches(tt.labels); got != tt.expected {
+				t.Errorf("ParseSelector(%q).Matches(%v) = %v, want %v", tt.selector, tt.labels, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseSelector_Invalid(t *testing.T) {
+	for _, raw := range []string{"=ops", "team=o p s", "-bad=x", "env in (a,b c)"} {
+		if _, err := ParseSelector(raw); err == nil {
+			t.Errorf("expected error parsing %q, got nil", raw)
+		}
+	}
+}
+
+func TestSelector_String(t *testing.T) {
+	sel, err := ParseSelector("team==ops, env notin (prod,staging),!legacy")
+	if err != nil {
+		t.Fatalf("ParseSelector failed: %v", err)
+	}
+	expected := "team=ops,env notin (prod,staging),!legacy"
+	if sel.String() != expected {
+		t.Errorf("expected %q, got %q", expected, sel.String())
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	if err := ValidateLabels(map[string]string{"team": "ops", "example.com/tier": ""}); err != nil {
+		t.Errorf("expected valid labels, got %v", err)
+	}
+	if err := ValidateLabels(map[string]string{"team": "has space"}); err == nil {
+		t.Errorf("expected error for invalid value, got nil")
+	}
+	if err := ValidateLabels(map[string]string{"": "x"}); err == nil {
+		t.Errorf("expected error for empty key, got nil")
+	}
+}
@@ -1,5 +1,11 @@
 package services
 
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+)
+
 // StorageService defines basic operations for a storage-backed service
 type StorageService interface {
 	CreateTable() error
@@ -8,3 +14,84 @@ type StorageService interface {
 	List() ([]interface{}, error)
 	FindByID(id string) (interface{}, error)
 }
+
+// ErrAlreadyExists is returned by Create when a client-supplied ID is taken
+var ErrAlreadyExists = errors.New("a resource with this id already exists")
+
+// ErrInvalidID is returned by ValidateID for IDs outside the allowed format
+var ErrInvalidID = errors.New("id must be 1-64 characters of letters, digits, '.', '_' or '-' and start with a letter or digit")
+
+// IDPattern is the format accepted by ValidateID
+const IDPattern = `^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`
+
+var idPattern = regexp.MustCompile(IDPattern)
+
+// ValidateID checks the format of a client-supplied alarm or event ID
+func ValidateID(id string) error {
+	if !idPattern.MatchString(id) {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to a table created by an older release
+func addColumnIfMissing(db dbtx, table, column, definition string) error {
+	columns, err := tableColumns(db, table)
+	if err != nil {
+		return err
+	}
+	if _, ok := columns[column]; ok {
+		return nil
+	}
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// tableColumns returns the columns of a table, each with its position in
+// the primary key: 1 for the first key column, 0 for one outside the key
+func tableColumns(db dbtx, table string) (map[string]int, error) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := map[string]int{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt interface{}
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = pk
+	}
+	return columns, rows.Err()
+}
+
+// keyByTenant rebuilds a table written before IDs were scoped to their
+// tenant, whose primary key does not start with the tenant column. The
+// table is recreated by create and refilled by fill, an INSERT that reads
+// the old rows from table_unscoped. It does nothing to a table already keyed
+// by tenant.
+func keyByTenant(db *sql.DB, table, create, fill string) error {
+	columns, err := tableColumns(db, table)
+	if err != nil || columns["tenant"] == 1 {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"ALTER TABLE " + table + " RENAME TO " + table + "_unscoped",
+		create,
+		fill,
+		"DROP TABLE " + table + "_unscoped",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
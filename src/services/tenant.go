@@ -0,0 +1,364 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTenant holds the data of requests that name no tenant, including
+// everything stored before tenants existed. It cannot be deleted.
+const DefaultTenant = "default"
+
+// Tenant statuses
+const (
+	TenantActive    = "active"
+	TenantSuspended = "suspended"
+)
+
+// ErrQuotaExceeded is returned when creating an alarm would take a tenant
+// past its limit of active alarms
+var ErrQuotaExceeded = errors.New("tenant has reached its limit of active alarms")
+
+// ErrDefaultTenant is returned when deleting the default tenant
+var ErrDefaultTenant = errors.New("the default tenant cannot be deleted")
+
+// Tenant is an isolated namespace of alarms and events. Zero limits are
+// unlimited.
+type Tenant struct {
+	ID                string    `json:"id"`
+	Name              string    `json:"name"`
+	Status            string    `json:"status" openapi:"enum=active|suspended"`
+	MaxActiveAlarms   int       `json:"max_active_alarms" doc:"Alarms that have not fired yet; 0 is unlimited"`
+	RequestsPerMinute int       `json:"requests_per_minute" doc:"Requests accepted per minute across the tenant's callers; 0 is unlimited"`
+	AllowedOrigins    []string  `json:"allowed_origins" doc:"Browser origins allowed by CORS to call the tenant, besides cors.allowed_origins"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// ValidateOrigin checks that origin is a scheme and host, such as
+// https://example.com, as browsers send in the Origin header
+func ValidateOrigin(origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") ||
+		u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("%q is not an origin such as https://example.com", origin)
+	}
+	return nil
+}
+
+// NormalizeOrigin returns origin as browsers send it, without a trailing
+// slash
+func NormalizeOrigin(origin string) string {
+	return strings.TrimSuffix(origin, "/")
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose storage operations are confined to t
+func WithTenant(ctx context.Context, t Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// TenantOf returns the tenant of ctx. Without one it is the default tenant
+// with no limits, so code that never learned about tenants stays confined
+// to the default.
+func TenantOf(ctx context.Context) Tenant {
+	if ctx != nil {
+		if t, ok := ctx.Value(tenantKey{}).(Tenant); ok {
+			return t
+		}
+	}
+	return Tenant{ID: DefaultTenant, Status: TenantActive}
+}
+
+// TenantStorage keeps the tenant registry. Deleting a tenant removes every
+// row that belongs to it, so it must be migrated after the stores it clears.
+type TenantStorage struct {
+	DB *sql.DB
+	// ctx names the actor and request recorded in the audit log; see
+	// WithContext
+	ctx context.Context
+}
+
+// WithContext returns a copy of s that records ctx's actor and request in
+// the audit entries of what it deletes
+func (s *TenantStorage) WithContext(ctx context.Context) *TenantStorage {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+func (s *TenantStorage) CreateTable() error {
+	table := `CREATE TABLE IF NOT EXISTS tenants (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		status TEXT NOT NULL,
+		max_active_alarms INTEGER NOT NULL DEFAULT 0,
+		requests_per_minute INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL
+	);`
+	if _, err := s.DB.Exec(table); err != nil {
+		return err
+	}
+	// origins are stored comma-separated; they never contain a comma
+	if err := addColumnIfMissing(s.DB, "tenants", "allowed_origins", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addAuditAfter(s.DB); err != nil {
+		return err
+	}
+	_, err := s.DB.Exec(
+		"INSERT OR IGNORE INTO tenants (id, name, status, created_at) VALUES (?, ?, ?, ?)",
+		DefaultTenant, "Default", TenantActive, time.Now().Unix(),
+	)
+	return err
+}
+
+// addAuditAfter adds tenants.audit_after, the seq of the last audit entry
+// recorded before the tenant was created. A tenant only reads the entries
+// after it, so one created with the ID of a deleted tenant cannot read what
+// the deleted one stored. Tenants that predate the column skip the entries
+// recorded before their creation time.
+func addAuditAfter(db dbtx) error {
+	if err := createAuditTable(db); err != nil {
+		return err
+	}
+	columns, err := tableColumns(db, "tenants")
+	if err != nil {
+		return err
+	}
+	if _, ok := columns["audit_after"]; ok {
+		return nil
+	}
+	if err := addColumnIfMissing(db, "tenants", "audit_after", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE tenants SET audit_after = (SELECT COALESCE(MAX(seq), 0) FROM " + auditTable +
+		" WHERE tenant = tenants.id AND at < tenants.created_at * 1000000000)")
+	return err
+}
+
+const tenantColumns = "id, name, status, max_active_alarms, requests_per_minute, allowed_origins, created_at"
+
+func scanTenant(row scanner) (Tenant, error) {
+	var t Tenant
+	var origins string
+	var created int64
+	if err := row.Scan(&t.ID, &t.Name, &t.Status, &t.MaxActiveAlarms, &t.RequestsPerMinute, &origins, &created); err != nil {
+		return Tenant{}, err
+	}
+	t.AllowedOrigins = splitOrigins(origins)
+	t.CreatedAt = time.Unix(created, 0).UTC()
+	return t, nil
+}
+
+func joinOrigins(origins []string) string {
+	normalized := make([]string, len(origins))
+	for i, o := range origins {
+		normalized[i] = NormalizeOrigin(o)
+	}
+	return strings.Join(normalized, ",")
+}
+
+func splitOrigins(joined string) []string {
+	if joined == "" {
+		return []string{}
+	}
+	return strings.Split(joined, ",")
+}
+
+// Create registers an active tenant. It returns ErrAlreadyExists when the
+// ID is taken.
+func (s *TenantStorage) Create(t Tenant) (Tenant, error) {
+	t.Status = TenantActive
+	t.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	t.AllowedOrigins = splitOrigins(joinOrigins(t.AllowedOrigins))
+	res, err := s.DB.Exec(
+		"INSERT OR IGNORE INTO tenants (id, name, status, max_active_alarms, requests_per_minute, allowed_origins, created_at, audit_after)"+
+			" VALUES (?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(seq), 0) FROM "+auditTable+"))",
+		t.ID, t.Name, t.Status, t.MaxActiveAlarms, t.RequestsPerMinute, joinOrigins(t.AllowedOrigins), t.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return Tenant{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrAlreadyExists
+		}
+		return Tenant{}, err
+	}
+	return t, nil
+}
+
+// Get returns one tenant, or sql.ErrNoRows
+func (s *TenantStorage) Get(id string) (Tenant, error) {
+	return scanTenant(s.DB.QueryRow("SELECT "+tenantColumns+" FROM tenants WHERE id = ?", id))
+}
+
+// List returns every tenant, oldest first
+func (s *TenantStorage) List() ([]Tenant, error) {
+	rows, err := s.DB.Query("SELECT " + tenantColumns + " FROM tenants ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tenants []Tenant
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// SetStatus suspends or resumes a tenant and returns it. It returns
+// sql.ErrNoRows for an unknown tenant.
+func (s *TenantStorage) SetStatus(id, status string) (Tenant, error) {
+	res, err := s.DB.Exec("UPDATE tenants SET status = ? WHERE id = ?", status, id)
+	if err != nil {
+		return Tenant{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return Tenant{}, err
+	}
+	return s.Get(id)
+}
+
+// SetAllowedOrigins replaces the CORS origins of a tenant and returns it.
+// It returns sql.ErrNoRows for an unknown tenant.
+func (s *TenantStorage) SetAllowedOrigins(id string, origins []string) (Tenant, error) {
+	res, err := s.DB.Exec("UPDATE tenants SET allowed_origins = ? WHERE id = ?", joinOrigins(origins), id)
+	if err != nil {
+		return Tenant{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return Tenant{}, err
+	}
+	return s.Get(id)
+}
+
+// AllowsOrigin reports whether an active tenant allows origin
+func (s *TenantStorage) AllowsOrigin(origin string) (bool, error) {
+	var n int
+	err := s.DB.QueryRow(
+		"SELECT COUNT(*) FROM tenants WHERE status = ? AND instr(',' || allowed_origins || ',', ',' || ? || ',') > 0",
+		TenantActive, NormalizeOrigin(origin),
+	).Scan(&n)
+	return n > 0, err
+}
+
+// Delete removes a tenant with its alarms, events, labels, ACLs, idempotent
+// responses and API keys, in one transaction. Each removed alarm and event
+// gets a delete entry in the audit log, and the tenant's history is purged
+// so neither a rebuild nor a tenant created later with the same ID brings
+// its resources back. It returns sql.ErrNoRows for an unknown tenant.
+func (s *TenantStorage) Delete(id string) error {
+	if id == DefaultTenant {
+		return ErrDefaultTenant
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM tenants WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	if err := auditTenantDeletion(s.ctx, tx, id); err != nil {
+		return err
+	}
+	statements := []string{
+		"DELETE FROM " + historyTable + " WHERE tenant = ?",
+		"DELETE FROM " + alarmLabelsTable + " WHERE tenant = ?",
+		"DELETE FROM " + alarmACLTable + " WHERE tenant = ?",
+		"DELETE FROM alarms WHERE tenant = ?",
+		"DELETE FROM " + eventLabelsTable + " WHERE tenant = ?",
+		"DELETE FROM " + eventACLTable + " WHERE tenant = ?",
+		"DELETE FROM events WHERE tenant = ?",
+		"DELETE FROM api_keys WHERE tenant = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return err
+		}
+	}
+	// idempotency scopes start with the tenant; see IdempotencyScope
+	prefix := id + " "
+	if _, err := tx.Exec("DELETE FROM idempotency_keys WHERE substr(scope, 1, ?) = ?", len(prefix), prefix); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// auditTenantDeletion records the deletion of every alarm and event of
+// tenant, within tx, before they are removed
+func auditTenantDeletion(ctx context.Context, tx *sql.Tx, tenant string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = WithTenant(ctx, Tenant{ID: tenant})
+	for _, resourceType := range []string{AuditAlarm, AuditEvent} {
+		table := "alarms"
+		if resourceType == AuditEvent {
+			table = "events"
+		}
+		rows, err := tx.Query("SELECT id FROM "+table+" WHERE tenant = ? ORDER BY id", tenant)
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			var before interface{}
+			if resourceType == AuditEvent {
+				before, err = findEvent(tx, tenant, id)
+			} else {
+				before, err = findAlarm(tx, tenant, id)
+			}
+			if err != nil {
+				return err
+			}
+			if err := recordAudit(ctx, tx, AuditDelete, resourceType, id, auditDiff(auditState(before), nil)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// IdempotencyScope confines an Idempotency-Key to one tenant, caller and
+// operation, so two callers choosing the same key never see each other's
+// responses
+func IdempotencyScope(tenant, caller, operation string) string {
+	return tenant + " " + caller + " " + operation
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+// tenantStores are every store over one database, migrated like the server
+type tenantStores struct {
+	tenants     *TenantStorage
+	alarms      *AlarmStorage
+	events      *EventStorage
+	search      *SearchStorage
+	batch       *BatchStorage
+	keys        *APIKeyStorage
+	idempotency *IdempotencyStorage
+	audit       *AuditStorage
+	history     *HistoryStorage
+}
+
+func setupTenantStores(t *testing.T) tenantStores {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// one connection, so every store sees the same in-memory database
+	db.SetMaxOpenConns(1)
+	s := tenantStores{
+		tenants: &TenantStorage{DB: db}, alarms: &AlarmStorage{DB: db}, events: &EventStorage{DB: db},
+		search: &SearchStorage{DB: db}, batch: &BatchStorage{DB: db}, keys: &APIKeyStorage{DB: db},
+		idempotency: &IdempotencyStorage{DB: db}, audit: &AuditStorage{DB: db}, history: &HistoryStorage{DB: db},
+	}
+	if err := MigrateSchema(db, s.alarms, s.events, s.search, s.idempotency, s.keys, s.tenants, s.audit, s.history); err != nil {
+		t.Fatalf("MigrateSchema failed: %v", err)
+	}
+	return s
+}
+
+func (s tenantStores) in(t *testing.T, id string) context.Context {
+	t.Helper()
+	tenant, err := s.tenants.Get(id)
+	if err != nil {
+		t.Fatalf("Get(%s) failed: %v", id, err)
+	}
+	return WithTenant(context.Background(), tenant)
+}
+
+func TestTenantStorage_Registry(t *testing.T) {
+	s := setupTenantStores(t)
+	if _, err := s.tenants.Create(Tenant{ID: "acme", Name: "Acme", MaxActiveAlarms: 5}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.tenants.Create(Tenant{ID: "acme", Name: "again"}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected ErrAlreadyExists, got %v", err)
+	}
+	list, err := s.tenants.List()
+	if err != nil || len(list) != 2 {
+		t.Fatalf("expected the default tenant and acme, got %+v, %v", list, err)
+	}
+	for _, tenant := range list {
+		if tenant.ID == "acme" && tenant.MaxActiveAlarms != 5 || tenant.Status != TenantActive {
+			t.Errorf("unexpected tenant %+v", tenant)
+		}
+	}
+	suspended, err := s.tenants.SetStatus("acme", TenantSuspended)
+	if err != nil || suspended.Status != TenantSuspended {
+		t.Errorf("expected acme to be suspended, got %+v, %v", suspended, err)
+	}
+	if _, err := s.tenants.SetStatus("nobody", TenantActive); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown tenant, got %v", err)
+	}
+	if err := s.tenants.Delete(DefaultTenant); !errors.Is(err, ErrDefaultTenant) {
+		t.Errorf("expected the default tenant to be kept, got %v", err)
+	}
+	if err := s.tenants.Delete("nobody"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown tenant, got %v", err)
+	}
+}
+
+func TestTenants_IsolateAlarmsAndEvents(t *testing.T) {
+	s := setupTenantStores(t)
+	s.tenants.Create(Tenant{ID: "acme", Name: "Acme"})
+	acme, other := s.in(t, "acme"), context.Background()
+	target := time.Now().Add(time.Hour)
+
+	if _, err := s.alarms.WithContext(acme).Create(datapkg.Alarm{ID: "a1", Name: "standup", Target: target, Labels: map[string]string{"team": "ops"}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.events.WithContext(acme).Create(datapkg.Event{ID: "e1", Name: "standup", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	alarms := s.alarms.WithContext(other)
+	if list, err := alarms.List(); err != nil || len(list) != 0 {
+		t.Errorf("expected the default tenant to see no alarms, got %v, %v", list, err)
+	}
+	if _, err := alarms.FindByID("a1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected another tenant's alarm to be missing, got %v", err)
+	}
+	if _, err := alarms.Update(datapkg.Alarm{ID: "a1", Name: "hijack", Target: target}, 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected another tenant's alarm to be missing on update, got %v", err)
+	}
+	if err := alarms.RemoveVersion("a1", 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected another tenant's alarm to be missing on delete, got %v", err)
+	}
+	if _, err := s.events.WithContext(other).FindByID("e1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected another tenant's event to be missing, got %v", err)
+	}
+	if err := alarms.Remove("a1"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if hits, err := s.search.WithContext(other).Search("standup", "", 10); err != nil || len(hits) != 0 {
+		t.Errorf("expected search to stay within the tenant, got %v, %v", hits, err)
+	}
+	results, err := s.batch.WithContext(other).Execute([]BatchOperation{{Op: BatchDelete, Type: BatchEvent, ID: "e1", Version: 1}}, false)
+	if err != nil || !errors.Is(results[0].Err, sql.ErrNoRows) {
+		t.Errorf("expected a batch to stay within the tenant, got %+v, %v", results, err)
+	}
+
+	raw, err := s.alarms.WithContext(acme).FindByID("a1")
+	if err != nil || raw.(datapkg.Alarm).Labels["team"] != "ops" || raw.(datapkg.Alarm).Tenant != "acme" {
+		t.Errorf("expected acme's alarm to be untouched, got %+v, %v", raw, err)
+	}
+	if hits, err := s.search.WithContext(acme).Search("standup", "", 10); err != nil || len(hits) != 2 {
+		t.Errorf("expected acme to find both resources, got %v, %v", hits, err)
+	}
+}
+
+func TestTenants_ScopeIDsToTheTenant(t *testing.T) {
+	s := setupTenantStores(t)
+	s.tenants.Create(Tenant{ID: "acme", Name: "Acme"})
+	acme, other := s.in(t, "acme"), context.Background()
+	past := time.Now().Add(-time.Minute)
+
+	if _, err := s.alarms.WithContext(acme).Create(datapkg.Alarm{ID: "a1", Name: "standup", Target: past,
+		Labels: map[string]string{"team": "ops"}, Viewers: []string{}, Editors: []string{"group:ops"}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.alarms.WithContext(other).Create(datapkg.Alarm{ID: "a1", Name: "retro", Target: past,
+		Labels: map[string]string{"team": "dev"}}); err != nil {
+		t.Fatalf("expected another tenant to reuse the ID, got %v", err)
+	}
+	if _, err := s.alarms.WithContext(other).Create(datapkg.Alarm{ID: "a1", Name: "again", Target: past}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected IDs to stay unique within a tenant, got %v", err)
+	}
+	if _, err := s.events.WithContext(acme).Create(datapkg.Event{ID: "e1", Name: "launch", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.events.WithContext(other).Create(datapkg.Event{ID: "e1", Name: "outage", StartedAt: time.Now()}); err != nil {
+		t.Fatalf("expected another tenant to reuse the ID, got %v", err)
+	}
+	if fired, err := s.alarms.FireDue(time.Now()); err != nil || len(fired) != 2 {
+		t.Fatalf("expected both alarms to fire, got %+v, %v", fired, err)
+	}
+	if _, err := s.history.Rebuild(); err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+	if err := s.alarms.WithContext(other).Remove("a1"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	raw, err := s.alarms.WithContext(acme).FindByID("a1")
+	if err != nil {
+		t.Fatalf("expected acme's alarm to survive, got %v", err)
+	}
+	if a := raw.(datapkg.Alarm); a.Name != "standup" || a.Labels["team"] != "ops" || !reflect.DeepEqual(a.Editors, []string{"group:ops"}) {
+		t.Errorf("expected acme's alarm with its own labels and ACL, got %+v", a)
+	}
+	if c, _ := s.alarms.CountStates(time.Now()); c.Fired != 1 {
+		t.Errorf("expected acme's alarm to stay fired, got %+v", c)
+	}
+	raw, err = s.events.WithContext(other).FindByID("e1")
+	if err != nil || raw.(datapkg.Event).Name != "outage" {
+		t.Errorf("expected the default tenant's own event, got %+v, %v", raw, err)
+	}
+	if hits, err := s.search.WithContext(acme).Search("retro", "", 10); err != nil || len(hits) != 0 {
+		t.Errorf("expected search to skip the other tenant's resources, got %+v, %v", hits, err)
+	}
+	if hits, err := s.search.WithContext(other).Search("outage", "", 10); err != nil || len(hits) != 1 {
+		t.Errorf("expected the default tenant to find its event, got %+v, %v", hits, err)
+	}
+}
+
+func TestTenants_QuotaCountsActiveAlarms(t *testing.T) {
+	s := setupTenantStores(t)
+	s.tenants.Create(Tenant{ID: "small", Name: "Small", MaxActiveAlarms: 1})
+	alarms := s.alarms.WithContext(s.in(t, "small"))
+	soon := time.Now().Add(time.Minute)
+	if _, err := alarms.Create(datapkg.Alarm{ID: "a1", Name: "one", Target: soon}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := alarms.Create(datapkg.Alarm{ID: "a2", Name: "two", Target: soon}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+	results, err := s.batch.WithContext(s.in(t, "small")).Execute([]BatchOperation{
+		{Op: BatchCreate, Type: BatchAlarm, Resource: datapkg.Alarm{ID: "a3", Name: "three", Target: soon}},
+	}, false)
+	if err != nil || !errors.Is(results[0].Err, ErrQuotaExceeded) {
+		t.Errorf("expected batches to respect the quota, got %+v, %v", results, err)
+	}
+	// a fired alarm no longer counts
+	if _, err := alarms.FireDue(soon.Add(time.Second)); err != nil {
+		t.Fatalf("FireDue failed: %v", err)
+	}
+	if _, err := alarms.Create(datapkg.Alarm{ID: "a2", Name: "two", Target: soon.Add(time.Hour)}); err != nil {
+		t.Errorf("expected room after the first alarm fired, got %v", err)
+	}
+}
+
+func TestTenantStorage_DeleteRemovesData(t *testing.T) {
+	s := setupTenantStores(t)
+	s.tenants.Create(Tenant{ID: "gone", Name: "Gone"})
+	gone := s.in(t, "gone")
+	s.alarms.WithContext(gone).Create(datapkg.Alarm{ID: "a1", Name: "x", Target: time.Now().Add(time.Hour), Labels: map[string]string{"k": "v"}})
+	s.events.WithContext(gone).Create(datapkg.Event{ID: "e1", Name: "x", StartedAt: time.Now()})
+	s.alarms.Create(datapkg.Alarm{ID: "kept", Name: "x", Target: time.Now().Add(time.Hour)})
+	s.keys.Create("bound", []string{ScopeAdmin}, "gone")
+	s.idempotency.Reserve("k1", IdempotencyScope("gone", "key:k", "POST /v1/alarms/create"), "h")
+	s.idempotency.Reserve("k1", IdempotencyScope("gone-too", "key:k", "POST /v1/alarms/create"), "h")
+
+	if err := s.tenants.Delete("gone"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	var rows int
+	s.alarms.DB.QueryRow(`SELECT (SELECT COUNT(*) FROM alarms) + (SELECT COUNT(*) FROM events) +
+		(SELECT COUNT(*) FROM api_keys) + (SELECT COUNT(*) FROM ` + alarmLabelsTable + `) +
+		(SELECT COUNT(*) FROM idempotency_keys)`).Scan(&rows)
+	if rows != 2 {
+		t.Errorf("expected only the default tenant's alarm and the other scope's key to remain, got %d rows", rows)
+	}
+	if _, err := s.tenants.Get("gone"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the tenant to be gone, got %v", err)
+	}
+}
+
+func TestTenantStorage_AllowedOrigins(t *testing.T) {
+	s := setupTenantStores(t)
+	created, err := s.tenants.Create(Tenant{ID: "acme", Name: "Acme", AllowedOrigins: []string{"https://dash.acme.example/"}})
+	if err != nil || len(created.AllowedOrigins) != 1 || created.AllowedOrigins[0] != "https://dash.acme.example" {
+		t.Fatalf("expected the origin to be stored without its slash, got %+v, %v", created, err)
+	}
+	if ok, err := s.tenants.AllowsOrigin("https://dash.acme.example"); err != nil || !ok {
+		t.Errorf("expected the origin to be allowed, got %v, %v", ok, err)
+	}
+	if ok, _ := s.tenants.AllowsOrigin("https://dash.acme"); ok {
+		t.Error("expected only whole origins to match")
+	}
+	s.tenants.SetStatus("acme", TenantSuspended)
+	if ok, _ := s.tenants.AllowsOrigin("https://dash.acme.example"); ok {
+		t.Error("expected a suspended tenant's origins to be refused")
+	}
+	updated, err := s.tenants.SetAllowedOrigins("acme", []string{"https://a.example", "https://b.example"})
+	if err != nil || len(updated.AllowedOrigins) != 2 {
+		t.Errorf("SetAllowedOrigins = %+v, %v", updated, err)
+	}
+	if _, err := s.tenants.SetAllowedOrigins("nobody", nil); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown tenant, got %v", err)
+	}
+	if err := ValidateOrigin("https://a.example/path"); err == nil {
+		t.Error("expected an origin with a path to be refused")
+	}
+}
+
+func TestTenantStorage_DeleteIsAuditedAndForgotten(t *testing.T) {
+	s := setupTenantStores(t)
+	s.tenants.Create(Tenant{ID: "gone", Name: "Gone"})
+	gone := s.in(t, "gone")
+	s.alarms.WithContext(gone).Create(datapkg.Alarm{ID: "a1", Name: "x", Target: time.Now().Add(time.Hour), Labels: map[string]string{"k": "v"}})
+	s.events.WithContext(gone).Create(datapkg.Event{ID: "e1", Name: "x", StartedAt: time.Now()})
+	s.alarms.Create(datapkg.Alarm{ID: "kept", Name: "x", Target: time.Now().Add(time.Hour)})
+	existed := time.Now()
+
+	admin := WithRequestID(WithActor(context.Background(), Actor{ID: "key:admin", Admin: true}), "req-1")
+	if err := s.tenants.WithContext(admin).Delete("gone"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	rows, err := s.audit.DB.Query("SELECT tenant, actor, request_id, resource_type, resource_id, changes FROM audit_log WHERE action = ? ORDER BY seq", AuditDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deleted []string
+	for rows.Next() {
+		var tenant, actor, requestID, resourceType, id, changes string
+		rows.Scan(&tenant, &actor, &requestID, &resourceType, &id, &changes)
+		if tenant != "gone" || actor != "key:admin" || requestID != "req-1" || !strings.Contains(changes, `"before"`) {
+			t.Errorf("unexpected entry for %s %s: %s %s %s %s", resourceType, id, tenant, actor, requestID, changes)
+		}
+		deleted = append(deleted, resourceType+" "+id)
+	}
+	rows.Close()
+	if strings.Join(deleted, ",") != "alarm a1,event e1" {
+		t.Errorf("expected an entry per removed resource, got %v", deleted)
+	}
+	if v, err := s.audit.Verify(); err != nil || !v.Valid {
+		t.Errorf("expected an intact chain, got %+v, %v", v, err)
+	}
+
+	if _, err := s.history.Rebuild(); err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+	var alarms, events int
+	s.alarms.DB.QueryRow("SELECT (SELECT COUNT(*) FROM alarms), (SELECT COUNT(*) FROM events)").Scan(&alarms, &events)
+	if alarms != 1 || events != 0 {
+		t.Errorf("expected the rebuild to keep the tenant's resources deleted, got %d alarms and %d events", alarms, events)
+	}
+	s.tenants.Create(Tenant{ID: "gone", Name: "Gone again"})
+	again := s.history.WithContext(s.in(t, "gone"))
+	if list, err := again.ListAsOf(AuditAlarm, Selector{}, existed); err != nil || len(list) != 0 {
+		t.Errorf("expected a recreated tenant not to inherit the old history, got %+v, %v", list, err)
+	}
+	if _, err := again.History(AuditEvent, "e1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the old event's history to be purged, got %v", err)
+	}
+	audit := s.audit.WithContext(s.in(t, "gone"))
+	if entries, err := audit.List(AuditFilter{}); err != nil || len(entries) != 0 {
+		t.Errorf("expected a recreated tenant not to read the old audit log, got %+v, %v", entries, err)
+	}
+	s.alarms.WithContext(s.in(t, "gone")).Create(datapkg.Alarm{ID: "a1", Name: "y", Target: time.Now().Add(time.Hour)})
+	if entries, err := audit.List(AuditFilter{}); err != nil || len(entries) != 1 || entries[0].Action != AuditCreate {
+		t.Errorf("expected only the recreated tenant's own entry, got %+v, %v", entries, err)
+	}
+}
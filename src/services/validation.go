@@ -0,0 +1,168 @@
+package services
+
+import (
+	datapkg "ClockAsService/src/data"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError describes why a single request field was rejected. Code is a
+// stable machine-readable identifier such as "target_in_past".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every field that failed validation
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (v *ValidationError) Error() string {
+	messages := make([]string, len(v.Errors))
+	for i, fe := range v.Errors {
+		messages[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add records a failed field
+func (v *ValidationError) Add(field, code, message string) {
+	v.Errors = append(v.Errors, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Err returns nil when nothing failed so callers can return it directly
+func (v *ValidationError) Err() error {
+	if len(v.Errors) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Field error codes shared by every transport
+const (
+	CodeRequired      = "required"
+	CodeTooLong       = "too_long"
+	CodeTargetInPast  = "target_in_past"
+	CodeTargetTooFar  = "target_too_far"
+	CodeInvalidID     = "invalid_id"
+	CodeInvalidLabels = "invalid_labels"
+	CodeInvalidACL    = "invalid_acl"
+)
+
+// ValidationRules bounds alarm and event payloads. Transports decode their
+// input into datapkg values and run them through the same rules so a request
+// is judged identically however it arrives.
+type ValidationRules struct {
+	MaxNameLength        int           // in characters
+	MaxDescriptionLength int           // in characters
+	MaxTargetHorizon     time.Duration // how far in the future an alarm may be set
+	MaxBodyBytes         int64         // request body cap for transports that read one
+}
+
+// DefaultValidationRules returns the limits used when nothing is configured
+func DefaultValidationRules() ValidationRules {
+	return ValidationRules{
+		MaxNameLength:        200,
+		MaxDescriptionLength: 2000,
+		MaxTargetHorizon:     10 * 365 * 24 * time.Hour,
+		MaxBodyBytes:         1 << 20,
+	}
+}
+
+// ValidateAlarm checks a new alarm. Name and target are required; the
+// description may be empty.
+func (r ValidationRules) ValidateAlarm(alarm datapkg.Alarm, now time.Time) error {
+	var verr ValidationError
+	r.checkIdentity(&verr, alarm.ID, alarm.Labels)
+	checkACL(&verr, alarm.Viewers, alarm.Editors)
+	if strings.TrimSpace(alarm.Name) == "" {
+		verr.Add("name", CodeRequired, "name is required")
+	}
+	if alarm.Target.IsZero() {
+		verr.Add("target", CodeRequired, "target is required")
+	}
+	r.checkAlarmFields(&verr, &alarm.Name, &alarm.Description, &alarm.Target, now)
+	return verr.Err()
+}
+
+// ValidateAlarmPatch checks the fields present in an alarm update; nil
+// fields are left unchanged and not checked
+func (r ValidationRules) ValidateAlarmPatch(name, description *string, target *time.Time, now time.Time) error {
+	var verr ValidationError
+	if name != nil && strings.TrimSpace(*name) == "" {
+		verr.Add("name", CodeRequired, "name must not be empty")
+	}
+	if target != nil && target.IsZero() {
+		verr.Add("target", CodeRequired, "target must not be empty")
+	}
+	r.checkAlarmFields(&verr, name, description, target, now)
+	return verr.Err()
+}
+
+// ValidateEvent checks a new event. Name is required.
+func (r ValidationRules) ValidateEvent(event datapkg.Event) error {
+	var verr ValidationError
+	r.checkIdentity(&verr, event.ID, event.Labels)
+	checkACL(&verr, event.Viewers, event.Editors)
+	if strings.TrimSpace(event.Name) == "" {
+		verr.Add("name", CodeRequired, "name is required")
+	}
+	r.checkText(&verr, &event.Name, &event.Description)
+	return verr.Err()
+}
+
+// ValidateEventPatch checks the fields present in an event update
+func (r ValidationRules) ValidateEventPatch(name, description *string) error {
+	var verr ValidationError
+	if name != nil && strings.TrimSpace(*name) == "" {
+		verr.Add("name", CodeRequired, "name must not be empty")
+	}
+	r.checkText(&verr, name, description)
+	return verr.Err()
+}
+
+func (r ValidationRules) checkIdentity(verr *ValidationError, id string, labels map[string]string) {
+	if id != "" {
+		if err := ValidateID(id); err != nil {
+			verr.Add("id", CodeInvalidID, err.Error())
+		}
+	}
+	if err := ValidateLabels(labels); err != nil {
+		verr.Add("labels", CodeInvalidLabels, err.Error())
+	}
+}
+
+func checkACL(verr *ValidationError, viewers, editors []string) {
+	if err := ValidateACL(viewers); err != nil {
+		verr.Add("acl.viewers", CodeInvalidACL, err.Error())
+	}
+	if err := ValidateACL(editors); err != nil {
+		verr.Add("acl.editors", CodeInvalidACL, err.Error())
+	}
+}
+
+func (r ValidationRules) checkAlarmFields(verr *ValidationError, name, description *string, target *time.Time, now time.Time) {
+	r.checkText(verr, name, description)
+	if target == nil || target.IsZero() {
+		return
+	}
+	switch {
+	case target.Before(now):
+		verr.Add("target", CodeTargetInPast, "Target must be in the future (in UTC)")
+	case r.MaxTargetHorizon > 0 && target.After(now.Add(r.MaxTargetHorizon)):
+		verr.Add("target", CodeTargetTooFar, fmt.Sprintf("target must be within %s of now", r.MaxTargetHorizon))
+	}
+}
+
+func (r ValidationRules) checkText(verr *ValidationError, name, description *string) {
+	if name != nil && r.MaxNameLength > 0 && utf8.RuneCountInString(*name) > r.MaxNameLength {
+		verr.Add("name", CodeTooLong, fmt.Sprintf("name must be at most %d characters", r.MaxNameLength))
+	}
+	if description != nil && r.MaxDescriptionLength > 0 && utf8.RuneCountInString(*description) > r.MaxDescriptionLength {
+		verr.Add("description", CodeTooLong, fmt.Sprintf("description must be at most %d characters", r.MaxDescriptionLength))
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func fieldCodes(t *testing.T, err error) map[string]string {
+	t.Helper()
+	codes := map[string]string{}
+	if err == nil {
+		return codes
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *ValidationError, got %T", err)
+	}
+	for _, fe := range verr.Errors {
+		codes[fe.Field] = fe.Code
+	}
+	return codes
+}
+
+func TestValidateAlarm(t *testing.T) {
+	rules := DefaultValidationRules()
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		alarm    datapkg.Alarm
+		expected map[string]string
+	}{
+		{
+			name:     "valid",
+			alarm:    datapkg.Alarm{Name: "a", Target: now.Add(time.Hour)},
+			expected: map[string]string{},
+		},
+		{
+			name:     "missing name and target",
+			alarm:    datapkg.Alarm{Name: "  "},
+			expected: map[string]string{"name": CodeRequired, "target": CodeRequired},
+		},
+		{
+			name:     "past target",
+			alarm:    datapkg.Alarm{Name: "a", Target: now.Add(-time.Second)},
+			expected: map[string]string{"target": CodeTargetInPast},
+		},
+		{
+			name:     "beyond horizon",
+			alarm:    datapkg.Alarm{Name: "a", Target: now.Add(rules.MaxTargetHorizon + time.Hour)},
+			expected: map[string]string{"target": CodeTargetTooFar},
+		},
+		{
+			name: "every violation at once",
+			alarm: datapkg.Alarm{
+				ID:          "-bad",
+				Name:        strings.Repeat("n", rules.MaxNameLength+1),
+				Description: strings.Repeat("d", rules.MaxDescriptionLength+1),
+				Target:      now.Add(-time.Hour),
+				Labels:      map[string]string{"": "x"},
+			},
+			expected: map[string]string{
+				"id": CodeInvalidID, "name": CodeTooLong, "description": CodeTooLong,
+				"target": CodeTargetInPast, "labels": CodeInvalidLabels,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fieldCodes(t, rules.ValidateAlarm(tt.alarm, now))
+			if len(got) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+			for field, code := range tt.expected {
+				if got[field] != code {
+					t.Errorf("field %s: expected %s, got %s", field, code, got[field])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateAlarm_LengthCountsCharacters(t *testing.T) {
+	rules := ValidationRules{MaxNameLength: 3}
+	now := time.Now()
+	alarm := datapkg.Alarm{Name: "äöü", Target: now.Add(time.Hour)}
+	if err := rules.ValidateAlarm(alarm, now); err != nil {
+		t.Fatalf("expected three characters to fit, got %v", err)
+	}
+}
+
+func TestValidateAlarmPatch_ChecksOnlyPresentFields(t *testing.T) {
+	rules := DefaultValidationRules()
+	now := time.Now()
+	if err := rules.ValidateAlarmPatch(nil, nil, nil, now); err != nil {
+		t.Fatalf("expected an empty patch to pass, got %v", err)
+	}
+	empty := ""
+	past := now.Add(-time.Hour)
+	got := fieldCodes(t, rules.ValidateAlarmPatch(&empty, nil, &past, now))
+	if got["name"] != CodeRequired || got["target"] != CodeTargetInPast {
+		t.Fatalf("unexpected field errors %v", got)
+	}
+}
+
+func TestValidateEvent(t *testing.T) {
+	rules := DefaultValidationRules()
+	if err := rules.ValidateEvent(datapkg.Event{Name: "standup"}); err != nil {
+		t.Fatalf("expected valid event, got %v", err)
+	}
+	got := fieldCodes(t, rules.ValidateEvent(datapkg.Event{
+		Description: strings.Repeat("d", rules.MaxDescriptionLength+1),
+	}))
+	if got["name"] != CodeRequired || got["description"] != CodeTooLong {
+		t.Fatalf("unexpected field errors %v", got)
+	}
+}
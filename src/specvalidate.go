@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"ClockAsService/src/services"
+)
+
+// withSpecValidation checks every request against the served OpenAPI
+// document before it reaches a handler: the method must be declared, query
+// and header parameters must be present and well typed, and JSON bodies must
+// match the request schema. Paths outside the document pass through.
+func withSpecValidation(next http.Handler) http.Handler {
+	var once sync.Once
+	var v *specValidator
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { v = newSpecValidator(openAPISpec()) })
+		v.serve(w, r, next)
+	})
+}
+
+// specValidator walks the JSON form of the document, so it sees exactly
+// what clients are given
+type specValidator struct {
+	paths    map[string]interface{}
+	schemas  map[string]interface{}
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+func newSpecValidator(spec []byte) *specValidator {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		panic(err)
+	}
+	components, _ := doc["components"].(map[string]interface{})
+	schemas, _ := components["schemas"].(map[string]interface{})
+	paths, _ := doc["paths"].(map[string]interface{})
+	return &specValidator{paths: paths, schemas: schemas, patterns: map[string]*regexp.Regexp{}}
+}
+
+func (v *specValidator) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	item, ok := v.paths[r.URL.Path].(map[string]interface{})
+	if !ok {
+		next.ServeHTTP(w, r)
+		return
+	}
+	op, ok := item[strings.ToLower(r.Method)].(map[string]interface{})
+	if !ok {
+		var allowed []string
+		for method := range item {
+			allowed = append(allowed, strings.ToUpper(method))
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeProblem(w, r, codeMethodNotAllowed, "")
+		return
+	}
+
+	var verr services.ValidationError
+	params, _ := op["parameters"].([]interface{})
+	for _, raw := range params {
+		v.checkParam(&verr, r, raw.(map[string]interface{}))
+	}
+	if err := verr.Err(); err != nil {
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	if schema := requestSchema(op); schema != nil {
+		body, ok := v.readBody(w, r)
+		if !ok {
+			return
+		}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			writeDecodeProblem(w, r, err)
+			return
+		}
+		if dec.More() {
+			writeDecodeProblem(w, r, errTrailingData)
+			return
+		}
+		v.validate(&verr, "", schema, doc)
+		if err := verr.Err(); err != nil {
+			writeValidationProblem(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	next.ServeHTTP(w, r)
+}
+
+func requestSchema(op map[string]interface{}) map[string]interface{} {
+	body, _ := op["requestBody"].(map[string]interface{})
+	content, _ := body["content"].(map[string]interface{})
+	media, _ := content["application/json"].(map[string]interface{})
+	schema, _ := media["schema"].(map[string]interface{})
+	return schema
+}
+
+// readBody applies the same Content-Type and size checks as decodeJSON
+func (v *specValidator) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeProblem(w, r, codeUnsupportedMediaType, "Content-Type must be application/json")
+		return nil, false
+	}
+	if validationRules.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, validationRules.MaxBodyBytes)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeDecodeProblem(w, r, err)
+		return nil, false
+	}
+	return body, true
+}
+
+func (v *specValidator) checkParam(verr *services.ValidationError, r *http.Request, p map[string]interface{}) {
+	name, _ := p["name"].(string)
+	required, _ := p["required"].(bool)
+	var value string
+	var present bool
+	switch p["in"] {
+	case "query":
+		var values []string
+		values, present = r.URL.Query()[name]
+		if present {
+			value = values[0]
+		}
+	case "header":
+		value = r.Header.Get(name)
+		present = value != ""
+	}
+	if !present {
+		if required {
+			verr.Add(name, services.CodeRequired, name+" is required")
+		}
+		return
+	}
+	schema, _ := p["schema"].(map[string]interface{})
+	if schema["type"] == "integer" {
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			verr.Add(name, codeInvalidParameter, "must be an integer")
+			return
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !inEnum(enum, value) {
+		verr.Add(name, codeInvalidParameter, "must be one of "+enumList(enum))
+	}
+}
+
+// validate checks value against the subset of JSON Schema the generator emits
+func (v *specValidator) validate(verr *services.ValidationError, field string, schema map[string]interface{}, value interface{}) {
+	if ref, ok := schema["$ref"].(string); ok {
+		schema, _ = v.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+	}
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			v.validate(verr, field, sub.(map[string]interface{}), value)
+		}
+		return
+	}
+	kind, _ := schema["type"].(string)
+	if kind == "" {
+		return
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable {
+			verr.Add(field, codeInvalidFieldType, "must not be null")
+		}
+		return
+	}
+	switch kind {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			verr.Add(field, codeInvalidFieldType, "expected object")
+			return
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				verr.Add(joinField(field, name.(string)), services.CodeRequired, name.(string)+" is required")
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if prop, ok := properties[key].(map[string]interface{}); ok {
+				v.validate(verr, joinField(field, key), prop, obj[key])
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					verr.Add(joinField(field, key), codeUnknownField, "unknown field")
+				}
+			case map[string]interface{}:
+				v.validate(verr, joinField(field, key), extra, obj[key])
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			verr.Add(field, codeInvalidFieldType, "expected array")
+			return
+		}
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		for i, item := range items {
+			v.validate(verr, fmt.Sprintf("%s[%d]", field, i), itemSchema, item)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			verr.Add(field, codeInvalidFieldType, "expected string")
+			return
+		}
+		v.validateString(verr, field, schema, s)
+	case "integer":
+		n, ok := value.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			verr.Add(field, codeInvalidFieldType, "expected integer")
+		}
+	case "number":
+		n, ok := value.(json.Number)
+		if _, err := n.Float64(); !ok || err != nil {
+			verr.Add(field, codeInvalidFieldType, "expected number")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			verr.Add(field, codeInvalidFieldType, "expected boolean")
+		}
+	}
+}
+
+func (v *specValidator) validateString(verr *services.ValidationError, field string, schema map[string]interface{}, s string) {
+	if schema["format"] == "date-time" {
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			verr.Add(field, codeInvalidTimeFormat, "expected an RFC 3339 timestamp")
+		}
+		return
+	}
+	length := utf8.RuneCountInString(s)
+	if min, ok := schema["minLength"].(float64); ok && length < int(min) {
+		verr.Add(field, services.CodeRequired, field+" must not be empty")
+	}
+	if max, ok := schema["maxLength"].(float64); ok && length > int(max) {
+		verr.Add(field, services.CodeTooLong, fmt.Sprintf("%s must be at most %d characters", field, int(max)))
+	}
+	if pattern, ok := schema["pattern"].(string); ok && !v.pattern(pattern).MatchString(s) {
+		code, _ := schema["x-error-code"].(string)
+		if code == "" {
+			code = codeValidationFailed
+		}
+		verr.Add(field, code, "must match "+pattern)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !inEnum(enum, s) {
+		verr.Add(field, codeValidationFailed, "must be one of "+enumList(enum))
+	}
+}
+
+func (v *specValidator) pattern(expr string) *regexp.Regexp {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	re, ok := v.patterns[expr]
+	if !ok {
+		re = regexp.MustCompile(expr)
+		v.patterns[expr] = re
+	}
+	return re
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func inEnum(enum []interface{}, value string) bool {
+	for _, e := range enum {
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, e := range enum {
+		values[i] = fmt.Sprint(e)
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"ClockAsService/src/services"
+)
+
+// tenantStore holds the tenants that alarms and events are isolated in
+var tenantStore *services.TenantStorage
+
+// tenantHeader names the tenant a request acts in
+const tenantHeader = "X-Tenant"
+
+// TenantRequest is the body of POST /admin/tenants
+type TenantRequest struct {
+	ID                string   `json:"id" openapi:"required,rule=id"`
+	Name              string   `json:"name" doc:"Defaults to the ID"`
+	MaxActiveAlarms   int      `json:"max_active_alarms" doc:"Alarms that have not fired yet; 0 is unlimited"`
+	RequestsPerMinute int      `json:"requests_per_minute" doc:"Requests accepted per minute across the tenant's callers; 0 is unlimited"`
+	AllowedOrigins    []string `json:"allowed_origins" doc:"Browser origins allowed by CORS to call the tenant"`
+}
+
+// TenantOriginsRequest is the body of PUT /admin/tenants/cors
+type TenantOriginsRequest struct {
+	AllowedOrigins []string `json:"allowed_origins" doc:"Browser origins allowed by CORS to call the tenant; replaces the current list"`
+}
+
+// validateTenantOrigins checks origins a tenant allows. Unlike
+// cors.allowed_origins they cannot be *.
+func validateTenantOrigins(verr *services.ValidationError, origins []string) {
+	for _, origin := range origins {
+		if err := services.ValidateOrigin(origin); err != nil {
+			verr.Add("allowed_origins", codeValidationFailed, err.Error())
+		}
+	}
+}
+
+var tenantParam = param{
+	Name: tenantHeader, In: "header",
+	Description: "Tenant to act in. Callers bound to a tenant may only name their own; others need the admin scope. Defaults to the caller's tenant, or " + services.DefaultTenant,
+}
+
+// tenantErrors can be returned by every operation that acts in a tenant
+var tenantErrors = []string{codeTenantForbidden, codeTenantNotFound, codeTenantSuspended, codeOriginForbidden}
+
+// withTenant resolves the tenant of every request to a non-public route,
+// rejects suspended tenants and confines the storage used by the handlers
+// to it. It runs after withAuth, which establishes the caller.
+func withTenant(mux *http.ServeMux, rts []route, next http.Handler) http.Handler {
+	byPath := map[string]route{}
+	for _, rt := range rts {
+		byPath[rt.Path] = rt
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt, known := byPath[routeOf(mux, r)]
+		if known && rt.Public {
+			next.ServeHTTP(w, r)
+			return
+		}
+		caller, _ := principalOf(r)
+		if known && rt.Global {
+			if caller.Tenant != "" {
+				writeProblem(w, r, codeTenantForbidden, "callers bound to a tenant cannot use service-wide routes")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := caller.Tenant
+		switch requested := r.Header.Get(tenantHeader); {
+		case requested == "" || requested == id:
+		case id != "":
+			writeProblem(w, r, codeTenantForbidden, "caller is bound to tenant "+id)
+			return
+		case !authEnabled || caller.has(services.ScopeAdmin):
+			id = requested
+		default:
+			writeProblem(w, r, codeTenantForbidden, "naming a tenant requires the admin scope or a key bound to it")
+			return
+		}
+		if id == "" {
+			id = services.DefaultTenant
+		}
+		tenant, err := tenantStore.Get(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeProblem(w, r, codeTenantNotFound, "no tenant with id "+id)
+			return
+		}
+		if err != nil {
+			writeStorageProblem(w, r, err, "")
+			return
+		}
+		if tenant.Status == services.TenantSuspended {
+			writeProblem(w, r, codeTenantSuspended, "tenant "+id+" is suspended")
+			return
+		}
+		// an origin only some tenants allow may only call those tenants
+		if origin := services.NormalizeOrigin(r.Header.Get("Origin")); corsGrantOf(r) == corsTenant &&
+			!containsString(tenant.AllowedOrigins, origin) {
+			writeProblem(w, r, codeOriginForbidden, "origin "+origin+" may not call tenant "+id)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(services.WithTenant(r.Context(), tenant)))
+	})
+}
+
+// tenantRoutes are the admin endpoints that manage tenants
+func tenantRoutes() []route {
+	idParam := param{Name: "id", In: "query", Required: true, Description: "Tenant ID"}
+	statusOp := func(summary string) []operation {
+		return []operation{{
+			Method:   http.MethodPost,
+			Summary:  summary,
+			Scopes:   []string{services.ScopeAdmin},
+			Params:   []param{idParam},
+			Status:   http.StatusOK,
+			Response: services.Tenant{},
+			Errors:   []string{codeTenantNotFound, codeValidationFailed, codeStorageUnavailable},
+		}}
+	}
+	return []route{
+		{Path: "/admin/tenants", Handler: tenantsHandler, Global: true, Ops: []operation{
+			{
+				Method:   http.MethodGet,
+				Summary:  "List tenants",
+				Scopes:   []string{services.ScopeAdmin},
+				Status:   http.StatusOK,
+				Response: []services.Tenant{},
+				Errors:   []string{codeStorageUnavailable},
+			},
+			{
+				Method:   http.MethodPost,
+				Summary:  "Create a tenant",
+				Scopes:   []string{services.ScopeAdmin},
+				Request:  TenantRequest{},
+				Status:   http.StatusCreated,
+				Response: services.Tenant{},
+				Errors:   append([]string{codeInvalidID, codeAlreadyExists, codeStorageUnavailable}, bodyErrors...),
+			},
+		}},
+		{Path: "/admin/tenants/suspend", Handler: tenantStatusHandler(services.TenantSuspended), Global: true,
+			Ops: statusOp("Suspend a tenant; its callers are refused until it is resumed")},
+		{Path: "/admin/tenants/resume", Handler: tenantStatusHandler(services.TenantActive), Global: true,
+			Ops: statusOp("Resume a suspended tenant")},
+		{Path: "/admin/tenants/cors", Handler: tenantOriginsHandler, Global: true, Ops: []operation{{
+			Method:   http.MethodPut,
+			Summary:  "Replace the browser origins allowed to call a tenant",
+			Scopes:   []string{services.ScopeAdmin},
+			Params:   []param{idParam},
+			Request:  TenantOriginsRequest{},
+			Status:   http.StatusOK,
+			Response: services.Tenant{},
+			Errors:   append([]string{codeTenantNotFound, codeStorageUnavailable}, bodyErrors...),
+		}}},
+		{Path: "/admin/tenants/delete", Handler: deleteTenantHandler, Global: true, Ops: []operation{{
+			Method:      http.MethodDelete,
+			Summary:     "Delete a tenant",
+			Description: "Deletes every alarm, event, API key and idempotent response of the tenant. The default tenant cannot be deleted.",
+			Scopes:      []string{services.ScopeAdmin},
+			Params:      []param{idParam},
+			Status:      http.StatusNoContent,
+			Errors:      []string{codeTenantNotFound, codeValidationFailed, codeStorageUnavailable},
+		}}},
+	}
+}
+
+func tenantsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tenants, err := tenantStore.List()
+		if err != nil {
+			writeStorageProblem(w, r, err, "Failed to list tenants")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tenants)
+		return
+	}
+	var req TenantRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var verr services.ValidationError
+	if err := services.ValidateID(req.ID); err != nil {
+		verr.Add("id", codeInvalidID, err.Error())
+	}
+	if req.MaxActiveAlarms < 0 {
+		verr.Add("max_active_alarms", codeValidationFailed, "must not be negative")
+	}
+	if req.RequestsPerMinute < 0 {
+		verr.Add("requests_per_minute", codeValidationFailed, "must not be negative")
+	}
+	validateTenantOrigins(&verr, req.AllowedOrigins)
+	if err := verr.Err(); err != nil {
+		writeValidationProblem(w, r, err)
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		req.Name = req.ID
+	}
+	tenant, err := tenantStore.Create(services.Tenant{
+		ID: req.ID, Name: req.Name, MaxActiveAlarms: req.MaxActiveAlarms, RequestsPerMinute: req.RequestsPerMinute,
+		AllowedOrigins: req.AllowedOrigins,
+	})
+	if errors.Is(err, services.ErrAlreadyExists) {
+		writeProblem(w, r, codeAlreadyExists, "A tenant with id "+req.ID+" already exists")
+		return
+	}
+	if err != nil {
+		writeStorageProblem(w, r, err, "Failed to create tenant")
+		return
+	}
+	requestLogger(r).Info("tenant created", "tenant", tenant.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tenant)
+}
+
+// tenantStatusHandler suspends or resumes the tenant named by ?id=
+func tenantStatusHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeProblem(w, r, codeValidationFailed, "id is required")
+			return
+		}
+		tenant, err := tenantStore.SetStatus(id, status)
+		if err != nil {
+			writeLookupProblem(w, r, err, codeTenantNotFound)
+			return
+		}
+		requestLogger(r).Warn("tenant status changed", "tenant", id, "status", status)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tenant)
+	}
+}
+
+// tenantOriginsHandler replaces the CORS origins of the tenant named by ?id=
+func tenantOriginsHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeProblem(w, r, codeValidationFailed, "id is required")
+		return
+	}
+	var req TenantOriginsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var verr services.ValidationError
+	validateTenantOrigins(&verr, req.AllowedOrigins)
+	if err := verr.Err(); err != nil {
+		writeValidationProblem(w, r, err)
+		return
+	}
+	tenant, err := tenantStore.SetAllowedOrigins(id, req.AllowedOrigins)
+	if err != nil {
+		writeLookupProblem(w, r, err, codeTenantNotFound)
+		return
+	}
+	requestLogger(r).Warn("tenant origins changed", "tenant", id, "allowed_origins", tenant.AllowedOrigins)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tenant)
+}
+
+func deleteTenantHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeProblem(w, r, codeValidationFailed, "id is required")
+		return
+	}
+	err := tenantStore.WithContext(r.Context()).Delete(id)
+	if errors.Is(err, services.ErrDefaultTenant) {
+		writeProblem(w, r, codeValidationFailed, err.Error())
+		return
+	}
+	if err != nil {
+		writeLookupProblem(w, r, err, codeTenantNotFound)
+		return
+	}
+	limiter.forget(tenantBucket(id))
+	requestLogger(r).Warn("tenant deleted", "tenant", id)
+	w.WriteHeader(http.StatusNoContent)
+}
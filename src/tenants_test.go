@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/services"
+)
+
+// tenantRequest is authRequest acting in the tenant named by X-Tenant
+func tenantRequest(handler http.Handler, method, target, key, tenant, body string) int {
+	w := authRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenant != "" {
+			r.Header.Set(tenantHeader, tenant)
+		}
+		handler.ServeHTTP(w, r)
+	}), method, target, key, body)
+	return w.Code
+}
+
+// newTenant creates a tenant through the admin API with an admin key
+func newTenant(t *testing.T, handler http.Handler, admin, body string) {
+	t.Helper()
+	if w := authRequest(handler, "POST", "/admin/tenants", admin, body); w.Code != http.StatusCreated {
+		t.Fatalf("failed to create tenant: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func newTenantKey(t *testing.T, tenant string, scopes ...string) string {
+	t.Helper()
+	_, secret, err := apiKeyStore.Create("test", scopes, tenant)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	return secret
+}
+
+func TestTenants_IsolateCallers(t *testing.T) {
+	handler := enableAuth(t)
+	admin := newKey(t, services.ScopeAdmin)
+	newTenant(t, handler, admin, `{"id":"acme","name":"Acme"}`)
+	acme := newTenantKey(t, "acme", services.ScopeAlarmsRead, services.ScopeAlarmsWrite)
+	reader := newKey(t, services.ScopeAlarmsRead)
+
+	create := `{"id":"a1","name":"standup","target":"2030-01-01T09:00:00Z"}`
+	if w := authRequest(handler, "POST", "/v1/alarms/create", acme, create); w.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	if code := tenantRequest(handler, "GET", "/v1/alarms/countdown?id=a1", admin, "", ""); code != http.StatusNotFound {
+		t.Errorf("expected the default tenant not to see acme's alarm, got %d", code)
+	}
+	if code := tenantRequest(handler, "GET", "/v1/alarms/countdown?id=a1", admin, "acme", ""); code != http.StatusOK {
+		t.Errorf("expected an admin naming acme to see its alarm, got %d", code)
+	}
+	if code := tenantRequest(handler, "GET", "/v1/alarms/countdown?id=a1", acme, "acme", ""); code != http.StatusOK {
+		t.Errorf("expected a bound key to name its own tenant, got %d", code)
+	}
+
+	tests := []struct {
+		name, key, tenant, target string
+		want                      int
+	}{
+		{"bound key naming another tenant", acme, services.DefaultTenant, "/v1/alarms/list", http.StatusForbidden},
+		{"unbound key without admin", reader, "acme", "/v1/alarms/list", http.StatusForbidden},
+		{"unknown tenant", admin, "nobody", "/v1/alarms/list", http.StatusNotFound},
+		{"bound key on a service-wide route", newTenantKey(t, "acme", services.ScopeAdmin), "", "/metrics", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		if code := tenantRequest(handler, "GET", tc.target, tc.key, tc.tenant, ""); code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, code)
+		}
+	}
+}
+
+func TestTenants_SuspendResumeDelete(t *testing.T) {
+	handler := enableAuth(t)
+	admin := newKey(t, services.ScopeAdmin)
+	newTenant(t, handler, admin, `{"id":"acme"}`)
+	acme := newTenantKey(t, "acme", services.ScopeAlarmsRead, services.ScopeAlarmsWrite)
+	if w := authRequest(handler, "POST", "/v1/alarms/create", acme, `{"id":"a1","name":"standup","target":"2030-01-01T09:00:00Z"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := authRequest(handler, "POST", "/admin/tenants/suspend?id=acme", admin, ""); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"status":"suspended"`) {
+		t.Fatalf("suspend failed: %d %s", w.Code, w.Body.String())
+	}
+	w := authRequest(handler, "GET", "/v1/alarms/list", acme, "")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), codeTenantSuspended) {
+		t.Errorf("expected a suspended tenant to be refused, got %d %s", w.Code, w.Body.String())
+	}
+	authRequest(handler, "POST", "/admin/tenants/resume?id=acme", admin, "")
+	if w := authRequest(handler, "GET", "/v1/alarms/list", acme, ""); w.Code != http.StatusOK {
+		t.Errorf("expected a resumed tenant to be served, got %d", w.Code)
+	}
+
+	if w := authRequest(handler, "DELETE", "/admin/tenants/delete?id=default", admin, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected the default tenant to be kept, got %d", w.Code)
+	}
+	if w := authRequest(handler, "DELETE", "/admin/tenants/delete?id=acme", admin, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete failed: %d %s", w.Code, w.Body.String())
+	}
+	if w := authRequest(handler, "GET", "/v1/alarms/list", acme, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the tenant's keys to be deleted with it, got %d", w.Code)
+	}
+	if w := authRequest(handler, "POST", "/admin/tenants/suspend?id=acme", admin, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected a deleted tenant to be unknown, got %d", w.Code)
+	}
+	// the alarm removed with the tenant is in the audit log, chained
+	w = authRequest(handler, "GET", "/admin/audit/verify", admin, "")
+	var v services.AuditVerification
+	json.NewDecoder(w.Body).Decode(&v)
+	if w.Code != http.StatusOK || !v.Valid || v.Entries != 2 {
+		t.Errorf("expected the creation and the deletion in an intact chain, got %d %+v", w.Code, v)
+	}
+}
+
+func TestTenants_QuotaAndRateLimit(t *testing.T) {
+	handler := enableAuth(t)
+	admin := newKey(t, services.ScopeAdmin)
+	newTenant(t, handler, admin, `{"id":"small","max_active_alarms":1,"requests_per_minute":3}`)
+	key := newTenantKey(t, "small", services.ScopeAlarmsRead, services.ScopeAlarmsWrite)
+
+	if w := authRequest(handler, "POST", "/v1/alarms/create", key, `{"name":"one","target":"2030-01-01T09:00:00Z"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	w := authRequest(handler, "POST", "/v2/alarms/create", key, `{"name":"two","target":"2030-01-01T09:00:00Z"}`)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), codeQuotaExceeded) {
+		t.Errorf("expected the quota to stop a second alarm, got %d %s", w.Code, w.Body.String())
+	}
+	authRequest(handler, "GET", "/v1/alarms/list", key, "")
+	w = authRequest(handler, "GET", "/v1/alarms/list", key, "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "20" {
+		t.Errorf("expected the fourth request in a minute to be limited, got %d Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if code := tenantRequest(handler, "GET", "/v1/alarms/list", admin, "", ""); code == http.StatusTooManyRequests {
+		t.Error("expected other tenants to keep their own budget")
+	}
+}
+
+func TestTenants_IdempotencyKeysAreScopedToTheTenant(t *testing.T) {
+	handler := enableAuth(t)
+	admin := newKey(t, services.ScopeAdmin)
+	newTenant(t, handler, admin, `{"id":"acme"}`)
+	body := `{"name":"deploy"}`
+	create := func(key string) *json.Decoder {
+		req := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("Idempotency-Key", "same")
+			handler.ServeHTTP(w, r)
+		})
+		w := authRequest(req, "POST", "/v2/events/create", key, body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+		}
+		return json.NewDecoder(w.Body)
+	}
+	var first, second EventV2
+	create(admin).Decode(&first)
+	create(newTenantKey(t, "acme", services.ScopeEventsWrite)).Decode(&second)
+	if first.ID == second.ID {
+		t.Error("expected another tenant's response not to be replayed")
+	}
+}
+
+func TestJWT_TenantClaimBindsTheCaller(t *testing.T) {
+	handler, idp := enableJWT(t, config.JWT{})
+	newTenant(t, handler, newKey(t, services.ScopeAdmin), `{"id":"acme"}`)
+	token := idp.token(t, map[string]interface{}{"sub": "svc", "tenant": "acme", "scope": "events:write events:read"})
+
+	if w := authRequest(handler, "POST", "/v1/events/create", token, `{"id":"e1","name":"deploy"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+	if raw, err := eventStore.FindByID("e1"); err == nil {
+		t.Errorf("expected the event in acme, found it in the default tenant: %+v", raw)
+	}
+	if code := tenantRequest(handler, "GET", "/v1/events/list", token, services.DefaultTenant, ""); code != http.StatusForbidden {
+		t.Errorf("expected the token to be bound to acme, got %d", code)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/metrics"
+	"ClockAsService/src/services"
+)
+
+// certReloader serves the certificate, key and client CAs of tls.*. Every
+// tls.reload, on the next handshake, it compares the files' modification
+// times and sizes with those it loaded and reads them again if they
+// changed, so renewed certificates are served without a restart. Files
+// that fail to load are logged and the previous ones kept.
+type certReloader struct {
+	cfg config.TLS
+
+	mu      sync.Mutex
+	checked time.Time
+	stamp   string
+	current *tls.Config
+}
+
+// certs serves the certificates of the running server; nil without TLS
+var certs *certReloader
+
+var _ = metrics.Default.NewGaugeFunc("clock_tls_certificate_expiry_timestamp_seconds", "Unix time the served certificate expires.",
+	nil, func(emit func(float64, ...string)) error {
+		if certs != nil {
+			if leaf := certs.leaf(); leaf != nil {
+				emit(float64(leaf.NotAfter.Unix()))
+			}
+		}
+		return nil
+	})
+
+// newCertReloader loads the files of cfg, failing if any of them is
+// missing or invalid
+func newCertReloader(cfg config.TLS) (*certReloader, error) {
+	r := &certReloader{cfg: cfg}
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return nil, err
+	}
+	if r.current, err = r.load(); err != nil {
+		return nil, err
+	}
+	r.stamp, r.checked = stamp, time.Now()
+	return r, nil
+}
+
+// serverConfig is the TLS configuration to serve with; every handshake
+// gets the configuration of the current files
+func (r *certReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config(time.Now()), nil
+		},
+	}
+}
+
+// config returns the configuration in force at now, reloading the files
+// first if they are due for a check and changed
+func (r *certReloader) config(now time.Time) *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.checked) < r.cfg.Reload {
+		return r.current
+	}
+	r.checked = now
+	stamp, err := r.fileStamp()
+	if err != nil {
+		slog.Warn("TLS files unreadable; keeping the loaded certificate", "error", err)
+		return r.current
+	}
+	if stamp == r.stamp {
+		return r.current
+	}
+	loaded, err := r.load()
+	if err != nil {
+		slog.Warn("TLS reload failed; keeping the loaded certificate", "error", err)
+		return r.current
+	}
+	r.current, r.stamp = loaded, stamp
+	slog.Info("TLS certificate reloaded", "subject", loaded.Certificates[0].Leaf.Subject.String(),
+		"expires", loaded.Certificates[0].Leaf.NotAfter)
+	return r.current
+}
+
+// leaf is the served certificate
+func (r *certReloader) leaf() *x509.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current.Certificates[0].Leaf
+}
+
+// files are the files r serves
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCA != "" {
+		files = append(files, r.cfg.ClientCA)
+	}
+	return files
+}
+
+// fileStamp identifies the current version of the files
+func (r *certReloader) fileStamp() (string, error) {
+	var stamp strings.Builder
+	for _, name := range r.files() {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&stamp, "%s %d %d\n", name, info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp.String(), nil
+}
+
+// load reads the files into a server configuration
+func (r *certReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", r.cfg.CertFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("load %s: %w", r.cfg.CertFile, err)
+		}
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+	if r.cfg.ClientCA == "" {
+		return cfg, nil
+	}
+	bundle, err := os.ReadFile(r.cfg.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("load %s: no PEM certificates", r.cfg.ClientCA)
+	}
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if r.cfg.ClientAuth == "require" {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// clientCertificate is the client certificate of r, if it presented one
+// that chains to tls.client_ca_file
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certPrincipal maps a verified client certificate to a principal: its
+// subject's common name names the caller, its organizations are the
+// caller's groups and its organizational units that name scopes are
+// granted. The caller is not bound to a tenant.
+func certPrincipal(cert *x509.Certificate) (principal, error) {
+	name := cert.Subject.CommonName
+	if name == "" {
+		return principal{}, errors.New("client certificate has no common name")
+	}
+	p := principal{ID: "cert:" + name, Groups: cert.Subject.Organization}
+	for _, unit := range cert.Subject.OrganizationalUnit {
+		for _, scope := range services.Scopes {
+			if unit == scope && !p.has(scope) {
+				p.Scopes = append(p.Scopes, scope)
+			}
+		}
+	}
+	return p, nil
+}
+
+// redirectHandler sends plain HTTP requests to the same URL over HTTPS on
+// the port of listen
+func redirectHandler(listen string) http.Handler {
+	_, port, _ := net.SplitHostPort(listen)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := (&url.URL{Host: r.Host}).Hostname()
+		if host == "" {
+			writeProblem(w, r, codeInvalidParameter, "a Host header is required to redirect to HTTPS")
+			return
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// startRedirect serves redirectHandler on cfg.TLS.RedirectListen and
+// returns the step that stops it
+func startRedirect(cfg config.Config) (*shutdownStep, error) {
+	ln, err := net.Listen("tcp", cfg.TLS.RedirectListen)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{
+		Handler:           redirectHandler(cfg.Listen),
+		ReadTimeout:       cfg.Timeouts.Read,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader,
+		WriteTimeout:      cfg.Timeouts.Write,
+		IdleTimeout:       cfg.Timeouts.Idle,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTPS redirect stopped", "error", err)
+		}
+	}()
+	slog.Info("redirecting HTTP to HTTPS", "address", ln.Addr().String())
+	return &shutdownStep{"https redirect", srv.Shutdown}, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/services"
+)
+
+// testCert is a certificate generated for a test, with its PEM files
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// issueCert generates a certificate for subject signed by parent, or self
+// signed as a CA when parent is nil, and writes it to dir
+func issueCert(t *testing.T, dir string, subject pkix.Name, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	tc := &testCert{cert: cert, key: key,
+		certFile: filepath.Join(dir, subject.CommonName+".crt"), keyFile: filepath.Join(dir, subject.CommonName+".key")}
+	writePEM(t, tc.certFile, "CERTIFICATE", der)
+	writePEM(t, tc.keyFile, "EC PRIVATE KEY", keyDER)
+	return tc
+}
+
+func writePEM(t *testing.T, name, kind string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS serves handler over TLS configured by cfg and returns its URL
+func serveTLS(t *testing.T, cfg config.TLS, handler http.Handler) string {
+	t.Helper()
+	r, err := newCertReloader(cfg)
+	if err != nil {
+		t.Fatalf("newCertReloader failed: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// handshake failures are expected, so the server does not log them
+	srv := &http.Server{Handler: handler, ErrorLog: log.New(io.Discard, "", 0)}
+	go srv.Serve(tls.NewListener(ln, r.serverConfig()))
+	t.Cleanup(func() { srv.Close() })
+	return "https://" + ln.Addr().String()
+}
+
+// tlsClient trusts ca and presents client, if not nil
+func tlsClient(ca, client *testCert) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	cfg := &tls.Config{RootCAs: roots}
+	if client != nil {
+		cfg.Certificates = []tls.Certificate{{Certificate: [][]byte{client.cert.Raw}, PrivateKey: client.key}}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+}
+
+func TestCertReloader_ReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, dir, pkix.Name{CommonName: "ca"}, nil)
+	first := issueCert(t, dir, pkix.Name{CommonName: "clock"}, ca)
+	r, err := newCertReloader(config.TLS{CertFile: first.certFile, KeyFile: first.keyFile, Reload: time.Minute})
+	if err != nil {
+		t.Fatalf("newCertReloader failed: %v", err)
+	}
+	served := func(now time.Time) []byte { return r.config(now).Certificates[0].Certificate[0] }
+
+	second := issueCert(t, t.TempDir(), pkix.Name{CommonName: "clock"}, ca)
+	for _, f := range [][2]string{{second.certFile, first.certFile}, {second.keyFile, first.keyFile}} {
+		data, _ := os.ReadFile(f[0])
+		os.WriteFile(f[1], data, 0o600)
+		os.Chtimes(f[1], time.Now(), time.Now().Add(time.Second))
+	}
+	if !bytes.Equal(served(time.Now()), first.cert.Raw) {
+		t.Error("expected the files not to be checked before tls.reload passes")
+	}
+	if !bytes.Equal(served(time.Now().Add(time.Minute)), second.cert.Raw) {
+		t.Fatal("expected the changed certificate to be served")
+	}
+
+	os.WriteFile(first.certFile, []byte("not a certificate"), 0o600)
+	if !bytes.Equal(served(time.Now().Add(2*time.Minute)), second.cert.Raw) {
+		t.Error("expected a broken certificate file to keep the loaded certificate")
+	}
+	if _, err := newCertReloader(config.TLS{CertFile: first.certFile, KeyFile: first.keyFile}); err == nil {
+		t.Error("expected a broken certificate file to fail at startup")
+	}
+}
+
+func TestMutualTLS_MapsClientCertificates(t *testing.T) {
+	handler := enableAuth(t)
+	dir := t.TempDir()
+	ca := issueCert(t, dir, pkix.Name{CommonName: "ca"}, nil)
+	server := issueCert(t, dir, pkix.Name{CommonName: "clock"}, ca)
+	alice := issueCert(t, dir, pkix.Name{CommonName: "alice", Organization: []string{"ops"},
+		OrganizationalUnit: []string{services.ScopeAlarmsRead, services.ScopeAlarmsWrite, "engineering"}}, ca)
+	nobody := issueCert(t, dir, pkix.Name{CommonName: "nobody"}, ca)
+	strangerCA := issueCert(t, t.TempDir(), pkix.Name{CommonName: "ca"}, nil)
+	stranger := issueCert(t, dir, pkix.Name{CommonName: "mallory", OrganizationalUnit: []string{services.ScopeAdmin}}, strangerCA)
+
+	url := serveTLS(t, config.TLS{CertFile: server.certFile, KeyFile: server.keyFile, ClientCA: ca.certFile,
+		ClientAuth: "optional", Reload: time.Minute}, handler)
+	body := `{"id":"a1","name":"standup","target":"2030-01-01T09:00:00Z"}`
+	resp, err := tlsClient(ca, alice).Post(url+"/v1/alarms/create", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the certificate to authenticate alice, got %d", resp.StatusCode)
+	}
+	resp, err = tlsClient(ca, alice).Get(url + "/v1/alarms/acl?id=a1")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	var acl ACLResponse
+	json.NewDecoder(resp.Body).Decode(&acl)
+	resp.Body.Close()
+	if acl.Owner != "cert:alice" {
+		t.Errorf("expected alice to own the alarm, got %q", acl.Owner)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		client *testCert
+		want   int
+	}{
+		{"no certificate", nil, http.StatusUnauthorized},
+		{"no scopes", nobody, http.StatusForbidden},
+	} {
+		resp, err := tlsClient(ca, tc.client).Get(url + "/v1/alarms/list")
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tc.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, resp.StatusCode)
+		}
+	}
+	if _, err := tlsClient(ca, stranger).Get(url + "/v1/alarms/list"); err == nil {
+		t.Error("expected a certificate from another CA to be refused")
+	}
+
+	url = serveTLS(t, config.TLS{CertFile: server.certFile, KeyFile: server.keyFile, ClientCA: ca.certFile,
+		ClientAuth: "require", Reload: time.Minute}, handler)
+	if _, err := tlsClient(ca, nil).Get(url + "/healthz"); err == nil {
+		t.Error("expected a connection without a certificate to be refused when required")
+	}
+}
+
+func TestCertPrincipal(t *testing.T) {
+	p, err := certPrincipal(&x509.Certificate{Subject: pkix.Name{CommonName: "ci",
+		Organization: []string{"ops"}, OrganizationalUnit: []string{services.ScopeEventsWrite, "build", services.ScopeEventsWrite}}})
+	if err != nil || p.ID != "cert:ci" || len(p.Scopes) != 1 || len(p.Groups) != 1 || p.Tenant != "" {
+		t.Errorf("unexpected principal %+v, %v", p, err)
+	}
+	if _, err := certPrincipal(&x509.Certificate{}); err == nil {
+		t.Error("expected a certificate without a common name to be refused")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct{ listen, host, want string }{
+		{":8443", "clock.example:8081", "https://clock.example:8443/v1/alarms/list?limit=5"},
+		{":443", "clock.example", "https://clock.example/v1/alarms/list?limit=5"},
+		{":443", "[::1]:80", "https://[::1]/v1/alarms/list?limit=5"},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("POST", "/v1/alarms/list?limit=5", nil)
+		r.Host = tc.host
+		w := httptest.NewRecorder()
+		redirectHandler(tc.listen).ServeHTTP(w, r)
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tc.want {
+			t.Errorf("%s via %s: got %d %q, want %q", tc.host, tc.listen, w.Code, w.Header().Get("Location"), tc.want)
+		}
+	}
+}
+
+func TestRun_ReleasesTheRedirectWhenStartupFails(t *testing.T) {
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect := free.Addr().String()
+	free.Close()
+
+	cfg := config.Default()
+	cfg.Listen = "127.0.0.1:0"
+	cfg.Database = filepath.Join(t.TempDir(), "clock.db")
+	cfg.TLS.RedirectListen = redirect
+	cfg.Tracing.Exporter = "file"
+	cfg.Tracing.File = filepath.Join(t.TempDir(), "missing", "spans.json")
+	// twice, which also shows each run registers routes on its own mux
+	for i := 0; i < 2; i++ {
+		if err := run(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "start tracing") {
+			t.Fatalf("run %d: expected tracing to fail, got %v", i+1, err)
+		}
+		ln, err := net.Listen("tcp", redirect)
+		if err != nil {
+			t.Fatalf("run %d: expected the redirect address to be free, got %v", i+1, err)
+		}
+		ln.Close()
+	}
+}
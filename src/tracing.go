@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/tracing"
+)
+
+// serviceName identifies this service in exported traces
+const serviceName = "clock-service"
+
+// startTracing installs the exporter selected by cfg and returns the step
+// that flushes it at shutdown, or nil when tracing is disabled
+func startTracing(cfg config.Tracing) (*shutdownStep, error) {
+	var exporter tracing.Exporter
+	var file *os.File
+	switch cfg.Exporter {
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case "file":
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		file = f
+		exporter = tracing.NewWriterExporter(f)
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.OTLPEndpoint, serviceName, 10*time.Second)
+	default:
+		return nil, nil
+	}
+	p := tracing.NewProvider(exporter, 5*time.Second, 512)
+	p.OnError = func(err error) { slog.Warn("failed to export spans", "error", err) }
+	tracing.SetProvider(p)
+	slog.Info("tracing enabled", "exporter", cfg.Exporter)
+	return &shutdownStep{"tracing", func(ctx context.Context) error {
+		tracing.SetProvider(nil)
+		err := p.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}}, nil
+}
+
+// withTracing records a server span for each request, continuing the trace
+// of an incoming traceparent header. Handlers reach the span through the
+// request context.
+func withTracing(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tracing.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := routeOf(mux, r)
+		ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), r.Method+" "+route, tracing.KindServer,
+			tracing.Attr{Key: "http.request.method", Value: r.Method},
+			tracing.Attr{Key: "http.route", Value: route},
+			tracing.Attr{Key: "url.path", Value: r.URL.Path},
+			tracing.Attr{Key: "request_id", Value: requestID(r)},
+		)
+		defer span.End()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		span.SetAttr("http.response.status_code", sw.status)
+		if sw.status >= http.StatusInternalServerError {
+			span.SetError(httpError(sw.status))
+		}
+	})
+}
+
+// httpError describes a server error status as a span error
+type httpError int
+
+func (e httpError) Error() string {
+	return http.StatusText(int(e))
+}
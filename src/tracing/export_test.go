@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSpans() []SpanData {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	trace := TraceID{0x0a, 0xf7}
+	return []SpanData{
+		{
+			Name: "alarms.create", Kind: KindInternal,
+			Context: SpanContext{TraceID: trace, SpanID: SpanID{2}, Sampled: true}, Parent: SpanID{1},
+			Start: start, End: start.Add(1500 * time.Microsecond),
+			Attrs: []Attr{{"db.system", "sqlite"}, {"rows", 3}, {"cached", false}},
+			Error: true, Description: "database is locked",
+		},
+		{
+			Name: "POST /v1/alarms/create", Kind: KindServer,
+			Context: SpanContext{TraceID: trace, SpanID: SpanID{1}, Sampled: true},
+			Start:   start, End: start.Add(2 * time.Millisecond),
+		},
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriterExporter(&buf).Export(context.Background(), testSpans()); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a line per span, got %q", buf.String())
+	}
+	want := `{"trace_id":"0af70000000000000000000000000000","span_id":"0200000000000000","parent_span_id":"0100000000000000",` +
+		`"name":"alarms.create","kind":"internal","start":"2026-01-02T03:04:05Z","duration_ms":1.5,` +
+		`"attributes":{"cached":false,"db.system":"sqlite","rows":3},"error":"database is locked"}`
+	if lines[0] != want {
+		t.Errorf("unexpected line\n%s\nwant\n%s", lines[0], want)
+	}
+	if strings.Contains(lines[1], "parent_span_id") || !strings.Contains(lines[1], `"kind":"server"`) {
+		t.Errorf("unexpected root line %s", lines[1])
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s %s", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("body is not JSON: %s", body)
+		}
+	}))
+	defer collector.Close()
+
+	e := NewOTLPExporter(collector.URL+"/v1/traces", "clock-service", time.Second)
+	if err := e.Export(context.Background(), testSpans()); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	rs := got["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	resource, _ := json.Marshal(rs["resource"])
+	if string(resource) != `{"attributes":[{"key":"service.name","value":{"stringValue":"clock-service"}}]}` {
+		t.Errorf("unexpected resource %s", resource)
+	}
+	spans := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	first, _ := json.Marshal(spans[0])
+	want := `{"attributes":[{"key":"db.system","value":{"stringValue":"sqlite"}},{"key":"rows","value":{"intValue":"3"}},` +
+		`{"key":"cached","value":{"boolValue":false}}],"endTimeUnixNano":"1767323045001500000","kind":1,"name":"alarms.create",` +
+		`"parentSpanId":"0100000000000000","spanId":"0200000000000000","startTimeUnixNano":"1767323045000000000",` +
+		`"status":{"code":2,"message":"database is locked"},"traceId":"0af70000000000000000000000000000"}`
+	if string(first) != want {
+		t.Errorf("unexpected span\n%s\nwant\n%s", first, want)
+	}
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusServiceUnavailable)
+	}))
+	defer rejecting.Close()
+	err := NewOTLPExporter(rejecting.URL, "clock-service", time.Second).Export(context.Background(), testSpans())
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected the collector's status in the error, got %v", err)
+	}
+}
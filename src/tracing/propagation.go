@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader carries a span context in W3C Trace Context format
+const TraceparentHeader = "traceparent"
+
+// ParseTraceparent decodes a traceparent header value,
+// version-traceid-spanid-flags. Fields added by later versions are ignored;
+// IDs of all zeros are rejected.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	var version, flags [1]byte
+	for _, f := range []struct {
+		hex string
+		dst []byte
+	}{
+		{parts[0], version[:]},
+		{parts[1], sc.TraceID[:]},
+		{parts[2], sc.SpanID[:]},
+		{parts[3], flags[:]},
+	} {
+		if len(f.hex) != 2*len(f.dst) || strings.ToLower(f.hex) != f.hex {
+			return SpanContext{}, false
+		}
+		if _, err := hex.Decode(f.dst, []byte(f.hex)); err != nil {
+			return SpanContext{}, false
+		}
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.Valid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// Traceparent encodes sc as a traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Extract returns ctx with the traceparent of h as the remote parent. A
+// missing or malformed header leaves ctx unchanged, starting a new trace.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := ParseTraceparent(h.Get(TraceparentHeader)); ok {
+		return WithRemoteParent(ctx, sc)
+	}
+	return ctx
+}
+
+// Inject sets the traceparent of an outgoing request to the current span
+// in ctx, or passes on the remote parent when nothing was recorded
+func Inject(ctx context.Context, h http.Header) {
+	if sc := spanContextOf(ctx); sc.Valid() {
+		h.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
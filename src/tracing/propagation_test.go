@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true, true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", true, false},
+		{"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-future", true, true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", false, false},
+		{"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false, false},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01", false, false},
+		{"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-1", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.value)
+		if ok != tt.ok || sc.Sampled != tt.sampled {
+			t.Errorf("ParseTraceparent(%q) = %+v, %v", tt.value, sc, ok)
+		}
+		if ok && tt.value[:2] == "00" && sc.Traceparent() != tt.value {
+			t.Errorf("round trip of %q gave %q", tt.value, sc.Traceparent())
+		}
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	const parent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	in := http.Header{}
+	in.Set(TraceparentHeader, parent)
+	ctx := Extract(context.Background(), in)
+
+	// without a recorded span the remote parent is passed on unchanged
+	SetProvider(nil)
+	out := http.Header{}
+	Inject(ctx, out)
+	if out.Get(TraceparentHeader) != parent {
+		t.Errorf("expected the parent to be passed on, got %q", out.Get(TraceparentHeader))
+	}
+
+	install(t)
+	ctx, span := Start(ctx, "webhook", KindClient)
+	defer span.End()
+	out = http.Header{}
+	Inject(ctx, out)
+	sc, ok := ParseTraceparent(out.Get(TraceparentHeader))
+	if !ok || sc.TraceID != span.Context().TraceID || sc.SpanID != span.Context().SpanID {
+		t.Errorf("expected the client span in %q", out.Get(TraceparentHeader))
+	}
+
+	out = http.Header{}
+	Inject(context.Background(), out)
+	if len(out) != 0 {
+		t.Errorf("expected no header without a trace, got %v", out)
+	}
+}
@@ -0,0 +1,296 @@
+// Package tracing records spans compatible with OpenTelemetry and exports
+// them in batches. Until a Provider is installed with SetProvider, Start
+// returns a nil *Span whose methods do nothing, so instrumented code costs
+// next to nothing when tracing is disabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the part of a span that crosses process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Valid reports whether both IDs are set
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Kind is the role of a span, numbered as in OTLP
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attr is a span attribute. Value is a string, bool, int, int64 or float64.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is a finished span as handed to an Exporter
+type SpanData struct {
+	Name        string
+	Kind        Kind
+	Context     SpanContext
+	Parent      SpanID
+	Start, End  time.Time
+	Attrs       []Attr
+	Error       bool
+	Description string
+}
+
+// Span is a span being recorded. A nil *Span is valid and records nothing.
+type Span struct {
+	provider *Provider
+	mu       sync.Mutex
+	data     SpanData
+	ended    bool
+}
+
+// Context returns the span's IDs, or the zero SpanContext for a nil span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttr adds or replaces an attribute
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.data.Attrs {
+		if s.data.Attrs[i].Key == key {
+			s.data.Attrs[i].Value = value
+			return
+		}
+	}
+	s.data.Attrs = append(s.data.Attrs, Attr{key, value})
+}
+
+// SetError marks the span as failed with err's message
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Error = true
+	s.data.Description = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.provider.enqueue(data)
+}
+
+// Exporter sends finished spans to a backend
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Provider batches finished spans and hands them to an Exporter from a
+// background goroutine
+type Provider struct {
+	exporter  Exporter
+	interval  time.Duration
+	batchSize int
+	// OnError is called when an export fails; the batch is dropped
+	OnError func(error)
+
+	mu      sync.Mutex
+	pending []SpanData
+	flush   chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	stopped sync.Once
+}
+
+// NewProvider starts exporting spans every interval, or sooner once
+// batchSize spans are waiting
+func NewProvider(exporter Exporter, interval time.Duration, batchSize int) *Provider {
+	p := &Provider{
+		exporter:  exporter,
+		interval:  interval,
+		batchSize: batchSize,
+		flush:     make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *Provider) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		case <-p.flush:
+		}
+		p.export(context.Background())
+	}
+}
+
+func (p *Provider) enqueue(data SpanData) {
+	p.mu.Lock()
+	p.pending = append(p.pending, data)
+	full := len(p.pending) >= p.batchSize
+	p.mu.Unlock()
+	if full {
+		select {
+		case p.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *Provider) export(ctx context.Context) error {
+	p.mu.Lock()
+	batch := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	err := p.exporter.Export(ctx, batch)
+	if err != nil && p.OnError != nil {
+		p.OnError(err)
+	}
+	return err
+}
+
+// Shutdown stops the background export and exports the spans still
+// waiting, within ctx
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.stopped.Do(func() { close(p.stop) })
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.export(ctx)
+}
+
+// provider is the installed Provider, or nil when tracing is disabled
+var provider atomic.Pointer[Provider]
+
+// SetProvider installs p for Start; nil disables tracing
+func SetProvider(p *Provider) {
+	provider.Store(p)
+}
+
+// Enabled reports whether a Provider is installed
+func Enabled() bool {
+	return provider.Load() != nil
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// FromContext returns the span started by Start in ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// WithRemoteParent makes sc, received from another process, the parent of
+// the next span started from ctx
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// spanContextOf returns the context of the current span, or the remote
+// parent when no span has been started
+func spanContextOf(ctx context.Context) SpanContext {
+	if s := FromContext(ctx); s != nil {
+		return s.Context()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// Start begins a span that is a child of the span or remote parent in ctx.
+// It returns ctx unchanged and a nil span when tracing is disabled or the
+// remote parent was not sampled.
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	p := provider.Load()
+	if p == nil {
+		return ctx, nil
+	}
+	parent := spanContextOf(ctx)
+	if parent.Valid() && !parent.Sampled {
+		return ctx, nil
+	}
+	s := &Span{provider: p, data: SpanData{
+		Name:  name,
+		Kind:  kind,
+		Start: time.Now(),
+		Attrs: attrs,
+	}}
+	s.data.Context = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: true}
+	if parent.Valid() {
+		s.data.Parent = parent.SpanID
+	} else {
+		s.data.Context.TraceID = newTraceID()
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func newTraceID() (id TraceID) {
+	randomFill(id[:])
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	randomFill(id[:])
+	return id
+}
+
+func randomFill(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("tracing: no randomness: %v", err))
+	}
+}
@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder keeps exported spans in memory
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+	err   error
+}
+
+func (r *recorder) Export(_ context.Context, spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return r.err
+}
+
+func (r *recorder) exported() []SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SpanData(nil), r.spans...)
+}
+
+// install records spans until the test ends. Batches wait for Shutdown.
+func install(t *testing.T) (*Provider, *recorder) {
+	t.Helper()
+	rec := &recorder{}
+	p := NewProvider(rec, time.Hour, 1000)
+	SetProvider(p)
+	t.Cleanup(func() {
+		SetProvider(nil)
+		p.Shutdown(context.Background())
+	})
+	return p, rec
+}
+
+func TestStart_DisabledIsNoop(t *testing.T) {
+	SetProvider(nil)
+	ctx := context.Background()
+	got, span := Start(ctx, "op", KindInternal)
+	if got != ctx || span != nil || Enabled() {
+		t.Fatalf("expected no span and the same context, got %v", span)
+	}
+	span.SetAttr("k", "v")
+	span.SetError(errors.New("boom"))
+	span.End()
+	if span.Context().Valid() {
+		t.Error("a nil span has no context")
+	}
+}
+
+func TestStart_ParentsSpans(t *testing.T) {
+	p, rec := install(t)
+	ctx, root := Start(context.Background(), "request", KindServer, Attr{"route", "/a"})
+	_, child := Start(ctx, "query", KindInternal)
+	child.SetError(errors.New("database is locked"))
+	child.End()
+	root.SetAttr("status", 200)
+	root.SetAttr("status", 503)
+	root.End()
+	root.End()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	spans := rec.exported()
+	if len(spans) != 2 {
+		t.Fatalf("expected two spans, got %+v", spans)
+	}
+	q, r := spans[0], spans[1]
+	if q.Context.TraceID != r.Context.TraceID || q.Parent != r.Context.SpanID || r.Parent != (SpanID{}) {
+		t.Errorf("expected query to be a child of request: %+v %+v", q, r)
+	}
+	if !q.Error || q.Description != "database is locked" || r.Error {
+		t.Errorf("unexpected error status %+v %+v", q, r)
+	}
+	if len(r.Attrs) != 2 || r.Attrs[1] != (Attr{"status", 503}) || r.Kind != KindServer {
+		t.Errorf("unexpected attributes %+v", r.Attrs)
+	}
+	if r.End.Before(r.Start) {
+		t.Errorf("span ended before it started")
+	}
+}
+
+func TestStart_RemoteParent(t *testing.T) {
+	p, rec := install(t)
+	remote, _ := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	_, span := Start(WithRemoteParent(context.Background(), remote), "request", KindServer)
+	span.End()
+
+	unsampled := remote
+	unsampled.Sampled = false
+	ctx := WithRemoteParent(context.Background(), unsampled)
+	if got, span := Start(ctx, "request", KindServer); span != nil || got != ctx {
+		t.Error("expected no span under an unsampled parent")
+	}
+	p.Shutdown(context.Background())
+
+	spans := rec.exported()
+	if len(spans) != 1 || spans[0].Context.TraceID != remote.TraceID || spans[0].Parent != remote.SpanID {
+		t.Fatalf("expected one span continuing the remote trace, got %+v", spans)
+	}
+}
+
+func TestProvider_ExportsFullBatches(t *testing.T) {
+	rec := &recorder{err: errors.New("collector down")}
+	failures := make(chan error, 1)
+	p := NewProvider(rec, time.Hour, 2)
+	p.OnError = func(err error) { failures <- err }
+	SetProvider(p)
+	defer SetProvider(nil)
+	defer p.Shutdown(context.Background())
+
+	for i := 0; i < 2; i++ {
+		_, span := Start(context.Background(), "op", KindInternal)
+		span.End()
+	}
+	select {
+	case err := <-failures:
+		if err != rec.err {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a full batch was not exported")
+	}
+	if n := len(rec.exported()); n != 2 {
+		t.Errorf("expected two spans exported, got %d", n)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ClockAsService/src/config"
+	"ClockAsService/src/tracing"
+)
+
+// spanRecorder keeps exported spans in memory
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(_ context.Context, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// recordSpans enables tracing for the test. The returned function flushes
+// and returns the spans ended so far.
+func recordSpans(t *testing.T) func() []tracing.SpanData {
+	t.Helper()
+	rec := &spanRecorder{}
+	p := tracing.NewProvider(rec, time.Hour, 1000)
+	tracing.SetProvider(p)
+	t.Cleanup(func() { tracing.SetProvider(nil) })
+	return func() []tracing.SpanData {
+		tracing.SetProvider(nil)
+		p.Shutdown(context.Background())
+		return rec.spans
+	}
+}
+
+func attr(span tracing.SpanData, key string) interface{} {
+	for _, a := range span.Attrs {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+func TestWithTracing_ContinuesIncomingTrace(t *testing.T) {
+	setupHandlersForTest(t)
+	spans := recordSpans(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+
+	const parent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	req := httptest.NewRequest("GET", "/v1/alarms/countdown?id=missing", nil)
+	req.Header.Set("traceparent", parent)
+	req.Header.Set("X-Request-ID", "req-3")
+	withRequestID(withTracing(mux, mux)).ServeHTTP(httptest.NewRecorder(), req)
+
+	got := spans()
+	if len(got) != 2 {
+		t.Fatalf("expected a storage and a server span, got %+v", got)
+	}
+	query, server := got[0], got[1]
+	remote, _ := tracing.ParseTraceparent(parent)
+	if server.Name != "GET /v1/alarms/countdown" || server.Kind != tracing.KindServer ||
+		server.Context.TraceID != remote.TraceID || server.Parent != remote.SpanID {
+		t.Errorf("expected the server span to continue the trace, got %+v", server)
+	}
+	if attr(server, "http.response.status_code") != http.StatusNotFound || attr(server, "request_id") != "req-3" || server.Error {
+		t.Errorf("unexpected server attributes %+v", server.Attrs)
+	}
+	if query.Name != "alarms.find_by_id" || query.Parent != server.Context.SpanID {
+		t.Errorf("expected the query under the server span, got %+v", query)
+	}
+}
+
+func TestWithTracing_MarksServerErrors(t *testing.T) {
+	spans := recordSpans(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	withTracing(mux, mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/broken", nil))
+	got := spans()
+	if len(got) != 1 || !got[0].Error || got[0].Parent != (tracing.SpanID{}) {
+		t.Fatalf("expected one failed root span, got %+v", got)
+	}
+}
+
+func TestStartTracing_FileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	step, err := startTracing(config.Tracing{Exporter: "file", File: path})
+	if err != nil || step == nil {
+		t.Fatalf("startTracing failed: %v", err)
+	}
+	_, span := tracing.Start(context.Background(), "op", tracing.KindInternal)
+	span.End()
+	if err := step.stop(context.Background()); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+	if tracing.Enabled() {
+		t.Error("expected tracing to be disabled after shutdown")
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read spans: %v", err)
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(raw))), &line); err != nil || line["name"] != "op" {
+		t.Fatalf("expected the span in the file, got %q", raw)
+	}
+
+	if step, err := startTracing(config.Tracing{Exporter: "none"}); step != nil || err != nil || tracing.Enabled() {
+		t.Errorf("expected no tracing, got %v, %v", step, err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/services"
+)
+
+// AlarmUpdateRequest carries the fields of an alarm update; on PATCH omitted
+// fields are left unchanged, on PUT all of them are required
+type AlarmUpdateRequest struct {
+	Name        *string    `json:"name" openapi:"rule=name"`
+	Description *string    `json:"description" openapi:"rule=description"`
+	Target      *time.Time `json:"target" openapi:"rule=target"`
+}
+
+// EventUpdateRequest carries the fields of an event update
+type EventUpdateRequest struct {
+	Name        *string `json:"name" openapi:"rule=name"`
+	Description *string `json:"description" openapi:"rule=description"`
+}
+
+// applyAlarmUpdate validates the fields present in req and merges them into alarm
+func applyAlarmUpdate(alarm datapkg.Alarm, req AlarmUpdateRequest) (datapkg.Alarm, error) {
+	var target *time.Time
+	if req.Target != nil {
+		utc := req.Target.UTC()
+		target = &utc
+	}
+	if err := validationRules.ValidateAlarmPatch(req.Name, req.Description, target, time.Now().UTC()); err != nil {
+		return alarm, err
+	}
+	if req.Name != nil {
+		alarm.Name = *req.Name
+	}
+	if req.Description != nil {
+		alarm.Description = *req.Description
+	}
+	if target != nil {
+		alarm.Target = *target
+	}
+	return alarm, nil
+}
+
+// applyEventUpdate validates the fields present in req and merges them into event
+func applyEventUpdate(event datapkg.Event, req EventUpdateRequest) (datapkg.Event, error) {
+	if err := validationRules.ValidateEventPatch(req.Name, req.Description); err != nil {
+		return event, err
+	}
+	if req.Name != nil {
+		event.Name = *req.Name
+	}
+	if req.Description != nil {
+		event.Description = *req.Description
+	}
+	return event, nil
+}
+
+// requireFields reports every field whose presence flag is false, in name
+// order so responses are stable
+func requireFields(present map[string]bool) error {
+	var names []string
+	for name, ok := range present {
+		if !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var verr services.ValidationError
+	for _, name := range names {
+		verr.Add(name, services.CodeRequired, name+" is required for PUT")
+	}
+	return verr.Err()
+}
+
+func updateAlarmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		writeProblem(w, r, codeMethodNotAllowed, "")
+		return
+	}
+	id := r.URL.Query().Get("id")
+	alarms := alarmStore.WithContext(r.Context())
+	version, ok := requireIfMatch(w, r, alarms.FindByID, id, codeAlarmNotFound)
+	if !ok {
+		return
+	}
+	var req AlarmUpdateRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if r.Method == http.MethodPut {
+		if err := requireFields(map[string]bool{
+			"name": req.Name != nil, "description": req.Description != nil, "target": req.Target != nil,
+		}); err != nil {
+			writeValidationProblem(w, r, err)
+			return
+		}
+	}
+	raw, err := alarms.FindByID(id)
+	if err != nil {
+		writeLookupProblem(w, r, err, codeAlarmNotFound)
+		return
+	}
+	alarm, err := applyAlarmUpdate(raw.(datapkg.Alarm), req)
+	if err != nil {
+		writeValidationProblem(w, r, err)
+		return
+	}
+	updatedRaw, err := alarms.Update(alarm, version)
+	if err != nil {
+		writeVersionedWriteError(w, r, err, codeAlarmNotFound, "Failed to update alarm")
+		return
+	}
+	updated := updatedRaw.(datapkg.Alarm)
+	w.Header().Set("ETag", etag(updated.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presentResource(r, updated))
+}
+
+func updateEventHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		writeProblem(w, r, codeMethodNotAllowed, "")
+		return
+	}
+	id := r.URL.Query().Get("id")
+	events := eventStore.WithContext(r.Context())
+	version, ok := requireIfMatch(w, r, events.FindByID, id, codeEventNotFound)
+	if !ok {
+		return
+	}
+	var req EventUpdateRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if r.Method == http.MethodPut {
+		if err := requireFields(map[string]bool{
+			"name": req.Name != nil, "description": req.Description != nil,
+		}); err != nil {
+			writeValidationProblem(w, r, err)
+			return
+		}
+	}
+	raw, err := events.FindByID(id)
+	if err != nil {
+		writeLookupProblem(w, r, err, codeEventNotFound)
+		return
+	}
+	event, err := applyEventUpdate(raw.(datapkg.Event), req)
+	if err != nil {
+		writeValidationProblem(w, r, err)
+		return
+	}
+	updatedRaw, err := events.Update(event, version)
+	if err != nil {
+		writeVersionedWriteError(w, r, err, codeEventNotFound, "Failed to update event")
+		return
+	}
+	updated := updatedRaw.(datapkg.Event)
+	w.Header().Set("ETag", etag(updated.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presentResource(r, updated))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func TestUpdateAlarm_RequiresMatchingETag(t *testing.T) {
+	setupHandlersForTest(t)
+
+	createdRaw, err := alarmStore.Create(datapkg.Alarm{Name: "a", Target: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("failed to create alarm in storage: %v", err)
+	}
+	created := createdRaw.(datapkg.Alarm)
+
+	patch := func(ifMatch string) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(map[string]interface{}{"name": "renamed"})
+		req := httptest.NewRequest("PATCH", "/alarms/update?id="+created.ID, bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		updateAlarmHandler(w, req)
+		return w
+	}
+
+	if w := patch(""); w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected 428 without If-Match, got %d", w.Code)
+	}
+	w := patch(etag(created.Version))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	newTag := w.Header().Get("ETag")
+	if newTag != etag(created.Version+1) {
+		t.Fatalf("expected ETag %s, got %s", etag(created.Version+1), newTag)
+	}
+	if w := patch(etag(created.Version)); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale If-Match, got %d", w.Code)
+	}
+
+	countdown := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/alarms/countdown?id="+created.ID, nil)
+		req.Header.Set("If-None-Match", newTag)
+		w := httptest.NewRecorder()
+		getAlarmCountdownHandler(w, req)
+		return w
+	}
+	first := countdown()
+	if first.Code != http.StatusOK || first.Header().Get("ETag") != newTag || first.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("expected a full uncached countdown with ETag %s, got %d %v", newTag, first.Code, first.Header())
+	}
+	// move the stored target a minute without touching the version, as if
+	// a minute had passed: the countdown changes while the ETag does not
+	if _, err := storageDB.Exec("UPDATE alarms SET target = target - 60 WHERE id = ?", created.ID); err != nil {
+		t.Fatalf("failed to move the target: %v", err)
+	}
+	if later := countdown(); later.Code != http.StatusOK || later.Header().Get("ETag") != newTag || later.Body.String() == first.Body.String() {
+		t.Fatalf("expected a fresh countdown under the same ETag, got %d %v %s", later.Code, later.Header(), later.Body.String())
+	}
+
+	req := httptest.NewRequest("DELETE", "/alarms/delete?id="+created.ID, nil)
+	req.Header.Set("If-Match", "*")
+	w = httptest.NewRecorder()
+	deleteAlarmsHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 deleting with If-Match *, got %d", w.Code)
+	}
+}
+
+func TestUpdateEvent_PutRequiresAllFields(t *testing.T) {
+	setupHandlersForTest(t)
+
+	createdRaw, err := eventStore.Create(datapkg.Event{Name: "e", StartedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("failed to create event in storage: %v", err)
+	}
+	created := createdRaw.(datapkg.Event)
+
+	raw, _ := json.Marshal(map[string]interface{}{"name": "only name"})
+	req := httptest.NewRequest("PUT", "/events/update?id="+created.ID, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", etag(created.Version))
+	w := httptest.NewRecorder()
+	updateEventHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for partial PUT, got %d", w.Code)
+	}
+}
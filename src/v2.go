@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+	"ClockAsService/src/services"
+)
+
+// Alarm statuses reported by v2. A due alarm has reached its target but
+// the scheduler has not fired it yet.
+const (
+	alarmPending = "pending"
+	alarmDue     = "due"
+	alarmFired   = "fired"
+)
+
+// AlarmV2 is the v2 wire shape of an alarm. Unlike datapkg.Alarm it uses
+// snake_case names, reports times in UTC at second precision and carries the
+// computed status and countdown.
+type AlarmV2 struct {
+	ID                string            `json:"id"`
+	Name              string            `json:"name"`
+	Description       string            `json:"description"`
+	Target            time.Time         `json:"target"`
+	CreatedAt         time.Time         `json:"created_at"`
+	Labels            map[string]string `json:"labels"`
+	Version           int64             `json:"version"`
+	Owner             string            `json:"owner" doc:"Principal that created the alarm, empty if authentication was off"`
+	Status            string            `json:"status" openapi:"enum=pending|due|fired" doc:"fired once the scheduler fired it, due from its target until then"`
+	Countdown         float64           `json:"countdown" doc:"Seconds until the target, never negative"`
+	CountdownDetailed string            `json:"countdown_detailed"`
+}
+
+// EventV2 is the v2 wire shape of an event
+type EventV2 struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	Description     string            `json:"description"`
+	StartedAt       time.Time         `json:"started_at"`
+	CreatedAt       time.Time         `json:"created_at"`
+	Labels          map[string]string `json:"labels"`
+	Version         int64             `json:"version"`
+	Owner           string            `json:"owner" doc:"Principal that created the event, empty if authentication was off"`
+	Elapsed         float64           `json:"elapsed" doc:"Seconds since the event started"`
+	ElapsedDetailed string            `json:"elapsed_detailed"`
+}
+
+func alarmV2(a datapkg.Alarm, now time.Time) AlarmV2 {
+	countdown := a.Target.Sub(now).Seconds()
+	status := alarmPending
+	if countdown <= 0 {
+		countdown = 0
+		status = alarmDue
+	}
+	if a.FiredAt != nil {
+		status = alarmFired
+	}
+	return AlarmV2{
+		ID:                a.ID,
+		Name:              a.Name,
+		Description:       a.Description,
+		Target:            wireTime(a.Target),
+		CreatedAt:         wireTime(a.CreatedAt),
+		Labels:            wireLabels(a.Labels),
+		Version:           a.Version,
+		Owner:             a.Owner,
+		Status:            status,
+		Countdown:         countdown,
+		CountdownDetailed: services.HumanizeDuration(countdown),
+	}
+}
+
+func eventV2(e datapkg.Event, now time.Time) EventV2 {
+	elapsed := now.Sub(e.StartedAt).Seconds()
+	return EventV2{
+		ID:              e.ID,
+		Name:            e.Name,
+		Description:     e.Description,
+		StartedAt:       wireTime(e.StartedAt),
+		CreatedAt:       wireTime(e.CreatedAt),
+		Labels:          wireLabels(e.Labels),
+		Version:         e.Version,
+		Owner:           e.Owner,
+		Elapsed:         elapsed,
+		ElapsedDetailed: services.HumanizeDuration(elapsed),
+	}
+}
+
+// wireTime matches what storage keeps, so a resource reads back the same as
+// it was returned on create
+func wireTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+// wireLabels always renders an object, never null
+func wireLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return map[string]string{}
+	}
+	return labels
+}
+
+// presentResource renders a stored alarm or event in the wire shape of the
+// request's API version. v1 writes the storage structs unchanged.
+func presentResource(r *http.Request, raw interface{}) interface{} {
+	return presentResourceAt(r, raw, time.Now())
+}
+
+// presentResourceAt is presentResource with countdowns and elapsed times
+// computed at now
+func presentResourceAt(r *http.Request, raw interface{}, now time.Time) interface{} {
+	if apiVersionOf(r) != "v2" {
+		return raw
+	}
+	switch v := raw.(type) {
+	case datapkg.Alarm:
+		return alarmV2(v, now)
+	case datapkg.Event:
+		return eventV2(v, now)
+	}
+	return raw
+}
+
+// presentAlarms renders a list of alarms for the request's API version,
+// with countdowns computed at now
+func presentAlarms(r *http.Request, alarms []datapkg.Alarm, now time.Time) interface{} {
+	if apiVersionOf(r) != "v2" {
+		return alarms
+	}
+	out := make([]AlarmV2, 0, len(alarms))
+	for _, a := range alarms {
+		out = append(out, alarmV2(a, now))
+	}
+	return out
+}
+
+// presentEvents renders a list of events for the request's API version,
+// with elapsed times computed at now
+func presentEvents(r *http.Request, events []datapkg.Event, now time.Time) interface{} {
+	if apiVersionOf(r) != "v2" {
+		return events
+	}
+	out := make([]EventV2, 0, len(events))
+	for _, e := range events {
+		out = append(out, eventV2(e, now))
+	}
+	return out
+}
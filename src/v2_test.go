@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	datapkg "ClockAsService/src/data"
+)
+
+func serveRoutes(t *testing.T, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	var reader *bytes.Reader
+	if body != nil {
+		raw, _ := json.Marshal(body)
+		reader = bytes.NewReader(raw)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestV2_CreateAlarmUsesSnakeCase(t *testing.T) {
+	setupHandlersForTest(t)
+
+	target := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	w := serveRoutes(t, "POST", "/v2/alarms/create", map[string]interface{}{
+		"name": "standup", "target": target.Format(time.RFC3339),
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	for _, key := range []string{"id", "name", "target", "created_at", "labels", "version", "status", "countdown", "countdown_detailed"} {
+		if _, ok := body[key]; !ok {
+			t.Errorf("expected key %q in %v", key, body)
+		}
+	}
+	if _, ok := body["ID"]; ok {
+		t.Errorf("v2 must not use storage field names: %v", body)
+	}
+	if body["status"] != alarmPending || body["target"] != target.Format(time.RFC3339) {
+		t.Errorf("unexpected status or target: %v", body)
+	}
+	if labels, ok := body["labels"].(map[string]interface{}); !ok || len(labels) != 0 {
+		t.Errorf("expected empty labels object, got %v", body["labels"])
+	}
+}
+
+func TestV2_ListIncludesComputedFields(t *testing.T) {
+	setupHandlersForTest(t)
+
+	w := serveRoutes(t, "GET", "/v2/alarms/list", nil)
+	if w.Body.String() != "[]\n" {
+		t.Fatalf("expected an empty array, got %s", w.Body.String())
+	}
+
+	past := datapkg.Alarm{Name: "past", Target: time.Now().Add(-time.Minute)}
+	if _, err := alarmStore.Create(past); err != nil {
+		t.Fatalf("failed to create alarm: %v", err)
+	}
+	w = serveRoutes(t, "GET", "/v2/alarms/list", nil)
+	var alarms []AlarmV2
+	if err := json.Unmarshal(w.Body.Bytes(), &alarms); err != nil || len(alarms) != 1 {
+		t.Fatalf("expected one alarm, got %s", w.Body.String())
+	}
+	if alarms[0].Status != alarmDue || alarms[0].Countdown != 0 {
+		t.Errorf("expected a due alarm with zero countdown, got %+v", alarms[0])
+	}
+
+	if _, err := alarmStore.FireDue(time.Now()); err != nil {
+		t.Fatalf("failed to fire due alarms: %v", err)
+	}
+	w = serveRoutes(t, "GET", "/v2/alarms/list", nil)
+	alarms = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &alarms); err != nil || len(alarms) != 1 {
+		t.Fatalf("expected one alarm, got %s", w.Body.String())
+	}
+	if alarms[0].Status != alarmFired {
+		t.Errorf("expected the scheduler to have fired it, got %+v", alarms[0])
+	}
+}
+
+func TestV1_KeepsStorageShape(t *testing.T) {
+	setupHandlersForTest(t)
+
+	for _, prefix := range []string{"", "/v1"} {
+		w := serveRoutes(t, "POST", prefix+"/events/create", map[string]interface{}{"name": "deploy"})
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if _, ok := body["ID"]; !ok {
+			t.Errorf("%s: expected the v1 shape, got %v", prefix, body)
+		}
+		w = serveRoutes(t, "GET", prefix+"/events/elapsed?id="+body["ID"].(string), nil)
+		var elapsed map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &elapsed)
+		if _, ok := elapsed["elapsed_detailed"]; !ok {
+			t.Errorf("%s: expected the v1 elapsed envelope, got %v", prefix, elapsed)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion is one version of the API. Every version mounts the same
+// handlers over the shared services; the presenters pick the wire shape
+// through apiVersionOf and Kinds documents the matching response types.
+type apiVersion struct {
+	Name string
+	// Lifecycle is advertised on every route of the version. A route's own
+	// lifecycle takes precedence where it is set.
+	Lifecycle lifecycle
+	// Successor names the version that replaces this one
+	Successor string
+	Kinds     func() []resourceKind
+}
+
+// defaultAPIVersion is served on unprefixed paths when Accept does not name
+// a version, so integrations written before versioning keep working
+const defaultAPIVersion = "v1"
+
+// apiVersions lists the versions served, oldest first. Each is mounted under
+// /<name> and can be negotiated on unprefixed paths with its media type.
+var apiVersions = []apiVersion{
+	{Name: "v1", Kinds: func() []resourceKind { return []resourceKind{alarmKind, eventKind} }},
+	{Name: "v2", Kinds: func() []resourceKind {
+		alarm, event := alarmKind, eventKind
+		alarm.Resource, alarm.Resources, alarm.Clocks = AlarmV2{}, []AlarmV2{}, AlarmV2{}
+		event.Resource, event.Resources, event.Clocks = EventV2{}, []EventV2{}, EventV2{}
+		return []resourceKind{alarm, event}
+	}},
+}
+
+// versionMediaType is the vendor media type that selects version in Accept
+func versionMediaType(version string) string {
+	return "application/vnd.clock." + version + "+json"
+}
+
+// lifecycle is the deprecation schedule of a version or route. Zero times
+// are not advertised.
+type lifecycle struct {
+	Deprecation time.Time
+	Sunset      time.Time
+	// Successor is the path clients should move to
+	Successor string
+}
+
+// over returns l with the fields unset in l taken from base
+func (l lifecycle) over(base lifecycle) lifecycle {
+	if l.Deprecation.IsZero() {
+		l.Deprecation = base.Deprecation
+	}
+	if l.Sunset.IsZero() {
+		l.Sunset = base.Sunset
+	}
+	if l.Successor == "" {
+		l.Successor = base.Successor
+	}
+	return l
+}
+
+// deprecated reports whether the lifecycle announces a deprecation or sunset
+func (l lifecycle) deprecated() bool {
+	return !l.Deprecation.IsZero() || !l.Sunset.IsZero()
+}
+
+// withLifecycle adds the Deprecation (RFC 9745), Sunset (RFC 8594) and
+// successor Link headers of l to responses from next
+func withLifecycle(l lifecycle, next http.HandlerFunc) http.HandlerFunc {
+	if !l.deprecated() && l.Successor == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		if !l.Deprecation.IsZero() {
+			h.Set("Deprecation", "@"+strconv.FormatInt(l.Deprecation.Unix(), 10))
+			h.Add("Link", `</versions>; rel="deprecation"`)
+		}
+		if !l.Sunset.IsZero() {
+			h.Set("Sunset", l.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if l.Successor != "" {
+			h.Add("Link", "<"+l.Successor+`>; rel="successor-version"`)
+		}
+		next(w, r)
+	}
+}
+
+type apiVersionKey struct{}
+
+// withAPIVersion marks requests handled by next as belonging to version
+func withAPIVersion(version string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	}
+}
+
+// apiVersionOf returns the API version a request was routed to. Handlers
+// called outside the router serve v1, the shape that predates versioning.
+func apiVersionOf(r *http.Request) string {
+	if v, ok := r.Context().Value(apiVersionKey{}).(string); ok {
+		return v
+	}
+	return defaultAPIVersion
+}
+
+// negotiateVersion returns the first served version named by a vendor media
+// type in accept, or the default version when accept names none. vendor
+// reports whether the version came from a media type; ok is false when accept
+// only lists versions that are not served.
+func negotiateVersion(accept string) (version string, vendor, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return defaultAPIVersion, false, true
+	}
+	acceptsDefault := false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(mediaType, "application/vnd.clock."), "+json")
+		if name == mediaType {
+			acceptsDefault = true
+			continue
+		}
+		for _, v := range apiVersions {
+			if v.Name == name {
+				return name, true, true
+			}
+		}
+	}
+	return defaultAPIVersion, false, acceptsDefault
+}
+
+// negotiate serves an unprefixed path with the handler of the version chosen
+// by the Accept header
+func negotiate(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		version, vendor, ok := negotiateVersion(r.Header.Get("Accept"))
+		if !ok {
+			writeProblem(w, r, codeUnsupportedVersion, "GET /versions lists the media types served")
+			return
+		}
+		if vendor {
+			w = &vendorWriter{ResponseWriter: w, mediaType: versionMediaType(version)}
+		}
+		handlers[version](w, r)
+	}
+}
+
+// vendorWriter labels JSON responses with the negotiated media type
+type vendorWriter struct {
+	http.ResponseWriter
+	mediaType   string
+	wroteHeader bool
+}
+
+func (w *vendorWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.Header().Get("Content-Type") == "application/json" {
+			w.Header().Set("Content-Type", w.mediaType)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *vendorWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// VersionInfo describes one served API version
+type VersionInfo struct {
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	MediaType   string     `json:"media_type" doc:"Accept value that selects this version on unprefixed paths"`
+	Status      string     `json:"status" openapi:"enum=current|supported|deprecated"`
+	Deprecation *time.Time `json:"deprecation,omitempty"`
+	Sunset      *time.Time `json:"sunset,omitempty" doc:"When the version stops being served"`
+	Successor   string     `json:"successor,omitempty"`
+}
+
+// VersionsResponse is returned by /versions
+type VersionsResponse struct {
+	Default  string        `json:"default" doc:"Version served on unprefixed paths when Accept names none"`
+	Latest   string        `json:"latest"`
+	Versions []VersionInfo `json:"versions"`
+}
+
+// versionsHandler lists the served versions and their lifecycle
+func versionsHandler(w http.ResponseWriter, r *http.Request) {
+	latest := apiVersions[len(apiVersions)-1].Name
+	resp := VersionsResponse{Default: defaultAPIVersion, Latest: latest}
+	for _, v := range apiVersions {
+		info := VersionInfo{
+			Name:      v.Name,
+			Prefix:    "/" + v.Name,
+			MediaType: versionMediaType(v.Name),
+			Status:    "supported",
+			Successor: v.Successor,
+		}
+		switch {
+		case v.Lifecycle.deprecated():
+			info.Status = "deprecated"
+		case v.Name == latest:
+			info.Status = "current"
+		}
+		if t := v.Lifecycle.Deprecation; !t.IsZero() {
+			info.Deprecation = &t
+		}
+		if t := v.Lifecycle.Sunset; !t.IsZero() {
+			info.Sunset = &t
+		}
+		resp.Versions = append(resp.Versions, info)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
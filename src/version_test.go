@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		accept  string
+		version string
+		vendor  bool
+		ok      bool
+	}{
+		{"", "v1", false, true},
+		{"application/json", "v1", false, true},
+		{"*/*", "v1", false, true},
+		{"application/vnd.clock.v2+json", "v2", true, true},
+		{"application/vnd.clock.v1+json", "v1", true, true},
+		{"application/json;q=0.5, application/vnd.clock.v2+json", "v2", true, true},
+		{"application/vnd.clock.v9+json, application/vnd.clock.v2+json", "v2", true, true},
+		{"application/vnd.clock.v9+json", "v1", false, false},
+		{"application/vnd.clock.v9+json, */*;q=0.1", "v1", false, true},
+	}
+	for _, tt := range tests {
+		version, vendor, ok := negotiateVersion(tt.accept)
+		if version != tt.version || vendor != tt.vendor || ok != tt.ok {
+			t.Errorf("negotiateVersion(%q) = %s, %v, %v; want %s, %v, %v",
+				tt.accept, version, vendor, ok, tt.version, tt.vendor, tt.ok)
+		}
+	}
+}
+
+func TestAccept_SelectsVersionOnUnprefixedPaths(t *testing.T) {
+	setupHandlersForTest(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+
+	serve := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/events/create", strings.NewReader(`{"name":"deploy"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(versionMediaType("v2"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/vnd.clock.v2+json" {
+		t.Errorf("expected the v2 media type, got %q", ct)
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept" {
+		t.Errorf("expected Vary: Accept, got %q", vary)
+	}
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if _, ok := body["started_at"]; !ok {
+		t.Errorf("expected the v2 shape, got %v", body)
+	}
+
+	w = serve("application/json")
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected application/json, got %q", ct)
+	}
+	body = nil
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if _, ok := body["StartedAt"]; !ok {
+		t.Errorf("expected the v1 shape, got %v", body)
+	}
+
+	w = serve("application/vnd.clock.v9+json")
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406, got %d: %s", w.Code, w.Body.String())
+	}
+	if p := decodeProblem(t, w); p.Code != codeUnsupportedVersion {
+		t.Errorf("expected %s, got %s", codeUnsupportedVersion, p.Code)
+	}
+}
+
+func TestMount_AdvertisesLifecycle(t *testing.T) {
+	deprecated := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)
+	routeSunset := time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC)
+	v := apiVersion{Name: "v1", Successor: "v2", Lifecycle: lifecycle{Deprecation: deprecated, Sunset: sunset}}
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(apiVersionOf(r)))
+	}
+	get := []operation{{Method: http.MethodGet, Summary: "test", Status: http.StatusOK}}
+	rts := mount(v, "/v1", []route{
+		{Path: "/kept", Handler: ok, Ops: get},
+		{Path: "/early", Handler: ok, Ops: get, Lifecycle: lifecycle{Sunset: routeSunset}},
+	})
+
+	tests := []struct {
+		route  route
+		sunset time.Time
+	}{
+		{rts[0], sunset},
+		{rts[1], routeSunset},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.route.Handler(w, httptest.NewRequest("GET", tt.route.Path, nil))
+		if w.Body.String() != "v1" {
+			t.Errorf("%s: expected the handler to see v1, got %q", tt.route.Path, w.Body.String())
+		}
+		if got := w.Header().Get("Deprecation"); got != "@1767225600" {
+			t.Errorf("%s: unexpected Deprecation %q", tt.route.Path, got)
+		}
+		if got := w.Header().Get("Sunset"); got != tt.sunset.Format(http.TimeFormat) {
+			t.Errorf("%s: unexpected Sunset %q", tt.route.Path, got)
+		}
+		successor := `</v2` + strings.TrimPrefix(tt.route.Path, "/v1") + `>; rel="successor-version"`
+		if links := w.Header().Values("Link"); len(links) != 2 || links[1] != successor {
+			t.Errorf("%s: unexpected Link %v", tt.route.Path, links)
+		}
+	}
+
+	spec := buildOpenAPI(rts, validationRules)
+	op := spec["paths"].(jsonObject)["/v1/kept"].(jsonObject)["get"].(jsonObject)
+	if op["deprecated"] != true {
+		t.Errorf("expected the operation to be documented as deprecated, got %v", op)
+	}
+}
+
+func TestVersionsHandler(t *testing.T) {
+	w := serveRoutes(t, "GET", "/versions", nil)
+	var resp VersionsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode %s: %v", w.Body.String(), err)
+	}
+	if resp.Default != "v1" || resp.Latest != "v2" || len(resp.Versions) != 2 {
+		t.Fatalf("unexpected versions: %+v", resp)
+	}
+	v2 := resp.Versions[1]
+	if v2.Prefix != "/v2" || v2.MediaType != "application/vnd.clock.v2+json" || v2.Status != "current" {
+		t.Errorf("unexpected v2 entry: %+v", v2)
+	}
+	if resp.Versions[0].Status != "supported" {
+		t.Errorf("unexpected v1 entry: %+v", resp.Versions[0])
+	}
+}